@@ -0,0 +1,96 @@
+package scrooge
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/binary"
+	"math/bits"
+
+	"scrooge/cryptoutil"
+)
+
+// BlockReward is the value a coinbase may create on top of the fees of its block.
+const BlockReward = 25.0
+
+// coinbaseIndex is the OutputIdx of the single input of a coinbase, which spends no output.
+const coinbaseIndex = -1
+
+// Block carries the transactions of one epoch in blockchain mode, where blocks mined by
+// anyone replace the epochs of Scrooge.
+type Block struct {
+	Hash          []byte
+	PrevBlockHash []byte
+	// Coinbase pays the reward and fees of the block to its miner.
+	Coinbase *Transaction
+	Txs      []*Transaction
+	Nonce    uint64
+	// Difficulty is the number of leading zero bits Hash must have.
+	Difficulty int
+}
+
+// NewCoinbase returns the coinbase of a block extending prevBlockHash, paying value to
+// address. Its input names the parent block, so that coinbases paying the same value
+// to the same address in different blocks differ.
+func NewCoinbase(prevBlockHash []byte, value float64, address rsa.PublicKey) *Transaction {
+	tx := NewTransaction()
+	tx.AddInput(prevBlockHash, coinbaseIndex)
+	tx.AddOutput(value, address)
+	tx.Finalize()
+	return tx
+}
+
+// IsCoinbase tells whether tx has the single input of a coinbase.
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].OutputIdx == coinbaseIndex
+}
+
+// NewBlock returns a block extending prevBlockHash with txs, paying value to address.
+// It still has to be mined.
+func NewBlock(prevBlockHash []byte, address rsa.PublicKey, value float64, txs []*Transaction, difficulty int) *Block {
+	return &Block{
+		PrevBlockHash: prevBlockHash,
+		Coinbase:      NewCoinbase(prevBlockHash, value, address),
+		Txs:           txs,
+		Difficulty:    difficulty,
+	}
+}
+
+// header returns the encoding hashed into the block hash. It commits to the
+// transactions through their hashes, which commit to their contents.
+func (block *Block) header() []byte {
+	var txHashes bytes.Buffer
+	txHashes.Write(block.Coinbase.Hash)
+	for _, tx := range block.Txs {
+		txHashes.Write(tx.Hash)
+	}
+	var header bytes.Buffer
+	header.Write(block.PrevBlockHash)
+	header.Write(cryptoutil.HashSha256(txHashes.Bytes()))
+	binary.Write(&header, binary.BigEndian, int32(block.Difficulty))
+	binary.Write(&header, binary.BigEndian, block.Nonce)
+	return header.Bytes()
+}
+
+func (block *Block) Finalize() {
+	block.Hash = cryptoutil.HashSha256(block.header())
+}
+
+// Mine tries the nonces from the current one until the block hash meets its difficulty,
+// and finalizes the block.
+func (block *Block) Mine() {
+	for block.Finalize(); !meetsDifficulty(block.Hash, block.Difficulty); block.Finalize() {
+		block.Nonce++
+	}
+}
+
+// meetsDifficulty tells whether hash starts with difficulty zero bits.
+func meetsDifficulty(hash []byte, difficulty int) bool {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= difficulty
+}
@@ -0,0 +1,358 @@
+package scrooge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"scrooge/cryptoutil"
+)
+
+var (
+	ErrBlockBadHash    = errors.New("scrooge: block hash does not match its contents")
+	ErrBlockKnown      = errors.New("scrooge: block already in the chain")
+	ErrBlockDifficulty = errors.New("scrooge: block difficulty is not the one of the chain")
+	ErrBlockWork       = errors.New("scrooge: block hash does not meet its difficulty")
+	ErrBlockParent     = errors.New("scrooge: block extends no block above the cut-off")
+	ErrBlockCoinbase   = errors.New("scrooge: block coinbase is malformed or pays too much")
+	ErrBlockTx         = errors.New("scrooge: block holds an invalid transaction")
+)
+
+// DefaultCutOffAge is the default of BlockChain.CutOffAge.
+const DefaultCutOffAge = 10
+
+// blockNode is a block of the tree of forks.
+type blockNode struct {
+	block  *Block
+	parent *blockNode
+	// height is the number of blocks before block, 0 for the genesis block.
+	height int
+}
+
+// BlockChain applies the rules of TxHandler to blocks mined by anyone rather than to the
+// epochs of a trusted Scrooge.
+//
+// It keeps a tree of the blocks extending its genesis block and follows the main
+// branch, the first one received at the greatest height. Each block of the main branch
+// is an epoch of a TxHandler whose pool is the state of the chain: HandleTxs must accept
+// all the transactions of the block, in order, after which its coinbase is added. The
+// History of the handler keeps the undo data of each epoch, so that when another branch
+// grows higher the chain rolls the pool back to the fork with RollbackEpoch and applies
+// the blocks of the new branch. The blocks of other branches are only checked against
+// their parent then: a branch found to hold an invalid block loses that block and its
+// descendants, and the main branch stays. A fork may only start in the last CutOffAge
+// blocks below the tip; the blocks below are forgotten, with the branches forking
+// below and the undo data of their epochs.
+//
+// The transactions waiting for a block are held in a Mempool on the pool of the chain,
+// from which a BlockHandler builds blocks. The transactions of the blocks a reorg takes
+// out of the main branch return to it, unless they are in the new branch or no longer
+// valid on it.
+type BlockChain struct {
+	// CutOffAge bounds how far below the tip a new block may be: its parent must be at
+	// least at the height of the tip less CutOffAge.
+	CutOffAge int
+
+	difficulty int
+	blocks     map[string]*blockNode
+	tip        *blockNode
+	handler    *TxHandler
+	mempool    *Mempool
+}
+
+// NewBlockChain returns a chain made of genesis, whose difficulty every block must have.
+func NewBlockChain(genesis *Block) (*BlockChain, error) {
+	bc := &BlockChain{
+		CutOffAge:  DefaultCutOffAge,
+		difficulty: genesis.Difficulty,
+		blocks:     make(map[string]*blockNode),
+		handler:    NewTxHandler(NewUTXOPool()),
+	}
+	bc.handler.History = NewTxHistory()
+	if err := bc.check(genesis); err != nil {
+		return nil, err
+	}
+	if genesis.PrevBlockHash != nil {
+		return nil, ErrBlockParent
+	}
+	if err := bc.connect(genesis); err != nil {
+		return nil, err
+	}
+	bc.tip = &blockNode{block: genesis}
+	bc.blocks[string(genesis.Hash)] = bc.tip
+	bc.mempool = NewMempool(bc.handler, 0)
+	return bc, nil
+}
+
+// AddBlock adds block to the tree. If it is higher than the tip, the chain reorganizes
+// to make it the tip, which fails if block or one of its ancestors is not valid.
+func (bc *BlockChain) AddBlock(block *Block) error {
+	if err := bc.check(block); err != nil {
+		return err
+	}
+	if _, ok := bc.blocks[string(block.Hash)]; ok {
+		return ErrBlockKnown
+	}
+	parent, ok := bc.blocks[string(block.PrevBlockHash)]
+	if !ok || parent.height < bc.tip.height-bc.CutOffAge {
+		return ErrBlockParent
+	}
+	node := &blockNode{block: block, parent: parent, height: parent.height + 1}
+	if node.height > bc.tip.height {
+		if err := bc.reorg(node); err != nil {
+			return err
+		}
+		bc.prune()
+	}
+	bc.blocks[string(block.Hash)] = node
+	return nil
+}
+
+// check applies to block the rules that do not depend on its parent.
+func (bc *BlockChain) check(block *Block) error {
+	if block.Coinbase == nil {
+		return ErrBlockCoinbase
+	}
+	if !bytes.Equal(cryptoutil.HashSha256(block.header()), block.Hash) {
+		return ErrBlockBadHash
+	}
+	if block.Difficulty != bc.difficulty {
+		return ErrBlockDifficulty
+	}
+	if !meetsDifficulty(block.Hash, block.Difficulty) {
+		return ErrBlockWork
+	}
+	coinbase := block.Coinbase
+	if !coinbase.IsCoinbase() || !bytes.Equal(coinbase.Inputs[0].PrevTxHash, block.PrevBlockHash) ||
+		!bytes.Equal(cryptoutil.HashSha256(coinbase.GetRawTx()), coinbase.Hash) {
+		return ErrBlockCoinbase
+	}
+	for idx := range coinbase.Outputs {
+		out := &coinbase.Outputs[idx]
+		if out.Value < 0 || math.IsNaN(out.Value) || math.IsInf(out.Value, 0) || outputFault(out) != "" {
+			return ErrBlockCoinbase
+		}
+	}
+	seen := make(map[string]bool)
+	for _, tx := range block.Txs {
+		if !bytes.Equal(cryptoutil.HashSha256(tx.GetRawTx()), tx.Hash) {
+			return fmt.Errorf("%w: %x: %w", ErrBlockTx, tx.Hash, ErrTxBadHash)
+		}
+		if seen[string(tx.Hash)] {
+			return fmt.Errorf("%w: %x included twice", ErrBlockTx, tx.Hash)
+		}
+		seen[string(tx.Hash)] = true
+	}
+	return nil
+}
+
+// connect applies block to the pool of the chain as a new epoch, which it leaves as it
+// was if block is not valid there.
+func (bc *BlockChain) connect(block *Block) error {
+	accepted := bc.handler.HandleTxs(block.Txs)
+	err := checkAccepted(block.Txs, accepted)
+	if err == nil {
+		var value float64
+		for _, out := range block.Coinbase.Outputs {
+			value += out.Value
+		}
+		if !(value <= BlockReward+blockFees(bc.handler.History, block.Txs)) {
+			err = ErrBlockCoinbase
+		}
+	}
+	if err != nil {
+		bc.handler.RollbackEpoch()
+		return err
+	}
+	bc.handler.applyCoinbase(block.Coinbase)
+	return nil
+}
+
+// checkAccepted fails with the first transaction of txs HandleTxs did not accept.
+func checkAccepted(txs, accepted []*Transaction) error {
+	if len(accepted) == len(txs) {
+		return nil
+	}
+	isAccepted := make(map[string]bool)
+	for _, tx := range accepted {
+		isAccepted[string(tx.Hash)] = true
+	}
+	for _, tx := range txs {
+		if !isAccepted[string(tx.Hash)] {
+			return fmt.Errorf("%w: %x", ErrBlockTx, tx.Hash)
+		}
+	}
+	return nil
+}
+
+// blockFees returns the fees paid by txs, which history has recorded.
+func blockFees(history *TxHistory, txs []*Transaction) float64 {
+	var fees float64
+	for _, tx := range txs {
+		for _, spent := range history.GetTx(tx.Hash).Spent {
+			fees += spent.Output.Value
+		}
+		for _, out := range tx.Outputs {
+			fees -= out.Value
+		}
+	}
+	return fees
+}
+
+// applyCoinbase adds the outputs of coinbase to the pool of handler in the epoch it has
+// just handled, and records it in the History of handler with that epoch.
+func (handler *TxHandler) applyCoinbase(coinbase *Transaction) {
+	epoch := handler.Pool.Epoch - 1
+	handler.History.record(handler.Pool, epoch, coinbase)
+	for idx := range coinbase.Outputs {
+		handler.Pool.restoreUTXO(UTXO{TxHash: string(coinbase.Hash), Index: idx}, &coinbase.Outputs[idx], epoch)
+	}
+}
+
+// reorg makes node, higher than the tip, the tip. It disconnects the blocks of the main
+// branch down to the fork with the branch of node, and connects those of that branch.
+// If one of them is not valid, it is forgotten with its descendants and the blocks of
+// the main branch are connected back.
+func (bc *BlockChain) reorg(node *blockNode) error {
+	var connected []*blockNode
+	fork := node
+	for fork.height > bc.tip.height {
+		connected = append(connected, fork)
+		fork = fork.parent
+	}
+	for old := bc.tip; fork != old; old = old.parent {
+		connected = append(connected, fork)
+		fork = fork.parent
+	}
+	slices.Reverse(connected)
+
+	var disconnected []*blockNode
+	// the transactions of the disconnected blocks, oldest first, coinbases included
+	var txs []*Transaction
+	for n := bc.tip; n != fork; n = n.parent {
+		undone, err := bc.handler.RollbackEpoch()
+		if err != nil {
+			// the History of the handler holds every epoch of the main branch
+			panic(err)
+		}
+		disconnected = append(disconnected, n)
+		txs = append(undone, txs...)
+	}
+
+	for idx, n := range connected {
+		if err := bc.connect(n.block); err != nil {
+			for range connected[:idx] {
+				bc.handler.RollbackEpoch()
+			}
+			for idx := len(disconnected) - 1; idx >= 0; idx-- {
+				bc.connect(disconnected[idx].block)
+			}
+			bc.forget(n)
+			return err
+		}
+	}
+	bc.tip = node
+	for _, n := range connected {
+		bc.mempool.Confirm(n.block.Txs)
+	}
+	bc.returnToMempool(txs)
+	return nil
+}
+
+// returnToMempool puts back in the mempool the transactions of disconnected blocks still
+// valid after a reorg, parents before children. Those spending outputs no longer there
+// are dropped, with the transactions of the mempool spending their outputs.
+func (bc *BlockChain) returnToMempool(txs []*Transaction) {
+	mp := bc.mempool
+	for _, tx := range txs {
+		switch {
+		case tx.IsCoinbase():
+		case bc.handler.History.GetTx(tx.Hash) != nil:
+			// in the new branch as well
+			continue
+		default:
+			// a copy relayed again while tx was confirmed waits as an orphan
+			mp.Remove(tx.Hash)
+			if err := mp.Add(tx); err == nil && !mp.IsOrphan(tx.Hash) {
+				continue
+			}
+			mp.Remove(tx.Hash)
+		}
+		for idx := range tx.Outputs {
+			if spender := mp.SpentBy(UTXO{TxHash: string(tx.Hash), Index: idx}); spender != nil {
+				mp.Remove(spender.Hash)
+			}
+		}
+	}
+}
+
+// forget drops node and its descendants from the tree.
+func (bc *BlockChain) forget(node *blockNode) {
+	for hash, n := range bc.blocks {
+		for ; n != nil && n.height >= node.height; n = n.parent {
+			if n == node {
+				delete(bc.blocks, hash)
+				break
+			}
+		}
+	}
+}
+
+// prune forgets the blocks below the lowest height a new block may extend, and those
+// not descending from the block of the main branch at that height. That block loses its
+// parent and the history its epochs below, so that neither grows with the chain.
+func (bc *BlockChain) prune() {
+	height := bc.tip.height - bc.CutOffAge
+	if height <= 0 {
+		return
+	}
+	root := bc.tip
+	for root.height > height {
+		root = root.parent
+	}
+	for hash, node := range bc.blocks {
+		for node.height > height {
+			node = node.parent
+		}
+		if node != root {
+			delete(bc.blocks, hash)
+		}
+	}
+	root.parent = nil
+	bc.handler.History.trim(height)
+}
+
+// Height returns the height of the tip, the genesis block being at height 0.
+func (bc *BlockChain) Height() int {
+	return bc.tip.height
+}
+
+func (bc *BlockChain) Tip() *Block {
+	return bc.tip.block
+}
+
+// Pool returns the UTXOs after the tip. It must not be changed.
+func (bc *BlockChain) Pool() *UTXOPool {
+	return bc.handler.Pool
+}
+
+// Block returns the block with the given hash, nil if it is unknown or was forgotten.
+func (bc *BlockChain) Block(hash []byte) *Block {
+	if node, ok := bc.blocks[string(hash)]; ok {
+		return node.block
+	}
+	return nil
+}
+
+// Difficulty returns the difficulty every block of the chain has.
+func (bc *BlockChain) Difficulty() int {
+	return bc.difficulty
+}
+
+// Mempool returns the transactions waiting for a block. Its RunEpoch must not be
+// called: blocks take the place of epochs.
+func (bc *BlockChain) Mempool() *Mempool {
+	return bc.mempool
+}
@@ -0,0 +1,403 @@
+package scrooge
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"math"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// testDifficulty keeps mining in the tests to a few hundred hashes.
+const testDifficulty = 8
+
+// newTestChain returns a chain whose genesis block pays the block reward to key.
+func newTestChain(t *testing.T) (*BlockChain, *rsa.PrivateKey) {
+	t.Helper()
+	key := cryptoutil.GetPrivateKey()
+	genesis := hMine(nil, key, BlockReward)
+	chain, err := NewBlockChain(genesis)
+	if err != nil {
+		t.Fatalf("NewBlockChain: %v", err)
+	}
+	return chain, key
+}
+
+func coinbaseOut(block *Block) UTXO {
+	return hOut(block.Coinbase, 0)
+}
+
+func TestBlockHandlerCreateBlock(t *testing.T) {
+	chain, key := newTestChain(t)
+	bh := NewBlockHandler(chain)
+	genesis := chain.Tip()
+
+	pay := hSpend(key, []UTXO{coinbaseOut(genesis)}, 20, 4)
+	change := hSpend(key, []UTXO{hOut(pay, 1)}, 3.5)
+	orphan := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 1)
+	for _, tx := range []*Transaction{pay, change, orphan} {
+		if err := bh.ProcessTx(tx); err != nil {
+			t.Fatalf("ProcessTx: %v", err)
+		}
+	}
+	block, err := bh.CreateBlock(key.PublicKey)
+	if err != nil {
+		t.Fatalf("CreateBlock: %v", err)
+	}
+	assertTxs(t, "block", block.Txs, pay, change)
+	if chain.Tip() != block || chain.Height() != 1 {
+		t.Fatalf("tip at height %v is not the new block", chain.Height())
+	}
+	if value := block.Coinbase.Outputs[0].Value; value != BlockReward+1.5 {
+		t.Fatalf("coinbase pays %v, expected the reward and 1.5 of fees", value)
+	}
+	if chain.Pool().Contains(coinbaseOut(genesis)) || !chain.Pool().Contains(hOut(change, 0)) ||
+		chain.Pool().Balance(cryptoutil.GetAddress(key.PublicKey)) != 20+3.5+BlockReward+1.5 {
+		t.Fatalf("pool after the block: %v", chain.Pool().GetAllUTXO())
+	}
+	if chain.Mempool().Len() != 1 || !chain.Mempool().IsOrphan(orphan.Hash) {
+		t.Fatalf("mempool holds %v transactions, expected the orphan", chain.Mempool().Len())
+	}
+	if chain.Pool().CreatedAt(coinbaseOut(block)) != 1 || chain.Pool().Epoch != 2 {
+		t.Fatalf("coinbase created at %v in a pool at epoch %v", chain.Pool().CreatedAt(coinbaseOut(block)), chain.Pool().Epoch)
+	}
+
+	bh.MaxBlockTxs = 1
+	rich := hSpend(key, []UTXO{coinbaseOut(block)}, 20)
+	poor := hSpend(key, []UTXO{hOut(pay, 0)}, 19.5)
+	bh.ProcessTx(poor)
+	bh.ProcessTx(rich)
+	block, err = bh.CreateBlock(key.PublicKey)
+	if err != nil {
+		t.Fatalf("CreateBlock: %v", err)
+	}
+	assertTxs(t, "block bounded to one transaction", block.Txs, rich)
+}
+
+func TestBlockChainInvalidBlocks(t *testing.T) {
+	chain, key := newTestChain(t)
+	genesis := chain.Tip()
+	pay := hSpend(key, []UTXO{coinbaseOut(genesis)}, 20)
+
+	forged := hSpend(key, []UTXO{coinbaseOut(genesis)}, 20)
+	forged.Hash = []byte("forged")
+	stranger := cryptoutil.GetPrivateKey()
+	stolen := hSpend(stranger, []UTXO{coinbaseOut(genesis)}, 20)
+	unmined := NewBlock(genesis.Hash, key.PublicKey, BlockReward, nil, testDifficulty)
+	for unmined.Finalize(); meetsDifficulty(unmined.Hash, testDifficulty); unmined.Finalize() {
+		unmined.Nonce++
+	}
+	tampered := hMine(genesis, key, BlockReward, pay)
+	tampered.Txs = nil
+	easy := NewBlock(genesis.Hash, key.PublicKey, BlockReward, nil, testDifficulty-1)
+	easy.Mine()
+	wrongParent := hMine(genesis, key, BlockReward)
+	wrongParent.Coinbase = NewCoinbase([]byte("elsewhere"), BlockReward, key.PublicKey)
+	wrongParent.Mine()
+	noCoinbase := hMine(genesis, key, BlockReward)
+	noCoinbase.Coinbase = hSpend(key, nil, BlockReward)
+	noCoinbase.Mine()
+	keyless := hMine(genesis, key, BlockReward)
+	keyless.Coinbase.Outputs[0].Type = PayToMultisig
+	keyless.Coinbase.Finalize()
+	keyless.Mine()
+
+	tests := []struct {
+		name  string
+		block *Block
+		err   error
+	}{
+		{"known", genesis, ErrBlockKnown},
+		{"tampered", tampered, ErrBlockBadHash},
+		{"insufficient work", unmined, ErrBlockWork},
+		{"other difficulty", easy, ErrBlockDifficulty},
+		{"unknown parent", hMine(&Block{Hash: []byte("unseen"), Difficulty: testDifficulty}, key, BlockReward), ErrBlockParent},
+		{"coinbase overpaying", hMine(genesis, key, BlockReward+5.5, pay), ErrBlockCoinbase},
+		{"NaN coinbase", hMine(genesis, key, math.NaN()), ErrBlockCoinbase},
+		{"infinite coinbase", hMine(genesis, key, math.Inf(1)), ErrBlockCoinbase},
+		{"coinbase output without co-signer", keyless, ErrBlockCoinbase},
+		{"coinbase of another parent", wrongParent, ErrBlockCoinbase},
+		{"coinbase without its input", noCoinbase, ErrBlockCoinbase},
+		{"double spend", hMine(genesis, key, BlockReward, pay, hSpend(key, []UTXO{coinbaseOut(genesis)}, 10)), ErrBlockTx},
+		{"child before parent", hMine(genesis, key, BlockReward, hSpend(key, []UTXO{hOut(pay, 0)}, 20), pay), ErrBlockTx},
+		{"forged hash", hMine(genesis, key, BlockReward, forged), ErrTxBadHash},
+		{"bad signature", hMine(genesis, key, BlockReward, stolen), ErrBlockTx},
+		{"coinbase among transactions", hMine(genesis, key, BlockReward, NewCoinbase(genesis.Hash, 1, key.PublicKey)), ErrBlockTx},
+	}
+	for _, test := range tests {
+		if err := chain.AddBlock(test.block); !errors.Is(err, test.err) {
+			t.Errorf("%v: AddBlock returned %v, expected %v", test.name, err, test.err)
+		}
+	}
+	if chain.Tip() != genesis || !chain.Pool().Contains(coinbaseOut(genesis)) || len(chain.Pool().H) != 1 {
+		t.Fatalf("invalid blocks changed the chain: height %v, pool %v", chain.Height(), chain.Pool().GetAllUTXO())
+	}
+	if err := chain.AddBlock(hMine(genesis, key, BlockReward, pay)); err != nil {
+		t.Fatalf("valid block: %v", err)
+	}
+}
+
+func TestBlockChainReorg(t *testing.T) {
+	chain, alice := newTestChain(t)
+	bob := cryptoutil.GetPrivateKey()
+	genesis := chain.Tip()
+	toBob := hPay(alice, []UTXO{coinbaseOut(genesis)}, []*rsa.PrivateKey{bob}, 25)
+	toAlice := hPay(alice, []UTXO{coinbaseOut(genesis)}, []*rsa.PrivateKey{alice}, 25)
+	unrelated := hSpend(bob, nil)
+	bh := NewBlockHandler(chain)
+	for _, tx := range []*Transaction{toBob, unrelated} {
+		if err := bh.ProcessTx(tx); err != nil {
+			t.Fatalf("ProcessTx: %v", err)
+		}
+	}
+
+	a1 := hMine(genesis, alice, BlockReward, toBob)
+	b1 := hMine(genesis, bob, BlockReward, toAlice)
+	b2 := hMine(b1, bob, BlockReward, unrelated)
+	for _, block := range []*Block{a1, b1} {
+		if err := bh.ProcessBlock(block); err != nil {
+			t.Fatalf("ProcessBlock: %v", err)
+		}
+	}
+	if chain.Tip() != a1 || !chain.Pool().Contains(hOut(toBob, 0)) || chain.Mempool().Len() != 1 {
+		t.Fatalf("a fork of the same height took the tip: %x", chain.Tip().Hash)
+	}
+
+	if err := bh.ProcessBlock(b2); err != nil {
+		t.Fatalf("ProcessBlock: %v", err)
+	}
+	if chain.Tip() != b2 || chain.Height() != 2 {
+		t.Fatalf("longer fork did not take the tip: %x at height %v", chain.Tip().Hash, chain.Height())
+	}
+	pool := chain.Pool()
+	if pool.Contains(hOut(toBob, 0)) || !pool.Contains(hOut(toAlice, 0)) || !pool.Contains(coinbaseOut(b2)) ||
+		pool.Contains(coinbaseOut(a1)) || pool.Balance(cryptoutil.GetAddress(alice.PublicKey)) != 25 {
+		t.Fatalf("pool after the reorg: %v", pool.GetAllUTXO())
+	}
+	if chain.Mempool().Get(unrelated.Hash) != nil {
+		t.Fatalf("transaction of the new branch left in the mempool")
+	}
+
+	// the old branch is still kept, and growing it back takes the tip back
+	a2 := hMine(a1, alice, BlockReward)
+	a3 := hMine(a2, alice, BlockReward)
+	for _, block := range []*Block{a2, a3} {
+		if err := bh.ProcessBlock(block); err != nil {
+			t.Fatalf("ProcessBlock: %v", err)
+		}
+	}
+	if chain.Tip() != a3 || !chain.Pool().Contains(hOut(toBob, 0)) || chain.Pool().Contains(hOut(toAlice, 0)) {
+		t.Fatalf("pool after the second reorg: %v", chain.Pool().GetAllUTXO())
+	}
+	if chain.Block(b1.Hash) != b1 {
+		t.Fatalf("fork above the cut-off forgotten")
+	}
+}
+
+func TestBlockChainCutOff(t *testing.T) {
+	chain, key := newTestChain(t)
+	chain.CutOffAge = 2
+	// the blocks of other differ from those CreateBlock mines for key on the same parent
+	other := cryptoutil.GetPrivateKey()
+	bh := NewBlockHandler(chain)
+	blocks := []*Block{chain.Tip()}
+	for height := 1; height <= 4; height++ {
+		block, err := bh.CreateBlock(key.PublicKey)
+		if err != nil {
+			t.Fatalf("CreateBlock: %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	if err := chain.AddBlock(hMine(blocks[1], other, BlockReward)); !errors.Is(err, ErrBlockParent) {
+		t.Fatalf("fork below the cut-off: AddBlock returned %v", err)
+	}
+	if chain.Block(blocks[1].Hash) != nil || chain.Block(blocks[2].Hash) != blocks[2] {
+		t.Fatalf("blocks below the cut-off kept or above it forgotten")
+	}
+	fork := hMine(blocks[2], other, BlockReward)
+	if err := chain.AddBlock(fork); err != nil {
+		t.Fatalf("fork at the cut-off: %v", err)
+	}
+	// a tip two blocks higher leaves fork below the cut-off
+	for height := 5; height <= 6; height++ {
+		if _, err := bh.CreateBlock(key.PublicKey); err != nil {
+			t.Fatalf("CreateBlock: %v", err)
+		}
+	}
+	if chain.Block(fork.Hash) != nil {
+		t.Fatalf("fork below the cut-off kept")
+	}
+	if err := chain.AddBlock(hMine(fork, key, BlockReward)); !errors.Is(err, ErrBlockParent) {
+		t.Fatalf("block on a pruned fork: AddBlock returned %v", err)
+	}
+}
+
+func TestBlockChainPrunesHistory(t *testing.T) {
+	chain, key := newTestChain(t)
+	chain.CutOffAge = 2
+	bh := NewBlockHandler(chain)
+	side := hMine(chain.Tip(), cryptoutil.GetPrivateKey(), BlockReward)
+	if err := chain.AddBlock(side); err != nil {
+		t.Fatal(err)
+	}
+	for height := 1; height <= 8; height++ {
+		if _, err := bh.CreateBlock(key.PublicKey); err != nil {
+			t.Fatalf("CreateBlock: %v", err)
+		}
+		// each block pays the coinbase before it back to key
+		tip := chain.Tip()
+		if err := chain.Mempool().Add(hSpend(key, []UTXO{coinbaseOut(tip)}, BlockReward)); err != nil {
+			t.Fatal(err)
+		}
+		// a side branch growing with the main one, from below the cut-off
+		side = hMine(side, cryptoutil.GetPrivateKey(), BlockReward)
+		chain.AddBlock(side)
+	}
+
+	depth := 0
+	for node := chain.tip; node != nil; node = node.parent {
+		depth++
+	}
+	if depth != chain.CutOffAge+1 {
+		t.Fatalf("tip reaches %v blocks", depth)
+	}
+	if len(chain.blocks) != chain.CutOffAge+1 || chain.Block(side.Hash) != nil {
+		t.Fatalf("%v blocks kept, side branch kept: %v", len(chain.blocks), chain.Block(side.Hash) != nil)
+	}
+	history := chain.handler.History
+	if len(history.epochs) != chain.CutOffAge+1 || history.GetTx(chain.Tip().Coinbase.Hash) == nil {
+		t.Fatalf("history holds %v epochs", len(history.epochs))
+	}
+	if len(history.entries) > 2*(chain.CutOffAge+1) {
+		t.Fatalf("history holds %v transactions", len(history.entries))
+	}
+}
+
+// branchCommitment returns the commitment of the pool of a chain made of genesis and blocks.
+func branchCommitment(t *testing.T, genesis *Block, blocks ...*Block) []byte {
+	t.Helper()
+	chain, err := NewBlockChain(genesis)
+	if err != nil {
+		t.Fatalf("NewBlockChain: %v", err)
+	}
+	for _, block := range blocks {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("AddBlock: %v", err)
+		}
+	}
+	return chain.Pool().Commitment()
+}
+
+func TestBlockChainBranchFlips(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	carol := cryptoutil.GetPrivateKey()
+	genesis := hMine(nil, alice, 20)
+	genesis.Coinbase.AddOutput(5, alice.PublicKey)
+	genesis.Coinbase.Finalize()
+	genesis.Mine()
+	chain, err := NewBlockChain(genesis)
+	if err != nil {
+		t.Fatalf("NewBlockChain: %v", err)
+	}
+	bh := NewBlockHandler(chain)
+
+	// payA and payB spend the same output, shared is valid on both branches and
+	// childA depends on payA
+	payA := hPay(alice, []UTXO{coinbaseOut(genesis)}, []*rsa.PrivateKey{bob}, 20)
+	payB := hPay(alice, []UTXO{coinbaseOut(genesis)}, []*rsa.PrivateKey{alice, carol}, 10, 10)
+	shared := hPay(alice, []UTXO{hOut(genesis.Coinbase, 1)}, []*rsa.PrivateKey{bob}, 5)
+	childA := hPay(bob, []UTXO{hOut(payA, 0)}, []*rsa.PrivateKey{carol}, 20)
+
+	a := []*Block{hMine(genesis, alice, BlockReward, payA, shared)}
+	a = append(a, hMine(a[0], alice, BlockReward, childA))
+	b := []*Block{hMine(genesis, bob, BlockReward, payB)}
+	spendA1Coinbase := hSpend(alice, []UTXO{coinbaseOut(a[0])}, 25)
+	for len(a) < 6 {
+		a = append(a, hMine(a[len(a)-1], alice, BlockReward))
+	}
+	for len(b) < 3 {
+		b = append(b, hMine(b[len(b)-1], bob, BlockReward))
+	}
+	b = append(b, hMine(b[2], bob, BlockReward, shared))
+	b = append(b, hMine(b[3], bob, BlockReward))
+
+	steps := []struct {
+		name    string
+		blocks  []*Block
+		tip     []*Block
+		mempool []*Transaction
+	}{
+		{"branch a", a[:2], a[:2], []*Transaction{spendA1Coinbase}},
+		{"to branch b", b[:3], b[:3], []*Transaction{shared}},
+		{"back to branch a", a[2:4], a[:4], nil},
+		{"to branch b again", b[3:5], b[:5], nil},
+		{"back to branch a again", a[4:6], a[:6], nil},
+	}
+	for idx, step := range steps {
+		for _, block := range step.blocks {
+			if err := bh.ProcessBlock(block); err != nil {
+				t.Fatalf("%v: ProcessBlock: %v", step.name, err)
+			}
+		}
+		if idx == 0 {
+			// dropped with the coinbase it spends by the first reorg
+			if err := bh.ProcessTx(spendA1Coinbase); err != nil {
+				t.Fatalf("ProcessTx: %v", err)
+			}
+		}
+		if chain.Tip() != step.tip[len(step.tip)-1] {
+			t.Fatalf("%v: tip at height %v is not the last block of the branch", step.name, chain.Height())
+		}
+		if commitment := branchCommitment(t, genesis, step.tip...); !bytes.Equal(chain.Pool().Commitment(), commitment) {
+			t.Fatalf("%v: pool commitment %x, expected %x", step.name, chain.Pool().Commitment(), commitment)
+		}
+		assertTxs(t, step.name+": mempool", chain.Mempool().Candidates(), step.mempool...)
+	}
+	if chain.Mempool().Len() != 0 {
+		t.Fatalf("%v transactions left in the mempool", chain.Mempool().Len())
+	}
+}
+
+func TestBlockChainInvalidFork(t *testing.T) {
+	chain, alice := newTestChain(t)
+	bob := cryptoutil.GetPrivateKey()
+	genesis := chain.Tip()
+	pay := hPay(alice, []UTXO{coinbaseOut(genesis)}, []*rsa.PrivateKey{bob}, 25)
+	stolen := hSpend(bob, []UTXO{coinbaseOut(genesis)}, 25)
+
+	main := []*Block{hMine(genesis, alice, BlockReward, pay)}
+	main = append(main, hMine(main[0], alice, BlockReward))
+	valid := hMine(genesis, bob, BlockReward)
+	invalid := hMine(valid, bob, BlockReward, stolen)
+	above := hMine(invalid, bob, BlockReward)
+	for _, block := range []*Block{main[0], main[1], valid, invalid} {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("blocks not above the tip are only checked on their own: %v", err)
+		}
+	}
+	commitment := chain.Pool().Commitment()
+	if err := chain.AddBlock(above); !errors.Is(err, ErrBlockTx) {
+		t.Fatalf("reorg to an invalid branch: AddBlock returned %v", err)
+	}
+	if chain.Tip() != main[1] || !bytes.Equal(chain.Pool().Commitment(), commitment) {
+		t.Fatalf("failed reorg left the chain at height %v", chain.Height())
+	}
+	if chain.Block(invalid.Hash) != nil || chain.Block(above.Hash) != nil || chain.Block(valid.Hash) != valid {
+		t.Fatalf("failed reorg kept the invalid block or forgot its valid parent")
+	}
+
+	fork := []*Block{valid, hMine(valid, bob, BlockReward)}
+	fork = append(fork, hMine(fork[1], bob, BlockReward))
+	for _, block := range fork[1:] {
+		if err := chain.AddBlock(block); err != nil {
+			t.Fatalf("valid block on the valid part of the fork: %v", err)
+		}
+	}
+	if chain.Tip() != fork[2] || chain.Pool().Contains(hOut(pay, 0)) || chain.Mempool().Get(pay.Hash) == nil {
+		t.Fatalf("reorg to the valid fork: height %v", chain.Height())
+	}
+}
@@ -0,0 +1,46 @@
+package scrooge
+
+import "crypto/rsa"
+
+// BlockHandler is the interface of a miner to its BlockChain: it passes on the blocks
+// and transactions received from the network, and builds and mines new blocks.
+type BlockHandler struct {
+	// MaxBlockBytes and MaxBlockTxs bound the blocks built by CreateBlock as
+	// TxHandler.MaxEpochBytes and MaxEpochTxs bound an epoch. Zero means no bound.
+	MaxBlockBytes int
+	MaxBlockTxs   int
+
+	chain *BlockChain
+}
+
+func NewBlockHandler(chain *BlockChain) *BlockHandler {
+	return &BlockHandler{chain: chain}
+}
+
+// ProcessBlock adds block to the chain, see BlockChain.AddBlock.
+func (bh *BlockHandler) ProcessBlock(block *Block) error {
+	return bh.chain.AddBlock(block)
+}
+
+// ProcessTx adds tx to the mempool of the chain, to be included in a later block.
+func (bh *BlockHandler) ProcessTx(tx *Transaction) error {
+	return bh.chain.mempool.Add(tx)
+}
+
+// CreateBlock builds a block on the tip with the candidates of the mempool that are
+// valid there, selected by fee rate if they exceed the bounds of bh, and paying the
+// block reward and their fees to address. It mines the block and adds it to the chain.
+func (bh *BlockHandler) CreateBlock(address rsa.PublicKey) (*Block, error) {
+	handler := NewTxHandler(bh.chain.Pool().Copy())
+	handler.MaxEpochBytes = bh.MaxBlockBytes
+	handler.MaxEpochTxs = bh.MaxBlockTxs
+	handler.History = NewTxHistory()
+	txs := handler.HandleTxs(bh.chain.mempool.Candidates())
+
+	block := NewBlock(bh.chain.Tip().Hash, address, BlockReward+blockFees(handler.History, txs), txs, bh.chain.difficulty)
+	block.Mine()
+	if err := bh.chain.AddBlock(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,248 @@
+package scrooge
+
+import "container/heap"
+
+// candidateState is the fate of a proposed transaction in selectByFeeRate.
+type candidateState int
+
+const (
+	candidatePending candidateState = iota
+	candidateAccepted
+	candidateRejected
+	candidateDeferred
+)
+
+// candidate is a transaction proposed to HandleTxs when the proposals exceed the epoch
+// budget. Its parents are the proposed transactions whose outputs it spends, and its
+// children those spending its outputs.
+type candidate struct {
+	tx       *Transaction
+	fee      float64
+	size     int
+	parents  []*candidate
+	children []*candidate
+	state    candidateState
+	// rate is the fee rate of the package c was accepted or deferred with.
+	rate float64
+	// index is the position of c among the proposals, which breaks ties.
+	index int
+	// version counts the times c was queued, so that stale queue entries are skipped.
+	version int
+}
+
+// pkg returns c preceded by its ancestors that are not accepted yet, parents before
+// children. If one of them is rejected or deferred, it returns the state c must take
+// instead, rejection first.
+func (c *candidate) pkg() ([]*candidate, candidateState) {
+	var pkg []*candidate
+	fate := candidatePending
+	seen := make(map[*candidate]bool)
+	var visit func(*candidate)
+	visit = func(c *candidate) {
+		if seen[c] {
+			return
+		}
+		seen[c] = true
+		switch c.state {
+		case candidateAccepted:
+			return
+		case candidateRejected:
+			fate = candidateRejected
+			return
+		case candidateDeferred:
+			if fate == candidatePending {
+				fate = candidateDeferred
+			}
+			return
+		}
+		for _, parent := range c.parents {
+			visit(parent)
+		}
+		pkg = append(pkg, c)
+	}
+	visit(c)
+	return pkg, fate
+}
+
+// exclude gives c the state fate, which an ancestor of c has, with its own fee rate.
+func (c *candidate) exclude(fate candidateState) {
+	c.state = fate
+	c.rate = c.fee / float64(c.size)
+}
+
+// queuedPackage is the package of a candidate with its fee and size when queued.
+type queuedPackage struct {
+	c       *candidate
+	fee     float64
+	size    int
+	version int
+}
+
+// packageQueue is a max-heap of packages by fee rate.
+type packageQueue []queuedPackage
+
+func (q packageQueue) Len() int { return len(q) }
+
+// Less compares fee rates without dividing, ties going to the earlier proposal.
+func (q packageQueue) Less(i, j int) bool {
+	a, b := q[i].fee*float64(q[j].size), q[j].fee*float64(q[i].size)
+	return a > b || a == b && q[i].c.index < q[j].c.index
+}
+
+func (q packageQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *packageQueue) Push(x any)   { *q = append(*q, x.(queuedPackage)) }
+
+func (q *packageQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// queue pushes the package of c, unless c cannot be packaged and takes another state.
+func (q *packageQueue) queue(c *candidate) {
+	pkg, fate := c.pkg()
+	if fate != candidatePending {
+		c.exclude(fate)
+		return
+	}
+	c.version++
+	entry := queuedPackage{c: c, version: c.version}
+	for _, member := range pkg {
+		entry.fee += member.fee
+		entry.size += member.size
+	}
+	heap.Push(q, entry)
+}
+
+// descendants adds the pending descendants of c to set.
+func (c *candidate) descendants(set map[*candidate]bool) {
+	for _, child := range c.children {
+		if child.state == candidatePending && !set[child] {
+			set[child] = true
+			child.descendants(set)
+		}
+	}
+}
+
+// fits tells whether bytes and count are within the epoch budget.
+func (handler *TxHandler) fits(bytes, count int) bool {
+	return (handler.MaxEpochBytes <= 0 || bytes <= handler.MaxEpochBytes) &&
+		(handler.MaxEpochTxs <= 0 || count <= handler.MaxEpochTxs)
+}
+
+func (handler *TxHandler) withinBudget(txs []*Transaction) bool {
+	if handler.MaxEpochBytes <= 0 {
+		return handler.fits(0, len(txs))
+	}
+	size := 0
+	for _, tx := range txs {
+		size += tx.Size()
+	}
+	return handler.fits(size, len(txs))
+}
+
+// selectByFeeRate accepts transactions from txs within the epoch budget, highest fee per
+// byte first, and returns them with the fees they pay. Transactions are scored as
+// packages, together with their ancestors in txs that are not accepted yet, so a child
+// paying a high fee pulls in a parent paying little. The packages wait in a queue by fee
+// rate, and those of the descendants of accepted transactions are queued again. A
+// package that does not fit in the room left is deferred, as are its descendants; a
+// transaction with a rejected ancestor is rejected.
+func (handler *TxHandler) selectByFeeRate(txs []*Transaction) ([]*Transaction, float64) {
+	candidates := handler.candidates(txs)
+	queue := make(packageQueue, 0, len(candidates))
+	for _, c := range candidates {
+		if c.state == candidatePending {
+			queue.queue(c)
+		}
+	}
+	var accepted []*Transaction
+	var usedBytes int
+	for queue.Len() > 0 {
+		best := heap.Pop(&queue).(queuedPackage)
+		c := best.c
+		if c.state != candidatePending || best.version != c.version {
+			continue
+		}
+		pkg, fate := c.pkg()
+		if fate != candidatePending {
+			c.exclude(fate)
+			continue
+		}
+		rate := best.fee / float64(best.size)
+		if !handler.fits(usedBytes+best.size, len(accepted)+len(pkg)) {
+			c.state = candidateDeferred
+			c.rate = rate
+			continue
+		}
+		rescore := make(map[*candidate]bool)
+		for _, member := range pkg {
+			if !handler.IsValidTx(member.tx) {
+				member.state = candidateRejected
+				continue
+			}
+			handler.apply(member.tx)
+			member.state = candidateAccepted
+			member.rate = rate
+			usedBytes += member.size
+			accepted = append(accepted, member.tx)
+			member.descendants(rescore)
+		}
+		// the packages of the descendants lost the accepted members
+		for d := range rescore {
+			if d.state == candidatePending {
+				queue.queue(d)
+			}
+		}
+	}
+	var acceptedRates, deferredRates []float64
+	var fees float64
+	for _, c := range candidates {
+		switch c.state {
+		case candidateAccepted:
+			fees += c.fee
+			acceptedRates = append(acceptedRates, c.rate)
+		case candidateDeferred:
+			handler.deferred = append(handler.deferred, c.tx)
+			deferredRates = append(deferredRates, c.rate)
+		}
+	}
+	handler.recordFees(acceptedRates, deferredRates)
+	return accepted, fees
+}
+
+// candidates prices txs against the pool and links each to its parents in txs. A
+// non-standard transaction, or one spending an output found in neither, is rejected
+// from the start.
+func (handler *TxHandler) candidates(txs []*Transaction) []*candidate {
+	candidates := make([]*candidate, len(txs))
+	byHash := make(map[string]*candidate, len(txs))
+	for idx, tx := range txs {
+		candidates[idx] = &candidate{tx: tx, size: tx.Size(), index: idx}
+		if _, ok := byHash[string(tx.Hash)]; !ok {
+			byHash[string(tx.Hash)] = candidates[idx]
+		}
+	}
+	for _, c := range candidates {
+		if !handler.isStandard(c.tx) {
+			c.state = candidateRejected
+		}
+		for _, in := range c.tx.Inputs {
+			utxo := UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}
+			if out := handler.Pool.GetTxOutput(utxo); out != nil {
+				c.fee += out.Value
+			} else if parent, ok := byHash[utxo.TxHash]; ok && utxo.Index >= 0 && utxo.Index < parent.tx.NumOutputs() {
+				c.fee += parent.tx.Outputs[utxo.Index].Value
+				c.parents = append(c.parents, parent)
+				parent.children = append(parent.children, c)
+			} else {
+				c.state = candidateRejected
+			}
+		}
+		for _, out := range c.tx.Outputs {
+			c.fee -= out.Value
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,136 @@
+package scrooge
+
+import (
+	"crypto/rsa"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// newBudgetHandler returns a handler for a pool holding count outputs of 10 paid to
+// key, txhash#1:0 onwards.
+func newBudgetHandler(count int) (*TxHandler, *rsa.PrivateKey) {
+	key := cryptoutil.GetPrivateKey()
+	pool := NewUTXOPool()
+	for idx := 0; idx < count; idx++ {
+		pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: idx}, &TOutput{Value: 10, Address: key.PublicKey})
+	}
+	return NewTxHandler(pool), key
+}
+
+func TestTransactionSizeAndFee(t *testing.T) {
+	handler, key := newBudgetHandler(2)
+	tx := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}, {TxHash: "txhash#1", Index: 1}}, 12, 5.5)
+	if tx.Size() != len(tx.GetRawTx()) {
+		t.Fatalf("size %v, raw transaction of %v bytes", tx.Size(), len(tx.GetRawTx()))
+	}
+	fee, err := tx.Fee(handler.Pool)
+	if err != nil || fee != 2.5 {
+		t.Fatalf("fee %v, %v", fee, err)
+	}
+	child := hSpend(key, []UTXO{hOut(tx, 0)}, 12)
+	if _, err := child.Fee(handler.Pool); err != ErrTxUnknownInput {
+		t.Fatalf("fee of a transaction spending outside the pool: %v", err)
+	}
+}
+
+func TestHandleTxsWithinBudget(t *testing.T) {
+	handler, key := newBudgetHandler(2)
+	handler.MaxEpochTxs = 2
+	// in budget, the proposal order is kept even though it is not by fee rate
+	cheap := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 10)
+	dear := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 5)
+	assertTxs(t, "accepted", handler.HandleTxs([]*Transaction{cheap, dear}), cheap, dear)
+	assertTxs(t, "deferred", handler.Deferred())
+}
+
+func TestHandleTxsByFeeRate(t *testing.T) {
+	handler, key := newBudgetHandler(4)
+	handler.MaxEpochTxs = 2
+	fee1 := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+	fee3 := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 7)
+	fee2 := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 2}}, 8)
+	forged := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 3}}, 1)
+	forged.Inputs[0].Signature[0] ^= 1
+
+	accepted := handler.HandleTxs([]*Transaction{fee1, fee3, fee2, forged})
+	// forged pays the most but is invalid, it takes no room
+	assertTxs(t, "accepted", accepted, fee3, fee2)
+	assertTxs(t, "deferred", handler.Deferred(), fee1)
+	if handler.Pool.Contains(UTXO{TxHash: "txhash#1", Index: 1}) || !handler.Pool.Contains(hOut(fee2, 0)) {
+		t.Fatal("pool not updated with the accepted transactions")
+	}
+}
+
+func TestHandleTxsByteBudget(t *testing.T) {
+	handler, key := newBudgetHandler(3)
+	small := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+	large := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 1, 1, 1)
+	other := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 2}}, 9.5)
+	handler.MaxEpochBytes = large.Size() + small.Size() - 1
+
+	// large pays the most per byte, after it neither of the others fits
+	assertTxs(t, "accepted", handler.HandleTxs([]*Transaction{other, small, large}), large)
+	assertTxs(t, "deferred", handler.Deferred(), other, small)
+}
+
+func TestHandleTxsChildPaysForParent(t *testing.T) {
+	handler, key := newBudgetHandler(2)
+	handler.MaxEpochTxs = 2
+	parent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 10)
+	child := hSpend(key, []UTXO{hOut(parent, 0)}, 5)
+	other := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 9)
+
+	// alone, parent pays nothing and other would go first
+	accepted := handler.HandleTxs([]*Transaction{child, other, parent})
+	assertTxs(t, "accepted", accepted, parent, child)
+	assertTxs(t, "deferred", handler.Deferred(), other)
+}
+
+func TestHandleTxsDefersDescendants(t *testing.T) {
+	handler, key := newBudgetHandler(2)
+	handler.MaxEpochTxs = 1
+	parent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+	child := hSpend(key, []UTXO{hOut(parent, 0)}, 8)
+	other := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 7)
+
+	assertTxs(t, "accepted", handler.HandleTxs([]*Transaction{parent, child, other}), other)
+	assertTxs(t, "deferred", handler.Deferred(), parent, child)
+}
+
+func TestMempoolKeepsDeferred(t *testing.T) {
+	mp, key := newTestMempool(0)
+	mp.handler.MaxEpochTxs = 1
+	low := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9.5)
+	high := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 3)
+	for _, tx := range []*Transaction{low, high} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	accepted, evicted := mp.RunEpoch()
+	assertTxs(t, "accepted in the first epoch", accepted, high)
+	assertTxs(t, "evicted in the first epoch", evicted)
+	assertTxs(t, "candidates", mp.Candidates(), low)
+
+	accepted, _ = mp.RunEpoch()
+	assertTxs(t, "accepted in the second epoch", accepted, low)
+	if mp.Len() != 0 {
+		t.Fatalf("%v transactions left", mp.Len())
+	}
+}
+
+func TestHandleTxsRescoresAfterAncestorAccepted(t *testing.T) {
+	handler, key := newBudgetHandler(2)
+	handler.MaxEpochTxs = 3
+	parent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 5, 5)
+	rich := hSpend(key, []UTXO{hOut(parent, 0)}, 0)
+	poor := hSpend(key, []UTXO{hOut(parent, 1)}, 2)
+	other := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 8.5)
+
+	// with parent, poor pays less than other, and more once parent is accepted with rich
+	accepted := handler.HandleTxs([]*Transaction{other, poor, rich, parent})
+	assertTxs(t, "accepted", accepted, parent, rich, poor)
+	assertTxs(t, "deferred", handler.Deferred(), other)
+}
@@ -0,0 +1,226 @@
+package scrooge
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+
+	"scrooge/cryptoutil"
+)
+
+var (
+	ErrBadCommittee       = errors.New("scrooge: committee threshold out of range or key repeated")
+	ErrHeaderSignature    = errors.New("scrooge: epoch header signature does not verify")
+	ErrHeaderUnknownKey   = errors.New("scrooge: epoch header signed by a key outside the committee")
+	ErrHeaderDuplicateKey = errors.New("scrooge: epoch header signed twice by the same key")
+	ErrHeaderThreshold    = errors.New("scrooge: epoch header signed by fewer keys than the committee threshold")
+	ErrHeaderChain        = errors.New("scrooge: epoch header does not extend the header chain")
+)
+
+// headerSigningTag prefixes the header hash in signatures, so that a signature on a
+// header is never one on anything else the committee keys sign.
+var headerSigningTag = []byte("scrooge epoch header\x00")
+
+// Committee is a set of Scrooge keys, Threshold of which must sign each epoch header.
+type Committee struct {
+	Keys      []ed25519.PublicKey
+	Threshold int
+}
+
+// NewCommittee returns the committee of keys signing with threshold of them.
+func NewCommittee(threshold int, keys ...ed25519.PublicKey) (*Committee, error) {
+	committee := &Committee{Keys: keys, Threshold: threshold}
+	if err := committee.check(); err != nil {
+		return nil, err
+	}
+	return committee, nil
+}
+
+// check tells whether the threshold is between 1 and the number of keys, and the keys
+// are well formed and distinct.
+func (committee *Committee) check() error {
+	if committee.Threshold < 1 || committee.Threshold > len(committee.Keys) {
+		return ErrBadCommittee
+	}
+	for idx, key := range committee.Keys {
+		if len(key) != ed25519.PublicKeySize || committee.index(key) != idx {
+			return ErrBadCommittee
+		}
+	}
+	return nil
+}
+
+// index returns the position of key in the committee, or -1.
+func (committee *Committee) index(key ed25519.PublicKey) int {
+	for idx, member := range committee.Keys {
+		if bytes.Equal(member, key) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Hash returns a hash of the threshold and keys of committee, in their order.
+func (committee *Committee) Hash() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int32(committee.Threshold))
+	for _, key := range committee.Keys {
+		buf.Write(key)
+	}
+	return cryptoutil.HashSha256(buf.Bytes())
+}
+
+// EpochHeader sums up an epoch for those who do not hold the UTXOPool: the
+// transactions it accepted, the pool it left, and the committee signing from the next
+// epoch on when it rotates.
+type EpochHeader struct {
+	Epoch    int
+	PrevHash []byte
+	// TxRoot is the MerkleRoot of the hashes of the accepted transactions, in order.
+	TxRoot         []byte
+	PoolCommitment []byte
+	// NextCommittee replaces the committee for the headers after this one. It is nil
+	// while the committee stays.
+	NextCommittee *Committee
+}
+
+// NewEpochHeader returns the header of the epoch following prev, or of epoch 0 if prev
+// is nil, that accepted txs and left pool. The committee is handed over to next unless
+// it is nil.
+func NewEpochHeader(prev *EpochHeader, pool *UTXOPool, txs []*Transaction, next *Committee) *EpochHeader {
+	hashes := make([][]byte, len(txs))
+	for idx, tx := range txs {
+		hashes[idx] = tx.Hash
+	}
+	header := &EpochHeader{TxRoot: MerkleRoot(hashes), PoolCommitment: pool.Commitment(), NextCommittee: next}
+	if prev != nil {
+		header.Epoch = prev.Epoch + 1
+		header.PrevHash = prev.Hash()
+	}
+	return header
+}
+
+// Hash returns the hash of header, which its signatures cover.
+func (header *EpochHeader) Hash() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int64(header.Epoch))
+	for _, field := range [][]byte{header.PrevHash, header.TxRoot, header.PoolCommitment} {
+		binary.Write(&buf, binary.BigEndian, int32(len(field)))
+		buf.Write(field)
+	}
+	if header.NextCommittee != nil {
+		buf.WriteByte(1)
+		buf.Write(header.NextCommittee.Hash())
+	} else {
+		buf.WriteByte(0)
+	}
+	return cryptoutil.HashSha256(buf.Bytes())
+}
+
+// HeaderSignature is the signature of one committee key on an epoch header.
+type HeaderSignature struct {
+	Key       ed25519.PublicKey
+	Signature []byte
+}
+
+// SignHeader returns the signature of key on header.
+func SignHeader(header *EpochHeader, key ed25519.PrivateKey) HeaderSignature {
+	return HeaderSignature{
+		Key:       key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, append(append([]byte{}, headerSigningTag...), header.Hash()...)),
+	}
+}
+
+// VerifyHeader tells whether sigs hold valid signatures on header by at least the
+// threshold of distinct keys of committee. Any signature by an outside key, a repeated
+// key or not verifying fails the header, even if the others would be enough.
+func VerifyHeader(header *EpochHeader, sigs []HeaderSignature, committee *Committee) error {
+	message := append(append([]byte{}, headerSigningTag...), header.Hash()...)
+	signed := make(map[int]bool)
+	for _, sig := range sigs {
+		idx := committee.index(sig.Key)
+		if idx < 0 {
+			return ErrHeaderUnknownKey
+		}
+		if signed[idx] {
+			return ErrHeaderDuplicateKey
+		}
+		if !ed25519.Verify(sig.Key, message, sig.Signature) {
+			return ErrHeaderSignature
+		}
+		signed[idx] = true
+	}
+	if len(signed) < committee.Threshold {
+		return ErrHeaderThreshold
+	}
+	return nil
+}
+
+// SignedHeader is an epoch header with the committee signatures on it.
+type SignedHeader struct {
+	Header     *EpochHeader
+	Signatures []HeaderSignature
+}
+
+// HeaderChain holds the signed headers from epoch 0 on, each signed by the committee
+// in charge of it: the one trusted at the start, until a header hands over to the next.
+type HeaderChain struct {
+	headers   []SignedHeader
+	committee *Committee
+}
+
+// NewHeaderChain returns an empty chain trusting committee to sign epoch 0.
+func NewHeaderChain(committee *Committee) *HeaderChain {
+	return &HeaderChain{committee: committee}
+}
+
+// Append adds header to the chain if it follows the last header and is signed by the
+// committee in charge. A NextCommittee in it takes charge of the headers after it.
+func (chain *HeaderChain) Append(header *EpochHeader, sigs []HeaderSignature) error {
+	if header.Epoch != len(chain.headers) {
+		return ErrHeaderChain
+	}
+	if tip := chain.Tip(); tip == nil && header.PrevHash != nil || tip != nil && !bytes.Equal(header.PrevHash, tip.Hash()) {
+		return ErrHeaderChain
+	}
+	if header.NextCommittee != nil {
+		if err := header.NextCommittee.check(); err != nil {
+			return err
+		}
+	}
+	if err := VerifyHeader(header, sigs, chain.committee); err != nil {
+		return err
+	}
+	chain.headers = append(chain.headers, SignedHeader{Header: header, Signatures: sigs})
+	if header.NextCommittee != nil {
+		chain.committee = header.NextCommittee
+	}
+	return nil
+}
+
+// Height returns the number of headers in the chain.
+func (chain *HeaderChain) Height() int {
+	return len(chain.headers)
+}
+
+// Tip returns the last header, or nil if there is none.
+func (chain *HeaderChain) Tip() *EpochHeader {
+	if len(chain.headers) == 0 {
+		return nil
+	}
+	return chain.headers[len(chain.headers)-1].Header
+}
+
+// Header returns the signed header of epoch, or nil if the chain does not hold it.
+func (chain *HeaderChain) Header(epoch int) *SignedHeader {
+	if epoch < 0 || epoch >= len(chain.headers) {
+		return nil
+	}
+	return &chain.headers[epoch]
+}
+
+// Committee returns the committee in charge of signing the next header.
+func (chain *HeaderChain) Committee() *Committee {
+	return chain.committee
+}
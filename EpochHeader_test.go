@@ -0,0 +1,144 @@
+package scrooge
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// hCommittee returns a committee of n fresh keys signing with threshold of them, and
+// its private keys.
+func hCommittee(t *testing.T, threshold, n int) (*Committee, []ed25519.PrivateKey) {
+	t.Helper()
+	keys := make([]ed25519.PublicKey, n)
+	privates := make([]ed25519.PrivateKey, n)
+	for idx := range keys {
+		public, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[idx], privates[idx] = public, private
+	}
+	committee, err := NewCommittee(threshold, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return committee, privates
+}
+
+func hSignHeader(header *EpochHeader, keys ...ed25519.PrivateKey) []HeaderSignature {
+	sigs := make([]HeaderSignature, len(keys))
+	for idx, key := range keys {
+		sigs[idx] = SignHeader(header, key)
+	}
+	return sigs
+}
+
+func TestNewCommittee(t *testing.T) {
+	committee, _ := hCommittee(t, 2, 3)
+	keys := committee.Keys
+	for _, c := range []struct {
+		threshold int
+		keys      []ed25519.PublicKey
+	}{
+		{0, keys},
+		{4, keys},
+		{2, []ed25519.PublicKey{keys[0], keys[1], keys[0]}},
+		{1, []ed25519.PublicKey{keys[0][:10]}},
+	} {
+		if _, err := NewCommittee(c.threshold, c.keys...); !errors.Is(err, ErrBadCommittee) {
+			t.Fatalf("committee of %v keys with threshold %v: %v", len(c.keys), c.threshold, err)
+		}
+	}
+}
+
+func TestVerifyHeader(t *testing.T) {
+	committee, keys := hCommittee(t, 3, 4)
+	_, outsider := hCommittee(t, 1, 1)
+	header := NewEpochHeader(nil, NewUTXOPool(), nil, nil)
+	other := NewEpochHeader(header, NewUTXOPool(), nil, nil)
+	forged := hSignHeader(header, keys[:3]...)
+	forged[1] = SignHeader(other, keys[1])
+
+	for _, c := range []struct {
+		what string
+		sigs []HeaderSignature
+		err  error
+	}{
+		{"threshold", hSignHeader(header, keys[1:]...), nil},
+		{"all keys", hSignHeader(header, keys...), nil},
+		{"insufficient", hSignHeader(header, keys[:2]...), ErrHeaderThreshold},
+		{"none", nil, ErrHeaderThreshold},
+		{"duplicate", hSignHeader(header, keys[0], keys[1], keys[0]), ErrHeaderDuplicateKey},
+		{"unknown key", hSignHeader(header, keys[0], keys[1], outsider[0], keys[2]), ErrHeaderUnknownKey},
+		{"other header", forged, ErrHeaderSignature},
+	} {
+		if err := VerifyHeader(header, c.sigs, committee); err != c.err {
+			t.Fatalf("%v: %v, expected %v", c.what, err, c.err)
+		}
+	}
+
+	sigs := hSignHeader(header, keys...)
+	header.TxRoot = MerkleRoot([][]byte{[]byte("tampered")})
+	if err := VerifyHeader(header, sigs, committee); err != ErrHeaderSignature {
+		t.Fatalf("tampered header: %v", err)
+	}
+}
+
+func TestHeaderChainRotation(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool := NewUTXOPool()
+	pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: key.PublicKey})
+	handler := NewTxHandler(pool)
+	first, firstKeys := hCommittee(t, 2, 3)
+	second, secondKeys := hCommittee(t, 3, 4)
+	chain := NewHeaderChain(first)
+
+	// epoch 0 hands the committee over to the second one
+	tx := hPay(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, []*rsa.PrivateKey{key}, 9)
+	genesis := NewEpochHeader(nil, handler.Pool, handler.HandleTxs([]*Transaction{tx}), second)
+	if !bytes.Equal(genesis.TxRoot, MerkleRoot([][]byte{tx.Hash})) {
+		t.Fatalf("header does not commit to the accepted transaction")
+	}
+	if err := chain.Append(genesis, hSignHeader(genesis, secondKeys[:3]...)); err != ErrHeaderUnknownKey {
+		t.Fatalf("epoch 0 signed by the next committee: %v", err)
+	}
+	if err := chain.Append(genesis, hSignHeader(genesis, firstKeys[:2]...)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.Committee().Hash(), second.Hash()) {
+		t.Fatalf("committee not rotated")
+	}
+
+	handler.HandleTxs(nil)
+	header := NewEpochHeader(genesis, handler.Pool, nil, nil)
+	if err := chain.Append(header, hSignHeader(header, firstKeys...)); err != ErrHeaderUnknownKey {
+		t.Fatalf("epoch 1 signed by the retired committee: %v", err)
+	}
+	if err := chain.Append(header, hSignHeader(header, secondKeys[:2]...)); err != ErrHeaderThreshold {
+		t.Fatalf("epoch 1 below the new threshold: %v", err)
+	}
+	unlinked := NewEpochHeader(nil, handler.Pool, nil, nil)
+	unlinked.Epoch = 1
+	if err := chain.Append(unlinked, hSignHeader(unlinked, secondKeys...)); err != ErrHeaderChain {
+		t.Fatalf("header not linked to the tip: %v", err)
+	}
+	if err := chain.Append(header, hSignHeader(header, secondKeys[1:]...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Append(header, hSignHeader(header, secondKeys...)); err != ErrHeaderChain {
+		t.Fatalf("header appended twice: %v", err)
+	}
+
+	bad := NewEpochHeader(header, handler.Pool, nil, &Committee{Threshold: 2, Keys: first.Keys[:1]})
+	if err := chain.Append(bad, hSignHeader(bad, secondKeys...)); err != ErrBadCommittee {
+		t.Fatalf("rotation to a malformed committee: %v", err)
+	}
+	if chain.Height() != 2 || chain.Header(0).Header != genesis || chain.Tip() != header || chain.Header(2) != nil {
+		t.Fatalf("chain of %v headers", chain.Height())
+	}
+}
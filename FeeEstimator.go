@@ -0,0 +1,140 @@
+package scrooge
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultFeeWindow is the number of epochs a new FeeEstimator remembers.
+	DefaultFeeWindow = 100
+	// DefaultFeeConfidence is the confidence a new FeeEstimator aims for.
+	DefaultFeeConfidence = 0.9
+)
+
+var (
+	ErrNoFeeHistory = errors.New("scrooge: no fee history to estimate from")
+	ErrFeeTarget    = errors.New("scrooge: fee target must be at least one epoch")
+)
+
+// EpochFees are the fee rates, in value per byte, of the transactions HandleTxs accepted
+// in an epoch and of those it deferred for lack of room in the epoch budget. A
+// transaction accepted or deferred with ancestors has the rate of the whole package.
+type EpochFees struct {
+	Epoch    int
+	Accepted []float64
+	Deferred []float64
+}
+
+// threshold is the lowest fee rate that would have got a transaction into the epoch:
+// any when nothing was deferred, otherwise that of the cheapest accepted transaction.
+func (fees EpochFees) threshold() float64 {
+	if len(fees.Deferred) == 0 {
+		return 0
+	}
+	if len(fees.Accepted) == 0 {
+		// not even one package fit, outbidding the deferred ones is the best guess
+		return maxFloat(fees.Deferred)
+	}
+	return minFloat(fees.Accepted)
+}
+
+// FeeEstimate is the answer of EstimateFee: paying Rate per byte, a transaction has
+// been accepted within the target number of epochs with probability Confidence over
+// the last Epochs epochs.
+type FeeEstimate struct {
+	Rate       float64
+	Confidence float64
+	Epochs     int
+}
+
+// FeeEstimator keeps the fee rates of the last Window epochs, as recorded by a
+// TxHandler whose Fees it is, and estimates from them the fee rate that gets a
+// transaction accepted soon.
+//
+// Each past epoch is reduced to its threshold, the lowest rate it accepted while it
+// deferred others, and a rate is taken to be accepted in any epoch with probability the
+// share of past epochs whose threshold it meets. Epochs are assumed independent.
+type FeeEstimator struct {
+	// Window is the number of epochs kept.
+	Window int
+	// Confidence is the probability EstimateFee aims for.
+	Confidence float64
+
+	history []EpochFees
+}
+
+func NewFeeEstimator() *FeeEstimator {
+	return &FeeEstimator{Window: DefaultFeeWindow, Confidence: DefaultFeeConfidence}
+}
+
+// Record adds the fee rates of an epoch, dropping the oldest epochs beyond Window.
+func (est *FeeEstimator) Record(fees EpochFees) {
+	est.history = append(est.history, fees)
+	est.trim()
+}
+
+func (est *FeeEstimator) trim() {
+	if est.Window > 0 && len(est.history) > est.Window {
+		est.history = append([]EpochFees(nil), est.history[len(est.history)-est.Window:]...)
+	}
+}
+
+// History returns the recorded epochs, oldest first.
+func (est *FeeEstimator) History() []EpochFees {
+	return est.history
+}
+
+// EstimateFee returns the lowest fee rate that gets a transaction accepted within
+// targetEpochs epochs with at least the Confidence of est, and the confidence it
+// reaches. The highest threshold seen is always accepted, so an estimate is found
+// whenever there is history.
+func (est *FeeEstimator) EstimateFee(targetEpochs int) (FeeEstimate, error) {
+	if targetEpochs < 1 {
+		return FeeEstimate{}, ErrFeeTarget
+	}
+	if len(est.history) == 0 {
+		return FeeEstimate{}, ErrNoFeeHistory
+	}
+	thresholds := make([]float64, len(est.history))
+	for idx, fees := range est.history {
+		thresholds[idx] = fees.threshold()
+	}
+	sort.Float64s(thresholds)
+
+	n := len(thresholds)
+	var estimate FeeEstimate
+	for idx, rate := range thresholds {
+		// a rate meets the thresholds up to its last occurrence
+		if idx+1 < n && thresholds[idx+1] == rate {
+			continue
+		}
+		perEpoch := float64(idx+1) / float64(n)
+		estimate = FeeEstimate{
+			Rate:       rate,
+			Confidence: 1 - math.Pow(1-perEpoch, float64(targetEpochs)),
+			Epochs:     n,
+		}
+		if estimate.Confidence >= est.Confidence {
+			break
+		}
+	}
+	return estimate, nil
+}
+
+func minFloat(values []float64) float64 {
+	min := values[0]
+	for _, value := range values[1:] {
+		min = math.Min(min, value)
+	}
+	return min
+}
+
+func maxFloat(values []float64) float64 {
+	max := values[0]
+	for _, value := range values[1:] {
+		max = math.Max(max, value)
+	}
+	return max
+}
@@ -0,0 +1,128 @@
+package scrooge
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// congested returns the fees of an epoch that accepted rates down to threshold and
+// deferred cheaper ones.
+func congested(epoch int, threshold float64) EpochFees {
+	return EpochFees{Epoch: epoch, Accepted: []float64{threshold * 3, threshold}, Deferred: []float64{threshold / 2}}
+}
+
+func TestFeeEstimatorErrors(t *testing.T) {
+	est := NewFeeEstimator()
+	if _, err := est.EstimateFee(1); err != ErrNoFeeHistory {
+		t.Fatalf("estimate without history: %v", err)
+	}
+	est.Record(congested(0, 1))
+	if _, err := est.EstimateFee(0); err != ErrFeeTarget {
+		t.Fatalf("estimate for no epochs: %v", err)
+	}
+}
+
+func TestFeeEstimatorSyntheticStreams(t *testing.T) {
+	est := NewFeeEstimator()
+	for epoch := 0; epoch < 5; epoch++ {
+		est.Record(EpochFees{Epoch: epoch, Accepted: []float64{0.5, 0.01}})
+	}
+	estimate, err := est.EstimateFee(1)
+	if err != nil || estimate != (FeeEstimate{Rate: 0, Confidence: 1, Epochs: 5}) {
+		t.Fatalf("estimate without congestion: %+v, %v", estimate, err)
+	}
+
+	// 8 epochs in 10 accept rates from 1, the others from 5
+	est = NewFeeEstimator()
+	for epoch := 0; epoch < 10; epoch++ {
+		if epoch%5 == 4 {
+			est.Record(congested(epoch, 5))
+		} else {
+			est.Record(congested(epoch, 1))
+		}
+	}
+	cases := []struct {
+		target     int
+		rate       float64
+		confidence float64
+	}{
+		// rate 1 gets in the next epoch with probability 0.8 only
+		{1, 5, 1},
+		{2, 1, 1 - 0.2*0.2},
+		{10, 1, 1 - math.Pow(0.2, 10)},
+	}
+	for _, c := range cases {
+		estimate, err := est.EstimateFee(c.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate.Rate != c.rate || math.Abs(estimate.Confidence-c.confidence) > 1e-9 || estimate.Epochs != 10 {
+			t.Errorf("estimate within %v epochs: %+v, expected rate %v at %v", c.target, estimate, c.rate, c.confidence)
+		}
+	}
+
+	est.Confidence = 0.5
+	if estimate, _ := est.EstimateFee(1); estimate.Rate != 1 || math.Abs(estimate.Confidence-0.8) > 1e-9 {
+		t.Fatalf("estimate at confidence 0.5: %+v", estimate)
+	}
+}
+
+func TestFeeEstimatorWindow(t *testing.T) {
+	est := NewFeeEstimator()
+	est.Window = 3
+	est.Record(congested(0, 100))
+	for epoch := 1; epoch < 5; epoch++ {
+		est.Record(congested(epoch, 1))
+	}
+	history := est.History()
+	if len(history) != 3 || history[0].Epoch != 2 {
+		t.Fatalf("history kept: %+v", history)
+	}
+	if estimate, _ := est.EstimateFee(1); estimate.Rate != 1 {
+		t.Fatalf("estimate still sees dropped epochs: %+v", estimate)
+	}
+}
+
+func TestFeeEstimatorJSON(t *testing.T) {
+	est := NewFeeEstimator()
+	est.Record(congested(7, 0.001))
+	est.Record(EpochFees{Epoch: 8, Accepted: []float64{1.0 / 3}})
+	data, err := json.Marshal(est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewFeeEstimator()
+	decoded.Window = 1
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.History(), est.History()[1:]) {
+		t.Fatalf("history decoded into a window of 1: %+v", decoded.History())
+	}
+	if err := json.Unmarshal([]byte(`{"epochs":[{"epoch":1,"accepted":["cheap"],"deferred":[]}]}`), decoded); err == nil {
+		t.Fatal("malformed fee rate decoded")
+	}
+}
+
+func TestHandleTxsRecordsFees(t *testing.T) {
+	handler, key := newBudgetHandler(4)
+	handler.Fees = NewFeeEstimator()
+	first := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+	handler.HandleTxs([]*Transaction{first})
+
+	handler.MaxEpochTxs = 1
+	low := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 8)
+	high := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 2}}, 6)
+	handler.HandleTxs([]*Transaction{low, high})
+
+	size := float64(first.Size())
+	expected := []EpochFees{
+		{Epoch: 0, Accepted: []float64{1 / size}},
+		{Epoch: 1, Accepted: []float64{4 / size}, Deferred: []float64{2 / size}},
+	}
+	if !reflect.DeepEqual(handler.Fees.History(), expected) {
+		t.Fatalf("recorded %+v, expected %+v", handler.Fees.History(), expected)
+	}
+}
@@ -0,0 +1,223 @@
+package scrooge
+
+import "errors"
+
+var (
+	ErrNoHistory    = errors.New("scrooge: handler keeps no history to roll back with")
+	ErrNoUndoData   = errors.New("scrooge: epoch was not recorded by the history")
+	ErrNoEpochsLeft = errors.New("scrooge: no epoch to roll back")
+)
+
+// SpentOutput is an output spent by an accepted transaction, as it was in the pool.
+type SpentOutput struct {
+	UTXO      UTXO
+	Output    TOutput
+	CreatedAt int
+}
+
+// HistoryEntry is a transaction accepted by HandleTxs, with the epoch it was accepted in
+// and the outputs its inputs spent, in input order.
+type HistoryEntry struct {
+	Tx    *Transaction
+	Epoch int
+	Spent []SpentOutput
+}
+
+// owners returns the addresses the transaction of entry spends from or pays, sorted.
+func (entry *HistoryEntry) owners() []string {
+	owners := make(map[string]bool)
+	for idx := range entry.Spent {
+		owners[entry.Spent[idx].Output.OwnerAddress()] = true
+	}
+	for idx := range entry.Tx.Outputs {
+		owners[entry.Tx.Outputs[idx].OwnerAddress()] = true
+	}
+	return sortedKeys(owners)
+}
+
+// Spend tells which input of which transaction spent an output, and in what epoch.
+type Spend struct {
+	TxHash []byte
+	Input  int
+	Epoch  int
+}
+
+// TxHistory records the transactions accepted by a TxHandler whose History it is. It
+// answers lookups by hash, by address and by spent output that the pool, which forgets
+// spent outputs, cannot, and holds what RollbackEpoch needs to undo an epoch.
+type TxHistory struct {
+	entries map[string]*HistoryEntry
+	// epochs lists the hashes accepted in each epoch, in order.
+	epochs map[int][]string
+	// byOwner lists the hashes of the transactions paying or spending from each address,
+	// oldest first.
+	byOwner map[string][]string
+	spentBy map[UTXO]Spend
+	// from is the first epoch of an unbroken run of recorded epochs ending before next.
+	from, next int
+}
+
+func NewTxHistory() *TxHistory {
+	return &TxHistory{
+		entries: make(map[string]*HistoryEntry),
+		epochs:  make(map[int][]string),
+		byOwner: make(map[string][]string),
+		spentBy: make(map[UTXO]Spend),
+		from:    -1,
+	}
+}
+
+// beginEpoch notes that epoch is being recorded.
+func (h *TxHistory) beginEpoch(epoch int) {
+	if h.from < 0 || epoch != h.next {
+		h.from = epoch
+	}
+	h.next = epoch + 1
+}
+
+// record adds tx, about to be applied to pool in epoch.
+func (h *TxHistory) record(pool *UTXOPool, epoch int, tx *Transaction) {
+	hash := string(tx.Hash)
+	entry := &HistoryEntry{Tx: tx, Epoch: epoch}
+	for idx, in := range tx.Inputs {
+		if in.OutputIdx == coinbaseIndex {
+			// the input of a coinbase spends no output
+			continue
+		}
+		utxo := UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}
+		entry.Spent = append(entry.Spent, SpentOutput{UTXO: utxo, Output: *pool.GetTxOutput(utxo), CreatedAt: pool.CreatedAt(utxo)})
+		h.spentBy[utxo] = Spend{TxHash: tx.Hash, Input: idx, Epoch: epoch}
+	}
+	for _, owner := range entry.owners() {
+		h.byOwner[owner] = append(h.byOwner[owner], hash)
+	}
+	h.entries[hash] = entry
+	h.epochs[epoch] = append(h.epochs[epoch], hash)
+}
+
+// rollback forgets the last recorded epoch and returns its entries, in the order they
+// were accepted.
+func (h *TxHistory) rollback() ([]*HistoryEntry, error) {
+	if h.from < 0 || h.next-1 < h.from {
+		return nil, ErrNoUndoData
+	}
+	epoch := h.next - 1
+	hashes := h.epochs[epoch]
+	entries := make([]*HistoryEntry, len(hashes))
+	for idx, hash := range hashes {
+		entries[idx] = h.entries[hash]
+	}
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		entry := entries[idx]
+		for _, spent := range entry.Spent {
+			delete(h.spentBy, spent.UTXO)
+		}
+		// the entries of the last epoch are the last ones of each owner
+		for _, owner := range entry.owners() {
+			list := h.byOwner[owner][:len(h.byOwner[owner])-1]
+			if len(list) == 0 {
+				delete(h.byOwner, owner)
+			} else {
+				h.byOwner[owner] = list
+			}
+		}
+		delete(h.entries, string(entry.Tx.Hash))
+	}
+	delete(h.epochs, epoch)
+	h.next = epoch
+	return entries, nil
+}
+
+// trim forgets the recorded epochs before epoch, which can no longer be rolled back.
+func (h *TxHistory) trim(epoch int) {
+	if h.from < 0 {
+		return
+	}
+	for ; h.from < epoch && h.from < h.next; h.from++ {
+		for _, hash := range h.epochs[h.from] {
+			entry := h.entries[hash]
+			for _, spent := range entry.Spent {
+				delete(h.spentBy, spent.UTXO)
+			}
+			// the entries of the first epoch are the first ones of each owner
+			for _, owner := range entry.owners() {
+				if list := h.byOwner[owner][1:]; len(list) == 0 {
+					delete(h.byOwner, owner)
+				} else {
+					h.byOwner[owner] = list
+				}
+			}
+			delete(h.entries, hash)
+		}
+		delete(h.epochs, h.from)
+	}
+}
+
+// GetTx returns the accepted transaction hash, nil if there is none.
+func (h *TxHistory) GetTx(hash []byte) *HistoryEntry {
+	if entry, ok := h.entries[string(hash)]; ok {
+		copied := *entry
+		return &copied
+	}
+	return nil
+}
+
+// History returns the transactions paying address or spending from it, as given by
+// TOutput.OwnerAddress, newest first. It skips the offset newest and returns at most
+// limit, all of them if limit is 0.
+func (h *TxHistory) History(address string, offset, limit int) []HistoryEntry {
+	list := h.byOwner[address]
+	entries := []HistoryEntry{}
+	if offset < 0 {
+		offset = 0
+	}
+	for idx := len(list) - 1 - offset; idx >= 0; idx-- {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		entries = append(entries, *h.entries[list[idx]])
+	}
+	return entries
+}
+
+// SpentBy returns the spend of utxo by an accepted transaction, nil if there is none.
+func (h *TxHistory) SpentBy(utxo UTXO) *Spend {
+	if spend, ok := h.spentBy[utxo]; ok {
+		return &spend
+	}
+	return nil
+}
+
+// RollbackEpoch undoes the last epoch handled by handler: the outputs its transactions
+// created leave the pool, the outputs they spent return to it with their age, and the
+// epoch counter goes back. It returns the transactions of the epoch, in the order they
+// were accepted. The epoch must have been recorded by the History of handler.
+func (handler *TxHandler) RollbackEpoch() ([]*Transaction, error) {
+	if handler.History == nil {
+		return nil, ErrNoHistory
+	}
+	if handler.Pool.Epoch == 0 {
+		return nil, ErrNoEpochsLeft
+	}
+	if handler.History.next != handler.Pool.Epoch {
+		return nil, ErrNoUndoData
+	}
+	entries, err := handler.History.rollback()
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*Transaction, len(entries))
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		tx := entries[idx].Tx
+		for outIdx := range tx.Outputs {
+			handler.Pool.RemoveUTXO(UTXO{TxHash: string(tx.Hash), Index: outIdx})
+		}
+		for _, spent := range entries[idx].Spent {
+			out := spent.Output
+			handler.Pool.restoreUTXO(spent.UTXO, &out, spent.CreatedAt)
+		}
+		txs[idx] = tx
+	}
+	handler.Pool.Epoch--
+	return txs, nil
+}
@@ -0,0 +1,137 @@
+package scrooge
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// hPay is hBuild for a transaction that is not replaceable.
+func hPay(from *rsa.PrivateKey, inputs []UTXO, to []*rsa.PrivateKey, values ...float64) *Transaction {
+	return hBuild(from, inputs, false, to, values...)
+}
+
+func assertEntries(t *testing.T, what string, entries []HistoryEntry, expected ...*Transaction) {
+	t.Helper()
+	txs := make([]*Transaction, len(entries))
+	for idx := range entries {
+		txs[idx] = entries[idx].Tx
+	}
+	assertTxs(t, what, txs, expected...)
+}
+
+// historyFixture runs two epochs: in the first alice pays 6 to bob, in the second bob
+// pays them back.
+func historyFixture(t *testing.T) (handler *TxHandler, alice, bob *rsa.PrivateKey, toBob, toAlice *Transaction) {
+	alice = cryptoutil.GetPrivateKey()
+	bob = cryptoutil.GetPrivateKey()
+	pool := NewUTXOPool()
+	pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: alice.PublicKey})
+	handler = NewTxHandler(pool)
+	handler.History = NewTxHistory()
+
+	toBob = hPay(alice, []UTXO{{TxHash: "txhash#1", Index: 0}}, []*rsa.PrivateKey{bob, alice}, 6, 4)
+	assertTxs(t, "first epoch", handler.HandleTxs([]*Transaction{toBob}), toBob)
+	toAlice = hPay(bob, []UTXO{hOut(toBob, 0)}, []*rsa.PrivateKey{alice}, 6)
+	assertTxs(t, "second epoch", handler.HandleTxs([]*Transaction{toAlice}), toAlice)
+	return
+}
+
+func TestHistoryQueries(t *testing.T) {
+	handler, alice, bob, toBob, toAlice := historyFixture(t)
+	history := handler.History
+	aliceAddress := cryptoutil.GetAddress(alice.PublicKey)
+
+	entry := history.GetTx(toBob.Hash)
+	if entry == nil || entry.Epoch != 0 || len(entry.Spent) != 1 {
+		t.Fatalf("entry of the first payment: %+v", entry)
+	}
+	if spent := entry.Spent[0]; spent.UTXO != (UTXO{TxHash: "txhash#1", Index: 0}) || spent.Output.Value != 10 || spent.CreatedAt != 0 {
+		t.Fatalf("output spent by the first payment: %+v", spent)
+	}
+	if history.GetTx([]byte("unknown")) != nil {
+		t.Fatal("unknown transaction found")
+	}
+
+	spend := history.SpentBy(UTXO{TxHash: "txhash#1", Index: 0})
+	if spend == nil || !bytes.Equal(spend.TxHash, toBob.Hash) || spend.Input != 0 || spend.Epoch != 0 {
+		t.Fatalf("spend of the first output: %+v", spend)
+	}
+	if spend := history.SpentBy(hOut(toBob, 0)); spend == nil || !bytes.Equal(spend.TxHash, toAlice.Hash) || spend.Epoch != 1 {
+		t.Fatalf("spend of bob's output: %+v", spend)
+	}
+	if history.SpentBy(hOut(toBob, 1)) != nil {
+		t.Fatal("unspent output has a spender")
+	}
+
+	assertEntries(t, "alice's history", history.History(aliceAddress, 0, 0), toAlice, toBob)
+	assertEntries(t, "bob's history", history.History(cryptoutil.GetAddress(bob.PublicKey), 0, 0), toAlice, toBob)
+	assertEntries(t, "first page", history.History(aliceAddress, 0, 1), toAlice)
+	assertEntries(t, "second page", history.History(aliceAddress, 1, 1), toBob)
+	assertEntries(t, "past the end", history.History(aliceAddress, 2, 1))
+	assertEntries(t, "unknown address", history.History("nobody", 0, 0))
+}
+
+func TestRollbackEpoch(t *testing.T) {
+	handler, alice, _, toBob, toAlice := historyFixture(t)
+	aliceAddress := cryptoutil.GetAddress(alice.PublicKey)
+
+	txs, err := handler.RollbackEpoch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTxs(t, "rolled back", txs, toAlice)
+	if handler.Pool.Epoch != 1 || !handler.Pool.Contains(hOut(toBob, 0)) || handler.Pool.Contains(hOut(toAlice, 0)) ||
+		handler.Pool.CreatedAt(hOut(toBob, 0)) != 0 {
+		t.Fatal("pool not rolled back to the first epoch")
+	}
+	if handler.History.GetTx(toAlice.Hash) != nil || handler.History.SpentBy(hOut(toBob, 0)) != nil {
+		t.Fatal("history not rolled back")
+	}
+	assertEntries(t, "alice's history", handler.History.History(aliceAddress, 0, 0), toBob)
+	checkOwnerIndex(t, "after one rollback", handler.Pool)
+
+	// the epoch can be handled again, and rolled back again
+	before, _ := json.Marshal(handler.Pool)
+	assertTxs(t, "second epoch again", handler.HandleTxs([]*Transaction{toAlice}), toAlice)
+	if _, err := handler.RollbackEpoch(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := json.Marshal(handler.Pool); !bytes.Equal(before, after) {
+		t.Fatalf("pool differs after a round trip:\n%s\n%s", before, after)
+	}
+
+	if txs, err := handler.RollbackEpoch(); err != nil || len(txs) != 1 {
+		t.Fatalf("rolling back the first epoch: %v, %v", txs, err)
+	}
+	if handler.Pool.Epoch != 0 || len(handler.Pool.H) != 1 || handler.Pool.GetTxOutput(UTXO{TxHash: "txhash#1", Index: 0}).Value != 10 {
+		t.Fatal("pool not rolled back to genesis")
+	}
+	assertEntries(t, "alice's history at genesis", handler.History.History(aliceAddress, 0, 0))
+	if _, err := handler.RollbackEpoch(); err != ErrNoEpochsLeft {
+		t.Fatalf("rolling back past genesis: %v", err)
+	}
+}
+
+func TestRollbackEpochErrors(t *testing.T) {
+	handler, _ := newBudgetHandler(1)
+	handler.HandleTxs(nil)
+	if _, err := handler.RollbackEpoch(); err != ErrNoHistory {
+		t.Fatalf("rollback without history: %v", err)
+	}
+	// epochs handled before the history was set cannot be undone
+	handler.History = NewTxHistory()
+	if _, err := handler.RollbackEpoch(); err != ErrNoUndoData {
+		t.Fatalf("rollback of an unrecorded epoch: %v", err)
+	}
+	handler.HandleTxs(nil)
+	if _, err := handler.RollbackEpoch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.RollbackEpoch(); err != ErrNoUndoData {
+		t.Fatalf("rollback past the recorded epochs: %v", err)
+	}
+}
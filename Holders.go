@@ -0,0 +1,103 @@
+package scrooge
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// holderBefore tells whether a ranks before b among the holders: richer, or as rich
+// with a smaller address.
+func holderBefore(a, b Holder) bool {
+	if a.Balance != b.Balance {
+		return a.Balance > b.Balance
+	}
+	return a.Address < b.Address
+}
+
+// holderTree holds holders in rank order. It is a treap: a search tree by rank that is
+// also a heap by a priority hashed from the address, which keeps it balanced with high
+// probability. Pools holding the same balances have the same tree.
+type holderTree struct {
+	root *holderNode
+}
+
+type holderNode struct {
+	holder      Holder
+	priority    uint64
+	left, right *holderNode
+}
+
+func (tree *holderTree) insert(h Holder) {
+	before, after := splitHolders(tree.root, h)
+	tree.root = mergeHolders(mergeHolders(before, &holderNode{holder: h, priority: holderPriority(h.Address)}), after)
+}
+
+func (tree *holderTree) remove(h Holder) {
+	tree.root = removeHolder(tree.root, h)
+}
+
+func holderPriority(address string) uint64 {
+	hash := sha256.Sum256([]byte(address))
+	return binary.BigEndian.Uint64(hash[:])
+}
+
+// first returns the first n holders, or all of them if there are fewer.
+func (tree *holderTree) first(n int) []Holder {
+	var holders []Holder
+	var stack []*holderNode
+	for node := tree.root; (node != nil || len(stack) > 0) && len(holders) < n; {
+		for ; node != nil; node = node.left {
+			stack = append(stack, node)
+		}
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		holders = append(holders, node.holder)
+		node = node.right
+	}
+	return holders
+}
+
+// splitHolders splits the tree under node into the holders ranked before h and the
+// others.
+func splitHolders(node *holderNode, h Holder) (*holderNode, *holderNode) {
+	if node == nil {
+		return nil, nil
+	}
+	if holderBefore(node.holder, h) {
+		before, after := splitHolders(node.right, h)
+		node.right = before
+		return node, after
+	}
+	before, after := splitHolders(node.left, h)
+	node.left = after
+	return before, node
+}
+
+// mergeHolders joins two trees, all the holders of a ranking before those of b.
+func mergeHolders(a, b *holderNode) *holderNode {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.priority > b.priority:
+		a.right = mergeHolders(a.right, b)
+		return a
+	}
+	b.left = mergeHolders(a, b.left)
+	return b
+}
+
+func removeHolder(node *holderNode, h Holder) *holderNode {
+	switch {
+	case node == nil:
+		return nil
+	case node.holder == h:
+		return mergeHolders(node.left, node.right)
+	case holderBefore(h, node.holder):
+		node.left = removeHolder(node.left, h)
+	default:
+		node.right = removeHolder(node.right, h)
+	}
+	return node
+}
@@ -0,0 +1,366 @@
+package scrooge
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// The JSON form of the ledger types is described by scrooge.schema.json. Hashes,
+// signatures and keys are hex strings, RSA addresses being the hex of their PKCS#1 DER
+// encoding, and amounts are decimal strings so that they round-trip exactly.
+
+var outputTypeNames = []string{
+	PayToAddress:   "address",
+	PayToMultisig:  "multisig",
+	PayToRevocable: "revocable",
+	PayToEd25519:   "ed25519",
+}
+
+func (t OutputType) String() string {
+	if t >= 0 && int(t) < len(outputTypeNames) {
+		return outputTypeNames[t]
+	}
+	return fmt.Sprintf("OutputType(%d)", int(t))
+}
+
+func parseOutputType(name string) (OutputType, error) {
+	for t, n := range outputTypeNames {
+		if n == name {
+			return OutputType(t), nil
+		}
+	}
+	return 0, fmt.Errorf("scrooge: unknown output type %q", name)
+}
+
+// FormatValue returns the decimal string form of an amount, the shortest one that
+// parses back to the same value.
+func FormatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// amountPattern is the amount pattern of the schema: plain decimals, with no exponent,
+// infinity or NaN.
+var amountPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// ParseValue parses an amount written by FormatValue.
+func ParseValue(s string) (float64, error) {
+	if !amountPattern.MatchString(s) {
+		return 0, fmt.Errorf("scrooge: bad amount %q", s)
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("scrooge: bad amount %q", s)
+	}
+	return value, nil
+}
+
+func encodeRSAKey(key rsa.PublicKey) string {
+	if key.N == nil {
+		return ""
+	}
+	return hex.EncodeToString(x509.MarshalPKCS1PublicKey(&key))
+}
+
+func decodeRSAKey(s string) (rsa.PublicKey, error) {
+	if s == "" {
+		return rsa.PublicKey{}, nil
+	}
+	der, err := hex.DecodeString(s)
+	if err != nil {
+		return rsa.PublicKey{}, fmt.Errorf("scrooge: bad address: %v", err)
+	}
+	key, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return rsa.PublicKey{}, fmt.Errorf("scrooge: bad address: %v", err)
+	}
+	return *key, nil
+}
+
+func decodeHex(field string, s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("scrooge: bad %v: %v", field, err)
+	}
+	return b, nil
+}
+
+type outputJSON struct {
+	Value          string `json:"value"`
+	Type           string `json:"type"`
+	Address        string `json:"address,omitempty"`
+	CoSigner       string `json:"coSigner,omitempty"`
+	RevocationHash string `json:"revocationHash,omitempty"`
+	Delay          int    `json:"delay,omitempty"`
+	EdAddress      string `json:"edAddress,omitempty"`
+}
+
+func (out TOutput) MarshalJSON() ([]byte, error) {
+	if out.Type < PayToAddress || out.Type > PayToEd25519 {
+		return nil, fmt.Errorf("scrooge: cannot encode output type %d", int(out.Type))
+	}
+	return json.Marshal(outputJSON{
+		Value:          FormatValue(out.Value),
+		Type:           out.Type.String(),
+		Address:        encodeRSAKey(out.Address),
+		CoSigner:       encodeRSAKey(out.CoSigner),
+		RevocationHash: hex.EncodeToString(out.RevocationHash),
+		Delay:          out.Delay,
+		EdAddress:      hex.EncodeToString(out.EdAddress),
+	})
+}
+
+func (out *TOutput) UnmarshalJSON(data []byte) error {
+	var j outputJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var decoded TOutput
+	var err error
+	if decoded.Value, err = ParseValue(j.Value); err != nil {
+		return err
+	}
+	if decoded.Type, err = parseOutputType(j.Type); err != nil {
+		return err
+	}
+	if decoded.Address, err = decodeRSAKey(j.Address); err != nil {
+		return err
+	}
+	if decoded.CoSigner, err = decodeRSAKey(j.CoSigner); err != nil {
+		return err
+	}
+	if decoded.RevocationHash, err = decodeHex("revocation hash", j.RevocationHash); err != nil {
+		return err
+	}
+	decoded.Delay = j.Delay
+	edAddress, err := decodeHex("Ed25519 address", j.EdAddress)
+	if err != nil {
+		return err
+	}
+	if edAddress != nil {
+		decoded.EdAddress = ed25519.PublicKey(edAddress)
+	}
+	*out = decoded
+	return nil
+}
+
+type inputJSON struct {
+	PrevTxHash  string `json:"prevTxHash"`
+	OutputIdx   int    `json:"outputIndex"`
+	Signature   string `json:"signature,omitempty"`
+	CoSignature string `json:"coSignature,omitempty"`
+	Preimage    string `json:"preimage,omitempty"`
+}
+
+func (in TInput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(inputJSON{
+		PrevTxHash:  hex.EncodeToString(in.PrevTxHash),
+		OutputIdx:   in.OutputIdx,
+		Signature:   hex.EncodeToString(in.Signature),
+		CoSignature: hex.EncodeToString(in.CoSignature),
+		Preimage:    hex.EncodeToString(in.Preimage),
+	})
+}
+
+func (in *TInput) UnmarshalJSON(data []byte) error {
+	var j inputJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	decoded := TInput{OutputIdx: j.OutputIdx}
+	var err error
+	if decoded.PrevTxHash, err = decodeHex("previous transaction hash", j.PrevTxHash); err != nil {
+		return err
+	}
+	if decoded.Signature, err = decodeHex("signature", j.Signature); err != nil {
+		return err
+	}
+	if decoded.CoSignature, err = decodeHex("co-signature", j.CoSignature); err != nil {
+		return err
+	}
+	if decoded.Preimage, err = decodeHex("preimage", j.Preimage); err != nil {
+		return err
+	}
+	*in = decoded
+	return nil
+}
+
+type transactionJSON struct {
+	Hash        string    `json:"hash,omitempty"`
+	Inputs      []TInput  `json:"inputs"`
+	Outputs     []TOutput `json:"outputs"`
+	Replaceable bool      `json:"replaceable,omitempty"`
+}
+
+func (tx Transaction) MarshalJSON() ([]byte, error) {
+	j := transactionJSON{
+		Hash:        hex.EncodeToString(tx.Hash),
+		Inputs:      tx.Inputs,
+		Outputs:     tx.Outputs,
+		Replaceable: tx.Replaceable,
+	}
+	if j.Inputs == nil {
+		j.Inputs = []TInput{}
+	}
+	if j.Outputs == nil {
+		j.Outputs = []TOutput{}
+	}
+	return json.Marshal(j)
+}
+
+func (tx *Transaction) UnmarshalJSON(data []byte) error {
+	var j transactionJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	hash, err := decodeHex("transaction hash", j.Hash)
+	if err != nil {
+		return err
+	}
+	*tx = Transaction{Hash: hash, Inputs: j.Inputs, Outputs: j.Outputs, Replaceable: j.Replaceable}
+	return nil
+}
+
+type utxoJSON struct {
+	TxHash string `json:"txHash"`
+	Index  int    `json:"index"`
+}
+
+func (utxo UTXO) MarshalJSON() ([]byte, error) {
+	return json.Marshal(utxoJSON{TxHash: hex.EncodeToString([]byte(utxo.TxHash)), Index: utxo.Index})
+}
+
+func (utxo *UTXO) UnmarshalJSON(data []byte) error {
+	var j utxoJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	txHash, err := decodeHex("transaction hash", j.TxHash)
+	if err != nil {
+		return err
+	}
+	*utxo = UTXO{TxHash: string(txHash), Index: j.Index}
+	return nil
+}
+
+type poolEntryJSON struct {
+	UTXO      UTXO    `json:"utxo"`
+	CreatedAt int     `json:"createdAt"`
+	Output    TOutput `json:"output"`
+}
+
+type poolJSON struct {
+	Epoch int             `json:"epoch"`
+	UTXOs []poolEntryJSON `json:"utxos"`
+}
+
+// MarshalJSON encodes the pool with its UTXOs sorted, so equal pools encode equally.
+func (pool *UTXOPool) MarshalJSON() ([]byte, error) {
+	utxos := sortUTXOs(pool.GetAllUTXO())
+	j := poolJSON{Epoch: pool.Epoch, UTXOs: make([]poolEntryJSON, 0, len(utxos))}
+	for _, utxo := range utxos {
+		out := pool.GetTxOutput(utxo)
+		if out == nil {
+			return nil, fmt.Errorf("scrooge: UTXO %x:%v has no output", utxo.TxHash, utxo.Index)
+		}
+		j.UTXOs = append(j.UTXOs, poolEntryJSON{UTXO: utxo, CreatedAt: pool.CreatedAt(utxo), Output: *out})
+	}
+	return json.Marshal(j)
+}
+
+func (pool *UTXOPool) UnmarshalJSON(data []byte) error {
+	var j poolJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	decoded := NewUTXOPool()
+	for _, entry := range j.UTXOs {
+		out := entry.Output
+		// AddUTXO stamps the UTXO with the current epoch
+		decoded.Epoch = entry.CreatedAt
+		decoded.AddUTXO(entry.UTXO, &out)
+	}
+	decoded.Epoch = j.Epoch
+	m := pool.poolMetrics
+	*pool = *decoded
+	if m != nil {
+		pool.SetMetrics(m)
+	}
+	return nil
+}
+
+type epochFeesJSON struct {
+	Epoch    int      `json:"epoch"`
+	Accepted []string `json:"accepted"`
+	Deferred []string `json:"deferred"`
+}
+
+type feeHistoryJSON struct {
+	Epochs []epochFeesJSON `json:"epochs"`
+}
+
+func formatValues(values []float64) []string {
+	strs := make([]string, len(values))
+	for idx, value := range values {
+		strs[idx] = FormatValue(value)
+	}
+	return strs
+}
+
+func parseValues(strs []string) ([]float64, error) {
+	var values []float64
+	for _, s := range strs {
+		value, err := ParseValue(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// MarshalJSON encodes the history of the estimator. Window and Confidence are settings
+// of the reader and are not encoded.
+func (est *FeeEstimator) MarshalJSON() ([]byte, error) {
+	j := feeHistoryJSON{Epochs: make([]epochFeesJSON, 0, len(est.history))}
+	for _, fees := range est.history {
+		j.Epochs = append(j.Epochs, epochFeesJSON{
+			Epoch:    fees.Epoch,
+			Accepted: formatValues(fees.Accepted),
+			Deferred: formatValues(fees.Deferred),
+		})
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON replaces the history of the estimator, keeping its last Window epochs.
+func (est *FeeEstimator) UnmarshalJSON(data []byte) error {
+	var j feeHistoryJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	history := make([]EpochFees, 0, len(j.Epochs))
+	for _, epoch := range j.Epochs {
+		accepted, err := parseValues(epoch.Accepted)
+		if err != nil {
+			return err
+		}
+		deferred, err := parseValues(epoch.Deferred)
+		if err != nil {
+			return err
+		}
+		history = append(history, EpochFees{Epoch: epoch.Epoch, Accepted: accepted, Deferred: deferred})
+	}
+	est.history = history
+	est.trim()
+	return nil
+}
@@ -0,0 +1,208 @@
+package scrooge
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+func loadFixture(t *testing.T, name string, v interface{}) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+	return data
+}
+
+func marshalIndent(t *testing.T, v interface{}) []byte {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// Fixtures named valid_* must pass IsValidTx against testdata/pool.json, invalid_* must not.
+func TestJSONFixtures(t *testing.T) {
+	pool := NewUTXOPool()
+	data := loadFixture(t, "pool.json", pool)
+	if len(pool.H) != 3 || pool.Epoch != 1 {
+		t.Fatalf("pool fixture decoded to %v UTXOs at epoch %v", len(pool.H), pool.Epoch)
+	}
+	if encoded := marshalIndent(t, pool); !bytes.Equal(encoded, data) {
+		t.Errorf("pool fixture re-encodes differently:\n%s", encoded)
+	}
+
+	names, err := filepath.Glob(filepath.Join("testdata", "*valid_*.json"))
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no transaction fixtures: %v", err)
+	}
+	for _, path := range names {
+		name := filepath.Base(path)
+		tx := &Transaction{}
+		data := loadFixture(t, name, tx)
+		if !bytes.Equal(cryptoutil.HashSha256(tx.GetRawTx()), tx.Hash) {
+			t.Errorf("%v: hash does not match the decoded transaction", name)
+		}
+		if encoded := marshalIndent(t, tx); !bytes.Equal(encoded, data) {
+			t.Errorf("%v: re-encodes differently:\n%s", name, encoded)
+		}
+		expected := strings.HasPrefix(name, "valid_")
+		if valid := NewTxHandler(pool).IsValidTx(tx); valid != expected {
+			t.Errorf("%v: IsValidTx returned %v", name, valid)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	carol, _, _ := ed25519.GenerateKey(rng)
+
+	tx := NewTransaction()
+	tx.AddInput([]byte("txhash#1"), 0)
+	tx.AddInput([]byte("txhash#2"), 3)
+	tx.AddOutput(0.1+0.2, alice.PublicKey)
+	tx.AddMultisigOutput(1e-9, alice.PublicKey, bob.PublicKey)
+	tx.AddRevocableOutput(123456789.987654321, alice.PublicKey, bob.PublicKey, cryptoutil.HashSha256([]byte("secret")), 7)
+	tx.AddEd25519Output(rng.Float64()*100, carol)
+	tx.AddSignature([]byte("signature"), 0)
+	tx.AddCoSignature([]byte("co-signature"), 1)
+	tx.AddPreimage([]byte("secret"), 1)
+	tx.Replaceable = true
+	tx.Finalize()
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Transaction{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tx, decoded) {
+		t.Fatalf("transaction changed in a round trip:\n%+v\n%+v", tx, decoded)
+	}
+	if !bytes.Equal(tx.GetRawTx(), decoded.GetRawTx()) {
+		t.Fatal("raw transaction changed in a round trip")
+	}
+
+	pool := NewUTXOPool()
+	for idx := range tx.Outputs {
+		pool.AddUTXO(UTXO{TxHash: string(tx.Hash), Index: idx}, &tx.Outputs[idx])
+		pool.Epoch++
+	}
+	data, err = json.Marshal(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedPool := NewUTXOPool()
+	if err := json.Unmarshal(data, decodedPool); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pool, decodedPool) {
+		t.Fatal("pool changed in a round trip")
+	}
+	if decodedPool.Age(UTXO{TxHash: string(tx.Hash), Index: 0}) != 4 {
+		t.Fatal("UTXO ages are not kept in a round trip")
+	}
+}
+
+func TestJSONRejectsMalformed(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		data string
+	}{
+		{&TOutput{}, `{"value":"1","type":"bitcoin"}`},
+		{&TOutput{}, `{"value":1,"type":"address"}`},
+		{&TOutput{}, `{"value":"one","type":"address"}`},
+		{&TOutput{}, `{"value":"NaN","type":"address"}`},
+		{&TOutput{}, `{"value":"Inf","type":"address"}`},
+		{&TOutput{}, `{"value":"1e5","type":"address"}`},
+		{&TOutput{}, `{"value":"0x10","type":"address"}`},
+		{&TOutput{}, `{"value":"+1","type":"address"}`},
+		{&TOutput{}, `{"value":"1","type":"address","address":"00ff"}`},
+		{&TOutput{}, `{"value":"1","type":"ed25519","edAddress":"xyz"}`},
+		{&TInput{}, `{"prevTxHash":"0","outputIndex":0}`},
+		{&Transaction{}, `{"hash":"zz","inputs":[],"outputs":[]}`},
+		{&UTXO{}, `{"txHash":"abc","index":1}`},
+		{NewUTXOPool(), `{"epoch":0,"utxos":[{"utxo":{"txHash":"00","index":0},"output":{"value":"1","type":"nope"}}]}`},
+	}
+	for _, c := range cases {
+		if err := json.Unmarshal([]byte(c.data), c.v); err == nil {
+			t.Errorf("%v decoded into %T", c.data, c.v)
+		}
+	}
+
+	if _, err := json.Marshal(TOutput{Type: OutputType(42)}); err == nil {
+		t.Error("output of unknown type encoded")
+	}
+}
+
+// The schema must list every property the encoder writes.
+func TestJSONSchemaCoversEncoding(t *testing.T) {
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Required   []string                   `json:"required"`
+		} `json:"$defs"`
+	}
+	data, err := os.ReadFile("scrooge.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	check := func(def string, object map[string]json.RawMessage) {
+		properties := schema.Defs[def].Properties
+		if properties == nil {
+			t.Fatalf("schema has no %v definition", def)
+		}
+		for key := range object {
+			if _, ok := properties[key]; !ok {
+				t.Errorf("schema %v has no property %q", def, key)
+			}
+		}
+		for _, key := range schema.Defs[def].Required {
+			if _, ok := object[key]; !ok {
+				t.Errorf("encoded %v lacks required property %q", def, key)
+			}
+		}
+	}
+	var pool struct {
+		Entries []map[string]json.RawMessage `json:"utxos"`
+	}
+	loadFixture(t, "pool.json", &pool)
+	for _, entry := range pool.Entries {
+		check("poolEntry", entry)
+		var utxo, output map[string]json.RawMessage
+		json.Unmarshal(entry["utxo"], &utxo)
+		json.Unmarshal(entry["output"], &output)
+		check("utxo", utxo)
+		check("output", output)
+	}
+
+	var tx struct {
+		Inputs  []map[string]json.RawMessage `json:"inputs"`
+		Outputs []map[string]json.RawMessage `json:"outputs"`
+	}
+	loadFixture(t, "valid_multisig.json", &tx)
+	for _, in := range tx.Inputs {
+		check("input", in)
+	}
+	for _, out := range tx.Outputs {
+		check("output", out)
+	}
+}
@@ -0,0 +1,447 @@
+package scrooge
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"scrooge/cryptoutil"
+)
+
+var (
+	ErrTxBadHash       = errors.New("scrooge: transaction hash does not match its contents")
+	ErrTxKnown         = errors.New("scrooge: transaction already in the mempool")
+	ErrTxConflict      = errors.New("scrooge: transaction spends an output already spent in the mempool")
+	ErrTxMissingOutput = errors.New("scrooge: transaction spends an output its parent does not have")
+	ErrTxBadSpend      = errors.New("scrooge: transaction input does not satisfy the output it spends")
+
+	ErrReplacementFee       = errors.New("scrooge: replacement does not pay a higher fee and fee rate")
+	ErrReplacementEvictions = errors.New("scrooge: replacement would evict too many transactions")
+)
+
+// DefaultMaxReplacementEvictions is the default of Mempool.MaxReplacementEvictions.
+const DefaultMaxReplacementEvictions = 100
+
+type mempoolEntry struct {
+	tx *Transaction
+	// added is the pool epoch in which the entry arrived.
+	added int
+	// seq orders the candidates so that parents come before their children.
+	seq int
+	// orphan is set while some parent of tx is neither in the pool nor a candidate.
+	orphan bool
+	// fee and size are known once tx is a candidate.
+	fee  float64
+	size int
+}
+
+func (entry *mempoolEntry) feeRate() float64 {
+	return entry.fee / float64(entry.size)
+}
+
+// Mempool holds the transactions waiting for an epoch between calls to HandleTxs.
+//
+// Transactions are indexed by hash and by the outputs they spend, so a transaction
+// spending an output already spent in the mempool is refused on entry. A transaction
+// with an input that is neither in the UTXO pool nor created by a candidate is an
+// orphan: it is parked until its parents arrive. Each epoch, RunEpoch hands the
+// candidates to HandleTxs and evicts the accepted transactions, the rejected ones with
+// their descendants, those in conflict with accepted transactions and those older than
+// Expiry epochs. Candidates deferred by the epoch budget of the handler stay.
+//
+// A transaction in conflict with candidates that are all Replaceable replaces them if
+// it pays a strictly higher fee than all the transactions it evicts, the conflicts and
+// their descendants, and a strictly higher fee rate than each conflict. The fee is the
+// input value minus the output value and the fee rate is the fee per byte of raw
+// transaction. A replacement may not evict more than MaxReplacementEvictions
+// transactions, so a large tree of descendants cannot make a replacement costly to check.
+type Mempool struct {
+	// Expiry is the number of epochs after which an entry is dropped, 0 for never.
+	Expiry int
+	// MaxReplacementEvictions bounds the transactions a replacement may evict.
+	MaxReplacementEvictions int
+
+	handler *TxHandler
+	entries map[string]*mempoolEntry
+	// spends maps each output spent in the mempool to the hash of its spender.
+	spends map[UTXO]string
+	// waiting maps the hash of each missing parent to the orphans waiting for it.
+	waiting map[string]map[string]bool
+	seq     int
+}
+
+// NewMempool returns an empty mempool for the pool of handler.
+func NewMempool(handler *TxHandler, expiry int) *Mempool {
+	return &Mempool{
+		Expiry:                  expiry,
+		MaxReplacementEvictions: DefaultMaxReplacementEvictions,
+		handler:                 handler,
+		entries:                 make(map[string]*mempoolEntry),
+		spends:                  make(map[UTXO]string),
+		waiting:                 make(map[string]map[string]bool),
+	}
+}
+
+// Add puts tx in the mempool, as a candidate for the next epoch or as an orphan. If tx
+// conflicts with candidates it may replace, they are evicted with their descendants.
+// A transaction breaking the Policy of the handler is refused with its error, and one
+// with an input not satisfying the available output it spends with ErrTxBadSpend.
+func (mp *Mempool) Add(tx *Transaction) error {
+	if !bytes.Equal(cryptoutil.HashSha256(tx.GetRawTx()), tx.Hash) {
+		return ErrTxBadHash
+	}
+	if err := mp.handler.Policy.Check(tx); err != nil {
+		return err
+	}
+	hash := string(tx.Hash)
+	if _, ok := mp.entries[hash]; ok {
+		return ErrTxKnown
+	}
+
+	spent := make(map[UTXO]bool)
+	conflicts := make(map[string]bool)
+	var missing []string
+	for _, in := range tx.Inputs {
+		utxo := UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}
+		if spent[utxo] {
+			return ErrTxConflict
+		}
+		spent[utxo] = true
+		if spender, ok := mp.spends[utxo]; ok {
+			conflicts[spender] = true
+		}
+		available, err := mp.available(utxo)
+		if err != nil {
+			return err
+		}
+		if !available {
+			missing = append(missing, utxo.TxHash)
+		}
+	}
+	if !mp.validSpends(tx) {
+		return ErrTxBadSpend
+	}
+
+	entry := &mempoolEntry{tx: tx, added: mp.handler.Pool.Epoch, orphan: len(missing) > 0}
+	if len(conflicts) > 0 {
+		if entry.orphan {
+			return ErrTxConflict
+		}
+		replaced, err := mp.replacements(entry, conflicts)
+		if err != nil {
+			return err
+		}
+		var evicted []*Transaction
+		for _, conflict := range replaced {
+			mp.evict(conflict, &evicted)
+		}
+	}
+
+	mp.entries[hash] = entry
+	for utxo := range spent {
+		mp.spends[utxo] = hash
+	}
+	if entry.orphan {
+		for _, parent := range missing {
+			if mp.waiting[parent] == nil {
+				mp.waiting[parent] = make(map[string]bool)
+			}
+			mp.waiting[parent][hash] = true
+		}
+		return nil
+	}
+	mp.promote(entry)
+	return nil
+}
+
+// replacements checks that entry may replace the candidates in conflicts, and returns
+// them in a stable order.
+func (mp *Mempool) replacements(entry *mempoolEntry, conflicts map[string]bool) ([]string, error) {
+	replaced := sortedKeys(conflicts)
+	descendants := make(map[string]*mempoolEntry)
+	for _, hash := range replaced {
+		conflict := mp.entries[hash]
+		if conflict.orphan || !conflict.tx.Replaceable {
+			return nil, ErrTxConflict
+		}
+		if err := mp.descendants(hash, descendants); err != nil {
+			return nil, err
+		}
+	}
+	for _, in := range entry.tx.Inputs {
+		// a replacement cannot spend what it evicts
+		if _, ok := descendants[string(in.PrevTxHash)]; ok {
+			return nil, ErrTxConflict
+		}
+	}
+
+	mp.price(entry)
+	var evictedFee float64
+	for _, evicted := range descendants {
+		evictedFee += evicted.fee
+	}
+	if entry.fee <= evictedFee {
+		return nil, ErrReplacementFee
+	}
+	for _, hash := range replaced {
+		if entry.feeRate() <= mp.entries[hash].feeRate() {
+			return nil, ErrReplacementFee
+		}
+	}
+	return replaced, nil
+}
+
+// descendants adds the transaction hash and its descendants to set, failing once set
+// holds more than MaxReplacementEvictions transactions.
+func (mp *Mempool) descendants(hash string, set map[string]*mempoolEntry) error {
+	if _, ok := set[hash]; ok {
+		return nil
+	}
+	entry := mp.entries[hash]
+	set[hash] = entry
+	if len(set) > mp.MaxReplacementEvictions {
+		return ErrReplacementEvictions
+	}
+	for idx := range entry.tx.Outputs {
+		if child, ok := mp.spends[UTXO{TxHash: hash, Index: idx}]; ok {
+			if err := mp.descendants(child, set); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// price sets the fee and size of entry, whose inputs must all be available.
+func (mp *Mempool) price(entry *mempoolEntry) {
+	var fee float64
+	for _, in := range entry.tx.Inputs {
+		utxo := UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}
+		if out := mp.handler.Pool.GetTxOutput(utxo); out != nil {
+			fee += out.Value
+		} else {
+			fee += mp.entries[utxo.TxHash].tx.Outputs[utxo.Index].Value
+		}
+	}
+	for _, out := range entry.tx.Outputs {
+		fee -= out.Value
+	}
+	entry.fee = fee
+	entry.size = entry.tx.Size()
+}
+
+// available tells whether utxo is in the pool or created by a candidate.
+func (mp *Mempool) available(utxo UTXO) (bool, error) {
+	if mp.handler.Pool.Contains(utxo) {
+		return true, nil
+	}
+	parent, ok := mp.entries[utxo.TxHash]
+	if !ok || parent.orphan {
+		return false, nil
+	}
+	if utxo.Index < 0 || utxo.Index >= parent.tx.NumOutputs() {
+		return false, ErrTxMissingOutput
+	}
+	return true, nil
+}
+
+// validSpends tells whether the inputs of tx spending available outputs satisfy them,
+// so that no one can claim an output in the mempool with a spend HandleTxs would reject.
+func (mp *Mempool) validSpends(tx *Transaction) bool {
+	for idx := range tx.Inputs {
+		in := &tx.Inputs[idx]
+		utxo := UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}
+		out := mp.handler.Pool.GetTxOutput(utxo)
+		if parent, ok := mp.entries[utxo.TxHash]; out == nil && ok && !parent.orphan && utxo.Index >= 0 && utxo.Index < parent.tx.NumOutputs() {
+			out = &parent.tx.Outputs[utxo.Index]
+		}
+		if out != nil && !mp.handler.isValidSpend(utxo, out, in, tx.GetRawDataToSign(idx)) {
+			return false
+		}
+	}
+	return true
+}
+
+// promote makes entry a candidate and then the orphans waiting for it whose parents
+// are now all available.
+func (mp *Mempool) promote(entry *mempoolEntry) {
+	entry.orphan = false
+	mp.price(entry)
+	mp.seq++
+	entry.seq = mp.seq
+	mp.resolve(string(entry.tx.Hash))
+}
+
+// resolve promotes the orphans waiting for the transaction hash, which has become a
+// candidate or entered the pool.
+func (mp *Mempool) resolve(hash string) {
+	orphans := mp.waiting[hash]
+	delete(mp.waiting, hash)
+	for _, orphanHash := range sortedKeys(orphans) {
+		entry, ok := mp.entries[orphanHash]
+		if !ok || !entry.orphan {
+			continue
+		}
+		// an orphan spending an output its parent lacks stays parked until it expires
+		ready := true
+		for _, in := range entry.tx.Inputs {
+			available, err := mp.available(UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx})
+			ready = ready && available && err == nil
+		}
+		switch {
+		case ready && mp.validSpends(entry.tx):
+			mp.promote(entry)
+		case ready:
+			var evicted []*Transaction
+			mp.evict(orphanHash, &evicted)
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// drop removes the transaction hash from the mempool, leaving its descendants.
+func (mp *Mempool) drop(hash string) *mempoolEntry {
+	entry, ok := mp.entries[hash]
+	if !ok {
+		return nil
+	}
+	delete(mp.entries, hash)
+	for _, in := range entry.tx.Inputs {
+		utxo := UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}
+		if mp.spends[utxo] == hash {
+			delete(mp.spends, utxo)
+		}
+		if orphans := mp.waiting[utxo.TxHash]; orphans != nil {
+			delete(orphans, hash)
+			if len(orphans) == 0 {
+				delete(mp.waiting, utxo.TxHash)
+			}
+		}
+	}
+	return entry
+}
+
+// evict removes the transaction hash and all its descendants from the mempool,
+// appending them to evicted.
+func (mp *Mempool) evict(hash string, evicted *[]*Transaction) {
+	entry := mp.drop(hash)
+	if entry == nil {
+		return
+	}
+	*evicted = append(*evicted, entry.tx)
+	for idx := range entry.tx.Outputs {
+		if child, ok := mp.spends[UTXO{TxHash: hash, Index: idx}]; ok {
+			mp.evict(child, evicted)
+		}
+	}
+}
+
+// Remove evicts the transaction with the given hash and its descendants, and returns
+// the evicted transactions.
+func (mp *Mempool) Remove(hash []byte) []*Transaction {
+	var evicted []*Transaction
+	mp.evict(string(hash), &evicted)
+	return evicted
+}
+
+// Confirm takes the transactions of accepted, which have entered the pool, out of the
+// mempool. It evicts the transactions in conflict with them and returns those.
+func (mp *Mempool) Confirm(accepted []*Transaction) []*Transaction {
+	var evicted []*Transaction
+	for _, tx := range accepted {
+		mp.drop(string(tx.Hash))
+	}
+	for _, tx := range accepted {
+		for _, in := range tx.Inputs {
+			if spender, ok := mp.spends[UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx}]; ok {
+				mp.evict(spender, &evicted)
+			}
+		}
+	}
+	for _, tx := range accepted {
+		mp.resolve(string(tx.Hash))
+	}
+	return evicted
+}
+
+// Candidates returns the transactions that are not orphans, parents before children.
+func (mp *Mempool) Candidates() []*Transaction {
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		if !entry.orphan {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	txs := make([]*Transaction, 0, len(entries))
+	for _, entry := range entries {
+		txs = append(txs, entry.tx)
+	}
+	return txs
+}
+
+// RunEpoch hands the candidates to HandleTxs and updates the mempool with the result. It
+// returns the accepted transactions and the evicted ones, which were rejected, in
+// conflict with accepted ones, descendants of either or expired.
+func (mp *Mempool) RunEpoch() (accepted []*Transaction, evicted []*Transaction) {
+	candidates := mp.Candidates()
+	accepted = mp.handler.HandleTxs(candidates)
+	evicted = mp.Confirm(accepted)
+	deferred := make(map[string]bool)
+	for _, tx := range mp.handler.Deferred() {
+		deferred[string(tx.Hash)] = true
+	}
+	for _, tx := range candidates {
+		if !deferred[string(tx.Hash)] {
+			mp.evict(string(tx.Hash), &evicted)
+		}
+	}
+	if mp.Expiry > 0 {
+		var expired []string
+		for hash, entry := range mp.entries {
+			if mp.handler.Pool.Epoch-entry.added >= mp.Expiry {
+				expired = append(expired, hash)
+			}
+		}
+		sort.Strings(expired)
+		for _, hash := range expired {
+			mp.evict(hash, &evicted)
+		}
+	}
+	return accepted, evicted
+}
+
+// Get returns the transaction with the given hash, or nil.
+func (mp *Mempool) Get(hash []byte) *Transaction {
+	if entry, ok := mp.entries[string(hash)]; ok {
+		return entry.tx
+	}
+	return nil
+}
+
+// IsOrphan tells whether the transaction with the given hash is parked as an orphan.
+func (mp *Mempool) IsOrphan(hash []byte) bool {
+	entry, ok := mp.entries[string(hash)]
+	return ok && entry.orphan
+}
+
+// SpentBy returns the transaction in the mempool spending utxo, or nil.
+func (mp *Mempool) SpentBy(utxo UTXO) *Transaction {
+	if spender, ok := mp.spends[utxo]; ok {
+		return mp.entries[spender].tx
+	}
+	return nil
+}
+
+// Len returns the number of transactions in the mempool, orphans included.
+func (mp *Mempool) Len() int {
+	return len(mp.entries)
+}
@@ -0,0 +1,375 @@
+package scrooge
+
+import (
+	"crypto/rsa"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// hSpend returns a transaction in which key spends inputs into outputs of the given
+// values, all paid to key.
+func hSpend(key *rsa.PrivateKey, inputs []UTXO, values ...float64) *Transaction {
+	return hBuild(key, inputs, false, []*rsa.PrivateKey{key}, values...)
+}
+
+// hReplaceable is hSpend for a replaceable transaction.
+func hReplaceable(key *rsa.PrivateKey, inputs []UTXO, values ...float64) *Transaction {
+	return hBuild(key, inputs, true, []*rsa.PrivateKey{key}, values...)
+}
+
+func hOut(tx *Transaction, idx int) UTXO {
+	return UTXO{TxHash: string(tx.Hash), Index: idx}
+}
+
+func assertTxs(t *testing.T, what string, txs []*Transaction, expected ...*Transaction) {
+	t.Helper()
+	if len(txs) != len(expected) {
+		t.Fatalf("%v: %v transactions, expected %v", what, len(txs), len(expected))
+	}
+	for idx := range txs {
+		if string(txs[idx].Hash) != string(expected[idx].Hash) {
+			t.Fatalf("%v: transaction %v is %x, expected %x", what, idx, txs[idx].Hash, expected[idx].Hash)
+		}
+	}
+}
+
+func newTestMempool(expiry int) (*Mempool, *rsa.PrivateKey) {
+	key := cryptoutil.GetPrivateKey()
+	pool := NewUTXOPool()
+	pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: key.PublicKey})
+	pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 1}, &TOutput{Value: 5, Address: key.PublicKey})
+	return NewMempool(NewTxHandler(pool), expiry), key
+}
+
+func TestMempoolOrphans(t *testing.T) {
+	mp, key := newTestMempool(0)
+	parent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 6, 4)
+	child := hSpend(key, []UTXO{hOut(parent, 1)}, 4)
+	grandchild := hSpend(key, []UTXO{hOut(child, 0), {TxHash: "txhash#1", Index: 1}}, 9)
+
+	for _, tx := range []*Transaction{grandchild, child} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		if !mp.IsOrphan(tx.Hash) {
+			t.Fatalf("%x is not an orphan", tx.Hash)
+		}
+	}
+	assertTxs(t, "candidates without the parent", mp.Candidates())
+
+	if err := mp.Add(parent); err != nil {
+		t.Fatal(err)
+	}
+	assertTxs(t, "candidates", mp.Candidates(), parent, child, grandchild)
+	if mp.SpentBy(UTXO{TxHash: "txhash#1", Index: 1}) != grandchild {
+		t.Fatal("spent outpoint index does not point to the spender")
+	}
+
+	accepted, evicted := mp.RunEpoch()
+	assertTxs(t, "accepted", accepted, parent, child, grandchild)
+	assertTxs(t, "evicted", evicted)
+	if mp.Len() != 0 {
+		t.Fatalf("%v transactions left in the mempool", mp.Len())
+	}
+}
+
+func TestMempoolConflicts(t *testing.T) {
+	mp, key := newTestMempool(0)
+	tx := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 10)
+	if err := mp.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := *tx
+	tampered.Outputs = []TOutput{{Value: 1, Address: key.PublicKey}}
+	cases := []struct {
+		tx       *Transaction
+		expected error
+	}{
+		{tx, ErrTxKnown},
+		{&tampered, ErrTxBadHash},
+		{hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9), ErrTxConflict},
+		{hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}, {TxHash: "txhash#1", Index: 1}}, 9), ErrTxConflict},
+		{hSpend(key, []UTXO{hOut(tx, 1)}, 1), ErrTxMissingOutput},
+	}
+	for idx, c := range cases {
+		if err := mp.Add(c.tx); err != c.expected {
+			t.Errorf("case %v: Add returned %v, expected %v", idx, err, c.expected)
+		}
+	}
+	if mp.Len() != 1 {
+		t.Fatalf("%v transactions in the mempool, expected 1", mp.Len())
+	}
+}
+
+func TestMempoolEvictsRejected(t *testing.T) {
+	mp, key := newTestMempool(0)
+
+	// spending more than its input takes the descendants down too
+	overspend := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 11)
+	child := hSpend(key, []UTXO{hOut(overspend, 0)}, 10)
+	valid := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 5)
+	for _, tx := range []*Transaction{overspend, child, valid} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	accepted, evicted := mp.RunEpoch()
+	assertTxs(t, "accepted", accepted, valid)
+	assertTxs(t, "evicted", evicted, overspend, child)
+	if mp.Len() != 0 {
+		t.Fatalf("%v transactions left in the mempool", mp.Len())
+	}
+}
+
+func TestMempoolRefusesBadSpends(t *testing.T) {
+	mp, key := newTestMempool(0)
+	thief := cryptoutil.GetPrivateKey()
+
+	// an unsigned claim on an output must not lock its owner out
+	unsigned := NewTransaction()
+	unsigned.AddInput([]byte("txhash#1"), 0)
+	unsigned.AddOutput(10, thief.PublicKey)
+	unsigned.AddSignature([]byte("junk"), 0)
+	unsigned.Finalize()
+	if err := mp.Add(unsigned); err != ErrTxBadSpend {
+		t.Fatalf("unsigned spend: %v", err)
+	}
+	stolen := hSpend(thief, []UTXO{{TxHash: "txhash#1", Index: 0}}, 10)
+	if err := mp.Add(stolen); err != ErrTxBadSpend {
+		t.Fatalf("spend signed by another key: %v", err)
+	}
+	parent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 10)
+	if err := mp.Add(parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(hSpend(thief, []UTXO{hOut(parent, 0)}, 10)); err != ErrTxBadSpend {
+		t.Fatalf("spend of a candidate output signed by another key: %v", err)
+	}
+
+	// an orphan is checked once its parent arrives
+	orphanParent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 5)
+	if err := mp.Add(hSpend(thief, []UTXO{hOut(orphanParent, 0)}, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(orphanParent); err != nil {
+		t.Fatal(err)
+	}
+	honest := hSpend(key, []UTXO{hOut(orphanParent, 0)}, 5)
+	if err := mp.Add(honest); err != nil {
+		t.Fatalf("spend of an output claimed by a bad orphan: %v", err)
+	}
+	assertTxs(t, "candidates", mp.Candidates(), parent, orphanParent, honest)
+}
+
+func TestMempoolConfirm(t *testing.T) {
+	mp, key := newTestMempool(0)
+	parent := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 10)
+	child := hSpend(key, []UTXO{hOut(parent, 0)}, 10)
+	rival := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 5)
+	orphanOfRival := hSpend(key, []UTXO{hOut(rival, 0)}, 5)
+	spender := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 4)
+
+	// the orphan is parked, the spender of its parent's input waits as a candidate
+	for _, tx := range []*Transaction{child, orphanOfRival, spender} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertTxs(t, "candidates", mp.Candidates(), spender)
+
+	// parent and rival enter the pool through another path
+	accepted := NewTxHandler(mp.handler.Pool).HandleTxs([]*Transaction{parent, rival})
+	assertTxs(t, "accepted", accepted, parent, rival)
+	evicted := mp.Confirm(accepted)
+	assertTxs(t, "evicted", evicted, spender)
+	assertTxs(t, "candidates", mp.Candidates(), child, orphanOfRival)
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	mp, key := newTestMempool(2)
+	orphan := hSpend(key, []UTXO{{TxHash: "txhash#9", Index: 0}}, 1)
+	if err := mp.Add(orphan); err != nil {
+		t.Fatal(err)
+	}
+	if _, evicted := mp.RunEpoch(); len(evicted) != 0 {
+		t.Fatal("orphan expired after one epoch")
+	}
+	late := hSpend(key, []UTXO{{TxHash: "txhash#8", Index: 0}}, 1)
+	if err := mp.Add(late); err != nil {
+		t.Fatal(err)
+	}
+	_, evicted := mp.RunEpoch()
+	assertTxs(t, "expired", evicted, orphan)
+	if mp.Get(late.Hash) != late {
+		t.Fatal("orphan expired before its time")
+	}
+}
+
+// Transactions chained in random order all get in within one epoch.
+func TestMempoolRandomArrivalOrder(t *testing.T) {
+	mp, key := newTestMempool(0)
+	var txs []*Transaction
+	outputs := []UTXO{{TxHash: "txhash#1", Index: 0}, {TxHash: "txhash#1", Index: 1}}
+	values := []float64{10, 5}
+	for len(txs) < 30 {
+		pick := rng.Intn(len(outputs))
+		in, value := outputs[pick], values[pick]
+		outputs = append(outputs[:pick], outputs[pick+1:]...)
+		values = append(values[:pick], values[pick+1:]...)
+		tx := hSpend(key, []UTXO{in}, value/2, value/2)
+		txs = append(txs, tx)
+		outputs = append(outputs, hOut(tx, 0), hOut(tx, 1))
+		values = append(values, value/2, value/2)
+	}
+
+	for _, idx := range rng.Perm(len(txs)) {
+		if err := mp.Add(txs[idx]); err != nil {
+			t.Fatalf("seed %v: %v", seed, err)
+		}
+	}
+	if len(mp.Candidates()) != len(txs) {
+		t.Fatalf("seed %v: %v candidates, expected %v", seed, len(mp.Candidates()), len(txs))
+	}
+	accepted, evicted := mp.RunEpoch()
+	if len(accepted) != len(txs) || len(evicted) != 0 {
+		t.Fatalf("seed %v: %v accepted, %v evicted", seed, len(accepted), len(evicted))
+	}
+	for idx := range outputs {
+		if mp.handler.Pool.GetTxOutput(outputs[idx]).Value != values[idx] {
+			t.Fatalf("seed %v: output %v has the wrong value", seed, idx)
+		}
+	}
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	mp, key := newTestMempool(0)
+	coin := []UTXO{{TxHash: "txhash#1", Index: 0}}
+
+	// each replacement pays more than the last, and the child of the first goes with it
+	original := hReplaceable(key, coin, 9)
+	child := hSpend(key, []UTXO{hOut(original, 0)}, 8)
+	chain := []*Transaction{original}
+	for _, value := range []float64{7.5, 7, 6} {
+		chain = append(chain, hReplaceable(key, coin, value))
+	}
+	for idx, tx := range chain {
+		if err := mp.Add(tx); err != nil {
+			t.Fatalf("replacement %v: %v", idx, err)
+		}
+		if idx == 0 {
+			if err := mp.Add(child); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, tx := range append([]*Transaction{child}, chain[:len(chain)-1]...) {
+		if mp.Get(tx.Hash) != nil {
+			t.Fatalf("%x was not replaced", tx.Hash)
+		}
+	}
+	assertTxs(t, "candidates", mp.Candidates(), chain[len(chain)-1])
+
+	// a lower or equal fee does not replace, nor does a final transaction
+	if err := mp.Add(hReplaceable(key, coin, 7)); err != ErrReplacementFee {
+		t.Fatalf("lower fee: Add returned %v", err)
+	}
+	if err := mp.Add(hSpend(key, coin, 6)); err != ErrReplacementFee {
+		t.Fatalf("equal fee: Add returned %v", err)
+	}
+	final := hSpend(key, coin, 5)
+	if err := mp.Add(final); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(hReplaceable(key, coin, 1)); err != ErrTxConflict {
+		t.Fatalf("replacing a final transaction: Add returned %v", err)
+	}
+
+	accepted, _ := mp.RunEpoch()
+	assertTxs(t, "accepted", accepted, final)
+}
+
+func TestMempoolReplacementFeeRate(t *testing.T) {
+	mp, key := newTestMempool(0)
+	coin := []UTXO{{TxHash: "txhash#1", Index: 0}}
+	original := hReplaceable(key, coin, 9)
+	if err := mp.Add(original); err != nil {
+		t.Fatal(err)
+	}
+
+	// many outputs pay a higher fee at a lower rate
+	values := make([]float64, 20)
+	for idx := range values {
+		values[idx] = 8.9 / 20
+	}
+	bloated := hReplaceable(key, coin, values...)
+	if err := mp.Add(bloated); err != ErrReplacementFee {
+		t.Fatalf("lower fee rate: Add returned %v", err)
+	}
+
+	// two conflicts, both beaten on fee rate, but not on their total fee
+	second := hReplaceable(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 4)
+	if err := mp.Add(second); err != nil {
+		t.Fatal(err)
+	}
+	both := []UTXO{{TxHash: "txhash#1", Index: 0}, {TxHash: "txhash#1", Index: 1}}
+	if err := mp.Add(hReplaceable(key, both, 13.5)); err != ErrReplacementFee {
+		t.Fatalf("fee below the total of the conflicts: Add returned %v", err)
+	}
+	merged := hReplaceable(key, both, 12.5)
+	if err := mp.Add(merged); err != nil {
+		t.Fatal(err)
+	}
+	assertTxs(t, "candidates", mp.Candidates(), merged)
+}
+
+func TestMempoolReplacementPinning(t *testing.T) {
+	mp, key := newTestMempool(0)
+	mp.MaxReplacementEvictions = 10
+	coin := []UTXO{{TxHash: "txhash#1", Index: 0}}
+	original := hReplaceable(key, coin, 9)
+	if err := mp.Add(original); err != nil {
+		t.Fatal(err)
+	}
+
+	// a final child does not pin its replaceable parent
+	pin := hSpend(key, []UTXO{hOut(original, 0)}, 9)
+	if err := mp.Add(pin); err != nil {
+		t.Fatal(err)
+	}
+	replacement := hReplaceable(key, coin, 8)
+	if err := mp.Add(replacement); err != nil {
+		t.Fatalf("final child pinned its parent: %v", err)
+	}
+	if mp.Get(pin.Hash) != nil {
+		t.Fatal("child of a replaced transaction left in the mempool")
+	}
+
+	// a replacement cannot spend the outputs it evicts
+	if err := mp.Add(hReplaceable(key, []UTXO{coin[0], hOut(replacement, 0)}, 1)); err != ErrTxConflict {
+		t.Fatalf("replacement spending a conflict: Add returned %v", err)
+	}
+
+	// a chain of descendants longer than the eviction limit does pin, whatever the fee
+	parent := replacement
+	for idx := 0; idx < mp.MaxReplacementEvictions; idx++ {
+		child := hSpend(key, []UTXO{hOut(parent, 0)}, 8)
+		if err := mp.Add(child); err != nil {
+			t.Fatal(err)
+		}
+		parent = child
+	}
+	if err := mp.Add(hReplaceable(key, coin, 0)); err != ErrReplacementEvictions {
+		t.Fatalf("replacement of %v transactions: Add returned %v", mp.MaxReplacementEvictions+1, err)
+	}
+	if mp.Len() != mp.MaxReplacementEvictions+1 {
+		t.Fatalf("%v transactions in the mempool after a refused replacement", mp.Len())
+	}
+
+	// nor can an orphan replace anything
+	if err := mp.Add(hReplaceable(key, []UTXO{coin[0], {TxHash: "txhash#9", Index: 0}}, 0)); err != ErrTxConflict {
+		t.Fatalf("orphan replacement: Add returned %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package scrooge
+
+import (
+	"bytes"
+
+	"scrooge/cryptoutil"
+)
+
+// The Merkle tree of a list of hashes hashes each behind a 0 byte into a leaf, and each
+// pair of nodes behind a 1 byte into their parent, so that no leaf passes for an inner
+// node. A node left without a sibling moves up a level as it is.
+
+func merkleLeaf(hash []byte) []byte {
+	return cryptoutil.HashSha256(append([]byte{0}, hash...))
+}
+
+func merkleNode(left, right []byte) []byte {
+	return cryptoutil.HashSha256(append(append([]byte{1}, left...), right...))
+}
+
+// merkleParents returns the level of the tree above level.
+func merkleParents(level [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(level)+1)/2)
+	for idx := 0; idx < len(level); idx += 2 {
+		if idx+1 == len(level) {
+			parents = append(parents, level[idx])
+		} else {
+			parents = append(parents, merkleNode(level[idx], level[idx+1]))
+		}
+	}
+	return parents
+}
+
+// MerkleRoot returns the root of the Merkle tree of hashes, or the hash of nothing if
+// there are none.
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return cryptoutil.HashSha256(nil)
+	}
+	level := make([][]byte, len(hashes))
+	for idx, hash := range hashes {
+		level[idx] = merkleLeaf(hash)
+	}
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return level[0]
+}
+
+// MerkleProof shows that a hash is the leaf at Index of a Merkle tree of Count leaves,
+// through the siblings of the nodes on its path to the root, from the bottom up.
+type MerkleProof struct {
+	Index    int
+	Count    int
+	Siblings [][]byte
+}
+
+// NewMerkleProof returns the proof that hashes[idx] is in the Merkle tree of hashes.
+func NewMerkleProof(hashes [][]byte, idx int) *MerkleProof {
+	proof := &MerkleProof{Index: idx, Count: len(hashes)}
+	level := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		level[i] = merkleLeaf(hash)
+	}
+	for ; len(level) > 1; idx /= 2 {
+		if sibling := idx ^ 1; sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sibling])
+		}
+		level = merkleParents(level)
+	}
+	return proof
+}
+
+// Verify tells whether proof shows hash to be in the Merkle tree of root, using each
+// of its siblings exactly once.
+func (proof *MerkleProof) Verify(hash, root []byte) bool {
+	if proof.Index < 0 || proof.Index >= proof.Count {
+		return false
+	}
+	node, siblings := merkleLeaf(hash), proof.Siblings
+	for idx, count := proof.Index, proof.Count; count > 1; idx, count = idx/2, (count+1)/2 {
+		if idx^1 >= count {
+			continue
+		}
+		if len(siblings) == 0 {
+			return false
+		}
+		if idx%2 == 0 {
+			node = merkleNode(node, siblings[0])
+		} else {
+			node = merkleNode(siblings[0], node)
+		}
+		siblings = siblings[1:]
+	}
+	return len(siblings) == 0 && bytes.Equal(node, root)
+}
@@ -0,0 +1,56 @@
+package scrooge
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	if root := MerkleRoot([][]byte{a}); !bytes.Equal(root, merkleLeaf(a)) {
+		t.Fatalf("root of one hash is not its leaf")
+	}
+	expected := merkleNode(merkleNode(merkleLeaf(a), merkleLeaf(b)), merkleLeaf(c))
+	if root := MerkleRoot([][]byte{a, b, c}); !bytes.Equal(root, expected) {
+		t.Fatalf("root of three hashes does not promote the third")
+	}
+	if bytes.Equal(MerkleRoot([][]byte{a, b}), MerkleRoot([][]byte{b, a})) {
+		t.Fatalf("root does not depend on the order")
+	}
+	// an inner node does not pass for a leaf
+	if bytes.Equal(MerkleRoot([][]byte{merkleLeaf(a), merkleLeaf(b)}), MerkleRoot([][]byte{a, b})) {
+		t.Fatalf("leaves and inner nodes hash alike")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		hashes := make([][]byte, count)
+		for idx := range hashes {
+			hashes[idx] = []byte(fmt.Sprint("tx", idx))
+		}
+		root := MerkleRoot(hashes)
+		for idx := range hashes {
+			proof := NewMerkleProof(hashes, idx)
+			if !proof.Verify(hashes[idx], root) {
+				t.Fatalf("proof of leaf %v of %v does not verify", idx, count)
+			}
+			if proof.Verify([]byte("other"), root) {
+				t.Fatalf("proof of leaf %v of %v verifies another hash", idx, count)
+			}
+			moved := *proof
+			moved.Index = (idx + 1) % count
+			if count > 1 && moved.Verify(hashes[idx], root) {
+				t.Fatalf("proof of leaf %v of %v verifies at index %v", idx, count, moved.Index)
+			}
+			if len(proof.Siblings) > 0 {
+				short := *proof
+				short.Siblings = proof.Siblings[1:]
+				if short.Verify(hashes[idx], root) {
+					t.Fatalf("proof of leaf %v of %v verifies without a sibling", idx, count)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package scrooge
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"scrooge/cryptoutil"
+)
+
+// Metrics receives measurements from a TxHandler and a UTXOPool. Package
+// scrooge/metrics has an implementation exported in the Prometheus text format.
+type Metrics interface {
+	// EpochHandled is called at the end of every HandleTxs with its duration, the
+	// numbers of transactions accepted and deferred and the fees the accepted ones pay.
+	EpochHandled(duration time.Duration, accepted, deferred int, fees float64)
+	// TxRejected is called for every transaction found invalid or non-standard.
+	TxRejected(reason string)
+	// SignatureChecked is called for every signature checked, cacheHit telling whether
+	// it was found in the signature cache.
+	SignatureChecked(cacheHit bool)
+	// PoolChanged is called with the number of UTXOs in the pool and their total value
+	// when it changes.
+	PoolChanged(utxos int, value float64)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) EpochHandled(time.Duration, int, int, float64) {}
+func (nopMetrics) TxRejected(string)                             {}
+func (nopMetrics) SignatureChecked(bool)                         {}
+func (nopMetrics) PoolChanged(int, float64)                      {}
+
+// DefaultSigCacheSize is the SigCacheSize of handlers made by NewTxHandler.
+const DefaultSigCacheSize = 10000
+
+func (handler *TxHandler) metrics() Metrics {
+	if handler.Metrics != nil {
+		return handler.Metrics
+	}
+	return nopMetrics{}
+}
+
+// sigCacheKey identifies the check of sig over data by the public key encoded as key.
+// kind separates the signature schemes.
+func sigCacheKey(kind byte, key, data, sig []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte{kind})
+	for _, field := range [][]byte{key, data, sig} {
+		binary.Write(h, binary.BigEndian, uint32(len(field)))
+		h.Write(field)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// verifyRSA checks sig over data by pubKey, which fails if pubKey is unset.
+func (handler *TxHandler) verifyRSA(pubKey *rsa.PublicKey, data, sig []byte) bool {
+	if pubKey.N == nil {
+		return false
+	}
+	key := append(pubKey.N.Bytes(), byte(pubKey.E>>24), byte(pubKey.E>>16), byte(pubKey.E>>8), byte(pubKey.E))
+	return handler.verifyCached(sigCacheKey('r', key, data, sig), func() bool {
+		return cryptoutil.RSAVerify(pubKey, data, sig)
+	})
+}
+
+func (handler *TxHandler) verifyEd25519(pubKey ed25519.PublicKey, data, sig []byte) bool {
+	return handler.verifyCached(sigCacheKey('e', pubKey, data, sig), func() bool {
+		return len(pubKey) == ed25519.PublicKeySize && ed25519.Verify(pubKey, data, sig)
+	})
+}
+
+// verifyCached runs verify unless the check identified by key already passed. Only
+// passed checks are cached; the cache is emptied when it reaches SigCacheSize.
+func (handler *TxHandler) verifyCached(key [sha256.Size]byte, verify func() bool) bool {
+	if _, ok := handler.sigCache[key]; ok {
+		handler.metrics().SignatureChecked(true)
+		return true
+	}
+	handler.metrics().SignatureChecked(false)
+	if !verify() {
+		return false
+	}
+	if handler.SigCacheSize > 0 {
+		if handler.sigCache == nil || len(handler.sigCache) >= handler.SigCacheSize {
+			handler.sigCache = make(map[[sha256.Size]byte]struct{})
+		}
+		handler.sigCache[key] = struct{}{}
+	}
+	return true
+}
+
+func (pool *UTXOPool) metrics() Metrics {
+	if pool.poolMetrics != nil {
+		return pool.poolMetrics
+	}
+	return nopMetrics{}
+}
+
+// SetMetrics makes pool report its size to m, starting with its current one. A nil m
+// stops the reports.
+func (pool *UTXOPool) SetMetrics(m Metrics) {
+	pool.poolMetrics = m
+	pool.value = 0
+	for _, out := range pool.H {
+		if out != nil {
+			pool.value += out.Value
+		}
+	}
+	pool.metrics().PoolChanged(len(pool.H), pool.value)
+}
@@ -0,0 +1,106 @@
+package scrooge
+
+import (
+	"testing"
+	"time"
+)
+
+// recordedMetrics keeps the last measurements it receives.
+type recordedMetrics struct {
+	epochs, accepted, deferred int
+	fees                       float64
+	rejected                   map[string]int
+	hits, misses               int
+	utxos                      int
+	value                      float64
+}
+
+func (m *recordedMetrics) EpochHandled(duration time.Duration, accepted, deferred int, fees float64) {
+	m.epochs++
+	m.accepted += accepted
+	m.deferred += deferred
+	m.fees += fees
+}
+
+func (m *recordedMetrics) TxRejected(reason string) {
+	if m.rejected == nil {
+		m.rejected = make(map[string]int)
+	}
+	m.rejected[reason]++
+}
+
+func (m *recordedMetrics) SignatureChecked(cacheHit bool) {
+	if cacheHit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+}
+
+func (m *recordedMetrics) PoolChanged(utxos int, value float64) {
+	m.utxos, m.value = utxos, value
+}
+
+func TestTxHandlerMetrics(t *testing.T) {
+	handler, key := newBudgetHandler(3)
+	m := &recordedMetrics{}
+	handler.Metrics = m
+	handler.Pool.SetMetrics(m)
+	if m.utxos != 3 || m.value != 30 {
+		t.Fatalf("pool reported %v UTXOs worth %v", m.utxos, m.value)
+	}
+
+	tx := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 4, 5.5)
+	forged := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 9)
+	forged.Inputs[0].Signature[0] ^= 1
+	overspend := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 2}}, 11)
+	if !handler.IsValidTx(tx) {
+		t.Fatal("transaction is not valid")
+	}
+	accepted := handler.HandleTxs([]*Transaction{tx, forged, overspend})
+	assertTxs(t, "accepted", accepted, tx)
+
+	if m.epochs != 1 || m.accepted != 1 || m.fees != 0.5 {
+		t.Errorf("epoch measurements: %+v", m)
+	}
+	if m.rejected["invalid spend"] != 1 || m.rejected["outputs exceed inputs"] != 1 || len(m.rejected) != 2 {
+		t.Errorf("rejections: %v", m.rejected)
+	}
+	// tx was checked twice, the second time from the cache
+	if m.hits != 1 || m.misses != 3 {
+		t.Errorf("signature checks: %v hits, %v misses", m.hits, m.misses)
+	}
+	if m.utxos != 4 || m.value != 29.5 {
+		t.Errorf("pool reported %v UTXOs worth %v after the epoch", m.utxos, m.value)
+	}
+}
+
+func TestSigCache(t *testing.T) {
+	handler, key := newBudgetHandler(1)
+	handler.SigCacheSize = 1
+	m := &recordedMetrics{}
+	handler.Metrics = m
+	tx := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+	for i := 0; i < 3; i++ {
+		handler.IsValidTx(tx)
+	}
+	if m.hits != 2 || m.misses != 1 {
+		t.Fatalf("%v hits, %v misses", m.hits, m.misses)
+	}
+
+	// a cached signature is only good for the same key and data
+	other := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 8)
+	other.Inputs[0].Signature = tx.Inputs[0].Signature
+	other.Finalize()
+	if handler.IsValidTx(other) {
+		t.Fatal("signature of another transaction accepted from the cache")
+	}
+
+	handler.SigCacheSize = 0
+	handler.sigCache = nil
+	handler.IsValidTx(tx)
+	handler.IsValidTx(tx)
+	if m.hits != 2 {
+		t.Fatalf("cache used with SigCacheSize 0: %v hits", m.hits)
+	}
+}
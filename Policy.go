@@ -0,0 +1,92 @@
+package scrooge
+
+import "errors"
+
+// ErrNonStandard matches, with errors.Is, every error returned by Policy.Check.
+var ErrNonStandard = errors.New("scrooge: non-standard transaction")
+
+// policyError is an error kind of Policy.Check.
+type policyError string
+
+func (err policyError) Error() string { return string(err) }
+
+func (err policyError) Is(target error) bool { return target == ErrNonStandard }
+
+var (
+	ErrTxTooLarge      error = policyError("scrooge: transaction larger than policy allows")
+	ErrTooManyInputs   error = policyError("scrooge: transaction has more inputs than policy allows")
+	ErrTooManyOutputs  error = policyError("scrooge: transaction has more outputs than policy allows")
+	ErrOutputType      error = policyError("scrooge: output type not allowed by policy")
+	ErrZeroValueOutput error = policyError("scrooge: zero-value output")
+	ErrDustOutput      error = policyError("scrooge: output below the dust threshold")
+)
+
+// Policy holds the local rules a node applies on top of the consensus rules of
+// IsValidTx before relaying or accepting a transaction. A transaction breaking them is
+// valid but non-standard: another node may accept it. Zero fields disable their rule.
+type Policy struct {
+	// MaxSize bounds the Size of a transaction.
+	MaxSize int
+	// MaxInputs and MaxOutputs bound the number of inputs and outputs of a transaction.
+	MaxInputs  int
+	MaxOutputs int
+	// DustThreshold is the smallest value of a non-zero output.
+	DustThreshold float64
+	// RejectZeroValue forbids outputs of value zero.
+	RejectZeroValue bool
+	// AllowedTypes lists the output types a transaction may create, nil for all.
+	AllowedTypes []OutputType
+}
+
+// DefaultPolicy returns the policy of a node that has not been configured otherwise.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MaxSize:         100000,
+		MaxInputs:       1000,
+		MaxOutputs:      1000,
+		DustThreshold:   1e-6,
+		RejectZeroValue: true,
+	}
+}
+
+// Check returns the first rule of p broken by tx, nil if tx is standard. A nil policy
+// allows everything.
+func (p *Policy) Check(tx *Transaction) error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxSize > 0 && tx.Size() > p.MaxSize {
+		return ErrTxTooLarge
+	}
+	if p.MaxInputs > 0 && len(tx.Inputs) > p.MaxInputs {
+		return ErrTooManyInputs
+	}
+	if p.MaxOutputs > 0 && len(tx.Outputs) > p.MaxOutputs {
+		return ErrTooManyOutputs
+	}
+	for _, out := range tx.Outputs {
+		if !p.allows(out.Type) {
+			return ErrOutputType
+		}
+		if out.Value == 0 {
+			if p.RejectZeroValue {
+				return ErrZeroValueOutput
+			}
+		} else if out.Value < p.DustThreshold {
+			return ErrDustOutput
+		}
+	}
+	return nil
+}
+
+func (p *Policy) allows(t OutputType) bool {
+	if p.AllowedTypes == nil {
+		return true
+	}
+	for _, allowed := range p.AllowedTypes {
+		if t == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package scrooge
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyRules(t *testing.T) {
+	handler, key := newBudgetHandler(3)
+	inputs := []UTXO{{TxHash: "txhash#1", Index: 0}, {TxHash: "txhash#1", Index: 1}, {TxHash: "txhash#1", Index: 2}}
+	standard := hSpend(key, inputs[:1], 9)
+
+	cases := []struct {
+		policy Policy
+		tx     *Transaction
+		err    error
+	}{
+		{Policy{MaxSize: standard.Size() - 1}, standard, ErrTxTooLarge},
+		{Policy{MaxInputs: 2}, hSpend(key, inputs, 20), ErrTooManyInputs},
+		{Policy{MaxOutputs: 2}, hSpend(key, inputs[:1], 1, 2, 3), ErrTooManyOutputs},
+		{Policy{AllowedTypes: []OutputType{PayToMultisig}}, standard, ErrOutputType},
+		{Policy{RejectZeroValue: true}, hSpend(key, inputs[:1], 9, 0), ErrZeroValueOutput},
+		{Policy{DustThreshold: 0.01}, hSpend(key, inputs[:1], 9, 0.001), ErrDustOutput},
+		// zero is not dust, only RejectZeroValue forbids it
+		{Policy{DustThreshold: 0.01}, hSpend(key, inputs[:1], 9, 0), nil},
+		{Policy{MaxSize: standard.Size(), AllowedTypes: []OutputType{PayToAddress}}, standard, nil},
+	}
+	for idx, c := range cases {
+		if !handler.IsValidTx(c.tx) {
+			t.Fatalf("case %v: transaction is not valid", idx)
+		}
+		err := c.policy.Check(c.tx)
+		if err != c.err {
+			t.Errorf("case %v: %v, expected %v", idx, err, c.err)
+		}
+		if c.err != nil && !errors.Is(err, ErrNonStandard) {
+			t.Errorf("case %v: %v is not ErrNonStandard", idx, err)
+		}
+	}
+	if err := (*Policy)(nil).Check(hSpend(key, inputs[:1], 0)); err != nil {
+		t.Fatalf("nil policy: %v", err)
+	}
+	if errors.Is(ErrTxConflict, ErrNonStandard) {
+		t.Fatal("consensus and mempool errors are not policy errors")
+	}
+}
+
+func TestPolicyAppliedByHandleTxs(t *testing.T) {
+	handler, key := newBudgetHandler(2)
+	handler.Policy = &Policy{DustThreshold: 0.01}
+	dust := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9, 0.001)
+	standard := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 9)
+	assertTxs(t, "accepted", handler.HandleTxs([]*Transaction{dust, standard}), standard)
+
+	// the selection by fee rate applies the policy too
+	handler, key = newBudgetHandler(2)
+	handler.Policy = &Policy{DustThreshold: 0.01}
+	handler.MaxEpochTxs = 1
+	dust = hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 1, 0.001)
+	standard = hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 9)
+	assertTxs(t, "accepted by fee rate", handler.HandleTxs([]*Transaction{dust, standard}), standard)
+	assertTxs(t, "deferred", handler.Deferred())
+}
+
+func TestPolicyAppliedByMempool(t *testing.T) {
+	mp, key := newTestMempool(0)
+	mp.handler.Policy = &Policy{MaxOutputs: 1}
+	tx := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 5, 5)
+	if err := mp.Add(tx); err != ErrTooManyOutputs {
+		t.Fatalf("non-standard transaction added: %v", err)
+	}
+	if !mp.handler.IsValidTx(tx) {
+		t.Fatal("refused transaction is not valid")
+	}
+	mp.handler.Policy = nil
+	if err := mp.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -75,6 +75,35 @@ func hGetWalletFor(wallets []*PersonWallet, name string) *PersonWallet {
 	return nil
 }
 
+// hBuild returns a transaction in which from spends inputs into outputs of the given
+// values, paying each of to in turn and the last of them for the outputs left over.
+func hBuild(from *rsa.PrivateKey, inputs []UTXO, replaceable bool, to []*rsa.PrivateKey, values ...float64) *Transaction {
+	tx := NewTransaction()
+	tx.Replaceable = replaceable
+	for _, in := range inputs {
+		tx.AddInput([]byte(in.TxHash), in.Index)
+	}
+	for idx, value := range values {
+		tx.AddOutput(value, to[min(idx, len(to)-1)].PublicKey)
+	}
+	for idx := range inputs {
+		hToAddSignature(tx, from, idx)
+	}
+	tx.Finalize()
+	return tx
+}
+
+// hMine returns a block mined on parent with txs, paying value to key. A nil parent
+// gives a genesis block of testDifficulty.
+func hMine(parent *Block, key *rsa.PrivateKey, value float64, txs ...*Transaction) *Block {
+	block := NewBlock(nil, key.PublicKey, value, txs, testDifficulty)
+	if parent != nil {
+		block = NewBlock(parent.Hash, key.PublicKey, value, txs, parent.Difficulty)
+	}
+	block.Mine()
+	return block
+}
+
 func hToAddSignature(myTx *Transaction, prKey *rsa.PrivateKey, txIdx int) {
 	rawData := myTx.GetRawDataToSign(txIdx)
 	signature, err := cryptoutil.RSASign(prKey, rawData)
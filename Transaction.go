@@ -2,31 +2,77 @@ package scrooge
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/binary"
-	"encoding/hex"
-	"fmt"
+	"errors"
 
 	"scrooge/cryptoutil"
 )
 
-var debugOutput bool = false
+var ErrTxUnknownInput = errors.New("scrooge: transaction spends an output not in the pool")
+
+// OutputType selects the condition under which a TOutput can be spent.
+type OutputType int
+
+const (
+	// PayToAddress outputs are spent with a signature by Address.
+	PayToAddress OutputType = iota
+	// PayToMultisig outputs are spent with signatures by both Address and CoSigner.
+	PayToMultisig
+	// PayToRevocable outputs are spent by Address once Delay epochs have passed since
+	// the output entered the pool, or at any time by CoSigner together with the
+	// preimage of RevocationHash.
+	PayToRevocable
+	// PayToEd25519 outputs are spent with an Ed25519 signature by EdAddress.
+	PayToEd25519
+)
 
 type TOutput struct {
 	Value   float64
 	Address rsa.PublicKey
+
+	// the fields below are only used by outputs other than PayToAddress
+	Type           OutputType
+	CoSigner       rsa.PublicKey
+	RevocationHash []byte
+	Delay          int
+	EdAddress      ed25519.PublicKey
 }
 
 type TInput struct {
 	PrevTxHash []byte
 	OutputIdx  int
 	Signature  []byte
+
+	// CoSignature is the CoSigner signature of a PayToMultisig input.
+	CoSignature []byte
+	// Preimage reveals the revocation secret when spending a PayToRevocable output
+	// through its CoSigner.
+	Preimage []byte
 }
 
 type Transaction struct {
 	Hash    []byte
 	Inputs  []TInput
 	Outputs []TOutput
+
+	// Replaceable signals that the transaction may be replaced in the mempool by a
+	// conflicting one paying a higher fee. It is signed with the rest of the transaction.
+	Replaceable bool
+}
+
+// replaceableTag ends the encoding of replaceable transactions, so the encoding of the
+// others is unchanged.
+var replaceableTag = []byte("\x00replaceable")
+
+// OwnerAddress returns the address of the key spending out: its EdAddress for
+// PayToEd25519 outputs and its Address for the others.
+func (out *TOutput) OwnerAddress() string {
+	if out.Type == PayToEd25519 {
+		return cryptoutil.GetEd25519Address(out.EdAddress)
+	}
+	return cryptoutil.GetAddress(out.Address)
 }
 
 func NewTransaction() *Transaction {
@@ -35,15 +81,35 @@ func NewTransaction() *Transaction {
 
 func (tx *Transaction) AddInput(prevTxHash []byte, outputIdx int) {
 	tx.Inputs = append(tx.Inputs, TInput{PrevTxHash: prevTxHash, OutputIdx: outputIdx})
-	if debugOutput {
-		fmt.Println(len(tx.Inputs))
-	}
 }
 
 func (tx *Transaction) AddOutput(value float64, address rsa.PublicKey) {
 	tx.Outputs = append(tx.Outputs, TOutput{Value: value, Address: address})
 }
 
+// AddMultisigOutput adds an output that needs signatures by both address and coSigner.
+func (tx *Transaction) AddMultisigOutput(value float64, address rsa.PublicKey, coSigner rsa.PublicKey) {
+	tx.Outputs = append(tx.Outputs, TOutput{Value: value, Address: address, Type: PayToMultisig, CoSigner: coSigner})
+}
+
+// AddRevocableOutput adds an output that address can spend delay epochs after it is
+// accepted, and that coSigner can spend at any time with the preimage of revocationHash.
+func (tx *Transaction) AddRevocableOutput(value float64, address rsa.PublicKey, coSigner rsa.PublicKey, revocationHash []byte, delay int) {
+	tx.Outputs = append(tx.Outputs, TOutput{
+		Value:          value,
+		Address:        address,
+		Type:           PayToRevocable,
+		CoSigner:       coSigner,
+		RevocationHash: revocationHash,
+		Delay:          delay,
+	})
+}
+
+// AddEd25519Output adds an output spendable with an Ed25519 signature by address.
+func (tx *Transaction) AddEd25519Output(value float64, address ed25519.PublicKey) {
+	tx.Outputs = append(tx.Outputs, TOutput{Value: value, Type: PayToEd25519, EdAddress: address})
+}
+
 func (tx *Transaction) AddSignature(signature []byte, idx int) {
 	//        inputs.get(index).addSignature(signature);
 	if idx < len(tx.Inputs) {
@@ -52,6 +118,20 @@ func (tx *Transaction) AddSignature(signature []byte, idx int) {
 
 }
 
+// AddCoSignature sets the CoSigner signature of the idx-th input.
+func (tx *Transaction) AddCoSignature(signature []byte, idx int) {
+	if idx < len(tx.Inputs) {
+		tx.Inputs[idx].CoSignature = signature
+	}
+}
+
+// AddPreimage sets the revocation preimage of the idx-th input.
+func (tx *Transaction) AddPreimage(preimage []byte, idx int) {
+	if idx < len(tx.Inputs) {
+		tx.Inputs[idx].Preimage = preimage
+	}
+}
+
 func (tx *Transaction) GetRawDataToSign(idx int) []byte {
 	if idx > tx.NumInputs() {
 		return nil
@@ -59,24 +139,15 @@ func (tx *Transaction) GetRawDataToSign(idx int) []byte {
 	// get the ith input - PrevTxHash
 	input := tx.Inputs[idx]
 	sigData := bytes.NewBuffer(input.PrevTxHash)
-	if debugOutput {
-		fmt.Printf("input.PrevTxHash: %v \n", sigData.Bytes())
-	}
 	// get the ith input - OutputIdx
 	binary.Write(sigData, binary.BigEndian, int32(input.OutputIdx))
-	if debugOutput {
-		fmt.Printf("input.PrevTxHash + input.OutputIdx: %v \n", sigData.Bytes())
-	}
 
 	// get all the output
 	for _, out := range tx.Outputs {
-		// add output[i].Value
-		sigData.Write(FloatToByte(out.Value))
-		// add output[i].Address
-		sigData.Write(cryptoutil.GetPEMPublicKey(out.Address))
+		writeOutput(sigData, &out)
 	}
-	if debugOutput {
-		fmt.Printf("len: %v  cap:%v\n", sigData.Len(), sigData.Cap())
+	if tx.Replaceable {
+		sigData.Write(replaceableTag)
 	}
 
 	return sigData.Bytes()
@@ -93,16 +164,15 @@ func (tx *Transaction) GetRawTx() []byte {
 		binary.Write(&rawData, binary.BigEndian, int32(in.OutputIdx))
 		// get signature
 		rawData.Write(in.Signature)
+		rawData.Write(in.CoSignature)
+		rawData.Write(in.Preimage)
 	}
 
 	for _, out := range tx.Outputs {
-		// get value
-		rawData.Write(FloatToByte(out.Value))
-		// get address
-		rawData.Write(cryptoutil.GetPEMPublicKey(out.Address))
+		writeOutput(&rawData, &out)
 	}
-	if debugOutput {
-		fmt.Printf("[GetRawTx()]len: %v  cap:%v\n", rawData.Len(), rawData.Cap())
+	if tx.Replaceable {
+		rawData.Write(replaceableTag)
 	}
 	return rawData.Bytes()
 	//	return []byte("some raw transaction....")
@@ -116,48 +186,58 @@ func (tx *Transaction) NumOutputs() int {
 	return len(tx.Outputs)
 }
 
+// Size returns the length in bytes of the raw transaction, the encoding hashed by
+// Finalize.
+func (tx *Transaction) Size() int {
+	return len(tx.GetRawTx())
+}
+
+// Fee returns the value of the outputs of pool spent by tx less the value of its
+// outputs. It fails with ErrTxUnknownInput if an input of tx is not in pool.
+func (tx *Transaction) Fee(pool *UTXOPool) (float64, error) {
+	var fee float64
+	for _, in := range tx.Inputs {
+		out := pool.GetTxOutput(UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx})
+		if out == nil {
+			return 0, ErrTxUnknownInput
+		}
+		fee += out.Value
+	}
+	for _, out := range tx.Outputs {
+		fee -= out.Value
+	}
+	return fee, nil
+}
+
 func (tx *Transaction) Finalize() {
 	tx.Hash = cryptoutil.HashSha256(tx.GetRawTx())
 }
 
+// writeOutput appends the encoding of out to buf. PayToAddress outputs encode as value
+// and address only, so their encoding is unchanged by the other output types.
+func writeOutput(buf *bytes.Buffer, out *TOutput) {
+	// add output[i].Value
+	buf.Write(FloatToByte(out.Value))
+	if out.Type == PayToEd25519 {
+		binary.Write(buf, binary.BigEndian, int32(out.Type))
+		buf.Write(out.EdAddress)
+		return
+	}
+	// add output[i].Address
+	buf.Write(cryptoutil.GetPEMPublicKey(out.Address))
+	if out.Type == PayToAddress {
+		return
+	}
+	binary.Write(buf, binary.BigEndian, int32(out.Type))
+	buf.Write(cryptoutil.GetPEMPublicKey(out.CoSigner))
+	if out.Type == PayToRevocable {
+		buf.Write(out.RevocationHash)
+		binary.Write(buf, binary.BigEndian, int32(out.Delay))
+	}
+}
+
 func FloatToByte(f float64) []byte {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.BigEndian, f)
 	return buf.Bytes()
 }
-
-func TestTransaction() {
-
-	myHex := []byte("0615487ebeff81fc55effa0305a8c87663bb99cf7dc0e55b78212341f5d35026")
-	myHex0InByte := make([]byte, hex.DecodedLen(len(myHex)))
-	hex.Decode(myHex0InByte, myHex)
-	fmt.Printf("hash in byte: %v\n", myHex0InByte)
-
-	pk1 := cryptoutil.GetPrivateKey()
-	pk2 := cryptoutil.GetPrivateKey()
-	pk3 := cryptoutil.GetPrivateKey()
-
-	myTx := NewTransaction()
-	myTx.AddInput(myHex0InByte, 0)
-	myTx.AddInput([]byte("prev tx hash #1"), 1)
-	myTx.AddInput([]byte("prev tx hash #2"), 0)
-	// myTx.AddOutput(5, cryptoutil.GetPEMPublicKey(pk1.PublicKey))
-	// myTx.AddOutput(2, cryptoutil.GetPEMPublicKey(pk1.PublicKey))
-	// myTx.AddOutput(3, cryptoutil.GetPEMPublicKey(pk2.PublicKey))
-	// myTx.AddOutput(1, cryptoutil.GetPEMPublicKey(pk3.PublicKey))
-	myTx.AddOutput(5, pk1.PublicKey)
-	myTx.AddOutput(2, pk1.PublicKey)
-	myTx.AddOutput(3, pk2.PublicKey)
-	myTx.AddOutput(1, pk3.PublicKey)
-	fmt.Printf("inputs: %v\n", myTx.NumInputs())
-	fmt.Printf("outputs: %v\n", myTx.NumOutputs())
-
-	rawDataToSign := myTx.GetRawDataToSign(0)
-	fmt.Printf("len:%v cap:%v\n", len(rawDataToSign), cap(rawDataToSign))
-	fmt.Printf("GetRawDataToSign: %v \n", rawDataToSign)
-	fmt.Printf("GetRawDataToSign: %x \n", rawDataToSign)
-
-	myTx.Finalize()
-	fmt.Printf("hash in byte: %v\n", myTx.Hash)
-	fmt.Printf("hash in hex: %x\n", myTx.Hash)
-}
@@ -3,18 +3,47 @@
 package scrooge
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"crypto/rsa"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"time"
 
 	"scrooge/cryptoutil"
 )
 
 type TxHandler struct {
 	Pool *UTXOPool
+	// MaxEpochBytes and MaxEpochTxs bound the total Size and the number of the
+	// transactions HandleTxs accepts in one epoch. Zero means no bound.
+	MaxEpochBytes int
+	MaxEpochTxs   int
+	// Fees, if set, records the fee rates of every epoch.
+	Fees *FeeEstimator
+	// Policy, if set, makes HandleTxs reject the non-standard transactions it is given.
+	// IsValidTx only applies the consensus rules.
+	Policy *Policy
+	// Logger, if set, receives why transactions are rejected at info level, with the
+	// detail of failed checks at debug level, and a summary of each epoch.
+	Logger *slog.Logger
+	// Metrics, if set, receives measurements of the epochs and checks of handler.
+	Metrics Metrics
+	// History, if set, records the accepted transactions and lets RollbackEpoch undo
+	// the epochs it recorded.
+	History *TxHistory
+	// SigCacheSize bounds the number of passed signature checks remembered, so that a
+	// transaction checked again, as when it is deferred, is not verified twice.
+	SigCacheSize int
+
+	deferred []*Transaction
+	sigCache map[[sha256.Size]byte]struct{}
 }
 
 func NewTxHandler(pool *UTXOPool) *TxHandler {
-	return &TxHandler{Pool: pool}
+	return &TxHandler{Pool: pool, SigCacheSize: DefaultSigCacheSize}
 }
 
 /**
@@ -22,44 +51,55 @@ func NewTxHandler(pool *UTXOPool) *TxHandler {
  * (1) all outputs claimed by {@code tx} are in the current UTXO pool,
  * (2) the signatures on each input of {@code tx} are valid,
  * (3) no UTXO is claimed multiple times by {@code tx},
- * (4) all of {@code tx}s output values are non-negative, and
+ * (4) all of {@code tx}s output values are non-negative and finite, and
  * (5) the sum of {@code tx}s input values is greater than or equal to the sum of its output
  *     values; and false otherwise.
  */
 func (handler *TxHandler) IsValidTx(tx *Transaction) bool {
+	log := handler.logger().With("tx", hex.EncodeToString(tx.Hash))
+	reject := func(reason string, attrs ...any) bool {
+		log.Info("transaction rejected", append([]any{"reason", reason}, attrs...)...)
+		handler.metrics().TxRejected(reason)
+		return false
+	}
+
 	txUTXOs := make(map[UTXO]bool)
 	var inValueSum, outValueSum float64
 	for inputIdx, txIn := range tx.Inputs {
 		// (3) no UTXO is claimed multiple times by {@code tx},
 		tmpUtxo := UTXO{TxHash: string(txIn.PrevTxHash), Index: txIn.OutputIdx}
 		if _, ok := txUTXOs[tmpUtxo]; ok {
-			fmt.Printf("%v is used as multiple input entry in transaction!\n", tmpUtxo)
-			return false
+			return reject("input spent twice", "input", inputIdx, "utxo", tmpUtxo)
 		}
 		txUTXOs[tmpUtxo] = true
 		// (1) all outputs claimed by {@code tx} are in the current UTXO pool
 		// what it actually means is whether the TxInput claimed existed in UTXO pool
 		exist := handler.Pool.Contains(tmpUtxo)
 		if !exist {
-			fmt.Printf("UTXO %v does not exist!\n", tmpUtxo)
-			return false
+			return reject("input not in pool", "input", inputIdx, "utxo", tmpUtxo)
 		}
 		// (2) the signatures on each input of {@code tx} are valid,
 		utxoTxOutput := handler.Pool.GetTxOutput(tmpUtxo)
 		//rawData := tx.GetRawDataToSign(txIn.OutputIdx)
 		rawData := tx.GetRawDataToSign(inputIdx)
-		isValid := cryptoutil.RSAVerify(&utxoTxOutput.Address, rawData, txIn.Signature)
+		isValid := handler.isValidSpend(tmpUtxo, utxoTxOutput, &txIn, rawData)
 		if !isValid {
-			fmt.Printf("rsa verification failed for Tx Input#%v!\ndata:%x\npub key:%x\nsign:%x\n", txIn.OutputIdx, rawData, utxoTxOutput.Address, txIn.Signature)
-			return false
+			log.Debug("spend check failed", "input", inputIdx, "utxo", tmpUtxo, "type", utxoTxOutput.Type,
+				"data", hex.EncodeToString(rawData), "signature", hex.EncodeToString(txIn.Signature))
+			return reject("invalid spend", "input", inputIdx, "utxo", tmpUtxo)
 		}
 		inValueSum += utxoTxOutput.Value
 	}
-	for _, txOut := range tx.Outputs {
-		// (4) all of {@code tx}s output values are non-negative, and
+	for outputIdx, txOut := range tx.Outputs {
+		// (4) all of {@code tx}s output values are non-negative and finite, and
 		if txOut.Value < 0 {
-			fmt.Printf("%v has negative value!\n", txOut)
-			return false
+			return reject("negative output value", "output", outputIdx, "value", txOut.Value)
+		}
+		if math.IsNaN(txOut.Value) || math.IsInf(txOut.Value, 0) {
+			return reject("non-finite output value", "output", outputIdx, "value", txOut.Value)
+		}
+		if reason := outputFault(&txOut); reason != "" {
+			return reject(reason, "output", outputIdx, "type", int(txOut.Type))
 		}
 		outValueSum += txOut.Value
 	}
@@ -67,26 +107,128 @@ func (handler *TxHandler) IsValidTx(tx *Transaction) bool {
 	// (5) the sum of {@code tx}s input values is greater than or equal to the sum of its output
 	// values; and false otherwise.
 	if inValueSum < outValueSum {
-		fmt.Printf("Sum of output value is greater than sum of input value for transaction with hash %x\n", tx.Hash)
-		return false
+		return reject("outputs exceed inputs", "in", inValueSum, "out", outValueSum)
 	}
 
 	return true
 }
 
+// outputFault returns why out cannot be created, or "" if it can: its type must be
+// known, the fields its type uses well formed, and the fields it does not use unset.
+func outputFault(out *TOutput) string {
+	switch out.Type {
+	case PayToAddress, PayToMultisig, PayToRevocable:
+		if !hasRSAKey(&out.Address) {
+			return "missing address"
+		}
+		if out.Type != PayToAddress && !hasRSAKey(&out.CoSigner) {
+			return "missing co-signer"
+		}
+		if out.Type == PayToAddress && out.CoSigner.N != nil || len(out.EdAddress) != 0 {
+			return "unused output field set"
+		}
+	case PayToEd25519:
+		if len(out.EdAddress) != ed25519.PublicKeySize {
+			return "malformed Ed25519 address"
+		}
+		if out.Address.N != nil || out.CoSigner.N != nil {
+			return "unused output field set"
+		}
+	default:
+		return "unknown output type"
+	}
+	if out.Type == PayToRevocable {
+		if len(out.RevocationHash) != sha256.Size || out.Delay < 0 {
+			return "malformed revocation condition"
+		}
+	} else if len(out.RevocationHash) != 0 || out.Delay != 0 {
+		return "unused output field set"
+	}
+	return ""
+}
+
+// hasRSAKey tells whether key is set to a usable public key.
+func hasRSAKey(key *rsa.PublicKey) bool {
+	return key.N != nil && key.N.Sign() > 0 && key.E > 1
+}
+
+// isValidSpend checks that txIn satisfies the spending condition of out, the output
+// held in the pool under utxo. rawData is the data signed by txIn. Fields of txIn the
+// condition does not use must be unset, as they are hashed with the transaction and
+// would let anyone change its hash.
+func (handler *TxHandler) isValidSpend(utxo UTXO, out *TOutput, txIn *TInput, rawData []byte) bool {
+	if len(txIn.CoSignature) != 0 && out.Type != PayToMultisig || len(txIn.Preimage) != 0 && out.Type != PayToRevocable {
+		handler.logger().Debug("input sets a field its output does not use", "utxo", utxo, "type", int(out.Type))
+		return false
+	}
+	switch out.Type {
+	case PayToAddress:
+		return handler.verifyRSA(&out.Address, rawData, txIn.Signature)
+	case PayToMultisig:
+		return handler.verifyRSA(&out.Address, rawData, txIn.Signature) &&
+			handler.verifyRSA(&out.CoSigner, rawData, txIn.CoSignature)
+	case PayToRevocable:
+		if txIn.Preimage != nil {
+			// revocation path, open to the CoSigner at any time
+			return bytes.Equal(cryptoutil.HashSha256(txIn.Preimage), out.RevocationHash) &&
+				handler.verifyRSA(&out.CoSigner, rawData, txIn.Signature)
+		}
+		if handler.Pool.Age(utxo) < out.Delay {
+			handler.logger().Debug("revocable output still locked", "utxo", utxo, "epochs", out.Delay-handler.Pool.Age(utxo))
+			return false
+		}
+		return handler.verifyRSA(&out.Address, rawData, txIn.Signature)
+	case PayToEd25519:
+		return handler.verifyEd25519(out.EdAddress, rawData, txIn.Signature)
+	}
+	handler.logger().Debug("unknown output type in pool", "utxo", utxo, "type", int(out.Type))
+	return false
+}
+
 /**
  * Handles each epoch by receiving an unordered array of proposed transactions, checking each
  * transaction for correctness, returning a mutually valid array of accepted transactions, and
  * updating the current UTXO pool as appropriate.
+ *
+ * When the proposed transactions exceed the epoch budget, they are selected by fee per byte
+ * instead, see selectByFeeRate. The array given is left as it is: the selection works on a
+ * copy of it.
  */
 func (handler *TxHandler) HandleTxs(possibleTxs []*Transaction) []*Transaction {
-	removedUTXOs := make([]*UTXO, 0, len(possibleTxs))
+	start := time.Now()
+	possibleTxs = append([]*Transaction(nil), possibleTxs...)
+	handler.deferred = nil
+	if handler.History != nil {
+		handler.History.beginEpoch(handler.Pool.Epoch)
+	}
+	proposed := len(possibleTxs)
+	var accepted []*Transaction
+	var fees float64
+	if handler.withinBudget(possibleTxs) {
+		accepted, fees = handler.handleInOrder(possibleTxs)
+	} else {
+		accepted, fees = handler.selectByFeeRate(possibleTxs)
+	}
+	handler.logger().Info("epoch handled", "epoch", handler.Pool.Epoch, "proposed", proposed,
+		"accepted", len(accepted), "deferred", len(handler.deferred))
+	handler.metrics().EpochHandled(time.Since(start), len(accepted), len(handler.deferred), fees)
+	handler.Pool.Epoch++
+	return accepted
+}
+
+// handleInOrder accepts the valid transactions of possibleTxs in order and returns them
+// with the fees they pay.
+func (handler *TxHandler) handleInOrder(possibleTxs []*Transaction) ([]*Transaction, float64) {
+	var rates []float64
+	var fees float64
 	for idx := 0; idx < len(possibleTxs); idx++ {
 		tx := possibleTxs[idx]
-		isValid := handler.IsValidTx(tx)
+		isValid := handler.isStandard(tx) && handler.IsValidTx(tx)
 		if isValid {
-			removedUTXOs = handler.removeInputFromUTXOPool(tx, removedUTXOs)
-			handler.addOutputIntoUTXOPool(tx)
+			fee, _ := tx.Fee(handler.Pool)
+			fees += fee
+			rates = append(rates, fee/float64(tx.Size()))
+			handler.apply(tx)
 		} else {
 			// remove tx from possibleTxs
 			possibleTxs[idx] = possibleTxs[len(possibleTxs)-1]
@@ -95,83 +237,63 @@ func (handler *TxHandler) HandleTxs(possibleTxs []*Transaction) []*Transaction {
 			idx--
 		}
 	}
-	return possibleTxs
+	handler.recordFees(rates, nil)
+	return possibleTxs, fees
 }
 
-func (handler *TxHandler) removeInputFromUTXOPool(tx *Transaction, removedUTXOs []*UTXO) []*UTXO {
-	for _, txInput := range tx.Inputs {
-		removeUtxo := &UTXO{TxHash: string(txInput.PrevTxHash), Index: txInput.OutputIdx}
-		handler.Pool.RemoveUTXO(*removeUtxo)
-		removedUTXOs = append(removedUTXOs, removeUtxo)
+// isStandard applies the Policy of handler to tx.
+func (handler *TxHandler) isStandard(tx *Transaction) bool {
+	if err := handler.Policy.Check(tx); err != nil {
+		handler.logger().Info("transaction non-standard", "tx", hex.EncodeToString(tx.Hash), "reason", err)
+		handler.metrics().TxRejected(err.Error())
+		return false
 	}
-	return removedUTXOs
+	return true
 }
 
-func (handler *TxHandler) addOutputIntoUTXOPool(tx *Transaction) {
-	for outIdx := 0; outIdx < len(tx.Outputs); outIdx++ {
-		tmpOutput := tx.Outputs[outIdx]
-		tmpUtxo := &UTXO{TxHash: string(tx.Hash), Index: outIdx}
-		handler.Pool.AddUTXO(*tmpUtxo, &tmpOutput)
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (handler *TxHandler) logger() *slog.Logger {
+	if handler.Logger != nil {
+		return handler.Logger
 	}
+	return discardLogger
 }
 
-//(tx.Outputs)
-
-func TestTxHandler() {
-
-	pk1 := cryptoutil.GetPrivateKey()
-	pk2 := cryptoutil.GetPrivateKey()
-	pk3 := cryptoutil.GetPrivateKey()
-
-	// create initial output in UTXOPool
-	utxos := []*UTXO{&UTXO{TxHash: "txhash#1", Index: 0},
-		&UTXO{TxHash: "txhash#1", Index: 1},
-		&UTXO{TxHash: "txhash#1", Index: 2},
-		&UTXO{TxHash: "txhash#2", Index: 0},
-		&UTXO{TxHash: "txhash#2", Index: 1},
+func (handler *TxHandler) recordFees(accepted, deferred []float64) {
+	if handler.Fees != nil {
+		handler.Fees.Record(EpochFees{Epoch: handler.Pool.Epoch, Accepted: accepted, Deferred: deferred})
 	}
+}
 
-	utxosOutput := []*TOutput{
-		&TOutput{Value: 10.5, Address: pk1.PublicKey},
-		&TOutput{Value: 15.5, Address: pk2.PublicKey},
-		&TOutput{Value: 5.5, Address: pk3.PublicKey},
-		&TOutput{Value: 1, Address: pk1.PublicKey},
-		&TOutput{Value: 12.3, Address: pk2.PublicKey},
-	}
+// Deferred returns the transactions the last HandleTxs left out for lack of room in
+// the epoch budget rather than for being invalid. They may be proposed again.
+func (handler *TxHandler) Deferred() []*Transaction {
+	return handler.deferred
+}
 
-	utxopool := NewUTXOPool()
-	for idx, item := range utxos {
-		utxopool.AddUTXO(*item, utxosOutput[idx])
+// apply moves tx into the pool: its inputs leave it and its outputs enter it.
+func (handler *TxHandler) apply(tx *Transaction) {
+	if handler.History != nil {
+		handler.History.record(handler.Pool, handler.Pool.Epoch, tx)
 	}
+	handler.removeInputFromUTXOPool(tx, nil)
+	handler.addOutputIntoUTXOPool(tx)
+}
 
-	newUtxos := utxopool.GetAllUTXO()
-	for _, item := range newUtxos {
-		fmt.Printf("item: %v\n", item)
+func (handler *TxHandler) removeInputFromUTXOPool(tx *Transaction, removedUTXOs []*UTXO) []*UTXO {
+	for _, txInput := range tx.Inputs {
+		removeUtxo := &UTXO{TxHash: string(txInput.PrevTxHash), Index: txInput.OutputIdx}
+		handler.Pool.RemoveUTXO(*removeUtxo)
+		removedUTXOs = append(removedUTXOs, removeUtxo)
 	}
-
-	txHandler := NewTxHandler(utxopool)
-
-	myTx := NewTransaction()
-	myTx.AddInput([]byte("txhash#1"), 0)
-
-	myTx.AddInput([]byte("prev tx hash #1"), 1)
-	myTx.AddOutput(5, pk1.PublicKey)
-	myTx.AddOutput(2, pk1.PublicKey)
-	myTx.AddOutput(3, pk2.PublicKey)
-	myTx.AddOutput(1, pk3.PublicKey)
-	toAddSignature(myTx, pk1, 0)
-	toAddSignature(myTx, pk1, 1)
-	myTx.Finalize()
-
-	txHandler.IsValidTx(myTx)
-
+	return removedUTXOs
 }
 
-func toAddSignature(myTx *Transaction, prKey *rsa.PrivateKey, txIdx int) {
-	rawData := myTx.GetRawDataToSign(txIdx)
-	signature, err := cryptoutil.RSASign(prKey, rawData)
-	if err == nil {
-		myTx.AddSignature(signature, txIdx)
-		fmt.Printf("txIdx:%v\npubKey:%x\nsignature:%x\n", txIdx, prKey.PublicKey, signature)
+func (handler *TxHandler) addOutputIntoUTXOPool(tx *Transaction) {
+	for outIdx := 0; outIdx < len(tx.Outputs); outIdx++ {
+		tmpOutput := tx.Outputs[outIdx]
+		tmpUtxo := &UTXO{TxHash: string(tx.Hash), Index: outIdx}
+		handler.Pool.AddUTXO(*tmpUtxo, &tmpOutput)
 	}
 }
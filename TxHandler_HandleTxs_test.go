@@ -165,6 +165,26 @@ func TestHandleTxsOnNonExistenceUTXO(t *testing.T) {
 	assertOutputAddedToUTXOPool(txHandler.Pool, possibleTxs, t)
 }
 
+// Test 7: test handleTransactions() leaves the array of proposed transactions as it is
+func TestHandleTxsLeavesArgument(t *testing.T) {
+	pool, wallets := testInit()
+
+	aliceWallet := hGetWalletFor(wallets, "Alice")
+	bobWallet := hGetWalletFor(wallets, "Bob")
+
+	invalidTx := createTestTransactionWithOutputExceedInput(aliceWallet, []int{0}, []*PersonWallet{bobWallet}, 1)
+	myTx := createTestTransaction(aliceWallet, []int{1}, []*PersonWallet{bobWallet})
+	possibleTxs := []*Transaction{invalidTx, myTx}
+
+	txHandler := NewTxHandler(pool)
+	if accepted := txHandler.HandleTxs(possibleTxs); len(accepted) != 1 || accepted[0] != myTx {
+		t.Fatalf("Result has %v tx, but it should only hold the valid one!", len(accepted))
+	}
+	if possibleTxs[0] != invalidTx || possibleTxs[1] != myTx {
+		t.Fatalf("The proposed transactions were changed to %v", possibleTxs)
+	}
+}
+
 func assertInputRemovedFromUTXOPool(pool *UTXOPool, txs []*Transaction, t *testing.T) {
 	for _, tx := range txs {
 		for _, tInput := range tx.Inputs {
@@ -0,0 +1,106 @@
+package scrooge
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+func decodeLog(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestTxHandlerLogsRejections(t *testing.T) {
+	var buf bytes.Buffer
+	handler, key := newBudgetHandler(1)
+	handler.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	forged := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+	forged.Inputs[0].Signature[0] ^= 1
+	handler.HandleTxs([]*Transaction{forged})
+
+	records := decodeLog(t, &buf)
+	if len(records) != 3 {
+		t.Fatalf("%v records: %v", len(records), records)
+	}
+	detail, rejected, epoch := records[0], records[1], records[2]
+	if detail["level"] != "DEBUG" || detail["signature"] != hex.EncodeToString(forged.Inputs[0].Signature) {
+		t.Errorf("detail record: %v", detail)
+	}
+	utxo := map[string]interface{}{"txHash": hex.EncodeToString([]byte("txhash#1")), "index": float64(0)}
+	if rejected["level"] != "INFO" || rejected["msg"] != "transaction rejected" ||
+		rejected["tx"] != hex.EncodeToString(forged.Hash) || rejected["reason"] != "invalid spend" ||
+		rejected["input"] != float64(0) || !equalJSON(rejected["utxo"], utxo) {
+		t.Errorf("rejection record: %v", rejected)
+	}
+	if epoch["msg"] != "epoch handled" || epoch["proposed"] != float64(1) || epoch["accepted"] != float64(0) {
+		t.Errorf("epoch record: %v", epoch)
+	}
+
+	handler.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	handler.Policy = &Policy{MaxOutputs: 1}
+	handler.HandleTxs([]*Transaction{hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 1, 2)})
+	records = decodeLog(t, &buf)
+	if len(records) != 2 || records[0]["msg"] != "transaction non-standard" || records[0]["reason"] != ErrTooManyOutputs.Error() {
+		t.Errorf("records at info level: %v", records)
+	}
+}
+
+func equalJSON(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+func TestCryptoutilLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cryptoutil.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer cryptoutil.SetLogger(nil)
+	key := cryptoutil.GetPrivateKey()
+	if cryptoutil.RSAVerify(&key.PublicKey, []byte("data"), []byte("signature")) {
+		t.Fatal("bad signature verified")
+	}
+	records := decodeLog(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "RSA verification failed" || records[0]["err"] == nil {
+		t.Fatalf("records: %v", records)
+	}
+}
+
+// Library code must not write to stdout or stderr unless given a logger.
+func TestSilentByDefault(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = w, w
+	func() {
+		defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+		handler, key := newBudgetHandler(2)
+		forged := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, 9)
+		forged.Inputs[0].Signature[0] ^= 1
+		overspend := hSpend(key, []UTXO{{TxHash: "txhash#1", Index: 1}}, 11)
+		missing := hSpend(key, []UTXO{{TxHash: "txhash#2", Index: 0}}, 1)
+		handler.HandleTxs([]*Transaction{forged, overspend, missing})
+		cryptoutil.RSAVerify(&key.PublicKey, []byte("data"), nil)
+	}()
+	w.Close()
+	output, _ := io.ReadAll(r)
+	if len(output) > 0 {
+		t.Fatalf("library wrote %q", output)
+	}
+}
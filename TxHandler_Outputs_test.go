@@ -0,0 +1,99 @@
+package scrooge
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"math"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+func TestUnusedInputFieldsRejected(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool := NewUTXOPool()
+	pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: key.PublicKey})
+	handler := NewTxHandler(pool)
+	tx := hPay(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, []*rsa.PrivateKey{key}, 9)
+	if !handler.IsValidTx(tx) {
+		t.Fatalf("payment rejected")
+	}
+
+	// anyone may append bytes to the input without the signature changing
+	for _, malleate := range []func(in *TInput){
+		func(in *TInput) { in.CoSignature = []byte("appended") },
+		func(in *TInput) { in.Preimage = []byte("appended") },
+	} {
+		malleated := *tx
+		malleated.Inputs = append([]TInput(nil), tx.Inputs...)
+		malleate(&malleated.Inputs[0])
+		malleated.Finalize()
+		if handler.IsValidTx(&malleated) {
+			t.Fatalf("input with a field its output does not use accepted")
+		}
+	}
+}
+
+func TestNonFiniteOutputsRejected(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	for _, value := range []float64{math.NaN(), math.Inf(1)} {
+		pool := NewUTXOPool()
+		pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: key.PublicKey})
+		tx := hPay(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, []*rsa.PrivateKey{key}, value)
+		if NewTxHandler(pool).IsValidTx(tx) {
+			t.Fatalf("output of %v accepted", value)
+		}
+	}
+}
+
+func TestMalformedOutputsRejected(t *testing.T) {
+	key, other := cryptoutil.GetPrivateKey(), cryptoutil.GetPrivateKey()
+	edKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationHash := cryptoutil.HashSha256([]byte("secret"))
+	for _, c := range []struct {
+		what string
+		out  TOutput
+	}{
+		{"unknown type", TOutput{Address: key.PublicKey, Type: PayToEd25519 + 1}},
+		{"no address", TOutput{}},
+		{"multisig without co-signer", TOutput{Address: key.PublicKey, Type: PayToMultisig}},
+		{"revocable without co-signer", TOutput{Address: key.PublicKey, Type: PayToRevocable, RevocationHash: revocationHash}},
+		{"revocable without hash", TOutput{Address: key.PublicKey, Type: PayToRevocable, CoSigner: other.PublicKey}},
+		{"negative delay", TOutput{Address: key.PublicKey, Type: PayToRevocable, CoSigner: other.PublicKey, RevocationHash: revocationHash, Delay: -1}},
+		{"address with co-signer", TOutput{Address: key.PublicKey, CoSigner: other.PublicKey}},
+		{"address with delay", TOutput{Address: key.PublicKey, Delay: 3}},
+		{"multisig with revocation hash", TOutput{Address: key.PublicKey, Type: PayToMultisig, CoSigner: other.PublicKey, RevocationHash: revocationHash}},
+		{"short Ed25519 address", TOutput{Type: PayToEd25519, EdAddress: edKey[:8]}},
+		{"Ed25519 with RSA address", TOutput{Type: PayToEd25519, EdAddress: edKey, Address: key.PublicKey}},
+		{"address with Ed25519 address", TOutput{Address: key.PublicKey, EdAddress: edKey}},
+	} {
+		pool := NewUTXOPool()
+		pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: key.PublicKey})
+		tx := NewTransaction()
+		tx.AddInput([]byte("txhash#1"), 0)
+		c.out.Value = 9
+		tx.Outputs = append(tx.Outputs, c.out)
+		hToAddSignature(tx, key, 0)
+		tx.Finalize()
+		if NewTxHandler(pool).IsValidTx(tx) {
+			t.Fatalf("%v: output accepted", c.what)
+		}
+	}
+}
+
+func TestSpendOfKeylessOutputRejected(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool := NewUTXOPool()
+	// an output in the pool from before its co-signer was required
+	pool.AddUTXO(UTXO{TxHash: "txhash#1", Index: 0}, &TOutput{Value: 10, Address: key.PublicKey, Type: PayToMultisig})
+	handler := NewTxHandler(pool)
+	tx := hPay(key, []UTXO{{TxHash: "txhash#1", Index: 0}}, []*rsa.PrivateKey{key}, 9)
+	tx.AddCoSignature(tx.Inputs[0].Signature, 0)
+	tx.Finalize()
+	if accepted := handler.HandleTxs([]*Transaction{tx}); len(accepted) != 0 {
+		t.Fatalf("spend of an output without co-signer accepted")
+	}
+}
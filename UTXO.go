@@ -1,6 +1,9 @@
 package scrooge
 
-import "fmt"
+import (
+	"encoding/hex"
+	"log/slog"
+)
 
 type UTXO struct {
 	TxHash string
@@ -19,21 +22,7 @@ func (utxo *UTXO) Equal(other *UTXO) bool {
 	return false
 }
 
-func TestUTXOComparsion() {
-	utxo1 := &UTXO{TxHash: "txhash#1", Index: 1}
-	utxo1mirror := &UTXO{TxHash: "txhash#1", Index: 1}
-	utxo2 := &UTXO{TxHash: "txhash#2", Index: 1}
-	utxo3 := &UTXO{TxHash: "txhash#1", Index: 3}
-
-	// note slice cannot be compared with ==, can only compare with nil
-	fmt.Printf("utxo1 equal utxo1mirror: %v\n", utxo1 == utxo1mirror)
-	fmt.Printf("utxo1 NOT equal utxo2: %v\n", utxo1 == utxo2)
-	fmt.Printf("utxo1 NOT equal utxo3: %v\n", utxo1 == utxo3)
-	fmt.Printf("utxo2 NOT equal utxo3: %v\n", utxo2 == utxo3)
-
-	// Equal function
-	fmt.Printf("utxo1 equal utxo1mirror (using Equal fn): %v\n", utxo1.Equal(utxo1mirror))
-	fmt.Printf("utxo1 NOT equal utxo2(using Equal fn): %v\n", utxo1.Equal(utxo2))
-	fmt.Printf("utxo1 NOT equal utxo3(using Equal fn): %v\n", utxo1.Equal(utxo3))
-	fmt.Printf("utxo2 NOT equal utxo3(using Equal fn): %v\n", utxo2.Equal(utxo3))
+// LogValue makes loggers show utxo with a hex hash.
+func (utxo UTXO) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("txHash", hex.EncodeToString([]byte(utxo.TxHash))), slog.Int("index", utxo.Index))
 }
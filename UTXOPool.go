@@ -1,21 +1,174 @@
 package scrooge
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"scrooge/cryptoutil"
+)
 
 type UTXOPool struct {
+	// H maps each UTXO to its output. It is read directly but only changed through
+	// AddUTXO and RemoveUTXO, which keep the owner index in step.
 	H map[UTXO]*TOutput
+	// Epoch is the number of epochs handled on this pool so far.
+	Epoch int
+	// created records the epoch in which each UTXO was added.
+	created map[UTXO]int
+	// value is the total value of the pool, kept while poolMetrics is set.
+	value       float64
+	poolMetrics Metrics
+	// owners indexes the UTXOs by the OwnerAddress of their output, and balances holds
+	// the total value of each owner. holders orders the owners by balance.
+	owners   map[string]map[UTXO]struct{}
+	balances map[string]float64
+	holders  holderTree
 }
 
 func NewUTXOPool() *UTXOPool {
-	return &UTXOPool{H: make(map[UTXO]*TOutput)}
+	return &UTXOPool{
+		H:        make(map[UTXO]*TOutput),
+		created:  make(map[UTXO]int),
+		owners:   make(map[string]map[UTXO]struct{}),
+		balances: make(map[string]float64),
+	}
+}
+
+// Copy returns a pool with the UTXOs, ages and epoch of pool. Its metrics are not set.
+func (pool *UTXOPool) Copy() *UTXOPool {
+	copied := NewUTXOPool()
+	for utxo, out := range pool.H {
+		copied.restoreUTXO(utxo, out, pool.created[utxo])
+	}
+	copied.Epoch = pool.Epoch
+	return copied
 }
 
 func (pool *UTXOPool) AddUTXO(utxo UTXO, txOutput *TOutput) {
+	if pool.created == nil {
+		pool.created = make(map[UTXO]int)
+	}
+	if pool.poolMetrics != nil {
+		pool.value += outputValue(txOutput) - outputValue(pool.H[utxo])
+	}
+	pool.unindex(utxo)
 	pool.H[utxo] = txOutput
+	pool.created[utxo] = pool.Epoch
+	pool.index(utxo, txOutput)
+	pool.metrics().PoolChanged(len(pool.H), pool.value)
 }
 
 func (pool *UTXOPool) RemoveUTXO(utxo UTXO) {
+	if pool.poolMetrics != nil {
+		pool.value -= outputValue(pool.H[utxo])
+	}
+	pool.unindex(utxo)
 	delete(pool.H, utxo)
+	delete(pool.created, utxo)
+	pool.metrics().PoolChanged(len(pool.H), pool.value)
+}
+
+// restoreUTXO puts back utxo as added in epoch createdAt.
+func (pool *UTXOPool) restoreUTXO(utxo UTXO, txOutput *TOutput, createdAt int) {
+	pool.AddUTXO(utxo, txOutput)
+	pool.created[utxo] = createdAt
+}
+
+func (pool *UTXOPool) index(utxo UTXO, out *TOutput) {
+	if out == nil {
+		return
+	}
+	if pool.owners == nil {
+		pool.owners = make(map[string]map[UTXO]struct{})
+		pool.balances = make(map[string]float64)
+	}
+	owner := out.OwnerAddress()
+	if pool.owners[owner] == nil {
+		pool.owners[owner] = make(map[UTXO]struct{})
+	}
+	pool.owners[owner][utxo] = struct{}{}
+	if balance, ok := pool.balances[owner]; ok {
+		pool.holders.remove(Holder{Address: owner, Balance: balance})
+	}
+	pool.balances[owner] += out.Value
+	pool.holders.insert(Holder{Address: owner, Balance: pool.balances[owner]})
+}
+
+func (pool *UTXOPool) unindex(utxo UTXO) {
+	out := pool.H[utxo]
+	if out == nil {
+		return
+	}
+	owner := out.OwnerAddress()
+	delete(pool.owners[owner], utxo)
+	pool.holders.remove(Holder{Address: owner, Balance: pool.balances[owner]})
+	if len(pool.owners[owner]) == 0 {
+		// also drops the rounding errors accumulated in the balance
+		delete(pool.owners, owner)
+		delete(pool.balances, owner)
+	} else {
+		pool.balances[owner] -= out.Value
+		pool.holders.insert(Holder{Address: owner, Balance: pool.balances[owner]})
+	}
+}
+
+// UTXOsByOwner returns the UTXOs whose output has owner as OwnerAddress, sorted. It
+// takes time in the number of UTXOs of owner, not of the pool.
+func (pool *UTXOPool) UTXOsByOwner(owner string) []UTXO {
+	utxos := make([]UTXO, 0, len(pool.owners[owner]))
+	for utxo := range pool.owners[owner] {
+		utxos = append(utxos, utxo)
+	}
+	return sortUTXOs(utxos)
+}
+
+// sortUTXOs sorts utxos by hash and index, and returns them.
+func sortUTXOs(utxos []UTXO) []UTXO {
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].TxHash != utxos[j].TxHash {
+			return utxos[i].TxHash < utxos[j].TxHash
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	return utxos
+}
+
+// Balance returns the total value of the UTXOs of owner.
+func (pool *UTXOPool) Balance(owner string) float64 {
+	return pool.balances[owner]
+}
+
+// Holder is an owner of UTXOs and their total value.
+type Holder struct {
+	Address string
+	Balance float64
+}
+
+// TopHolders returns the n owners with the largest balances, richest first, ties
+// sorted by address. It takes time in n and in the logarithm of the number of owners.
+func (pool *UTXOPool) TopHolders(n int) []Holder {
+	if n <= 0 {
+		return nil
+	}
+	return pool.holders.first(n)
+}
+
+func outputValue(out *TOutput) float64 {
+	if out == nil {
+		return 0
+	}
+	return out.Value
+}
+
+// CreatedAt returns the epoch in which utxo was added to the pool.
+func (pool *UTXOPool) CreatedAt(utxo UTXO) int {
+	return pool.created[utxo]
+}
+
+// Age returns the number of epochs utxo has been in the pool.
+func (pool *UTXOPool) Age(utxo UTXO) int {
+	return pool.Epoch - pool.created[utxo]
 }
 
 func (pool *UTXOPool) GetTxOutput(utxo UTXO) *TOutput {
@@ -27,6 +180,24 @@ func (pool *UTXOPool) Contains(utxo UTXO) bool {
 	return ok
 }
 
+// Commitment returns a hash of the epoch of pool and of its UTXOs with their outputs and
+// creation epochs. Pools holding the same state have the same commitment, whatever the
+// order in which they reached it.
+func (pool *UTXOPool) Commitment() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int64(pool.Epoch))
+	for _, utxo := range sortUTXOs(pool.GetAllUTXO()) {
+		binary.Write(&buf, binary.BigEndian, int32(len(utxo.TxHash)))
+		buf.WriteString(utxo.TxHash)
+		binary.Write(&buf, binary.BigEndian, int32(utxo.Index))
+		binary.Write(&buf, binary.BigEndian, int64(pool.created[utxo]))
+		if out := pool.H[utxo]; out != nil {
+			writeOutput(&buf, out)
+		}
+	}
+	return cryptoutil.HashSha256(buf.Bytes())
+}
+
 func (pool *UTXOPool) GetAllUTXO() []UTXO {
 	utxos := make([]UTXO, 0, len(pool.H))
 	for key, _ := range pool.H {
@@ -34,30 +205,3 @@ func (pool *UTXOPool) GetAllUTXO() []UTXO {
 	}
 	return utxos
 }
-
-func TestUTXOPool() {
-	utxo1 := &UTXO{TxHash: "txhash#1", Index: 1}
-	utxo1mirror := &UTXO{TxHash: "txhash#1", Index: 1}
-	utxo2 := &UTXO{TxHash: "txhash#2", Index: 1}
-	utxo3 := &UTXO{TxHash: "txhash#1", Index: 3}
-
-	utxopool := NewUTXOPool()
-	utxopool.AddUTXO(*utxo1, nil)
-	utxopool.AddUTXO(*utxo1mirror, nil)
-	utxopool.AddUTXO(*utxo2, nil)
-	utxopool.AddUTXO(*utxo3, nil)
-
-	utxos := utxopool.GetAllUTXO()
-	for i, item := range utxos {
-		fmt.Printf("%v)%v\n", i, item)
-	}
-
-	fmt.Printf("find previously added utxo1 in utxopool: %v\n", utxopool.Contains(*utxo1))
-	fmt.Printf("find utxo1 through new object in utxopool: %v\n", utxopool.Contains(UTXO{TxHash: "txhash#1", Index: 1}))
-
-	utxopool.RemoveUTXO(*utxo1)
-	fmt.Printf("find after deletion of *utxo1: %v\n", utxopool.Contains(UTXO{TxHash: "txhash#1", Index: 1}))
-	utxopool.RemoveUTXO(*&UTXO{TxHash: "txhash#2", Index: 1})
-	fmt.Printf("find after deletion of UTXO{TxHash: \"txhash#2\", Index: 1}: %v\n", utxopool.Contains(UTXO{TxHash: "txhash#2", Index: 1}))
-
-}
@@ -0,0 +1,226 @@
+package scrooge
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// checkOwnerIndex compares the owner index of pool with a scan of pool.H. Values must be
+// whole numbers so that balances are exact whatever the order of the sums.
+func checkOwnerIndex(t *testing.T, what string, pool *UTXOPool) {
+	t.Helper()
+	owned := make(map[string][]UTXO)
+	balances := make(map[string]float64)
+	for utxo, out := range pool.H {
+		owner := out.OwnerAddress()
+		owned[owner] = append(owned[owner], utxo)
+		balances[owner] += out.Value
+	}
+	if len(pool.owners) != len(owned) || len(pool.balances) != len(owned) {
+		t.Fatalf("%v: index has %v owners and %v balances, pool has %v owners", what, len(pool.owners), len(pool.balances), len(owned))
+	}
+	var holders []Holder
+	for owner, utxos := range owned {
+		sort.Slice(utxos, func(i, j int) bool {
+			if utxos[i].TxHash != utxos[j].TxHash {
+				return utxos[i].TxHash < utxos[j].TxHash
+			}
+			return utxos[i].Index < utxos[j].Index
+		})
+		if indexed := pool.UTXOsByOwner(owner); !reflect.DeepEqual(indexed, utxos) {
+			t.Fatalf("%v: UTXOs of %v are %v, expected %v", what, owner, indexed, utxos)
+		}
+		if pool.Balance(owner) != balances[owner] {
+			t.Fatalf("%v: balance of %v is %v, expected %v", what, owner, pool.Balance(owner), balances[owner])
+		}
+		holders = append(holders, Holder{Address: owner, Balance: balances[owner]})
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Balance != holders[j].Balance {
+			return holders[i].Balance > holders[j].Balance
+		}
+		return holders[i].Address < holders[j].Address
+	})
+	for _, n := range []int{1, 3, len(holders) + 1} {
+		expected := holders[:min(n, len(holders))]
+		if top := pool.TopHolders(n); !(len(top) == 0 && len(expected) == 0) && !reflect.DeepEqual(top, expected) {
+			t.Fatalf("%v: top %v holders are %v, expected %v", what, n, top, expected)
+		}
+	}
+}
+
+func TestOwnerIndexQueries(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	carol, _, _ := ed25519.GenerateKey(rng)
+	aliceAddress := cryptoutil.GetAddress(alice.PublicKey)
+	bobAddress := cryptoutil.GetAddress(bob.PublicKey)
+	carolAddress := cryptoutil.GetEd25519Address(carol)
+
+	pool := NewUTXOPool()
+	pool.AddUTXO(UTXO{TxHash: "b", Index: 0}, &TOutput{Value: 5, Address: alice.PublicKey})
+	pool.AddUTXO(UTXO{TxHash: "a", Index: 1}, &TOutput{Value: 2, Address: alice.PublicKey})
+	// a multisig output belongs to its first signer
+	pool.AddUTXO(UTXO{TxHash: "a", Index: 0}, &TOutput{Value: 1, Address: bob.PublicKey, Type: PayToMultisig, CoSigner: alice.PublicKey})
+	pool.AddUTXO(UTXO{TxHash: "c", Index: 0}, &TOutput{Value: 7, Type: PayToEd25519, EdAddress: carol})
+
+	if utxos := pool.UTXOsByOwner(aliceAddress); !reflect.DeepEqual(utxos, []UTXO{{"a", 1}, {"b", 0}}) {
+		t.Fatalf("alice's UTXOs: %v", utxos)
+	}
+	if pool.Balance(aliceAddress) != 7 || pool.Balance(bobAddress) != 1 || pool.Balance("nobody") != 0 {
+		t.Fatal("wrong balances")
+	}
+	// ties go to the smaller address
+	first, second := Holder{aliceAddress, 7}, Holder{carolAddress, 7}
+	if carolAddress < aliceAddress {
+		first, second = second, first
+	}
+	if top := pool.TopHolders(2); !reflect.DeepEqual(top, []Holder{first, second}) {
+		t.Fatalf("top holders: %v", top)
+	}
+	if top := pool.TopHolders(0); top != nil {
+		t.Fatalf("top 0 holders: %v", top)
+	}
+
+	// replacing an output moves it to its new owner
+	pool.AddUTXO(UTXO{TxHash: "b", Index: 0}, &TOutput{Value: 3, Address: bob.PublicKey})
+	pool.RemoveUTXO(UTXO{TxHash: "c", Index: 0})
+	pool.RemoveUTXO(UTXO{TxHash: "c", Index: 0})
+	if pool.Balance(aliceAddress) != 2 || pool.Balance(bobAddress) != 4 || len(pool.UTXOsByOwner(carolAddress)) != 0 {
+		t.Fatal("index not updated")
+	}
+	checkOwnerIndex(t, "after updates", pool)
+}
+
+// TestOwnerIndexRandomEpochs runs epochs of random payments, some of them invalid or in
+// conflict, between random owners, with outputs also added and removed directly.
+func TestOwnerIndexRandomEpochs(t *testing.T) {
+	rsaKeys := []*rsa.PrivateKey{cryptoutil.GetPrivateKey(), cryptoutil.GetPrivateKey()}
+	var edKeys []ed25519.PrivateKey
+	for i := 0; i < 4; i++ {
+		_, key, _ := ed25519.GenerateKey(rng)
+		edKeys = append(edKeys, key)
+	}
+	owners := len(rsaKeys) + len(edKeys)
+	addOutput := func(tx *Transaction, owner int, value float64) {
+		if owner < len(rsaKeys) {
+			tx.AddOutput(value, rsaKeys[owner].PublicKey)
+		} else {
+			tx.AddEd25519Output(value, edKeys[owner-len(rsaKeys)].Public().(ed25519.PublicKey))
+		}
+	}
+	sign := func(tx *Transaction, pool *UTXOPool) {
+		for idx, in := range tx.Inputs {
+			out := pool.GetTxOutput(UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx})
+			data := tx.GetRawDataToSign(idx)
+			for _, key := range rsaKeys {
+				if out.Type == PayToAddress && key.PublicKey.Equal(&out.Address) {
+					sig, _ := cryptoutil.RSASign(key, data)
+					tx.AddSignature(sig, idx)
+				}
+			}
+			for _, key := range edKeys {
+				if out.Type == PayToEd25519 && key.Public().(ed25519.PublicKey).Equal(out.EdAddress) {
+					tx.AddSignature(ed25519.Sign(key, data), idx)
+				}
+			}
+		}
+	}
+
+	pool := NewUTXOPool()
+	genesis := NewTransaction()
+	for i := 0; i < 30; i++ {
+		addOutput(genesis, rng.Intn(owners), float64(1+rng.Intn(50)))
+	}
+	genesis.Finalize()
+	for idx := range genesis.Outputs {
+		pool.AddUTXO(UTXO{TxHash: string(genesis.Hash), Index: idx}, &genesis.Outputs[idx])
+	}
+	checkOwnerIndex(t, "genesis", pool)
+
+	handler := NewTxHandler(pool)
+	for epoch := 0; epoch < 25; epoch++ {
+		utxos := pool.GetAllUTXO()
+		sort.Slice(utxos, func(i, j int) bool {
+			if utxos[i].TxHash != utxos[j].TxHash {
+				return utxos[i].TxHash < utxos[j].TxHash
+			}
+			return utxos[i].Index < utxos[j].Index
+		})
+		rng.Shuffle(len(utxos), func(i, j int) { utxos[i], utxos[j] = utxos[j], utxos[i] })
+
+		var txs []*Transaction
+		for len(utxos) > 0 && len(txs) < 8 {
+			n := 1 + rng.Intn(min(3, len(utxos)))
+			tx := NewTransaction()
+			var in float64
+			for _, utxo := range utxos[:n] {
+				tx.AddInput([]byte(utxo.TxHash), utxo.Index)
+				in += pool.GetTxOutput(utxo).Value
+			}
+			// some payments spend outputs already spent this epoch
+			if rng.Intn(5) > 0 {
+				utxos = utxos[n:]
+			}
+			for in > 0 {
+				value := float64(1 + rng.Intn(int(in)))
+				addOutput(tx, rng.Intn(owners), value)
+				in -= value
+			}
+			sign(tx, pool)
+			if rng.Intn(6) == 0 {
+				tx.Inputs[0].Signature = []byte("forged")
+			}
+			tx.Finalize()
+			txs = append(txs, tx)
+		}
+		handler.HandleTxs(txs)
+		checkOwnerIndex(t, fmt.Sprintf("seed %v, epoch %v", seed, epoch), pool)
+
+		minted := UTXO{TxHash: fmt.Sprintf("minted#%v", epoch), Index: 0}
+		pool.AddUTXO(minted, &TOutput{Value: float64(rng.Intn(10)), Type: PayToMultisig,
+			Address: rsaKeys[rng.Intn(len(rsaKeys))].PublicKey, CoSigner: rsaKeys[0].PublicKey})
+		if utxos := pool.GetAllUTXO(); rng.Intn(2) == 0 && len(utxos) > 0 {
+			pool.RemoveUTXO(utxos[rng.Intn(len(utxos))])
+		}
+		checkOwnerIndex(t, fmt.Sprintf("seed %v, after direct changes in epoch %v", seed, epoch), pool)
+	}
+
+	data, err := json.Marshal(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewUTXOPool()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	checkOwnerIndex(t, "decoded pool", decoded)
+}
+
+func TestHolderTreeBalanced(t *testing.T) {
+	var tree holderTree
+	for idx := 0; idx < 4096; idx++ {
+		// balances in rank order, the worst case of an unbalanced search tree
+		tree.insert(Holder{Address: fmt.Sprint("owner", idx), Balance: float64(4096 - idx)})
+	}
+	var depth func(node *holderNode) int
+	depth = func(node *holderNode) int {
+		if node == nil {
+			return 0
+		}
+		return 1 + max(depth(node.left), depth(node.right))
+	}
+	if d := depth(tree.root); d > 64 {
+		t.Fatalf("tree of 4096 holders %v deep", d)
+	}
+	if top := tree.first(2); len(top) != 2 || top[0].Balance != 4096 || top[1].Balance != 4095 {
+		t.Fatalf("top holders: %v", top)
+	}
+}
@@ -0,0 +1,244 @@
+package bft
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/internal/scroogetest"
+	"scrooge/netsim"
+)
+
+// committee returns a committee of n replicas on a network drawing from seed, whose pool
+// is the scroogetest pool of count outputs owned by key.
+func committee(seed int64, n int, key *rsa.PrivateKey, count int) (*netsim.Network, []*Replica) {
+	net := netsim.New(seed)
+	return net, NewCommittee(net, scroogetest.Pool(key, count), n)
+}
+
+// equivocate makes r send its proposals and votes as they are to the replicas of even
+// index, and to the others a proposal of the transactions alt returns, with its votes
+// following. It relays no transactions.
+func equivocate(r *Replica, replicas []*Replica, alt func(txs []*scrooge.Transaction) []*scrooge.Transaction) (count *int) {
+	count = new(int)
+	swapped := make(map[string][]byte)
+	honest := r.out
+	r.out = func(to int, msg any) {
+		odd := false
+		for idx, replica := range replicas {
+			odd = odd || (replica.ID == to && idx%2 == 1)
+		}
+		switch m := msg.(type) {
+		case netsim.TxMsg:
+			return
+		case Proposal:
+			if odd {
+				alternative := alt(m.Txs)
+				swapped[string(ValueID(m.Txs))] = ValueID(alternative)
+				m.Txs = alternative
+				*count++
+			}
+			msg = m
+		case Vote:
+			if id, ok := swapped[string(m.ID)]; ok && odd {
+				m.ID = id
+				r.sign(&m)
+			}
+			msg = m
+		}
+		honest(to, msg)
+	}
+	return count
+}
+
+// checkAgreement fails the test unless the replicas of honest decided the same epochs,
+// at least epochs of them.
+func checkAgreement(t *testing.T, replicas []*Replica, honest []int, epochs int) {
+	t.Helper()
+	first := replicas[honest[0]]
+	for _, idx := range honest {
+		r := replicas[idx]
+		if r.Height() < epochs {
+			t.Fatalf("replica %v decided %v epochs, want %v", idx, r.Height(), epochs)
+		}
+		for epoch := 0; epoch < min(r.Height(), first.Height()); epoch++ {
+			if !bytes.Equal(ValueID(r.Decided(epoch)), ValueID(first.Decided(epoch))) {
+				t.Fatalf("replicas %v and %v decided differently in epoch %v", honest[0], idx, epoch)
+			}
+		}
+		if r.Height() == first.Height() && !bytes.Equal(r.Handler.Pool.Commitment(), first.Handler.Pool.Commitment()) {
+			t.Fatalf("replicas %v and %v at the same height hold different pools", honest[0], idx)
+		}
+	}
+}
+
+func TestCommitteeDecidesWithLossAndDeadReplica(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	net, replicas := committee(1, 4, key, 8)
+	net.Loss = 0.05
+	replicas[2].out = func(int, any) {}
+	for idx := 0; idx < 8; idx++ {
+		r, tx := replicas[idx%2], scroogetest.Spend(key, idx, key.PublicKey, 9)
+		net.After(time.Duration(idx)*700*time.Millisecond, func() { r.Submit(tx) })
+	}
+	net.RunFor(20 * time.Second)
+
+	checkAgreement(t, replicas, []int{0, 1, 3}, 10)
+	for idx := 0; idx < 8; idx++ {
+		if !replicas[3].Handler.Pool.Contains(scrooge.UTXO{TxHash: string(scroogetest.Spend(key, idx, key.PublicKey, 9).Hash)}) {
+			t.Fatalf("transaction %v never decided", idx)
+		}
+	}
+}
+
+func TestEquivocatingLeader(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	net, replicas := committee(2, 4, key, 1)
+	// both spend the only output, A reaching only the equivocating replica
+	a, b := scroogetest.Spend(key, 0, key.PublicKey, 9), scroogetest.Spend(key, 0, key.PublicKey, 8)
+	replicas[0].Mempool.Add(a)
+	equivocations := equivocate(replicas[0], replicas, func(txs []*scrooge.Transaction) []*scrooge.Transaction {
+		if len(txs) == 1 && bytes.Equal(txs[0].Hash, a.Hash) {
+			return []*scrooge.Transaction{b}
+		}
+		if len(txs) > 0 {
+			return nil
+		}
+		return []*scrooge.Transaction{a}
+	})
+	net.RunFor(15 * time.Second)
+
+	if *equivocations == 0 {
+		t.Fatalf("the leader never equivocated")
+	}
+	checkAgreement(t, replicas, []int{1, 2, 3}, 8)
+	pool := replicas[1].Handler.Pool
+	if pool.Contains(scrooge.UTXO{TxHash: string(a.Hash)}) == pool.Contains(scrooge.UTXO{TxHash: string(b.Hash)}) {
+		t.Fatalf("not exactly one of the double spends decided")
+	}
+}
+
+func TestTwoEquivocatingLeadersOfSeven(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	net, replicas := committee(3, 7, key, 4)
+	net.Loss = 0.02
+	var equivocations []*int
+	for _, r := range replicas[:2] {
+		for idx := 0; idx < 2; idx++ {
+			r.Mempool.Add(scroogetest.Spend(key, idx, key.PublicKey, 9))
+		}
+		// the faulty replicas collude, sending the odd replicas the other double spends
+		equivocations = append(equivocations, equivocate(r, replicas, func(txs []*scrooge.Transaction) []*scrooge.Transaction {
+			var alt []*scrooge.Transaction
+			for _, tx := range txs {
+				alt = append(alt, scroogetest.Spend(key, tx.Inputs[0].OutputIdx, key.PublicKey, 7))
+			}
+			return alt
+		}))
+	}
+	for idx := 2; idx < 4; idx++ {
+		tx := scroogetest.Spend(key, idx, key.PublicKey, 9)
+		net.After(time.Second, func() { replicas[idx].Submit(tx) })
+	}
+	net.RunFor(20 * time.Second)
+
+	if *equivocations[0]+*equivocations[1] == 0 {
+		t.Fatalf("the leaders never equivocated")
+	}
+	checkAgreement(t, replicas, []int{2, 3, 4, 5, 6}, 15)
+	for idx := 0; idx < 4; idx++ {
+		if replicas[2].Handler.Pool.Contains(scroogetest.Out(idx)) {
+			t.Fatalf("output %v never spent", idx)
+		}
+	}
+}
+
+func TestTooManyFaultsStall(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	net, replicas := committee(4, 4, key, 1)
+	replicas[0].out = func(int, any) {}
+	replicas[1].out = func(int, any) {}
+	net.RunFor(10 * time.Second)
+	for _, r := range replicas[2:] {
+		if r.Height() != 0 {
+			t.Fatalf("decided %v epochs with two replicas of four down", r.Height())
+		}
+	}
+}
+
+func TestRoundsOutOfRange(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	net, replicas := committee(5, 4, key, 2)
+	faulty := replicas[3]
+	faulty.out = func(int, any) {}
+	// the faulty replica floods the others with proposals and signed votes of negative
+	// rounds and of rounds and heights far ahead
+	var flood func()
+	flood = func() {
+		for _, r := range replicas[:3] {
+			height := r.Height()
+			for _, round := range []int{-1 - height, -1, r.round + MaxRoundsAhead + 1, 1 << 30} {
+				net.Send(faulty.ID, r.ID, Proposal{Height: height, Round: round, ValidRound: -1})
+				vote := Vote{Type: Prevote, Height: height, Round: round, Voter: faulty.ID}
+				faulty.sign(&vote)
+				net.Send(faulty.ID, r.ID, vote)
+			}
+			for _, ahead := range []int{MaxHeightsAhead + 1, 1 << 30} {
+				net.Send(faulty.ID, r.ID, Proposal{Height: height + ahead, ValidRound: -1})
+			}
+		}
+		net.After(100*time.Millisecond, flood)
+	}
+	net.After(0, flood)
+	for idx := 0; idx < 2; idx++ {
+		tx := scroogetest.Spend(key, idx, key.PublicKey, 9)
+		net.After(time.Second, func() { replicas[idx].Submit(tx) })
+	}
+	net.RunFor(10 * time.Second)
+
+	checkAgreement(t, replicas, []int{0, 1, 2}, 5)
+	for idx, r := range replicas[:3] {
+		for round := range r.rounds {
+			if round < 0 || round > r.round+MaxRoundsAhead {
+				t.Fatalf("replica %v kept round %v in round %v", idx, round, r.round)
+			}
+		}
+		for height := range r.future {
+			if height > r.Height()+MaxHeightsAhead {
+				t.Fatalf("replica %v kept height %v at height %v", idx, height, r.Height())
+			}
+		}
+	}
+	for idx := 0; idx < 2; idx++ {
+		if replicas[0].Handler.Pool.Contains(scroogetest.Out(idx)) {
+			t.Fatalf("output %v never spent", idx)
+		}
+	}
+}
+
+func TestFutureMessagesKeptOnce(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	_, replicas := committee(6, 4, key, 1)
+	r, faulty := replicas[0], replicas[3]
+	height := r.Height() + 1
+	vote := Vote{Type: Prevote, Height: height, Voter: faulty.ID}
+	faulty.sign(&vote)
+	forged := Vote{Type: Precommit, Height: height, Voter: replicas[1].ID}
+	faulty.sign(&forged)
+	odd := Vote{Type: 7, Height: height, Voter: faulty.ID}
+	faulty.sign(&odd)
+
+	// a faulty replica sends the same messages of the next height again and again
+	for idx := 0; idx < 100; idx++ {
+		r.Receive(faulty.ID, Proposal{Height: height, ValidRound: -1})
+		r.Receive(faulty.ID, vote)
+		r.Receive(faulty.ID, forged)
+		r.Receive(faulty.ID, odd)
+	}
+	if kept := len(r.future[height].msgs); kept != 2 {
+		t.Fatalf("kept %v messages of the next height, want a proposal and a vote", kept)
+	}
+}
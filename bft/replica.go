@@ -0,0 +1,707 @@
+// Package bft replicates Scrooge over a committee of replicas, so that no single one of
+// them decides the epochs.
+//
+// The replicas agree on the transactions accepted by each epoch with a protocol in the
+// style of Tendermint, tolerating f faulty replicas out of N as long as f < N/3. Each
+// epoch, or height, runs in rounds. In each round a proposer, rotating with the height and
+// the round, proposes the transactions its mempool accepts with HandleTxs. The replicas
+// prevote for the proposal if its transactions replay on their pool and it does not
+// conflict with the value they are locked on, then precommit it, locking on it, once a
+// quorum of more than 2N/3 prevoted for it. A quorum of precommits for a proposal decides
+// it: each replica applies its transactions with HandleTxs to its own pool. Rounds that
+// fail to gather a quorum, as when a proposer equivocates or is down, time out and the
+// next proposer takes over.
+//
+// Votes are signed by the Ed25519 key of their replica, so that they can be checked by
+// replicas that did not receive them: a proposal made again in a later round carries
+// the quorum of prevotes it gathered, and the epochs a replica that fell behind asks for
+// carry the quorum of precommits that decided them. Messages travel over a
+// netsim.Network.
+package bft
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/netsim"
+)
+
+const (
+	DefaultInterval       = 500 * time.Millisecond
+	DefaultTimeoutPropose = 400 * time.Millisecond
+	DefaultTimeoutVote    = 200 * time.Millisecond
+	DefaultResendInterval = time.Second
+	// MaxRoundsAhead and MaxHeightsAhead bound how far past its round and height a
+	// replica keeps the proposals and votes it receives, so that a faulty replica cannot
+	// make it hold the state of arbitrarily many rounds or heights.
+	MaxRoundsAhead  = 64
+	MaxHeightsAhead = 64
+)
+
+// Proposal proposes the transactions of an epoch. ValidRound is the round in which a
+// quorum prevoted for them, or -1, and Polka holds the prevotes of that quorum.
+type Proposal struct {
+	Height     int
+	Round      int
+	Txs        []*scrooge.Transaction
+	ValidRound int
+	Polka      []Vote
+}
+
+// VoteType is the type of a Vote.
+type VoteType int
+
+const (
+	Prevote VoteType = iota
+	Precommit
+)
+
+// Vote is a prevote or a precommit by the replica Voter for the proposal whose
+// transactions have the ID given by ValueID, or for none if ID is nil.
+type Vote struct {
+	Type      VoteType
+	Height    int
+	Round     int
+	ID        []byte
+	Voter     int
+	Signature []byte
+}
+
+// signedData returns the data signed by the voter of v.
+func (v *Vote) signedData() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, []int64{int64(v.Type), int64(v.Height), int64(v.Round)})
+	buf.Write(v.ID)
+	return buf.Bytes()
+}
+
+// GetDecision asks a replica for the transactions decided at Height.
+type GetDecision struct {
+	Height int
+}
+
+// Decision carries the transactions decided at Height with the precommits of the quorum
+// that decided them.
+type Decision struct {
+	Height     int
+	Txs        []*scrooge.Transaction
+	Precommits []Vote
+}
+
+// ValueID returns the ID of a proposal of txs, which votes refer to.
+func ValueID(txs []*scrooge.Transaction) []byte {
+	digest := sha256.New()
+	for _, tx := range txs {
+		binary.Write(digest, binary.BigEndian, int32(len(tx.Hash)))
+		digest.Write(tx.Hash)
+	}
+	return digest.Sum(nil)
+}
+
+type step int
+
+const (
+	propose step = iota
+	prevote
+	precommit
+)
+
+// roundState holds what a replica received in a round of the current height. Votes map
+// their voters to the ID they vote for, "" for none.
+type roundState struct {
+	proposal *Proposal
+	id       string
+	// justified tells whether the Polka of the proposal holds a quorum of prevotes.
+	justified  bool
+	prevotes   map[int]string
+	precommits map[int]string
+	// signed holds the votes received by type, to justify a proposal of a later round or
+	// a decision.
+	signed [2]map[int]Vote
+	// senders holds the replicas that sent anything in the round.
+	senders map[int]bool
+	// the rules below fire once per round
+	prevoteTimer   bool
+	precommitTimer bool
+	polka          bool
+}
+
+type envelope struct {
+	from int
+	msg  any
+}
+
+// futureHeight holds the messages received for a later height, in order, and the keys of
+// those kept.
+type futureHeight struct {
+	msgs []envelope
+	kept map[futureKey]bool
+}
+
+// futureKey identifies the proposal of a round by a sender, or the vote of a round and
+// type by a voter.
+type futureKey struct {
+	round int
+	vote  bool
+	t     VoteType
+	from  int
+}
+
+// Replica is a member of a committee running Scrooge.
+type Replica struct {
+	ID      int
+	Handler *scrooge.TxHandler
+	Mempool *scrooge.Mempool
+	// Interval is the time between the decision of an epoch and the start of the next.
+	Interval time.Duration
+	// TimeoutPropose and TimeoutVote bound the wait for a proposal and for a quorum of
+	// votes. They grow linearly with the round.
+	TimeoutPropose time.Duration
+	TimeoutVote    time.Duration
+	// ResendInterval is the period at which a replica sends again its messages of the
+	// current round, which may have been lost.
+	ResendInterval time.Duration
+
+	net     *netsim.Network
+	members []int
+	key     ed25519.PrivateKey
+	keys    map[int]ed25519.PublicKey
+	// out sends a message to another replica.
+	out  func(to int, msg any)
+	seen map[string]bool
+	// decided holds the transactions of the epochs decided, from epoch base on, and
+	// certs the precommits deciding them.
+	base    int
+	decided [][]*scrooge.Transaction
+	certs   [][]Vote
+
+	// the state of the current height
+	started     bool
+	round       int
+	step        step
+	lockedID    string
+	lockedRound int
+	validID     string
+	validRound  int
+	rounds      map[int]*roundState
+	values      map[string][]*scrooge.Transaction
+	valid       map[string]bool
+	sent        []any
+	asked       time.Duration
+	future      map[int]*futureHeight
+}
+
+// NewCommittee adds n replicas to net, each with a copy of pool, and starts them. Their
+// keys are drawn from the random source of net.
+func NewCommittee(net *netsim.Network, pool *scrooge.UTXOPool, n int) []*Replica {
+	replicas := make([]*Replica, n)
+	members := make([]int, n)
+	keys := make(map[int]ed25519.PublicKey)
+	for idx := range replicas {
+		seed := make([]byte, ed25519.SeedSize)
+		net.Rand().Read(seed)
+		handler := scrooge.NewTxHandler(pool.Copy())
+		r := &Replica{
+			Handler:        handler,
+			Mempool:        scrooge.NewMempool(handler, 0),
+			Interval:       DefaultInterval,
+			TimeoutPropose: DefaultTimeoutPropose,
+			TimeoutVote:    DefaultTimeoutVote,
+			ResendInterval: DefaultResendInterval,
+			net:            net,
+			members:        members,
+			key:            ed25519.NewKeyFromSeed(seed),
+			keys:           keys,
+			seen:           make(map[string]bool),
+			base:           pool.Epoch,
+			future:         make(map[int]*futureHeight),
+		}
+		r.ID = net.Join(r)
+		keys[r.ID] = r.key.Public().(ed25519.PublicKey)
+		r.out = func(to int, msg any) { net.Send(r.ID, to, msg) }
+		r.reset()
+		replicas[idx], members[idx] = r, r.ID
+	}
+	for _, r := range replicas {
+		net.After(0, func() { r.startRound(0); r.update() })
+		net.After(r.ResendInterval, r.resend)
+	}
+	return replicas
+}
+
+// Height returns the number of epochs applied to the pool of r, which is the height it
+// is deciding.
+func (r *Replica) Height() int {
+	return r.Handler.Pool.Epoch
+}
+
+// Decided returns the transactions r applied in epoch, or nil if it has not.
+func (r *Replica) Decided(epoch int) []*scrooge.Transaction {
+	if epoch < r.base || epoch >= r.Height() {
+		return nil
+	}
+	return r.decided[epoch-r.base]
+}
+
+// Submit adds tx to the mempool of r and relays it to the other replicas.
+func (r *Replica) Submit(tx *scrooge.Transaction) error {
+	if err := r.Mempool.Add(tx); err != nil {
+		return err
+	}
+	r.seen[string(tx.Hash)] = true
+	r.relay(-1, netsim.TxMsg{Tx: tx})
+	return nil
+}
+
+// faults returns the number of faulty replicas tolerated and quorum the number of
+// replicas whose votes decide.
+func (r *Replica) faults() int { return (len(r.members) - 1) / 3 }
+func (r *Replica) quorum() int { return 2*len(r.members)/3 + 1 }
+
+func (r *Replica) proposer(height, round int) int {
+	return r.members[(height+round)%len(r.members)]
+}
+
+// relay sends msg to the other replicas but except.
+func (r *Replica) relay(except int, msg any) {
+	for _, member := range r.members {
+		if member != r.ID && member != except {
+			r.out(member, msg)
+		}
+	}
+}
+
+// broadcast sends a message of the current round to every replica, r included, and
+// keeps it to be sent again.
+func (r *Replica) broadcast(msg any) {
+	r.sent = append(r.sent, msg)
+	r.relay(-1, msg)
+	r.net.After(0, func() { r.Receive(r.ID, msg) })
+}
+
+func (r *Replica) resend() {
+	for _, msg := range r.sent {
+		r.relay(-1, msg)
+	}
+	r.net.After(r.ResendInterval, r.resend)
+}
+
+// reset clears the state of the height once it is decided.
+func (r *Replica) reset() {
+	r.started, r.round, r.step = false, 0, propose
+	r.lockedID, r.lockedRound, r.validID, r.validRound = "", -1, "", -1
+	r.rounds = make(map[int]*roundState)
+	r.values = make(map[string][]*scrooge.Transaction)
+	r.valid = make(map[string]bool)
+	r.sent = nil
+	r.asked = -1
+}
+
+func (r *Replica) state(round int) *roundState {
+	rs, ok := r.rounds[round]
+	if !ok {
+		rs = &roundState{
+			prevotes:   make(map[int]string),
+			precommits: make(map[int]string),
+			signed:     [2]map[int]Vote{make(map[int]Vote), make(map[int]Vote)},
+			senders:    make(map[int]bool),
+		}
+		r.rounds[round] = rs
+	}
+	return rs
+}
+
+// timeout returns base grown for round.
+func timeout(base time.Duration, round int) time.Duration {
+	return base * time.Duration(round+1)
+}
+
+func (r *Replica) startRound(round int) {
+	r.started, r.round, r.step = true, round, propose
+	r.sent = nil
+	height := r.Height()
+	if r.proposer(height, round) == r.ID {
+		msg := Proposal{Height: height, Round: round, ValidRound: r.validRound}
+		if r.validRound >= 0 {
+			msg.Txs, msg.Polka = r.values[r.validID], r.votes(Prevote, r.validRound, r.validID)
+		} else {
+			msg.Txs = r.candidates()
+		}
+		r.broadcast(msg)
+	}
+	r.net.After(timeout(r.TimeoutPropose, round), func() {
+		if r.at(height, round, propose) {
+			r.vote(Prevote, "")
+			r.update()
+		}
+	})
+}
+
+// at tells whether r is still at the given height, round and step.
+func (r *Replica) at(height, round int, s step) bool {
+	return r.started && r.Height() == height && r.round == round && r.step == s
+}
+
+// candidates returns the transactions of the mempool HandleTxs accepts, in order.
+func (r *Replica) candidates() []*scrooge.Transaction {
+	handler := scrooge.NewTxHandler(r.Handler.Pool.Copy())
+	txs := handler.HandleTxs(r.Mempool.Candidates())
+	r.valid[string(ValueID(txs))] = true
+	return txs
+}
+
+// vote sends a vote of the current round for id and moves to the step following it.
+func (r *Replica) vote(t VoteType, id string) {
+	msg := Vote{Type: t, Height: r.Height(), Round: r.round, Voter: r.ID}
+	if id != "" {
+		msg.ID = []byte(id)
+	}
+	r.sign(&msg)
+	r.broadcast(msg)
+	r.step = prevote
+	if t == Precommit {
+		r.step = precommit
+	}
+}
+
+func (r *Replica) sign(v *Vote) {
+	v.Signature = ed25519.Sign(r.key, v.signedData())
+}
+
+// verify tells whether v is signed by its voter, a member of the committee.
+func (r *Replica) verify(v *Vote) bool {
+	key, ok := r.keys[v.Voter]
+	return ok && ed25519.Verify(key, v.signedData(), v.Signature)
+}
+
+// votes returns the signed votes of type t received in round for id, by member order.
+func (r *Replica) votes(t VoteType, round int, id string) []Vote {
+	var votes []Vote
+	signed := r.state(round).signed[t]
+	for _, voter := range r.members {
+		if vote, ok := signed[voter]; ok && string(vote.ID) == id {
+			votes = append(votes, vote)
+		}
+	}
+	return votes
+}
+
+// isQuorum tells whether votes hold votes of type t by a quorum for id in the given
+// height and round.
+func (r *Replica) isQuorum(votes []Vote, t VoteType, height, round int, id string) bool {
+	voters := make(map[int]bool)
+	for _, vote := range votes {
+		if vote.Type == t && vote.Height == height && vote.Round == round && string(vote.ID) == id && r.verify(&vote) {
+			voters[vote.Voter] = true
+		}
+	}
+	return len(voters) >= r.quorum()
+}
+
+// record adds v to the votes of its round if it is signed by its voter and the first of
+// its type from the voter in the round. A faulty voter may send other replicas
+// different votes.
+func (r *Replica) record(v Vote) {
+	if (v.Type != Prevote && v.Type != Precommit) || !r.verify(&v) {
+		return
+	}
+	rs := r.state(v.Round)
+	rs.senders[v.Voter] = true
+	votes := rs.prevotes
+	if v.Type == Precommit {
+		votes = rs.precommits
+	}
+	if _, ok := votes[v.Voter]; ok {
+		return
+	}
+	votes[v.Voter] = string(v.ID)
+	rs.signed[v.Type][v.Voter] = v
+}
+
+// isValid tells whether the transactions with the given ID are known and all accepted
+// in order by HandleTxs on the pool of r.
+func (r *Replica) isValid(id string) bool {
+	if valid, ok := r.valid[id]; ok {
+		return valid
+	}
+	txs, ok := r.values[id]
+	if !ok {
+		return false
+	}
+	handler := scrooge.NewTxHandler(r.Handler.Pool.Copy())
+	r.valid[id] = len(handler.HandleTxs(txs)) == len(txs)
+	return r.valid[id]
+}
+
+// tally counts the votes for each ID.
+func tally(votes map[int]string) map[string]int {
+	counts := make(map[string]int)
+	for _, id := range votes {
+		counts[id]++
+	}
+	return counts
+}
+
+// sortedRounds returns the rounds r has state for, in order, so that replicas act the
+// same in runs of the same seed.
+func (r *Replica) sortedRounds() []int {
+	rounds := make([]int, 0, len(r.rounds))
+	for round := range r.rounds {
+		rounds = append(rounds, round)
+	}
+	slices.Sort(rounds)
+	return rounds
+}
+
+// update applies the rules of the protocol until none fires.
+func (r *Replica) update() {
+	for r.started && r.apply() {
+	}
+}
+
+// apply fires the first rule of the protocol whose condition holds and tells whether
+// one did.
+func (r *Replica) apply() bool {
+	for _, round := range r.sortedRounds() {
+		for id, count := range tally(r.state(round).precommits) {
+			if id == "" || count < r.quorum() {
+				continue
+			}
+			if r.isValid(id) {
+				r.commit(id, r.votes(Precommit, round, id))
+				return true
+			}
+			if _, ok := r.values[id]; !ok {
+				r.askDecision()
+			}
+		}
+	}
+
+	height, round, rs := r.Height(), r.round, r.state(r.round)
+	prevotes := tally(rs.prevotes)
+	switch {
+	case r.step == propose && rs.proposal != nil && rs.proposal.ValidRound < 0:
+		if r.isValid(rs.id) && (r.lockedRound < 0 || r.lockedID == rs.id) {
+			r.vote(Prevote, rs.id)
+		} else {
+			r.vote(Prevote, "")
+		}
+		return true
+	case r.step == propose && rs.proposal != nil && rs.proposal.ValidRound < round &&
+		(rs.justified || tally(r.state(rs.proposal.ValidRound).prevotes)[rs.id] >= r.quorum()):
+		if r.isValid(rs.id) && (r.lockedRound <= rs.proposal.ValidRound || r.lockedID == rs.id) {
+			r.vote(Prevote, rs.id)
+		} else {
+			r.vote(Prevote, "")
+		}
+		return true
+	case r.step >= prevote && !rs.prevoteTimer && len(rs.prevotes) >= r.quorum():
+		rs.prevoteTimer = true
+		r.net.After(timeout(r.TimeoutVote, round), func() {
+			if r.at(height, round, prevote) {
+				r.vote(Precommit, "")
+				r.update()
+			}
+		})
+		return true
+	case r.step >= prevote && rs.proposal != nil && !rs.polka && prevotes[rs.id] >= r.quorum() && r.isValid(rs.id):
+		rs.polka = true
+		if r.step == prevote {
+			r.lockedID, r.lockedRound = rs.id, round
+			r.vote(Precommit, rs.id)
+		}
+		r.validID, r.validRound = rs.id, round
+		return true
+	case r.step == prevote && prevotes[""] >= r.quorum():
+		r.vote(Precommit, "")
+		return true
+	case !rs.precommitTimer && len(rs.precommits) >= r.quorum():
+		rs.precommitTimer = true
+		r.net.After(timeout(r.TimeoutVote, round), func() {
+			if r.started && r.Height() == height && r.round == round {
+				r.startRound(round + 1)
+				r.update()
+			}
+		})
+		return true
+	}
+
+	for _, later := range r.sortedRounds() {
+		if later > round && len(r.state(later).senders) > r.faults() {
+			r.startRound(later)
+			return true
+		}
+	}
+	return false
+}
+
+// commit applies the transactions with the given ID, decided by the precommits of cert,
+// to the pool of r, and schedules the next height.
+func (r *Replica) commit(id string, cert []Vote) {
+	txs := r.values[id]
+	r.Handler.HandleTxs(txs)
+	r.Mempool.Confirm(txs)
+	r.decided = append(r.decided, txs)
+	r.certs = append(r.certs, cert)
+	for _, tx := range txs {
+		r.seen[string(tx.Hash)] = true
+	}
+	r.reset()
+
+	height := r.Height()
+	r.net.After(r.Interval, func() {
+		if r.Height() == height && !r.started {
+			r.startRound(0)
+			r.update()
+		}
+	})
+	for h := range r.future {
+		if h < height {
+			delete(r.future, h)
+		}
+	}
+	future := r.future[height]
+	delete(r.future, height)
+	if future != nil {
+		for _, e := range future.msgs {
+			r.Receive(e.from, e.msg)
+		}
+	}
+}
+
+// askDecision asks the other replicas for the transactions decided at the current
+// height, at most once per TimeoutVote.
+func (r *Replica) askDecision() {
+	if r.asked >= 0 && r.net.Now()-r.asked < r.TimeoutVote {
+		return
+	}
+	r.asked = r.net.Now()
+	r.relay(-1, GetDecision{Height: r.Height()})
+}
+
+func wellFormed(txs []*scrooge.Transaction) bool {
+	for _, tx := range txs {
+		if !bytes.Equal(cryptoutil.HashSha256(tx.GetRawTx()), tx.Hash) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Replica) member(id int) bool {
+	return slices.Contains(r.members, id)
+}
+
+// Receive handles a message delivered by the network.
+func (r *Replica) Receive(from int, msg any) {
+	if msg, ok := msg.(netsim.TxMsg); ok {
+		if !r.seen[string(msg.Tx.Hash)] {
+			r.seen[string(msg.Tx.Hash)] = true
+			if r.Mempool.Add(msg.Tx) == nil {
+				r.relay(from, msg)
+			}
+		}
+		return
+	}
+	if !r.member(from) {
+		return
+	}
+
+	height := r.Height()
+	switch msg := msg.(type) {
+	case Proposal:
+		if !r.inRange(msg.Round) || !r.current(from, msg, msg.Height) || !wellFormed(msg.Txs) {
+			return
+		}
+		rs := r.state(msg.Round)
+		rs.senders[from] = true
+		if from == r.proposer(height, msg.Round) && rs.proposal == nil {
+			rs.proposal, rs.id = &msg, string(ValueID(msg.Txs))
+			if _, ok := r.values[rs.id]; !ok {
+				r.values[rs.id] = msg.Txs
+			}
+			rs.justified = msg.ValidRound >= 0 && msg.ValidRound < msg.Round &&
+				r.isQuorum(msg.Polka, Prevote, height, msg.ValidRound, rs.id)
+		}
+	case Vote:
+		if !r.inRange(msg.Round) || !r.current(from, msg, msg.Height) {
+			return
+		}
+		r.record(msg)
+	case GetDecision:
+		if msg.Height >= r.base && msg.Height < height {
+			r.out(from, Decision{Height: msg.Height, Txs: r.Decided(msg.Height), Precommits: r.certs[msg.Height-r.base]})
+		}
+		return
+	case Decision:
+		if msg.Height != height || !wellFormed(msg.Txs) {
+			return
+		}
+		id := string(ValueID(msg.Txs))
+		if len(msg.Precommits) == 0 || !r.isQuorum(msg.Precommits, Precommit, height, msg.Precommits[0].Round, id) {
+			return
+		}
+		if _, ok := r.values[id]; !ok {
+			r.values[id] = msg.Txs
+		}
+		if r.isValid(id) {
+			r.commit(id, msg.Precommits)
+		}
+		return
+	default:
+		return
+	}
+	r.update()
+}
+
+// inRange tells whether r keeps the messages of round: it must not be negative, nor more
+// than MaxRoundsAhead past the current round.
+func (r *Replica) inRange(round int) bool {
+	return round >= 0 && round <= r.round+MaxRoundsAhead
+}
+
+// current tells whether a message of the given height is for the current one. Messages
+// of later heights are kept for when r reaches them, and make r ask for the decisions it
+// is missing.
+func (r *Replica) current(from int, msg any, height int) bool {
+	if height > r.Height() {
+		r.keep(from, msg, height)
+		r.askDecision()
+	}
+	return height == r.Height()
+}
+
+// keep adds msg, of a later height, to the messages r replays when it reaches the height.
+// It keeps messages up to MaxHeightsAhead, and at most one proposal per round and sender
+// and one vote per round, type and voter, whose signature it checks first, so that
+// neither faulty replicas nor messages sent again make r hold more.
+func (r *Replica) keep(from int, msg any, height int) {
+	if height > r.Height()+MaxHeightsAhead {
+		return
+	}
+	var key futureKey
+	switch msg := msg.(type) {
+	case Proposal:
+		key = futureKey{round: msg.Round, from: from}
+	case Vote:
+		if (msg.Type != Prevote && msg.Type != Precommit) || !r.verify(&msg) {
+			return
+		}
+		key = futureKey{round: msg.Round, vote: true, t: msg.Type, from: msg.Voter}
+	}
+	future := r.future[height]
+	if future == nil {
+		future = &futureHeight{kept: make(map[futureKey]bool)}
+		r.future[height] = future
+	}
+	if !future.kept[key] {
+		future.kept[key] = true
+		future.msgs = append(future.msgs, envelope{from: from, msg: msg})
+	}
+}
@@ -0,0 +1,399 @@
+// Package channel implements two-party payment channels on top of the scrooge ledger.
+//
+// A channel is opened by a funding transaction paying into a PayToMultisig output owned
+// by both parties. Payments are then made off-ledger by exchanging signatures on new
+// commitment transactions, each spending the funding output with the current balances.
+// Every party holds its own commitment, in which its own balance is paid to a
+// PayToRevocable output: the holder can only spend it after a delay, while the
+// counterparty can take it at once with the revocation secret the holder hands over
+// when the state is replaced. Broadcasting an old commitment therefore loses the
+// holder's balance to the counterparty.
+package channel
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+// Open is sent by the funder to start a channel.
+type Open struct {
+	FundingTxHash []byte
+	Capacity      float64
+	Delay         int
+	// revocation hashes of the funder's commitments 0 and 1
+	RevocationHash     []byte
+	NextRevocationHash []byte
+}
+
+// Accept answers an Open with the signature on the funder's first commitment.
+type Accept struct {
+	Signature []byte
+	// revocation hashes of the acceptor's commitments 0 and 1
+	RevocationHash     []byte
+	NextRevocationHash []byte
+}
+
+// FundingSigned carries the funder's signature on the acceptor's first commitment. The
+// funder broadcasts the funding transaction once it is sent.
+type FundingSigned struct {
+	Signature []byte
+}
+
+// Update proposes the next channel state, moving Amount from the sender to the receiver.
+type Update struct {
+	Number int
+	Amount float64
+	// Signature of the sender on the receiver's commitment Number
+	Signature []byte
+}
+
+// Ack accepts an Update, signing the sender's new commitment and revoking the
+// receiver's previous one.
+type Ack struct {
+	Number    int
+	Signature []byte
+	// Secret revokes the receiver's commitment Number-1
+	Secret []byte
+	// NextRevocationHash is the hash of the receiver's commitment Number+1
+	NextRevocationHash []byte
+}
+
+// Revocation completes an update by revoking the proposer's previous commitment.
+type Revocation struct {
+	Number             int
+	Secret             []byte
+	NextRevocationHash []byte
+}
+
+// Channel is one party's view of a payment channel.
+type Channel struct {
+	key    *rsa.PrivateKey
+	remote rsa.PublicKey
+	funder bool
+
+	Capacity float64
+	Delay    int
+	Funding  scrooge.UTXO
+
+	// seed derives the revocation secret of each of our commitments
+	seed []byte
+
+	// Number is the index of the current commitment
+	Number int
+	// funderBalance is the part of Capacity owed to the funder in the current state
+	funderBalance float64
+	// commitment is our current commitment, carrying the counterparty signature
+	commitment *scrooge.Transaction
+
+	remoteHash     []byte
+	remoteNextHash []byte
+	// revoked maps the revocation hashes of revoked remote commitments to their secret
+	revoked map[string][]byte
+
+	// pending is the update we proposed and have not seen acknowledged yet
+	pending *Update
+}
+
+// NewChannel creates our view of a channel with remote. The funder puts up the whole
+// capacity; delay is the number of epochs a party must wait to spend its own balance
+// after a unilateral close.
+func NewChannel(key *rsa.PrivateKey, remote rsa.PublicKey, funder bool, capacity float64, delay int) *Channel {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		panic(err)
+	}
+	c := &Channel{
+		key:      key,
+		remote:   remote,
+		funder:   funder,
+		Capacity: capacity,
+		Delay:    delay,
+		seed:     seed,
+		revoked:  make(map[string][]byte),
+	}
+	c.funderBalance = capacity
+	return c
+}
+
+// Balances returns our own and the counterparty's balance in the current state.
+func (c *Channel) Balances() (local float64, remote float64) {
+	funderBal, otherBal := c.split(c.funderBalance)
+	if c.funder {
+		return funderBal, otherBal
+	}
+	return otherBal, funderBal
+}
+
+// FundingTransaction builds and signs the transaction paying Capacity from the funder's
+// inputs into the 2-of-2 funding output. Whatever is left of the inputs goes back to
+// the funder.
+func (c *Channel) FundingTransaction(pool *scrooge.UTXOPool, inputs []scrooge.UTXO) (*scrooge.Transaction, error) {
+	if !c.funder {
+		return nil, errors.New("channel: only the funder builds the funding transaction")
+	}
+	tx := scrooge.NewTransaction()
+	var inValue float64
+	for _, utxo := range inputs {
+		out := pool.GetTxOutput(utxo)
+		if out == nil {
+			return nil, fmt.Errorf("channel: UTXO %v is not in the pool", utxo)
+		}
+		inValue += out.Value
+		tx.AddInput([]byte(utxo.TxHash), utxo.Index)
+	}
+	if inValue < c.Capacity {
+		return nil, fmt.Errorf("channel: inputs hold %v, need %v", inValue, c.Capacity)
+	}
+	tx.AddMultisigOutput(c.Capacity, c.key.PublicKey, c.remote)
+	if change := inValue - c.Capacity; change > 0 {
+		tx.AddOutput(change, c.key.PublicKey)
+	}
+	for idx := range tx.Inputs {
+		sig, err := cryptoutil.RSASign(c.key, tx.GetRawDataToSign(idx))
+		if err != nil {
+			return nil, err
+		}
+		tx.AddSignature(sig, idx)
+	}
+	tx.Finalize()
+	c.Funding = scrooge.UTXO{TxHash: string(tx.Hash), Index: 0}
+	return tx, nil
+}
+
+// Open starts the channel on the funding transaction built by FundingTransaction.
+func (c *Channel) Open() *Open {
+	return &Open{
+		FundingTxHash:      []byte(c.Funding.TxHash),
+		Capacity:           c.Capacity,
+		Delay:              c.Delay,
+		RevocationHash:     c.revocationHash(0),
+		NextRevocationHash: c.revocationHash(1),
+	}
+}
+
+// Accept joins the channel proposed by msg and signs the funder's first commitment.
+func (c *Channel) Accept(msg *Open) (*Accept, error) {
+	if c.funder {
+		return nil, errors.New("channel: the funder cannot accept a channel")
+	}
+	if msg.Capacity != c.Capacity || msg.Delay != c.Delay {
+		return nil, fmt.Errorf("channel: unexpected capacity %v or delay %v", msg.Capacity, msg.Delay)
+	}
+	c.Funding = scrooge.UTXO{TxHash: string(msg.FundingTxHash), Index: 0}
+	c.remoteHash = msg.RevocationHash
+	c.remoteNextHash = msg.NextRevocationHash
+
+	sig, err := c.signRemoteCommitment(0, c.funderBalance, c.remoteHash)
+	if err != nil {
+		return nil, err
+	}
+	return &Accept{Signature: sig, RevocationHash: c.revocationHash(0), NextRevocationHash: c.revocationHash(1)}, nil
+}
+
+// ReceiveAccept checks the signature on our first commitment and signs the acceptor's.
+func (c *Channel) ReceiveAccept(msg *Accept) (*FundingSigned, error) {
+	if err := c.acceptCommitment(0, c.funderBalance, msg.Signature); err != nil {
+		return nil, err
+	}
+	c.remoteHash = msg.RevocationHash
+	c.remoteNextHash = msg.NextRevocationHash
+
+	sig, err := c.signRemoteCommitment(0, c.funderBalance, c.remoteHash)
+	if err != nil {
+		return nil, err
+	}
+	return &FundingSigned{Signature: sig}, nil
+}
+
+// ReceiveFundingSigned checks the funder's signature on our first commitment.
+func (c *Channel) ReceiveFundingSigned(msg *FundingSigned) error {
+	return c.acceptCommitment(0, c.funderBalance, msg.Signature)
+}
+
+// Pay proposes a new state that moves amount from us to the counterparty.
+func (c *Channel) Pay(amount float64) (*Update, error) {
+	if c.pending != nil {
+		return nil, errors.New("channel: an update is already in flight")
+	}
+	if c.commitment == nil {
+		return nil, errors.New("channel: channel is not open")
+	}
+	local, _ := c.Balances()
+	if amount <= 0 || amount > local {
+		return nil, fmt.Errorf("channel: cannot pay %v out of %v", amount, local)
+	}
+	number := c.Number + 1
+	sig, err := c.signRemoteCommitment(number, c.moved(-amount), c.remoteNextHash)
+	if err != nil {
+		return nil, err
+	}
+	c.pending = &Update{Number: number, Amount: amount, Signature: sig}
+	return c.pending, nil
+}
+
+// ReceiveUpdate applies a payment proposed by the counterparty.
+func (c *Channel) ReceiveUpdate(msg *Update) (*Ack, error) {
+	if c.pending != nil {
+		return nil, errors.New("channel: an update is already in flight")
+	}
+	if msg.Number != c.Number+1 {
+		return nil, fmt.Errorf("channel: update %v does not follow state %v", msg.Number, c.Number)
+	}
+	_, remote := c.Balances()
+	if msg.Amount <= 0 || msg.Amount > remote {
+		return nil, fmt.Errorf("channel: counterparty cannot pay %v out of %v", msg.Amount, remote)
+	}
+	funderBalance := c.moved(msg.Amount)
+	if err := c.acceptCommitment(msg.Number, funderBalance, msg.Signature); err != nil {
+		return nil, err
+	}
+	sig, err := c.signRemoteCommitment(msg.Number, funderBalance, c.remoteNextHash)
+	if err != nil {
+		return nil, err
+	}
+	c.funderBalance = funderBalance
+	c.Number = msg.Number
+	return &Ack{
+		Number:             msg.Number,
+		Signature:          sig,
+		Secret:             c.revocationSecret(msg.Number - 1),
+		NextRevocationHash: c.revocationHash(msg.Number + 1),
+	}, nil
+}
+
+// ReceiveAck completes our pending payment and revokes our previous commitment.
+func (c *Channel) ReceiveAck(msg *Ack) (*Revocation, error) {
+	if c.pending == nil || msg.Number != c.pending.Number {
+		return nil, fmt.Errorf("channel: unexpected ack for state %v", msg.Number)
+	}
+	funderBalance := c.moved(-c.pending.Amount)
+	if err := c.acceptCommitment(msg.Number, funderBalance, msg.Signature); err != nil {
+		return nil, err
+	}
+	if err := c.storeRevocation(msg.Secret, msg.NextRevocationHash); err != nil {
+		return nil, err
+	}
+	c.funderBalance = funderBalance
+	c.Number = msg.Number
+	c.pending = nil
+	return &Revocation{
+		Number:             msg.Number - 1,
+		Secret:             c.revocationSecret(msg.Number - 1),
+		NextRevocationHash: c.revocationHash(msg.Number + 1),
+	}, nil
+}
+
+// ReceiveRevocation records the revocation of the counterparty's previous commitment.
+func (c *Channel) ReceiveRevocation(msg *Revocation) error {
+	if msg.Number != c.Number-1 {
+		return fmt.Errorf("channel: unexpected revocation of state %v", msg.Number)
+	}
+	return c.storeRevocation(msg.Secret, msg.NextRevocationHash)
+}
+
+// storeRevocation checks secret against the counterparty's current revocation hash and
+// moves on to its next commitment.
+func (c *Channel) storeRevocation(secret []byte, nextHash []byte) error {
+	hash := cryptoutil.HashSha256(secret)
+	if string(hash) != string(c.remoteHash) {
+		return errors.New("channel: revocation secret does not match")
+	}
+	c.revoked[string(hash)] = secret
+	c.remoteHash = c.remoteNextHash
+	c.remoteNextHash = nextHash
+	return nil
+}
+
+// acceptCommitment verifies the counterparty's signature on our commitment number and
+// makes it our current commitment.
+func (c *Channel) acceptCommitment(number int, funderBalance float64, sig []byte) error {
+	tx := c.buildCommitment(true, funderBalance, c.revocationHash(number))
+	if !cryptoutil.RSAVerify(&c.remote, tx.GetRawDataToSign(0), sig) {
+		return fmt.Errorf("channel: invalid signature on commitment %v", number)
+	}
+	c.setFundingSignature(tx, !c.funder, sig)
+	c.commitment = tx
+	return nil
+}
+
+// signRemoteCommitment signs the counterparty's commitment number.
+func (c *Channel) signRemoteCommitment(number int, funderBalance float64, revocationHash []byte) ([]byte, error) {
+	if revocationHash == nil {
+		return nil, fmt.Errorf("channel: no revocation hash for commitment %v", number)
+	}
+	tx := c.buildCommitment(false, funderBalance, revocationHash)
+	return cryptoutil.RSASign(c.key, tx.GetRawDataToSign(0))
+}
+
+// buildCommitment builds the unsigned commitment held by us (local) or by the
+// counterparty. The holder's balance is revocable and delayed, the other balance is paid
+// out directly.
+func (c *Channel) buildCommitment(local bool, funderBalance float64, revocationHash []byte) *scrooge.Transaction {
+	holder, other := c.key.PublicKey, c.remote
+	if !local {
+		holder, other = other, holder
+	}
+	funderBal, otherBal := c.split(funderBalance)
+	holderBal, counterBal := funderBal, otherBal
+	if local != c.funder {
+		holderBal, counterBal = otherBal, funderBal
+	}
+
+	tx := scrooge.NewTransaction()
+	tx.AddInput([]byte(c.Funding.TxHash), c.Funding.Index)
+	if holderBal > 0 {
+		tx.AddRevocableOutput(holderBal, holder, other, revocationHash, c.Delay)
+	}
+	if counterBal > 0 {
+		tx.AddOutput(counterBal, other)
+	}
+	return tx
+}
+
+// setFundingSignature places sig in the input slot of the funding output: the funder
+// owns the output Address and signs first, the other party is its CoSigner.
+func (c *Channel) setFundingSignature(tx *scrooge.Transaction, byFunder bool, sig []byte) {
+	if byFunder {
+		tx.AddSignature(sig, 0)
+	} else {
+		tx.AddCoSignature(sig, 0)
+	}
+}
+
+// moved returns the funder balance after we receive the given amount from the
+// counterparty; a negative amount pays the counterparty.
+func (c *Channel) moved(received float64) float64 {
+	if c.funder {
+		return c.funderBalance + received
+	}
+	return c.funderBalance - received
+}
+
+// split divides Capacity into the funder's and the other party's balance. The other
+// balance is rounded down when needed so the outputs never exceed the funding output.
+func (c *Channel) split(funderBalance float64) (float64, float64) {
+	other := c.Capacity - funderBalance
+	for other > 0 && funderBalance+other > c.Capacity {
+		other = math.Nextafter(other, 0)
+	}
+	return funderBalance, other
+}
+
+// revocationSecret derives the secret revoking our commitment number.
+func (c *Channel) revocationSecret(number int) []byte {
+	data := make([]byte, len(c.seed)+8)
+	copy(data, c.seed)
+	binary.BigEndian.PutUint64(data[len(c.seed):], uint64(number))
+	return cryptoutil.HashSha256(data)
+}
+
+func (c *Channel) revocationHash(number int) []byte {
+	return cryptoutil.HashSha256(c.revocationSecret(number))
+}
@@ -0,0 +1,246 @@
+package channel
+
+import (
+	"crypto/rsa"
+	"math/rand"
+	"testing"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+var seed = time.Now().UTC().UnixNano()
+var rng = rand.New(rand.NewSource(seed))
+
+const testDelay = 3
+
+// openTestChannel funds a channel of capacity 10 from Alice to Bob and runs the funding
+// transaction through an epoch.
+func openTestChannel(t *testing.T) (*scrooge.TxHandler, *Channel, *Channel) {
+	aliceKey := cryptoutil.GetPrivateKey()
+	bobKey := cryptoutil.GetPrivateKey()
+
+	pool := scrooge.NewUTXOPool()
+	aliceUtxo := scrooge.UTXO{TxHash: "txhash#1", Index: 0}
+	pool.AddUTXO(aliceUtxo, &scrooge.TOutput{Value: 12.5, Address: aliceKey.PublicKey})
+	handler := scrooge.NewTxHandler(pool)
+
+	alice := NewChannel(aliceKey, bobKey.PublicKey, true, 10, testDelay)
+	bob := NewChannel(bobKey, aliceKey.PublicKey, false, 10, testDelay)
+
+	fundingTx, err := alice.FundingTransaction(pool, []scrooge.UTXO{aliceUtxo})
+	if err != nil {
+		t.Fatalf("FundingTransaction: %v", err)
+	}
+	accept, err := bob.Accept(alice.Open())
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	fundingSigned, err := alice.ReceiveAccept(accept)
+	if err != nil {
+		t.Fatalf("ReceiveAccept: %v", err)
+	}
+	if err := bob.ReceiveFundingSigned(fundingSigned); err != nil {
+		t.Fatalf("ReceiveFundingSigned: %v", err)
+	}
+
+	assertAccepted(handler, fundingTx, t)
+	return handler, alice, bob
+}
+
+// pay runs one full update round in which from pays amount to to.
+func pay(from *Channel, to *Channel, amount float64, t *testing.T) {
+	update, err := from.Pay(amount)
+	if err != nil {
+		t.Fatalf("Pay(%v): %v", amount, err)
+	}
+	ack, err := to.ReceiveUpdate(update)
+	if err != nil {
+		t.Fatalf("ReceiveUpdate: %v", err)
+	}
+	revocation, err := from.ReceiveAck(ack)
+	if err != nil {
+		t.Fatalf("ReceiveAck: %v", err)
+	}
+	if err := to.ReceiveRevocation(revocation); err != nil {
+		t.Fatalf("ReceiveRevocation: %v", err)
+	}
+}
+
+func assertAccepted(handler *scrooge.TxHandler, tx *scrooge.Transaction, t *testing.T) {
+	t.Helper()
+	if accepted := handler.HandleTxs([]*scrooge.Transaction{tx}); len(accepted) != 1 {
+		t.Fatalf("transaction %x was rejected", tx.Hash)
+	}
+}
+
+func assertRejected(handler *scrooge.TxHandler, tx *scrooge.Transaction, t *testing.T) {
+	t.Helper()
+	if accepted := handler.HandleTxs([]*scrooge.Transaction{tx}); len(accepted) != 0 {
+		t.Fatalf("transaction %x was accepted", tx.Hash)
+	}
+}
+
+func assertPaid(pool *scrooge.UTXOPool, tx *scrooge.Transaction, key rsa.PublicKey, value float64, t *testing.T) {
+	t.Helper()
+	for idx, out := range tx.Outputs {
+		if out.Address.Equal(&key) && out.Type == scrooge.PayToAddress {
+			if out.Value != value {
+				t.Errorf("output %v pays %v, expected %v", idx, out.Value, value)
+			}
+			if !pool.Contains(scrooge.UTXO{TxHash: string(tx.Hash), Index: idx}) {
+				t.Errorf("output %v is not in the pool", idx)
+			}
+			return
+		}
+	}
+	t.Errorf("no output pays %v", value)
+}
+
+// Thousands of off-ledger updates in both directions, then Alice broadcasts a revoked
+// commitment and Bob takes her balance.
+func TestChannelSimulationWithCheatingAttempt(t *testing.T) {
+	handler, alice, bob := openTestChannel(t)
+
+	updates := 2000
+	if testing.Short() {
+		updates = 200
+	}
+	var oldCommitment *scrooge.Transaction
+	var oldAliceBalance, oldBobBalance float64
+	for i := 0; i < updates; i++ {
+		from, to := alice, bob
+		if rng.Intn(2) == 0 {
+			from, to = bob, alice
+		}
+		local, _ := from.Balances()
+		if local < 0.01 {
+			from, to = to, from
+			local, _ = from.Balances()
+		}
+		pay(from, to, local*rng.Float64()*0.5+0.001, t)
+
+		aliceLocal, aliceRemote := alice.Balances()
+		bobLocal, bobRemote := bob.Balances()
+		if aliceLocal != bobRemote || aliceRemote != bobLocal {
+			t.Fatalf("update %v: views diverged, alice (%v, %v) bob (%v, %v)", i, aliceLocal, aliceRemote, bobLocal, bobRemote)
+		}
+		if alice.Number != i+1 || bob.Number != i+1 {
+			t.Fatalf("update %v: alice at state %v, bob at state %v", i, alice.Number, bob.Number)
+		}
+		if i == updates/2 {
+			var err error
+			if oldCommitment, err = alice.ForceClose(); err != nil {
+				t.Fatalf("ForceClose: %v", err)
+			}
+			oldAliceBalance, oldBobBalance = aliceLocal, aliceRemote
+		}
+	}
+	// make sure the old state was worth cheating with
+	pay(bob, alice, 0.0001, t)
+
+	// Bob cannot claim the current state
+	current, err := alice.ForceClose()
+	if err != nil {
+		t.Fatalf("ForceClose: %v", err)
+	}
+	if _, err := bob.Penalty(current); err == nil {
+		t.Errorf("Penalty succeeded on a commitment that is not revoked")
+	}
+
+	// Alice broadcasts the revoked commitment, which the ledger cannot tell apart
+	assertAccepted(handler, oldCommitment, t)
+	assertPaid(handler.Pool, oldCommitment, bob.key.PublicKey, oldBobBalance, t)
+
+	penalty, err := bob.Penalty(oldCommitment)
+	if err != nil {
+		t.Fatalf("Penalty: %v", err)
+	}
+	assertAccepted(handler, penalty, t)
+	assertPaid(handler.Pool, penalty, bob.key.PublicKey, oldAliceBalance, t)
+
+	// by the time the delay is over, Alice has nothing left to sweep
+	for epoch := 0; epoch < testDelay; epoch++ {
+		handler.HandleTxs(nil)
+	}
+	sweep, err := alice.Sweep(oldCommitment)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	assertRejected(handler, sweep, t)
+}
+
+func TestChannelCooperativeClose(t *testing.T) {
+	handler, alice, bob := openTestChannel(t)
+
+	pay(alice, bob, 4, t)
+	pay(bob, alice, 1.5, t)
+	pay(alice, bob, 0.25, t)
+
+	msg, err := bob.ProposeClose()
+	if err != nil {
+		t.Fatalf("ProposeClose: %v", err)
+	}
+	closeTx, err := alice.AcceptClose(msg)
+	if err != nil {
+		t.Fatalf("AcceptClose: %v", err)
+	}
+	assertAccepted(handler, closeTx, t)
+	assertPaid(handler.Pool, closeTx, alice.key.PublicKey, 7.25, t)
+	assertPaid(handler.Pool, closeTx, bob.key.PublicKey, 2.75, t)
+
+	// a forged closing signature is refused
+	forged := &Close{Signature: append([]byte(nil), msg.Signature...)}
+	forged.Signature[0] ^= 0xff
+	if _, err := alice.AcceptClose(forged); err == nil {
+		t.Errorf("AcceptClose accepted a forged signature")
+	}
+}
+
+func TestChannelUnilateralClose(t *testing.T) {
+	handler, alice, bob := openTestChannel(t)
+
+	pay(alice, bob, 6, t)
+
+	commitment, err := bob.ForceClose()
+	if err != nil {
+		t.Fatalf("ForceClose: %v", err)
+	}
+	assertAccepted(handler, commitment, t)
+	assertPaid(handler.Pool, commitment, alice.key.PublicKey, 4, t)
+
+	if _, err := alice.Penalty(commitment); err == nil {
+		t.Errorf("Penalty succeeded on the latest commitment")
+	}
+
+	sweep, err := bob.Sweep(commitment)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	for epoch := 1; epoch < testDelay; epoch++ {
+		assertRejected(handler, sweep, t)
+	}
+	assertAccepted(handler, sweep, t)
+	assertPaid(handler.Pool, sweep, bob.key.PublicKey, 6, t)
+}
+
+func TestChannelRejectsInvalidUpdates(t *testing.T) {
+	_, alice, bob := openTestChannel(t)
+
+	if _, err := bob.Pay(1); err == nil {
+		t.Errorf("Bob paid without any balance")
+	}
+	update, err := alice.Pay(3)
+	if err != nil {
+		t.Fatalf("Pay: %v", err)
+	}
+	tampered := *update
+	tampered.Amount = 5
+	if _, err := bob.ReceiveUpdate(&tampered); err == nil {
+		t.Errorf("ReceiveUpdate accepted a signature for another amount")
+	}
+	if _, err := alice.Pay(1); err == nil {
+		t.Errorf("Pay started a second update while one is in flight")
+	}
+}
@@ -0,0 +1,127 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+// Close carries the proposer's signature on the cooperative closing transaction.
+type Close struct {
+	Signature []byte
+}
+
+// ProposeClose signs a transaction paying both current balances out of the funding
+// output without any delay.
+func (c *Channel) ProposeClose() (*Close, error) {
+	if c.pending != nil {
+		return nil, errors.New("channel: an update is still in flight")
+	}
+	tx := c.buildClose()
+	sig, err := cryptoutil.RSASign(c.key, tx.GetRawDataToSign(0))
+	if err != nil {
+		return nil, err
+	}
+	return &Close{Signature: sig}, nil
+}
+
+// AcceptClose countersigns the closing transaction proposed by msg and returns it ready
+// for HandleTxs.
+func (c *Channel) AcceptClose(msg *Close) (*scrooge.Transaction, error) {
+	if c.pending != nil {
+		return nil, errors.New("channel: an update is still in flight")
+	}
+	tx := c.buildClose()
+	rawData := tx.GetRawDataToSign(0)
+	if !cryptoutil.RSAVerify(&c.remote, rawData, msg.Signature) {
+		return nil, errors.New("channel: invalid signature on closing transaction")
+	}
+	sig, err := cryptoutil.RSASign(c.key, rawData)
+	if err != nil {
+		return nil, err
+	}
+	c.setFundingSignature(tx, !c.funder, msg.Signature)
+	c.setFundingSignature(tx, c.funder, sig)
+	tx.Finalize()
+	return tx, nil
+}
+
+// ForceClose returns our current commitment with both signatures, ready for HandleTxs.
+// Our own balance can be swept with Sweep once Delay epochs have passed.
+func (c *Channel) ForceClose() (*scrooge.Transaction, error) {
+	if c.commitment == nil {
+		return nil, errors.New("channel: channel is not open")
+	}
+	tx := *c.commitment
+	tx.Inputs = append([]scrooge.TInput(nil), c.commitment.Inputs...)
+	sig, err := cryptoutil.RSASign(c.key, tx.GetRawDataToSign(0))
+	if err != nil {
+		return nil, err
+	}
+	c.setFundingSignature(&tx, c.funder, sig)
+	tx.Finalize()
+	return &tx, nil
+}
+
+// Sweep spends our delayed output of commitment, a transaction returned by ForceClose,
+// to our own key. HandleTxs rejects it until Delay epochs have passed.
+func (c *Channel) Sweep(commitment *scrooge.Transaction) (*scrooge.Transaction, error) {
+	for idx, out := range commitment.Outputs {
+		if out.Type == scrooge.PayToRevocable && c.key.PublicKey.Equal(&out.Address) {
+			return c.spend(commitment.Hash, idx, out.Value, nil)
+		}
+	}
+	return nil, errors.New("channel: commitment holds no delayed output of ours")
+}
+
+// Penalty takes the delayed output of a revoked commitment broadcast by the
+// counterparty.
+func (c *Channel) Penalty(commitment *scrooge.Transaction) (*scrooge.Transaction, error) {
+	for idx, out := range commitment.Outputs {
+		if out.Type != scrooge.PayToRevocable || !c.key.PublicKey.Equal(&out.CoSigner) {
+			continue
+		}
+		secret, ok := c.revoked[string(out.RevocationHash)]
+		if !ok {
+			return nil, fmt.Errorf("channel: commitment %x is not revoked", commitment.Hash)
+		}
+		return c.spend(commitment.Hash, idx, out.Value, secret)
+	}
+	return nil, errors.New("channel: commitment holds no delayed output of the counterparty")
+}
+
+// spend builds a transaction moving the output idx of txHash to our key.
+func (c *Channel) spend(txHash []byte, idx int, value float64, preimage []byte) (*scrooge.Transaction, error) {
+	tx := scrooge.NewTransaction()
+	tx.AddInput(txHash, idx)
+	tx.AddOutput(value, c.key.PublicKey)
+	sig, err := cryptoutil.RSASign(c.key, tx.GetRawDataToSign(0))
+	if err != nil {
+		return nil, err
+	}
+	tx.AddSignature(sig, 0)
+	tx.AddPreimage(preimage, 0)
+	tx.Finalize()
+	return tx, nil
+}
+
+// buildClose builds the unsigned cooperative closing transaction, paying the funder
+// first.
+func (c *Channel) buildClose() *scrooge.Transaction {
+	funderBal, otherBal := c.split(c.funderBalance)
+	funderKey, otherKey := c.key.PublicKey, c.remote
+	if !c.funder {
+		funderKey, otherKey = otherKey, funderKey
+	}
+	tx := scrooge.NewTransaction()
+	tx.AddInput([]byte(c.Funding.TxHash), c.Funding.Index)
+	if funderBal > 0 {
+		tx.AddOutput(funderBal, funderKey)
+	}
+	if otherBal > 0 {
+		tx.AddOutput(otherBal, otherKey)
+	}
+	return tx
+}
@@ -0,0 +1,121 @@
+// Command scrooge-node serves a Scrooge ledger over the local HTTP API of package node.
+//
+// Usage:
+//
+//	scrooge-node [--listen 127.0.0.1:8334] [--pool pool.json] [--fees fees.json] [--interval 10s] [--verbose] [--metrics]
+//
+// The pool snapshot, in the format written by scrooge pool commands, is loaded at start
+// and written back after every epoch, and so is the fee history behind GET /fee. With
+// --verbose, why each transaction is rejected is logged to stderr, and the detail of
+// failed signature checks too. With --metrics, GET /metrics serves the measurements of
+// the node in the Prometheus text format. With --interval, epochs also run on a timer rather
+// than only on POST /epoch.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/metrics"
+	"scrooge/node"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8334", "loopback address to serve the API on")
+	poolPath := flag.String("pool", "pool.json", "pool snapshot, updated after every epoch")
+	feesPath := flag.String("fees", "fees.json", "fee history, updated after every epoch")
+	interval := flag.Duration("interval", 0, "run an epoch at this interval, 0 for on request only")
+	verbose := flag.Bool("verbose", false, "log transaction checks to stderr")
+	withMetrics := flag.Bool("metrics", false, "serve Prometheus metrics on GET /metrics")
+	flag.Parse()
+
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := node.New(pool)
+	if err := loadFees(*feesPath, n); err != nil {
+		log.Fatal(err)
+	}
+	if *verbose {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		n.SetLogger(logger)
+		cryptoutil.SetLogger(logger)
+	}
+	if *withMetrics {
+		n.EnableMetrics(metrics.New())
+	}
+	n.OnEpoch = func(result node.EpochResult) {
+		log.Printf("epoch %v: %v accepted, %v rejected", result.Epoch, len(result.Accepted), len(result.Rejected))
+		if err := save(*poolPath, n.WriteSnapshot); err != nil {
+			log.Printf("saving pool: %v", err)
+		}
+		if err := save(*feesPath, n.WriteFeeHistory); err != nil {
+			log.Printf("saving fee history: %v", err)
+		}
+	}
+	if *interval > 0 {
+		go func() {
+			for range time.Tick(*interval) {
+				n.RunEpoch()
+			}
+		}()
+	}
+
+	log.Printf("serving on %v", *listen)
+	log.Fatal(n.ListenAndServe(*listen))
+}
+
+func loadPool(path string) (*scrooge.UTXOPool, error) {
+	pool := scrooge.NewUTXOPool()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pool, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, pool); err != nil {
+		return nil, fmt.Errorf("reading pool %v: %v", path, err)
+	}
+	return pool, nil
+}
+
+func loadFees(path string, n *node.Node) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := n.ReadFeeHistory(f); err != nil {
+		return fmt.Errorf("reading fee history %v: %v", path, err)
+	}
+	return nil
+}
+
+// save writes the output of write through a temporary file so a crash never leaves half
+// of it.
+func save(path string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
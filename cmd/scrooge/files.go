@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/keystore"
+)
+
+// errInvalid reports a failed check whose result was already printed.
+var errInvalid = errors.New("invalid")
+
+// newFlags returns the flag set of a subcommand with the common --format flag.
+func newFlags(e *env, name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	format := fs.String("format", "text", "output format, text or json")
+	return fs, format
+}
+
+// emit prints v as JSON, or calls text to print it for humans.
+func emit(e *env, format string, v interface{}, text func(w io.Writer)) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "text":
+		text(e.stdout)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// multiFlag collects the values of a repeated flag.
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return fmt.Sprint(*m)
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// readInput reads path, or standard input when path is "-" or empty.
+func readInput(e *env, path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(e.stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// writeOutput writes data to path, or standard output when path is "-" or empty.
+func writeOutput(e *env, path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := e.stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func readTx(e *env, path string) (*scrooge.Transaction, error) {
+	data, err := readInput(e, path)
+	if err != nil {
+		return nil, err
+	}
+	tx := &scrooge.Transaction{}
+	if err := json.Unmarshal(data, tx); err != nil {
+		return nil, fmt.Errorf("reading transaction: %v", err)
+	}
+	return tx, nil
+}
+
+func writeTx(e *env, path string, tx *scrooge.Transaction) error {
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutput(e, path, append(data, '\n'))
+}
+
+func readTxs(e *env, path string) ([]*scrooge.Transaction, error) {
+	data, err := readInput(e, path)
+	if err != nil {
+		return nil, err
+	}
+	var txs []*scrooge.Transaction
+	if err := json.Unmarshal(data, &txs); err != nil {
+		return nil, fmt.Errorf("reading transactions: %v", err)
+	}
+	return txs, nil
+}
+
+// loadPool reads a pool snapshot. A missing file is an empty pool.
+func loadPool(path string) (*scrooge.UTXOPool, error) {
+	pool := scrooge.NewUTXOPool()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pool, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, pool); err != nil {
+		return nil, fmt.Errorf("reading pool %v: %v", path, err)
+	}
+	return pool, nil
+}
+
+func savePool(path string, pool *scrooge.UTXOPool) error {
+	data, err := json.MarshalIndent(pool, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func sortedUTXOs(pool *scrooge.UTXOPool) []scrooge.UTXO {
+	utxos := pool.GetAllUTXO()
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].TxHash != utxos[j].TxHash {
+			return utxos[i].TxHash < utxos[j].TxHash
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	return utxos
+}
+
+// loadKey reads a PEM key file holding an RSA or Ed25519 key, private or public.
+func loadKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecodePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey, ed25519.PrivateKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%v: unsupported key type %T", path, key)
+}
+
+// publicOf returns the public half of a key returned by loadKey.
+func publicOf(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public().(ed25519.PublicKey)
+	}
+	return key
+}
+
+// addressOf returns the scrooge address of a key returned by loadKey.
+func addressOf(key interface{}) string {
+	switch k := publicOf(key).(type) {
+	case *rsa.PublicKey:
+		return cryptoutil.GetAddress(*k)
+	case ed25519.PublicKey:
+		return cryptoutil.GetEd25519Address(k)
+	}
+	return ""
+}
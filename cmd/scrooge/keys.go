@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"scrooge/cryptoutil"
+	"scrooge/keystore"
+)
+
+type keyResult struct {
+	File    string `json:"file"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+func keygenCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "keygen")
+	keyType := fs.String("type", "rsa", "key type, rsa or ed25519")
+	out := fs.String("out", "", "PEM file to write the private key to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("keygen: --out is required")
+	}
+
+	var key interface{}
+	switch *keyType {
+	case "rsa":
+		key = cryptoutil.GetPrivateKey()
+	case "ed25519":
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		key = edKey
+	default:
+		return fmt.Errorf("keygen: unknown key type %q", *keyType)
+	}
+	data, err := keystore.EncodePEM(key, keystore.PKCS8)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0600); err != nil {
+		return err
+	}
+
+	result := keyResult{File: *out, Type: *keyType, Address: addressOf(key)}
+	return emit(e, *format, result, func(w io.Writer) {
+		fmt.Fprintf(w, "%v key written to %v\naddress: %v\n", result.Type, result.File, result.Address)
+	})
+}
+
+func addressCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("address: no key file given")
+	}
+	results := make([]keyResult, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		key, err := loadKey(path)
+		if err != nil {
+			return err
+		}
+		keyType := "rsa"
+		if _, ok := publicOf(key).(ed25519.PublicKey); ok {
+			keyType = "ed25519"
+		}
+		results = append(results, keyResult{File: path, Type: keyType, Address: addressOf(key)})
+	}
+	return emit(e, *format, results, func(w io.Writer) {
+		for _, result := range results {
+			fmt.Fprintf(w, "%v  %v\n", result.Address, result.File)
+		}
+	})
+}
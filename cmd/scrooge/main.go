@@ -0,0 +1,83 @@
+// Command scrooge manages keys, transactions and UTXO pool snapshots from the shell.
+//
+// Usage:
+//
+//	scrooge keygen [--type rsa|ed25519] --out key.pem
+//	scrooge address key.pem...
+//	scrooge tx build --in <txhash>:<index>... --out <value>:<key.pem>... [--json spec.json] [--replaceable]
+//	scrooge tx sign --key key.pem --pool pool.json tx.json
+//	scrooge tx verify --pool pool.json tx.json
+//	scrooge tx hash tx.json
+//	scrooge pool mint --pool pool.json --key key.pem --value <value>
+//	scrooge pool show --pool pool.json
+//	scrooge pool balance --pool pool.json <address>
+//	scrooge epoch apply --pool pool.json txs.json
+//
+// Every command accepts --format text|json. Transactions are read from and written to
+// files, or standard input and output when the file is "-" or omitted.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// command runs one subcommand with its arguments.
+type command func(env *env, args []string) error
+
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]command{
+	"keygen":       keygenCmd,
+	"address":      addressCmd,
+	"tx build":     txBuildCmd,
+	"tx sign":      txSignCmd,
+	"tx verify":    txVerifyCmd,
+	"tx hash":      txHashCmd,
+	"pool mint":    poolMintCmd,
+	"pool show":    poolShowCmd,
+	"pool balance": poolBalanceCmd,
+	"epoch apply":  epochApplyCmd,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	e := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	rest := args[1:]
+	if !ok && len(args) > 1 {
+		cmd, ok = commands[args[0]+" "+args[1]]
+		rest = args[2:]
+	}
+	if !ok {
+		fmt.Fprintf(stderr, "scrooge: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	if err := cmd(e, rest); err != nil {
+		if err != errInvalid {
+			fmt.Fprintf(stderr, "scrooge: %v\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: scrooge <command> [flags]")
+	fmt.Fprintln(w, "commands: keygen, address, tx build, tx sign, tx verify, tx hash,")
+	fmt.Fprintln(w, "          pool mint, pool show, pool balance, epoch apply")
+}
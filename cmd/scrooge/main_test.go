@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runOK runs the command line args and returns its standard output.
+func runOK(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(stdin), &stdout, &stderr); code != 0 {
+		t.Fatalf("scrooge %v: exit code %v: %v", strings.Join(args, " "), code, stderr.String())
+	}
+	return stdout.String()
+}
+
+// runJSON runs the command line args with --format json and decodes its output into v.
+func runJSON(t *testing.T, v interface{}, args ...string) {
+	t.Helper()
+	// flags end at the first positional argument, so --format goes right after the command
+	at := 0
+	for at < len(args) && !strings.HasPrefix(args[at], "-") {
+		at++
+	}
+	args = append(args[:at:at], append([]string{"--format", "json"}, args[at:]...)...)
+	out := runOK(t, "", args...)
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("scrooge %v: %v\n%v", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	pool := path("pool.json")
+
+	var alice, bob keyResult
+	runJSON(t, &alice, "keygen", "--out", path("alice.pem"))
+	runJSON(t, &bob, "keygen", "--type", "ed25519", "--out", path("bob.pem"))
+	if out := runOK(t, "", "address", path("alice.pem")); !strings.HasPrefix(out, alice.Address) {
+		t.Fatalf("address printed %q, expected %v", out, alice.Address)
+	}
+
+	var coin utxoResult
+	runJSON(t, &coin, "pool", "mint", "--pool", pool, "--key", path("alice.pem"), "--value", "10")
+	runOK(t, "", "pool", "mint", "--pool", pool, "--key", path("alice.pem"), "--value", "10")
+	var shown poolResult
+	runJSON(t, &shown, "pool", "show", "--pool", pool)
+	if len(shown.UTXOs) != 2 || shown.Total != 20 {
+		t.Fatalf("pool holds %v UTXOs worth %v, expected 2 worth 20", len(shown.UTXOs), shown.Total)
+	}
+
+	runOK(t, "", "tx", "build", "--in", coin.TxHash+":0", "--out", "4:"+path("bob.pem"),
+		"--out", "6:"+path("alice.pem"), "--file", path("tx.json"))
+	if code := run([]string{"tx", "verify", "--pool", pool, path("tx.json")}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != 1 {
+		t.Fatalf("unsigned transaction verified with exit code %v", code)
+	}
+
+	var signed signResult
+	runJSON(t, &signed, "tx", "sign", "--key", path("alice.pem"), "--pool", pool, path("tx.json"))
+	if len(signed.Signed) != 1 {
+		t.Fatalf("signed inputs %v, expected [0]", signed.Signed)
+	}
+	var verified verifyResult
+	runJSON(t, &verified, "tx", "verify", "--pool", pool, path("tx.json"))
+	if !verified.Valid || !verified.HashValid || verified.Hash != signed.Hash || verified.NonStandard != "" {
+		t.Fatalf("signed transaction does not verify: %+v", verified)
+	}
+	if hash := strings.TrimSpace(runOK(t, "", "tx", "hash", path("tx.json"))); hash != signed.Hash {
+		t.Fatalf("tx hash printed %v, expected %v", hash, signed.Hash)
+	}
+
+	// the same transaction twice is a double spend, one copy gets in
+	tx, err := os.ReadFile(path("tx.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := "[" + string(tx) + "," + string(tx) + "]"
+	if err := os.WriteFile(path("empty.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var epoch epochResult
+	runJSON(t, &epoch, "epoch", "apply", "--pool", pool, path("empty.json"))
+	if epoch.Epoch != 1 || len(epoch.Accepted) != 0 {
+		t.Fatalf("empty epoch: %+v", epoch)
+	}
+	out := runOK(t, txs, "epoch", "apply", "--pool", pool, "-")
+	if !strings.Contains(out, "1 accepted, 1 rejected") || !strings.Contains(out, signed.Hash) {
+		t.Fatalf("epoch apply printed %q", out)
+	}
+
+	var balance balanceResult
+	runJSON(t, &balance, "pool", "balance", "--pool", pool, bob.Address)
+	if balance.Balance != 4 || len(balance.UTXOs) != 1 || balance.UTXOs[0].CreatedAt != 1 {
+		t.Fatalf("bob's balance: %+v", balance)
+	}
+	if out := runOK(t, "", "pool", "balance", "--pool", pool, alice.Address); out != "16\n" {
+		t.Fatalf("alice's balance printed %q, expected 16", out)
+	}
+}
+
+func TestCommandLineErrors(t *testing.T) {
+	dir := t.TempDir()
+	cases := [][]string{
+		{},
+		{"mint"},
+		{"keygen"},
+		{"keygen", "--type", "dsa", "--out", filepath.Join(dir, "key.pem")},
+		{"tx", "build", "--in", "00:0"},
+		{"pool", "balance", "--pool", filepath.Join(dir, "pool.json")},
+		{"pool", "show", "--format", "yaml", "--pool", filepath.Join(dir, "pool.json")},
+	}
+	for _, args := range cases {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code == 0 {
+			t.Errorf("scrooge %v succeeded", strings.Join(args, " "))
+		} else if stderr.Len() == 0 {
+			t.Errorf("scrooge %v failed without a message", strings.Join(args, " "))
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+type utxoResult struct {
+	TxHash    string  `json:"txHash"`
+	Index     int     `json:"index"`
+	Value     float64 `json:"value"`
+	Type      string  `json:"type"`
+	Owner     string  `json:"owner"`
+	CreatedAt int     `json:"createdAt"`
+}
+
+type poolResult struct {
+	Epoch int          `json:"epoch"`
+	Total float64      `json:"total"`
+	UTXOs []utxoResult `json:"utxos"`
+}
+
+func utxoResults(pool *scrooge.UTXOPool, match func(out *scrooge.TOutput) bool) ([]utxoResult, float64) {
+	results := []utxoResult{}
+	var total float64
+	for _, utxo := range sortedUTXOs(pool) {
+		out := pool.GetTxOutput(utxo)
+		if !match(out) {
+			continue
+		}
+		results = append(results, utxoResult{
+			TxHash:    hex.EncodeToString([]byte(utxo.TxHash)),
+			Index:     utxo.Index,
+			Value:     out.Value,
+			Type:      out.Type.String(),
+			Owner:     out.OwnerAddress(),
+			CreatedAt: pool.CreatedAt(utxo),
+		})
+		total += out.Value
+	}
+	return results, total
+}
+
+func printUTXOs(w io.Writer, results []utxoResult) {
+	for _, r := range results {
+		fmt.Fprintf(w, "%v:%v  %v  %v  %v  epoch %v\n", r.TxHash, r.Index, r.Value, r.Type, r.Owner, r.CreatedAt)
+	}
+}
+
+// poolMintCmd creates new coins out of nothing, as only Scrooge can.
+func poolMintCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "pool mint")
+	poolPath := fs.String("pool", "pool.json", "pool snapshot")
+	keyPath := fs.String("key", "", "PEM file of the receiving key")
+	value := fs.Float64("value", 0, "value of the new coin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *value <= 0 {
+		return errors.New("pool mint: --value must be positive")
+	}
+	key, err := loadKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		return err
+	}
+
+	tx := scrooge.NewTransaction()
+	switch pub := publicOf(key).(type) {
+	case *rsa.PublicKey:
+		tx.AddOutput(*value, *pub)
+	case ed25519.PublicKey:
+		tx.AddEd25519Output(*value, pub)
+	}
+	// the pool size keeps equal coins minted to the same key apart
+	raw := binary.BigEndian.AppendUint64(tx.GetRawTx(), uint64(len(pool.H)))
+	utxo := scrooge.UTXO{TxHash: string(cryptoutil.HashSha256(raw)), Index: 0}
+	pool.AddUTXO(utxo, &tx.Outputs[0])
+	if err := savePool(*poolPath, pool); err != nil {
+		return err
+	}
+
+	result := utxoResult{
+		TxHash:    hex.EncodeToString([]byte(utxo.TxHash)),
+		Value:     *value,
+		Type:      tx.Outputs[0].Type.String(),
+		Owner:     tx.Outputs[0].OwnerAddress(),
+		CreatedAt: pool.Epoch,
+	}
+	return emit(e, *format, result, func(w io.Writer) {
+		printUTXOs(w, []utxoResult{result})
+	})
+}
+
+func poolShowCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "pool show")
+	poolPath := fs.String("pool", "pool.json", "pool snapshot")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		return err
+	}
+	utxos, total := utxoResults(pool, func(*scrooge.TOutput) bool { return true })
+	result := poolResult{Epoch: pool.Epoch, Total: total, UTXOs: utxos}
+	return emit(e, *format, result, func(w io.Writer) {
+		fmt.Fprintf(w, "epoch %v, %v UTXOs, total value %v\n", result.Epoch, len(result.UTXOs), result.Total)
+		printUTXOs(w, result.UTXOs)
+	})
+}
+
+type balanceResult struct {
+	Address string       `json:"address"`
+	Balance float64      `json:"balance"`
+	UTXOs   []utxoResult `json:"utxos"`
+}
+
+func poolBalanceCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "pool balance")
+	poolPath := fs.String("pool", "pool.json", "pool snapshot")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("pool balance: expected one address")
+	}
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		return err
+	}
+	address := fs.Arg(0)
+	utxos, total := utxoResults(pool, func(out *scrooge.TOutput) bool { return out.OwnerAddress() == address })
+	result := balanceResult{Address: address, Balance: total, UTXOs: utxos}
+	return emit(e, *format, result, func(w io.Writer) {
+		fmt.Fprintf(w, "%v\n", result.Balance)
+	})
+}
+
+type epochResult struct {
+	Epoch    int      `json:"epoch"`
+	Accepted []string `json:"accepted"`
+	Rejected int      `json:"rejected"`
+}
+
+func epochApplyCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "epoch apply")
+	poolPath := fs.String("pool", "pool.json", "pool snapshot, updated in place")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		return err
+	}
+	txs, err := readTxs(e, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	proposed := len(txs)
+	accepted := scrooge.NewTxHandler(pool).HandleTxs(txs)
+	if err := savePool(*poolPath, pool); err != nil {
+		return err
+	}
+
+	result := epochResult{Epoch: pool.Epoch, Accepted: []string{}, Rejected: proposed - len(accepted)}
+	for _, tx := range accepted {
+		result.Accepted = append(result.Accepted, hex.EncodeToString(tx.Hash))
+	}
+	return emit(e, *format, result, func(w io.Writer) {
+		fmt.Fprintf(w, "epoch %v: %v accepted, %v rejected\n", result.Epoch, len(result.Accepted), result.Rejected)
+		for _, hash := range result.Accepted {
+			fmt.Fprintf(w, "  %v\n", hash)
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+// txSpec is the JSON form of the inputs and outputs given to tx build.
+type txSpec struct {
+	Inputs      []specInput  `json:"inputs"`
+	Outputs     []specOutput `json:"outputs"`
+	Replaceable bool         `json:"replaceable"`
+}
+
+type specInput struct {
+	TxHash string `json:"txHash"`
+	Index  int    `json:"index"`
+}
+
+type specOutput struct {
+	Value float64 `json:"value"`
+	// Key is the PEM file of the receiving key
+	Key string `json:"key"`
+}
+
+func txBuildCmd(e *env, args []string) error {
+	fs, _ := newFlags(e, "tx build")
+	var ins, outs multiFlag
+	fs.Var(&ins, "in", "input as <txhash>:<index>, repeatable")
+	fs.Var(&outs, "out", "output as <value>:<key.pem>, repeatable")
+	specPath := fs.String("json", "", "JSON file listing inputs and outputs")
+	replaceable := fs.Bool("replaceable", false, "let a conflicting transaction with a higher fee replace this one")
+	file := fs.String("file", "-", "file to write the unsigned transaction to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var spec txSpec
+	if *specPath != "" {
+		data, err := readInput(e, *specPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return fmt.Errorf("tx build: %v: %v", *specPath, err)
+		}
+	}
+	for _, in := range ins {
+		txHash, index, err := splitPair(in)
+		if err != nil {
+			return fmt.Errorf("tx build: --in %q: %v", in, err)
+		}
+		idx, err := strconv.Atoi(index)
+		if err != nil {
+			return fmt.Errorf("tx build: --in %q: bad index", in)
+		}
+		spec.Inputs = append(spec.Inputs, specInput{TxHash: txHash, Index: idx})
+	}
+	for _, out := range outs {
+		value, key, err := splitPair(out)
+		if err != nil {
+			return fmt.Errorf("tx build: --out %q: %v", out, err)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("tx build: --out %q: bad value", out)
+		}
+		spec.Outputs = append(spec.Outputs, specOutput{Value: v, Key: key})
+	}
+	if len(spec.Inputs) == 0 || len(spec.Outputs) == 0 {
+		return errors.New("tx build: at least one input and one output are required")
+	}
+
+	tx := scrooge.NewTransaction()
+	for _, in := range spec.Inputs {
+		txHash, err := hex.DecodeString(in.TxHash)
+		if err != nil {
+			return fmt.Errorf("tx build: bad transaction hash %q", in.TxHash)
+		}
+		tx.AddInput(txHash, in.Index)
+	}
+	for _, out := range spec.Outputs {
+		key, err := loadKey(out.Key)
+		if err != nil {
+			return err
+		}
+		switch pub := publicOf(key).(type) {
+		case *rsa.PublicKey:
+			tx.AddOutput(out.Value, *pub)
+		case ed25519.PublicKey:
+			tx.AddEd25519Output(out.Value, pub)
+		}
+	}
+	tx.Replaceable = spec.Replaceable || *replaceable
+	tx.Finalize()
+	return writeTx(e, *file, tx)
+}
+
+// splitPair splits s at its last colon.
+func splitPair(s string) (string, string, error) {
+	idx := strings.LastIndex(s, ":")
+	if idx <= 0 || idx == len(s)-1 {
+		return "", "", errors.New("expected two values separated by a colon")
+	}
+	return s[:idx], s[idx+1:], nil
+}
+
+type signResult struct {
+	Hash   string `json:"hash"`
+	Signed []int  `json:"signed"`
+}
+
+func txSignCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "tx sign")
+	keyPath := fs.String("key", "", "PEM file of the signing key")
+	poolPath := fs.String("pool", "pool.json", "pool snapshot holding the spent outputs")
+	file := fs.String("file", "", "file to write the signed transaction to, the input file by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	key, err := loadKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		return err
+	}
+	txPath := fs.Arg(0)
+	tx, err := readTx(e, txPath)
+	if err != nil {
+		return err
+	}
+
+	result := signResult{Signed: []int{}}
+	for idx, in := range tx.Inputs {
+		out := pool.GetTxOutput(scrooge.UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx})
+		if out == nil {
+			continue
+		}
+		signed, err := signInput(tx, idx, out, key)
+		if err != nil {
+			return err
+		}
+		if signed {
+			result.Signed = append(result.Signed, idx)
+		}
+	}
+	if len(result.Signed) == 0 {
+		return errors.New("tx sign: the key signs none of the inputs")
+	}
+	tx.Finalize()
+	result.Hash = hex.EncodeToString(tx.Hash)
+
+	if *file == "" {
+		*file = txPath
+	}
+	if *file == "" || *file == "-" {
+		// the transaction itself goes to standard output
+		return writeTx(e, *file, tx)
+	}
+	if err := writeTx(e, *file, tx); err != nil {
+		return err
+	}
+	return emit(e, *format, result, func(w io.Writer) {
+		fmt.Fprintf(w, "signed inputs %v\nhash: %v\n", result.Signed, result.Hash)
+	})
+}
+
+// signInput adds the signature of key to input idx of tx if out, the output it spends,
+// needs one.
+func signInput(tx *scrooge.Transaction, idx int, out *scrooge.TOutput, key interface{}) (bool, error) {
+	rawData := tx.GetRawDataToSign(idx)
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		if out.Type == scrooge.PayToEd25519 && out.EdAddress.Equal(k.Public()) {
+			tx.AddSignature(ed25519.Sign(k, rawData), idx)
+			return true, nil
+		}
+	case *rsa.PrivateKey:
+		var coSignature bool
+		switch {
+		case out.Type == scrooge.PayToAddress && out.Address.Equal(&k.PublicKey):
+		case out.Type == scrooge.PayToMultisig && out.Address.Equal(&k.PublicKey):
+		case out.Type == scrooge.PayToMultisig && out.CoSigner.Equal(&k.PublicKey):
+			coSignature = true
+		case out.Type == scrooge.PayToRevocable && tx.Inputs[idx].Preimage == nil && out.Address.Equal(&k.PublicKey):
+		case out.Type == scrooge.PayToRevocable && tx.Inputs[idx].Preimage != nil && out.CoSigner.Equal(&k.PublicKey):
+		default:
+			return false, nil
+		}
+		sig, err := cryptoutil.RSASign(k, rawData)
+		if err != nil {
+			return false, err
+		}
+		if coSignature {
+			tx.AddCoSignature(sig, idx)
+		} else {
+			tx.AddSignature(sig, idx)
+		}
+		return true, nil
+	default:
+		return false, errors.New("tx sign: a private key is required")
+	}
+	return false, nil
+}
+
+type verifyResult struct {
+	Hash      string `json:"hash"`
+	HashValid bool   `json:"hashValid"`
+	Valid     bool   `json:"valid"`
+	// NonStandard is the default policy rule the transaction breaks, if any.
+	NonStandard string `json:"nonStandard,omitempty"`
+}
+
+func txVerifyCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "tx verify")
+	poolPath := fs.String("pool", "pool.json", "pool snapshot to verify against")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pool, err := loadPool(*poolPath)
+	if err != nil {
+		return err
+	}
+	tx, err := readTx(e, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	hash := cryptoutil.HashSha256(tx.GetRawTx())
+	result := verifyResult{
+		Hash:      hex.EncodeToString(hash),
+		HashValid: bytes.Equal(hash, tx.Hash),
+		Valid:     scrooge.NewTxHandler(pool).IsValidTx(tx),
+	}
+	if err := scrooge.DefaultPolicy().Check(tx); err != nil {
+		result.NonStandard = err.Error()
+	}
+	if err := emit(e, *format, result, func(w io.Writer) {
+		switch {
+		case !result.HashValid:
+			fmt.Fprintf(w, "invalid: hash does not match, expected %v\n", result.Hash)
+		case !result.Valid:
+			fmt.Fprintf(w, "invalid: %v\n", result.Hash)
+		case result.NonStandard != "":
+			fmt.Fprintf(w, "valid but non-standard (%v): %v\n", result.NonStandard, result.Hash)
+		default:
+			fmt.Fprintf(w, "valid: %v\n", result.Hash)
+		}
+	}); err != nil {
+		return err
+	}
+	if !result.Valid || !result.HashValid {
+		return errInvalid
+	}
+	return nil
+}
+
+func txHashCmd(e *env, args []string) error {
+	fs, format := newFlags(e, "tx hash")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tx, err := readTx(e, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	hash := hex.EncodeToString(cryptoutil.HashSha256(tx.GetRawTx()))
+	return emit(e, *format, map[string]string{"hash": hash}, func(w io.Writer) {
+		fmt.Fprintln(w, hash)
+	})
+}
@@ -0,0 +1,104 @@
+package consensus
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"scrooge"
+)
+
+func network(malicious float64, behaviors ...Behavior) Config {
+	return Config{
+		Nodes:           100,
+		Rounds:          10,
+		Txs:             100,
+		PGraph:          0.1,
+		PMalicious:      malicious,
+		PTxDistribution: 0.05,
+		Malicious:       behaviors,
+		Seed:            1,
+	}
+}
+
+func TestCompliantNodesAgree(t *testing.T) {
+	report := Simulate(network(0))
+	if report.Compliant != 100 || report.Agreement != 1 || report.Coverage != 1 || report.Foreign != 0 {
+		t.Fatalf("compliant network: %v compliant nodes, agreement %v, coverage %v, %v foreign",
+			report.Compliant, report.Agreement, report.Coverage, report.Foreign)
+	}
+}
+
+func TestMaliciousNodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		behaviors []Behavior
+	}{
+		{"dead", []Behavior{Dead}},
+		{"silent", []Behavior{Silent}},
+		{"flipping", []Behavior{Flipping}},
+		{"mixed", []Behavior{Dead, Silent, Flipping}},
+	}
+	for _, test := range tests {
+		for _, malicious := range []float64{0.15, 0.45} {
+			report := Simulate(network(malicious, test.behaviors...))
+			if report.Agreement != 1 || report.Foreign != 0 || report.Coverage < 0.5 {
+				t.Errorf("%v nodes, %v of the network: agreement %v, coverage %v, %v foreign", test.name,
+					malicious, report.Agreement, report.Coverage, report.Foreign)
+			}
+		}
+	}
+}
+
+func TestSybilSplitsConsensus(t *testing.T) {
+	cfg := network(0.2)
+	sybil := NewSybil(rand.New(rand.NewSource(2)), 3, cfg.Rounds)
+	cfg.Malicious = []Behavior{sybil.Behavior()}
+	report := Simulate(cfg)
+	if report.Agreement >= 1 {
+		t.Fatalf("transactions forged in the last round reached every compliant node")
+	}
+	if report.Foreign != 0 && report.Foreign != len(sybil.Forged()) {
+		t.Fatalf("%v foreign transactions in the consensus, %v forged", report.Foreign, len(sybil.Forged()))
+	}
+}
+
+func TestSimulateDeterministic(t *testing.T) {
+	cfg := network(0.3, Dead, Silent, Flipping)
+	first := Simulate(cfg)
+	if second := Simulate(cfg); !reflect.DeepEqual(first, second) {
+		t.Fatalf("same seed, different reports")
+	}
+	cfg.Seed = 2
+	if other := Simulate(cfg); reflect.DeepEqual(first.Consensus, other.Consensus) {
+		t.Fatalf("another seed gave the same consensus")
+	}
+}
+
+func TestCompliantNodeStopsFollowing(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a, b, c := randomTx(rng), randomTx(rng), randomTx(rng)
+	node := NewCompliantNode()
+	node.SetFollowees([]bool{true, true, true, false})
+	node.ReceiveFromFollowees([]Candidate{{a, 0}, {a, 1}, {b, 1}, {c, 3}})
+	assertSet(t, "after the first round", node.SendToFollowers(), a, b)
+
+	// 1 drops b, 2 has proposed nothing yet
+	node.ReceiveFromFollowees([]Candidate{{a, 0}, {a, 1}})
+	if followees := node.Followees(); !reflect.DeepEqual(followees, []int{0, 2}) {
+		t.Fatalf("followees after 1 dropped a transaction: %v", followees)
+	}
+	node.ReceiveFromFollowees([]Candidate{{a, 0}, {c, 1}})
+	assertSet(t, "after the third round", node.SendToFollowers(), a, b)
+	node.ReceiveFromFollowees([]Candidate{{a, 0}, {c, 2}})
+	assertSet(t, "after the fourth round", node.SendToFollowers(), a, b, c)
+}
+
+func assertSet(t *testing.T, what string, txs []*scrooge.Transaction, expected ...*scrooge.Transaction) {
+	t.Helper()
+	set := make(txSet)
+	set.add(expected...)
+	if !reflect.DeepEqual(txs, set.sorted()) {
+		t.Fatalf("%v: %v transactions, expected %v", what, len(txs), len(expected))
+	}
+}
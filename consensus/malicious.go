@@ -0,0 +1,138 @@
+package consensus
+
+import (
+	"math/rand"
+
+	"scrooge"
+)
+
+// DeadNode never proposes anything.
+type DeadNode struct{}
+
+func NewDeadNode() *DeadNode {
+	return &DeadNode{}
+}
+
+func (*DeadNode) SetFollowees([]bool)                           {}
+func (*DeadNode) SetPendingTransactions([]*scrooge.Transaction) {}
+func (*DeadNode) SendToFollowers() []*scrooge.Transaction       { return nil }
+func (*DeadNode) ReceiveFromFollowees([]Candidate)              {}
+
+// SilentNode proposes its pending transactions in the first round, then stops: it never
+// relays what it hears.
+type SilentNode struct {
+	pending []*scrooge.Transaction
+	spoken  bool
+}
+
+func NewSilentNode() *SilentNode {
+	return &SilentNode{}
+}
+
+func (*SilentNode) SetFollowees([]bool) {}
+
+func (node *SilentNode) SetPendingTransactions(txs []*scrooge.Transaction) {
+	node.pending = txs
+}
+
+func (node *SilentNode) SendToFollowers() []*scrooge.Transaction {
+	if node.spoken {
+		return nil
+	}
+	node.spoken = true
+	return node.pending
+}
+
+func (*SilentNode) ReceiveFromFollowees([]Candidate) {}
+
+// FlippingNode proposes a different random half of the transactions it has heard of
+// every round.
+type FlippingNode struct {
+	rng   *rand.Rand
+	known txSet
+}
+
+func NewFlippingNode(rng *rand.Rand) *FlippingNode {
+	return &FlippingNode{rng: rng, known: make(txSet)}
+}
+
+func (*FlippingNode) SetFollowees([]bool) {}
+
+func (node *FlippingNode) SetPendingTransactions(txs []*scrooge.Transaction) {
+	node.known.add(txs...)
+}
+
+func (node *FlippingNode) SendToFollowers() []*scrooge.Transaction {
+	var txs []*scrooge.Transaction
+	for _, tx := range node.known.sorted() {
+		if node.rng.Intn(2) == 0 {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func (node *FlippingNode) ReceiveFromFollowees(candidates []Candidate) {
+	for _, candidate := range candidates {
+		node.known.add(candidate.Tx)
+	}
+}
+
+// Sybil is an adversary running many nodes. They relay like compliant nodes until the
+// last of Rounds rounds, in which they all propose the transactions the adversary
+// forged as well. The forged transactions only reach the followers of its nodes, too
+// late to be relayed further, which splits the compliant nodes.
+type Sybil struct {
+	Rounds int
+	forged []*scrooge.Transaction
+}
+
+// NewSybil returns an adversary forging count transactions, for a simulation of rounds rounds.
+func NewSybil(rng *rand.Rand, count, rounds int) *Sybil {
+	sybil := &Sybil{Rounds: rounds}
+	for len(sybil.forged) < count {
+		sybil.forged = append(sybil.forged, randomTx(rng))
+	}
+	return sybil
+}
+
+// Forged returns the transactions forged by sybil.
+func (sybil *Sybil) Forged() []*scrooge.Transaction {
+	return sybil.forged
+}
+
+// Node returns a new node run by sybil.
+func (sybil *Sybil) Node() Node {
+	return &sybilNode{sybil: sybil, known: make(txSet)}
+}
+
+// Behavior returns the behavior of the nodes run by sybil.
+func (sybil *Sybil) Behavior() Behavior {
+	return func(*rand.Rand) Node { return sybil.Node() }
+}
+
+type sybilNode struct {
+	sybil *Sybil
+	known txSet
+	round int
+}
+
+func (*sybilNode) SetFollowees([]bool) {}
+
+func (node *sybilNode) SetPendingTransactions(txs []*scrooge.Transaction) {
+	node.known.add(txs...)
+}
+
+func (node *sybilNode) SendToFollowers() []*scrooge.Transaction {
+	node.round++
+	if node.round == node.sybil.Rounds {
+		node.known.add(node.sybil.forged...)
+	}
+	return node.known.sorted()
+}
+
+func (node *sybilNode) ReceiveFromFollowees(candidates []Candidate) {
+	for _, candidate := range candidates {
+		node.known.add(candidate.Tx)
+	}
+}
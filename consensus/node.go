@@ -0,0 +1,126 @@
+// Package consensus simulates how a network of nodes, none of them trusted like
+// Scrooge, can agree on a set of transactions.
+//
+// Each node follows some of the others. Every round, it sends the transactions it
+// proposes to its followers and receives the proposals of its followees. After the
+// last round, the transactions a node sends are those it settles on. Compliant nodes
+// relay everything they hear, so that transactions spread through the follow graph;
+// malicious ones may stay silent, change their mind or inject their own transactions.
+// Simulate runs a network of both on a random follow graph and reports how far the
+// compliant nodes agree.
+package consensus
+
+import (
+	"sort"
+
+	"scrooge"
+)
+
+// Candidate is a transaction proposed by the followee Sender.
+type Candidate struct {
+	Tx     *scrooge.Transaction
+	Sender int
+}
+
+// Node is a participant in the consensus, numbered by its index in the network.
+type Node interface {
+	// SetFollowees tells the node the nodes it follows: followees[i] is set if it
+	// follows node i.
+	SetFollowees(followees []bool)
+	// SetPendingTransactions gives the node the transactions it initially knows.
+	SetPendingTransactions(txs []*scrooge.Transaction)
+	// SendToFollowers returns the transactions the node proposes this round, and after
+	// the last round those it reaches consensus on.
+	SendToFollowers() []*scrooge.Transaction
+	// ReceiveFromFollowees hands the node the proposals of its followees this round.
+	ReceiveFromFollowees(candidates []Candidate)
+}
+
+// txSet is a set of transactions by hash.
+type txSet map[string]*scrooge.Transaction
+
+func (set txSet) add(txs ...*scrooge.Transaction) {
+	for _, tx := range txs {
+		set[string(tx.Hash)] = tx
+	}
+}
+
+// sorted returns the transactions of set sorted by hash, so that equal sets compare equal.
+func (set txSet) sorted() []*scrooge.Transaction {
+	txs := make([]*scrooge.Transaction, 0, len(set))
+	for _, tx := range set {
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return string(txs[i].Hash) < string(txs[j].Hash) })
+	return txs
+}
+
+// CompliantNode relays every transaction it has heard of, so the proposals of the nodes
+// it follows grow from round to round. A compliant followee never drops a transaction
+// it has proposed: one that does, by going silent or changing its proposal, is no
+// longer followed.
+type CompliantNode struct {
+	followees []bool
+	known     txSet
+	// proposed holds the transactions each followee has proposed so far.
+	proposed map[int]txSet
+}
+
+func NewCompliantNode() *CompliantNode {
+	return &CompliantNode{known: make(txSet), proposed: make(map[int]txSet)}
+}
+
+func (node *CompliantNode) SetFollowees(followees []bool) {
+	node.followees = append([]bool(nil), followees...)
+}
+
+func (node *CompliantNode) SetPendingTransactions(txs []*scrooge.Transaction) {
+	node.known.add(txs...)
+}
+
+func (node *CompliantNode) SendToFollowers() []*scrooge.Transaction {
+	return node.known.sorted()
+}
+
+func (node *CompliantNode) ReceiveFromFollowees(candidates []Candidate) {
+	received := make(map[int]txSet)
+	for _, candidate := range candidates {
+		if candidate.Sender < 0 || candidate.Sender >= len(node.followees) || !node.followees[candidate.Sender] {
+			continue
+		}
+		if received[candidate.Sender] == nil {
+			received[candidate.Sender] = make(txSet)
+		}
+		received[candidate.Sender].add(candidate.Tx)
+	}
+	for followee, proposed := range node.proposed {
+		for hash := range proposed {
+			if _, ok := received[followee][hash]; !ok {
+				node.followees[followee] = false
+				delete(node.proposed, followee)
+				delete(received, followee)
+				break
+			}
+		}
+	}
+	for followee, txs := range received {
+		if node.proposed[followee] == nil {
+			node.proposed[followee] = make(txSet)
+		}
+		for _, tx := range txs {
+			node.proposed[followee].add(tx)
+			node.known.add(tx)
+		}
+	}
+}
+
+// Followees returns the nodes node still follows.
+func (node *CompliantNode) Followees() []int {
+	var followees []int
+	for idx, follows := range node.followees {
+		if follows {
+			followees = append(followees, idx)
+		}
+	}
+	return followees
+}
@@ -0,0 +1,164 @@
+package consensus
+
+import (
+	"math/rand"
+	"strings"
+
+	"scrooge"
+)
+
+// Behavior returns a new malicious node, drawing on rng for its choices.
+type Behavior func(rng *rand.Rand) Node
+
+// Dead, Silent and Flipping are the behaviors of DeadNode, SilentNode and FlippingNode.
+func Dead(*rand.Rand) Node         { return NewDeadNode() }
+func Silent(*rand.Rand) Node       { return NewSilentNode() }
+func Flipping(rng *rand.Rand) Node { return NewFlippingNode(rng) }
+
+// Config describes a simulated network.
+type Config struct {
+	Nodes  int
+	Rounds int
+	// Txs is the number of transactions drawn, each given to every node with
+	// probability PTxDistribution.
+	Txs int
+	// PGraph is the probability that a node follows another and PMalicious that a node
+	// is malicious.
+	PGraph          float64
+	PMalicious      float64
+	PTxDistribution float64
+	// Malicious lists the behaviors of the malicious nodes, one drawn for each. Without
+	// any, the malicious nodes are dead.
+	Malicious []Behavior
+	// Seed seeds every random choice: the same configuration gives the same report.
+	Seed int64
+}
+
+// Report is the outcome of a simulation.
+type Report struct {
+	// Compliant is the number of compliant nodes.
+	Compliant int
+	// Consensus is the set of transactions most compliant nodes end with, sorted by
+	// hash, and Agreement the fraction of the compliant nodes ending with it.
+	Consensus []*scrooge.Transaction
+	Agreement float64
+	// Coverage is the fraction of the transactions given to some node that are in
+	// Consensus, and Foreign the number of transactions of Consensus given to none.
+	Coverage float64
+	Foreign  int
+}
+
+// randomTx returns a transaction spending a random output, unique in the simulation.
+// The nodes never check transactions, so it needs no signature.
+func randomTx(rng *rand.Rand) *scrooge.Transaction {
+	hash := make([]byte, 32)
+	rng.Read(hash)
+	tx := scrooge.NewTransaction()
+	tx.AddInput(hash, 0)
+	tx.Finalize()
+	return tx
+}
+
+// Simulate builds the network of cfg, with a random follow graph, random malicious
+// nodes and randomly distributed transactions, and runs it for cfg.Rounds rounds.
+func Simulate(cfg Config) Report {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	behaviors := cfg.Malicious
+	if len(behaviors) == 0 {
+		behaviors = []Behavior{Dead}
+	}
+
+	nodes := make([]Node, cfg.Nodes)
+	compliant := make([]bool, cfg.Nodes)
+	for idx := range nodes {
+		if rng.Float64() < cfg.PMalicious {
+			nodes[idx] = behaviors[rng.Intn(len(behaviors))](rng)
+		} else {
+			nodes[idx] = NewCompliantNode()
+			compliant[idx] = true
+		}
+	}
+
+	followees := make([][]bool, cfg.Nodes)
+	for idx := range followees {
+		followees[idx] = make([]bool, cfg.Nodes)
+		for other := range followees[idx] {
+			followees[idx][other] = other != idx && rng.Float64() < cfg.PGraph
+		}
+		nodes[idx].SetFollowees(followees[idx])
+	}
+
+	distributed := make(txSet)
+	pending := make([][]*scrooge.Transaction, cfg.Nodes)
+	for count := 0; count < cfg.Txs; count++ {
+		tx := randomTx(rng)
+		for idx := range pending {
+			if rng.Float64() < cfg.PTxDistribution {
+				pending[idx] = append(pending[idx], tx)
+				distributed.add(tx)
+			}
+		}
+	}
+	for idx, node := range nodes {
+		node.SetPendingTransactions(pending[idx])
+	}
+
+	for round := 0; round < cfg.Rounds; round++ {
+		proposals := make([][]*scrooge.Transaction, cfg.Nodes)
+		for idx, node := range nodes {
+			proposals[idx] = node.SendToFollowers()
+		}
+		for idx, node := range nodes {
+			var candidates []Candidate
+			for sender, follows := range followees[idx] {
+				if follows {
+					for _, tx := range proposals[sender] {
+						candidates = append(candidates, Candidate{Tx: tx, Sender: sender})
+					}
+				}
+			}
+			node.ReceiveFromFollowees(candidates)
+		}
+	}
+
+	return report(nodes, compliant, distributed)
+}
+
+// report tallies the sets the compliant nodes end with.
+func report(nodes []Node, compliant []bool, distributed txSet) Report {
+	var r Report
+	counts := make(map[string]int)
+	sets := make(map[string][]*scrooge.Transaction)
+	best := ""
+	for idx, node := range nodes {
+		if !compliant[idx] {
+			continue
+		}
+		r.Compliant++
+		txs := node.SendToFollowers()
+		var key strings.Builder
+		for _, tx := range txs {
+			key.Write(tx.Hash)
+		}
+		counts[key.String()]++
+		sets[key.String()] = txs
+		if counts[key.String()] > counts[best] || (counts[key.String()] == counts[best] && key.String() < best) {
+			best = key.String()
+		}
+	}
+	if r.Compliant == 0 {
+		return r
+	}
+
+	r.Consensus = sets[best]
+	r.Agreement = float64(counts[best]) / float64(r.Compliant)
+	for _, tx := range r.Consensus {
+		if _, ok := distributed[string(tx.Hash)]; !ok {
+			r.Foreign++
+		}
+	}
+	if len(distributed) > 0 {
+		r.Coverage = float64(len(r.Consensus)-r.Foreign) / float64(len(distributed))
+	}
+	return r
+}
@@ -2,26 +2,47 @@ package cryptoutil
 
 import (
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/hex"
 	"encoding/pem"
-	"fmt"
+	"log/slog"
+	"sync/atomic"
 )
 
+var discard = slog.New(slog.DiscardHandler)
+
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger sends the diagnostics of the package, such as failed verifications at
+// debug level, to l. They are discarded by default, or again after SetLogger(nil).
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+func log() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return discard
+}
+
 func GetPrivateKey() *rsa.PrivateKey {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		fmt.Println(err.Error())
+		log().Error("generating RSA key", "err", err)
 	}
 	return privateKey
 }
 
+// GetPEMPrivateKey encodes prKey as a PKCS#1 "RSA PRIVATE KEY" PEM block.
 func GetPEMPrivateKey(prKey *rsa.PrivateKey) []byte {
 	prKeyBlock := &pem.Block{
-		Type:  "PRIVATE KEY",
+		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(prKey),
 	}
 
@@ -31,7 +52,7 @@ func GetPEMPrivateKey(prKey *rsa.PrivateKey) []byte {
 func GetPEMPublicKey(puKey rsa.PublicKey) []byte {
 	asn1Bytes, err := asn1.Marshal(puKey)
 	if err != nil {
-		fmt.Println("Fatal error ", err.Error())
+		log().Error("encoding RSA public key", "err", err)
 		return nil
 	}
 	var puKeyBlock = &pem.Block{
@@ -42,6 +63,18 @@ func GetPEMPublicKey(puKey rsa.PublicKey) []byte {
 	return pem.EncodeToMemory(puKeyBlock)
 }
 
+// GetAddress returns the address of puKey: the first 20 bytes of the SHA-256 of its
+// PEM encoding, in hex.
+func GetAddress(puKey rsa.PublicKey) string {
+	return hex.EncodeToString(HashSha256(GetPEMPublicKey(puKey))[:20])
+}
+
+// GetEd25519Address returns the address of an Ed25519 public key: the first 20 bytes of
+// its SHA-256, in hex.
+func GetEd25519Address(puKey ed25519.PublicKey) string {
+	return hex.EncodeToString(HashSha256(puKey)[:20])
+}
+
 func HashSha256(rawData []byte) []byte {
 	sha_256 := sha256.New()
 	sha_256.Write(rawData)
@@ -54,7 +87,7 @@ func RSASign(priKey *rsa.PrivateKey, data []byte) ([]byte, error) {
 	hashed := sha256.Sum256(data)
 	signature, err := rsa.SignPKCS1v15(rng, priKey, crypto.SHA256, hashed[:])
 	if err != nil {
-		fmt.Printf("Error from signing: %s\n", err)
+		log().Debug("RSA signing failed", "err", err)
 		return nil, err
 	}
 	return signature, nil
@@ -67,7 +100,7 @@ func RSAVerify(pubKey *rsa.PublicKey, data []byte, signature []byte) bool {
 	hashed := sha256.Sum256(data)
 	err := rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, hashed[:], signature)
 	if err != nil {
-		fmt.Printf("Error from verification: %s\n", err)
+		log().Debug("RSA verification failed", "err", err)
 		return false
 	}
 	return true
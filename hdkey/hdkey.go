@@ -0,0 +1,114 @@
+// Package hdkey derives Ed25519 keys deterministically from a single seed, following
+// SLIP-0010, with BIP-39 mnemonic phrases to back the seed up.
+//
+// Ed25519 only supports hardened derivation, so every path element is hardened whether
+// or not it is written with a trailing apostrophe.
+package hdkey
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HardenedOffset is added to the index of every derived child.
+const HardenedOffset uint32 = 1 << 31
+
+// Purpose and CoinType make up the default scrooge account path m/44'/1984'/account'.
+const (
+	Purpose  uint32 = 44
+	CoinType uint32 = 1984
+)
+
+// Key is an extended private key: an Ed25519 seed and the chain code to derive its
+// children.
+type Key struct {
+	key       []byte
+	chainCode []byte
+	Depth     int
+	// Index is the hardened index of the key under its parent, 0 for the master key.
+	Index uint32
+}
+
+// NewMasterKey returns the master key of seed, which must be 16 to 64 bytes long.
+func NewMasterKey(seed []byte) (*Key, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("hdkey: seed of %v bytes, expected 16 to 64", len(seed))
+	}
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	return &Key{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+// Child derives the child at index, which is hardened if it is not already.
+func (k *Key) Child(index uint32) *Key {
+	index |= HardenedOffset
+	data := make([]byte, 1+32+4)
+	copy(data[1:], k.key)
+	binary.BigEndian.PutUint32(data[33:], index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	return &Key{key: sum[:32], chainCode: sum[32:], Depth: k.Depth + 1, Index: index}
+}
+
+// Derive follows path, such as "m/44'/1984'/0'/5'", from the master key k.
+func (k *Key) Derive(path string) (*Key, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if k.Depth != 0 {
+		return nil, errors.New("hdkey: paths are derived from the master key")
+	}
+	key := k
+	for _, index := range indexes {
+		key = key.Child(index)
+	}
+	return key, nil
+}
+
+// ParsePath parses a derivation path into hardened indexes.
+func ParsePath(path string) ([]uint32, error) {
+	elements := strings.Split(strings.TrimSpace(path), "/")
+	if elements[0] != "m" {
+		return nil, fmt.Errorf("hdkey: path %q does not start at m", path)
+	}
+	indexes := make([]uint32, 0, len(elements)-1)
+	for _, element := range elements[1:] {
+		element = strings.TrimRight(element, "'hH")
+		index, err := strconv.ParseUint(element, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, fmt.Errorf("hdkey: bad path element %q in %q", element, path)
+		}
+		indexes = append(indexes, uint32(index)|HardenedOffset)
+	}
+	return indexes, nil
+}
+
+// AccountPath returns the path of the address at index of account.
+func AccountPath(account uint32, index uint32) string {
+	return fmt.Sprintf("m/%v'/%v'/%v'/%v'", Purpose, CoinType, account, index)
+}
+
+// PrivateKey returns the Ed25519 private key of k.
+func (k *Key) PrivateKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(k.key)
+}
+
+// PublicKey returns the Ed25519 public key of k.
+func (k *Key) PublicKey() ed25519.PublicKey {
+	return k.PrivateKey().Public().(ed25519.PublicKey)
+}
+
+// ChainCode returns the chain code of k.
+func (k *Key) ChainCode() []byte {
+	return append([]byte(nil), k.chainCode...)
+}
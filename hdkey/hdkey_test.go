@@ -0,0 +1,100 @@
+package hdkey
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// test vector 1 for ed25519 from SLIP-0010
+func TestSLIP10Vectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatalf("NewMasterKey: %v", err)
+	}
+
+	cases := []struct {
+		path, chainCode, private string
+	}{
+		{"m", "90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb", "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7"},
+		{"m/0'", "8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69", "68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3"},
+		{"m/0'/1'", "a320425f77d1b5c2505a6b1b27382b37368ee640e3557c315416801243552f14", "b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2"},
+		{"m/0'/1'/2'", "2e69929e00b5ab250f49c3fb1c12f252de4fed2c1db88387094a0f8c4c9ccd6c", "92a5b23c0b8a99e37d07df3fb9966917f5d06e02ddbd909c7e184371463e9fc9"},
+		{"m/0'/1'/2'/2'", "8f6d87f93d750e0efccda017d662a1b31a266e4a6f5993b15f5c1f07f74dd5cc", "30d1dc7e5fc04c31219ab25a27ae00b50f6fd66622f6e9c913253d6511d1e662"},
+		{"m/0'/1'/2'/2'/1000000000'", "68789923a0cac2cd5a29172a475fe9e0fb14cd6adb5ad98a3fa70333e7afa230", "8f94d394a8e8fd6b1bc2f3f49f5c47e385281d5c17e65324b0f62483e37e8793"},
+	}
+	for _, c := range cases {
+		key, err := master.Derive(c.path)
+		if err != nil {
+			t.Fatalf("Derive(%v): %v", c.path, err)
+		}
+		if hex.EncodeToString(key.ChainCode()) != c.chainCode {
+			t.Errorf("%v: chain code %x, expected %v", c.path, key.ChainCode(), c.chainCode)
+		}
+		if hex.EncodeToString(key.PrivateKey().Seed()) != c.private {
+			t.Errorf("%v: private key %x, expected %v", c.path, key.PrivateKey().Seed(), c.private)
+		}
+	}
+
+	// unhardened indexes are hardened
+	if a, _ := master.Derive("m/7/3"); hex.EncodeToString(a.PublicKey()) != hex.EncodeToString(master.Child(7).Child(3|HardenedOffset).PublicKey()) {
+		t.Errorf("m/7/3 and m/7'/3' differ")
+	}
+	for _, path := range []string{"", "x/0'", "m/-1", "m/2147483648", "m//1"} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%q) succeeded", path)
+		}
+	}
+}
+
+// test vectors from the BIP-39 reference implementation, with passphrase "TREZOR"
+func TestMnemonicVectors(t *testing.T) {
+	cases := []struct {
+		entropy, mnemonic, seed string
+	}{
+		{
+			"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+			"dd48c104698c30cfe2b6142103248622fb7bb0ff692eebb00089b32d22484e1613912f0a5b694407be899ffd31ed3992c456cdf60f5d4564b8ba3f05a69890ad",
+		},
+	}
+	for _, c := range cases {
+		entropy, _ := hex.DecodeString(c.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil || mnemonic != c.mnemonic {
+			t.Errorf("EntropyToMnemonic(%v)=%q, %v", c.entropy, mnemonic, err)
+		}
+		decoded, err := MnemonicToEntropy(c.mnemonic)
+		if err != nil || hex.EncodeToString(decoded) != c.entropy {
+			t.Errorf("MnemonicToEntropy(%q)=%x, %v", c.mnemonic, decoded, err)
+		}
+		seed, err := Seed(c.mnemonic, "TREZOR")
+		if err != nil || hex.EncodeToString(seed) != c.seed {
+			t.Errorf("Seed(%q)=%x, %v", c.mnemonic, seed, err)
+		}
+	}
+
+	bad := strings.Replace(cases[0].mnemonic, "about", "abandon", 1)
+	if err := ValidateMnemonic(bad); err != ErrInvalidMnemonic {
+		t.Errorf("ValidateMnemonic accepted a bad checksum: %v", err)
+	}
+	if err := ValidateMnemonic("abandon abandon scrooge"); err == nil {
+		t.Errorf("ValidateMnemonic accepted a short mnemonic")
+	}
+
+	mnemonic, err := NewMnemonic(256)
+	if err != nil || len(strings.Fields(mnemonic)) != 24 || ValidateMnemonic(mnemonic) != nil {
+		t.Errorf("NewMnemonic(256)=%q, %v", mnemonic, err)
+	}
+}
@@ -0,0 +1,108 @@
+package hdkey
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// english.txt is the BIP-39 English word list.
+//
+//go:embed english.txt
+var english string
+
+var wordList = strings.Fields(english)
+
+var wordIndex = func() map[string]int {
+	index := make(map[string]int, len(wordList))
+	for i, word := range wordList {
+		index[word] = i
+	}
+	return index
+}()
+
+var ErrInvalidMnemonic = errors.New("hdkey: invalid mnemonic")
+
+// NewMnemonic returns a BIP-39 mnemonic encoding bits of fresh entropy. bits must be a
+// multiple of 32 between 128 and 256.
+func NewMnemonic(bits int) (string, error) {
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic encodes entropy as a BIP-39 mnemonic.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", fmt.Errorf("hdkey: entropy of %v bits, expected 128 to 256 in steps of 32", bits)
+	}
+	checksumBits := uint(bits / 32)
+	checksum := sha256.Sum256(entropy)
+
+	// entropy followed by the first checksumBits bits of its hash, 11 bits per word
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(checksum[0]>>(8-checksumBits))))
+
+	words := make([]string, (bits+int(checksumBits))/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a BIP-39 mnemonic, checking its words and checksum.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+	data := new(big.Int)
+	for _, word := range words {
+		idx, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("hdkey: unknown mnemonic word %q", word)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(idx)))
+	}
+
+	checksumBits := uint(len(words) / 3)
+	checksum := new(big.Int).And(data, big.NewInt(int64(1)<<checksumBits-1)).Int64()
+	data.Rsh(data, checksumBits)
+	entropy := data.FillBytes(make([]byte, (len(words)*11-int(checksumBits))/8))
+
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+// ValidateMnemonic reports whether mnemonic is a well-formed BIP-39 mnemonic.
+func ValidateMnemonic(mnemonic string) error {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err
+}
+
+// Seed derives the 64 bytes BIP-39 seed of mnemonic protected by passphrase. Mnemonic
+// and passphrase are used as given: callers with non-ASCII passphrases must normalize
+// them to NFKD themselves.
+func Seed(mnemonic string, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key(sha512.New, normalized, []byte("mnemonic"+passphrase), 2048, 64)
+}
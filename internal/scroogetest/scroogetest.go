@@ -0,0 +1,52 @@
+// Package scroogetest holds the fixtures shared by the tests of the packages built on
+// scrooge: a pool of outputs of a made-up transaction, and signed spends of them.
+package scroogetest
+
+import (
+	"crypto/rsa"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+// PrevTxHash is the hash of the made-up transaction whose outputs Pool holds.
+const PrevTxHash = "txhash#1"
+
+// Out returns output idx of PrevTxHash.
+func Out(idx int) scrooge.UTXO {
+	return scrooge.UTXO{TxHash: PrevTxHash, Index: idx}
+}
+
+// Pool returns a pool in which key owns count outputs of PrevTxHash worth 10 each.
+func Pool(key *rsa.PrivateKey, count int) *scrooge.UTXOPool {
+	pool := scrooge.NewUTXOPool()
+	for idx := 0; idx < count; idx++ {
+		pool.AddUTXO(Out(idx), &scrooge.TOutput{Value: 10, Address: key.PublicKey})
+	}
+	return pool
+}
+
+// Spend returns a transaction in which key spends output idx of PrevTxHash, paying
+// value to payee.
+func Spend(key *rsa.PrivateKey, idx int, payee rsa.PublicKey, value float64) *scrooge.Transaction {
+	tx := scrooge.NewTransaction()
+	tx.AddInput([]byte(PrevTxHash), idx)
+	tx.AddOutput(value, payee)
+	signature, err := cryptoutil.RSASign(key, tx.GetRawDataToSign(0))
+	if err != nil {
+		panic(err)
+	}
+	tx.AddSignature(signature, 0)
+	tx.Finalize()
+	return tx
+}
+
+// Spends returns count transactions in which key spends each of the first count outputs
+// of PrevTxHash, paying 9 of its 10 to itself.
+func Spends(key *rsa.PrivateKey, count int) []*scrooge.Transaction {
+	txs := make([]*scrooge.Transaction, count)
+	for idx := range txs {
+		txs[idx] = Spend(key, idx, key.PublicKey, 9)
+	}
+	return txs
+}
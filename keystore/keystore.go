@@ -0,0 +1,280 @@
+// Package keystore keeps private keys on disk, encrypted with a passphrase, and converts
+// keys from and to standard PEM files.
+//
+// Each key is stored in its own JSON file named after the key ID. The key is encoded as
+// PKCS#8 and sealed with AES-256-GCM under a key derived from the passphrase with scrypt.
+package keystore
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"scrooge/cryptoutil"
+)
+
+const (
+	fileVersion = 1
+	fileSuffix  = ".key.json"
+
+	// DefaultScryptN, DefaultScryptR and DefaultScryptP are the scrypt costs of new keys.
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+)
+
+var (
+	ErrWrongPassphrase = errors.New("keystore: wrong passphrase or corrupted key file")
+	ErrKeyNotFound     = errors.New("keystore: key not found")
+)
+
+// KeyInfo describes a stored key without decrypting it.
+type KeyInfo struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+type scryptParams struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"`
+}
+
+type cipherParams struct {
+	Name  string `json:"name"`
+	Nonce string `json:"nonce"`
+}
+
+// keyFile is the on-disk format of a stored key.
+type keyFile struct {
+	Version int `json:"version"`
+	KeyInfo
+	KDF        scryptParams `json:"kdf"`
+	Cipher     cipherParams `json:"cipher"`
+	Ciphertext string       `json:"ciphertext"`
+}
+
+type KeyStore struct {
+	dir string
+	// ScryptN, ScryptR and ScryptP are the scrypt costs used by Save.
+	ScryptN int
+	ScryptR int
+	ScryptP int
+}
+
+// New opens the key store in dir, creating the directory if needed.
+func New(dir string) (*KeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &KeyStore{dir: dir, ScryptN: DefaultScryptN, ScryptR: DefaultScryptR, ScryptP: DefaultScryptP}, nil
+}
+
+// Info returns the ID, type and address of a supported private key.
+func Info(key crypto.PrivateKey) (KeyInfo, error) {
+	pub, err := publicKey(key)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	info := KeyInfo{ID: hex.EncodeToString(cryptoutil.HashSha256(der)[:8]), Type: keyType(key)}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		info.Address = cryptoutil.GetAddress(*k)
+	case ed25519.PublicKey:
+		info.Address = cryptoutil.GetEd25519Address(k)
+	default:
+		info.Address = hex.EncodeToString(cryptoutil.HashSha256(der)[:20])
+	}
+	return info, nil
+}
+
+// Save encrypts key with passphrase and writes it to the store, replacing any earlier
+// copy of the same key.
+func (ks *KeyStore) Save(key crypto.PrivateKey, passphrase string) (KeyInfo, error) {
+	info, err := Info(key)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	plaintext, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return KeyInfo{}, err
+	}
+	kdf := scryptParams{Name: "scrypt", N: ks.ScryptN, R: ks.ScryptR, P: ks.ScryptP, Salt: hex.EncodeToString(salt)}
+	aead, err := newAEAD(passphrase, &kdf)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return KeyInfo{}, err
+	}
+	file := keyFile{
+		Version:    fileVersion,
+		KeyInfo:    info,
+		KDF:        kdf,
+		Cipher:     cipherParams{Name: "aes-256-gcm", Nonce: hex.EncodeToString(nonce)},
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(info.ID))),
+	}
+	data, err := json.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated key behind
+	tmp, err := os.CreateTemp(ks.dir, ".tmp-"+info.ID)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return KeyInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return KeyInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), ks.path(info.ID)); err != nil {
+		return KeyInfo{}, err
+	}
+	return info, nil
+}
+
+// Load decrypts the key id with passphrase.
+func (ks *KeyStore) Load(id string, passphrase string) (crypto.PrivateKey, error) {
+	file, err := ks.readFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if file.Version != fileVersion || file.KDF.Name != "scrypt" || file.Cipher.Name != "aes-256-gcm" {
+		return nil, fmt.Errorf("keystore: unsupported key file version %v (%v, %v)", file.Version, file.KDF.Name, file.Cipher.Name)
+	}
+	aead, err := newAEAD(passphrase, &file.KDF)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(file.Cipher.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("keystore: bad nonce in key %v", id)
+	}
+	ciphertext, err := hex.DecodeString(file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: bad ciphertext in key %v", id)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(file.ID))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return x509.ParsePKCS8PrivateKey(plaintext)
+}
+
+// List returns the stored keys sorted by ID.
+func (ks *KeyStore) List() ([]KeyInfo, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []KeyInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		file, err := ks.readFile(strings.TrimSuffix(name, fileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, file.KeyInfo)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
+
+// Delete removes the key id from the store.
+func (ks *KeyStore) Delete(id string) error {
+	err := os.Remove(ks.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// Import stores the private key of a PKCS#1 or PKCS#8 PEM file.
+func (ks *KeyStore) Import(pemData []byte, passphrase string) (KeyInfo, error) {
+	key, err := DecodePEM(pemData)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if _, err := publicKey(key); err != nil {
+		return KeyInfo{}, errors.New("keystore: PEM file holds no private key")
+	}
+	return ks.Save(key, passphrase)
+}
+
+// Export decrypts the key id and encodes it in format.
+func (ks *KeyStore) Export(id string, passphrase string, format Format) ([]byte, error) {
+	key, err := ks.Load(id, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePEM(key, format)
+}
+
+func (ks *KeyStore) path(id string) string {
+	return filepath.Join(ks.dir, filepath.Base(id)+fileSuffix)
+}
+
+func (ks *KeyStore) readFile(id string) (*keyFile, error) {
+	data, err := os.ReadFile(ks.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	file := &keyFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("keystore: key %v: %v", id, err)
+	}
+	return file, nil
+}
+
+// newAEAD derives the AES-256-GCM cipher of passphrase with the scrypt parameters kdf.
+func newAEAD(passphrase string, kdf *scryptParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(kdf.Salt)
+	if err != nil {
+		return nil, errors.New("keystore: bad scrypt salt")
+	}
+	key, err := scryptKey([]byte(passphrase), salt, kdf.N, kdf.R, kdf.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
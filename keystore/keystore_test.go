@@ -0,0 +1,205 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/pem"
+	"reflect"
+	"testing"
+
+	"scrooge/cryptoutil"
+)
+
+// test vectors from RFC 7914 section 12
+func TestScryptVectors(t *testing.T) {
+	cases := []struct {
+		password, salt string
+		N, r, p        int
+		expected       string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, c := range cases {
+		key, err := scryptKey([]byte(c.password), []byte(c.salt), c.N, c.r, c.p, 64)
+		if err != nil {
+			t.Fatalf("scryptKey: %v", err)
+		}
+		if hex.EncodeToString(key) != c.expected {
+			t.Errorf("scrypt(%q, %q, %v, %v, %v)=%x, expected %v", c.password, c.salt, c.N, c.r, c.p, key, c.expected)
+		}
+	}
+	if _, err := scryptKey([]byte("password"), nil, 1000, 8, 1, 32); err == nil {
+		t.Errorf("scryptKey accepted N that is not a power of two")
+	}
+}
+
+func testKeys(t *testing.T) []crypto.PrivateKey {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []crypto.PrivateKey{cryptoutil.GetPrivateKey(), edKey, ecKey}
+}
+
+func testKeyStore(t *testing.T) *KeyStore {
+	ks, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// keep the tests fast, the format is the same
+	ks.ScryptN = 1 << 10
+	return ks
+}
+
+func TestKeyStoreSaveLoad(t *testing.T) {
+	ks := testKeyStore(t)
+	keys := testKeys(t)
+
+	infos := make(map[string]KeyInfo)
+	for _, key := range keys {
+		info, err := ks.Save(key, "correct horse")
+		if err != nil {
+			t.Fatalf("Save %T: %v", key, err)
+		}
+		infos[info.ID] = info
+
+		loaded, err := ks.Load(info.ID, "correct horse")
+		if err != nil {
+			t.Fatalf("Load %v: %v", info.ID, err)
+		}
+		if !reflect.DeepEqual(mustPublic(t, loaded), mustPublic(t, key)) {
+			t.Errorf("key %v (%v) did not round-trip", info.ID, info.Type)
+		}
+		if _, err := ks.Load(info.ID, "wrong horse"); err != ErrWrongPassphrase {
+			t.Errorf("Load with wrong passphrase: err=%v", err)
+		}
+	}
+
+	rsaKey := keys[0].(*rsa.PrivateKey)
+	rsaInfo, _ := Info(rsaKey)
+	if rsaInfo.Type != "rsa" || rsaInfo.Address != cryptoutil.GetAddress(rsaKey.PublicKey) {
+		t.Errorf("RSA key info %+v does not carry its scrooge address", rsaInfo)
+	}
+
+	listed, err := ks.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != len(keys) {
+		t.Fatalf("List returned %v keys, expected %v", len(listed), len(keys))
+	}
+	for _, info := range listed {
+		if infos[info.ID] != info {
+			t.Errorf("listed %+v, saved %+v", info, infos[info.ID])
+		}
+	}
+
+	if err := ks.Delete(listed[0].ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := ks.Load(listed[0].ID, "correct horse"); err != ErrKeyNotFound {
+		t.Errorf("Load after Delete: err=%v", err)
+	}
+	if err := ks.Delete(listed[0].ID); err != ErrKeyNotFound {
+		t.Errorf("second Delete: err=%v", err)
+	}
+}
+
+func TestPEMRoundTrip(t *testing.T) {
+	for _, key := range testKeys(t) {
+		pub := mustPublic(t, key)
+		formats := []Format{PKCS8, PKIX}
+		if _, ok := key.(*rsa.PrivateKey); ok {
+			formats = append(formats, PKCS1)
+		}
+		for _, format := range formats {
+			data, err := EncodePEM(key, format)
+			if err != nil {
+				t.Fatalf("EncodePEM %T format %v: %v", key, format, err)
+			}
+			decoded, err := DecodePEM(data)
+			if err != nil {
+				t.Fatalf("DecodePEM %T format %v: %v", key, format, err)
+			}
+			if format == PKIX {
+				if !reflect.DeepEqual(decoded, pub) {
+					t.Errorf("%T public key did not round-trip through PKIX", key)
+				}
+			} else if !reflect.DeepEqual(mustPublic(t, decoded), pub) {
+				t.Errorf("%T did not round-trip through format %v", key, format)
+			}
+		}
+	}
+
+	// public RSA keys also round-trip through PKCS#1
+	rsaKey := cryptoutil.GetPrivateKey()
+	data, err := EncodePEM(&rsaKey.PublicKey, PKCS1)
+	if err != nil {
+		t.Fatalf("EncodePEM: %v", err)
+	}
+	if block, _ := pem.Decode(data); block.Type != "RSA PUBLIC KEY" {
+		t.Errorf("PKCS#1 public key block type %q", block.Type)
+	}
+	if decoded, err := DecodePEM(data); err != nil || !reflect.DeepEqual(decoded, &rsaKey.PublicKey) {
+		t.Errorf("RSA public key did not round-trip through PKCS#1: %v", err)
+	}
+
+	// blocks written by cryptoutil
+	if decoded, err := DecodePEM(cryptoutil.GetPEMPublicKey(rsaKey.PublicKey)); err != nil || !reflect.DeepEqual(decoded, &rsaKey.PublicKey) {
+		t.Errorf("cryptoutil public key did not decode: %v", err)
+	}
+	if decoded, err := DecodePEM(cryptoutil.GetPEMPrivateKey(rsaKey)); err != nil || !reflect.DeepEqual(mustPublic(t, decoded), &rsaKey.PublicKey) {
+		t.Errorf("cryptoutil private key did not decode: %v", err)
+	}
+
+	if _, err := EncodePEM(testKeys(t)[1], PKCS1); err == nil {
+		t.Errorf("PKCS#1 encoded an Ed25519 key")
+	}
+}
+
+func TestKeyStoreImportExport(t *testing.T) {
+	ks := testKeyStore(t)
+	for _, key := range testKeys(t) {
+		for _, format := range []Format{PKCS1, PKCS8} {
+			data, err := EncodePEM(key, format)
+			if err != nil {
+				continue
+			}
+			info, err := ks.Import(data, "pass")
+			if err != nil {
+				t.Fatalf("Import %T: %v", key, err)
+			}
+			exported, err := ks.Export(info.ID, "pass", format)
+			if err != nil {
+				t.Fatalf("Export %v: %v", info.ID, err)
+			}
+			decoded, err := DecodePEM(exported)
+			if err != nil || !reflect.DeepEqual(mustPublic(t, decoded), mustPublic(t, key)) {
+				t.Errorf("%T did not survive import and export: %v", key, err)
+			}
+		}
+		public, _ := EncodePEM(key, PKIX)
+		if _, err := ks.Import(public, "pass"); err == nil {
+			t.Errorf("Import accepted a public key")
+		}
+	}
+}
+
+func mustPublic(t *testing.T, key interface{}) crypto.PublicKey {
+	t.Helper()
+	pub, err := publicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
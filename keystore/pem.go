@@ -0,0 +1,130 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Format is a standard PEM key encoding.
+type Format int
+
+const (
+	// PKCS1 encodes RSA keys only, as "RSA PRIVATE KEY" or "RSA PUBLIC KEY".
+	PKCS1 Format = iota
+	// PKCS8 encodes any private key as "PRIVATE KEY".
+	PKCS8
+	// PKIX encodes any public key as "PUBLIC KEY". Private keys export their public half.
+	PKIX
+)
+
+const (
+	pkcs1PrivateType = "RSA PRIVATE KEY"
+	pkcs1PublicType  = "RSA PUBLIC KEY"
+	pkcs8Type        = "PRIVATE KEY"
+	pkixType         = "PUBLIC KEY"
+)
+
+// EncodePEM encodes an RSA, Ed25519 or ECDSA key, private or public, in format.
+func EncodePEM(key interface{}, format Format) ([]byte, error) {
+	block := &pem.Block{}
+	var err error
+	switch format {
+	case PKCS1:
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			block.Type, block.Bytes = pkcs1PrivateType, x509.MarshalPKCS1PrivateKey(k)
+		case *rsa.PublicKey:
+			block.Type, block.Bytes = pkcs1PublicType, x509.MarshalPKCS1PublicKey(k)
+		default:
+			return nil, fmt.Errorf("keystore: PKCS#1 cannot encode %T", key)
+		}
+	case PKCS8:
+		block.Type = pkcs8Type
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	case PKIX:
+		if signer, ok := key.(crypto.Signer); ok {
+			key = signer.Public()
+		}
+		block.Type = pkixType
+		block.Bytes, err = x509.MarshalPKIXPublicKey(key)
+	default:
+		return nil, fmt.Errorf("keystore: unknown format %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// DecodePEM decodes the first PEM block of data according to its type. Private keys are
+// returned as *rsa.PrivateKey, ed25519.PrivateKey or *ecdsa.PrivateKey, public keys as
+// *rsa.PublicKey, ed25519.PublicKey or *ecdsa.PublicKey.
+//
+// PKCS#1 blocks mislabelled "PRIVATE KEY" or "PUBLIC KEY", as written by older versions
+// of cryptoutil and by cryptoutil.GetPEMPublicKey, are accepted as well.
+func DecodePEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("keystore: no PEM block found")
+	}
+	if procType, ok := block.Headers["Proc-Type"]; ok {
+		return nil, fmt.Errorf("keystore: legacy PEM encryption %v is not supported", procType)
+	}
+	switch block.Type {
+	case pkcs1PrivateType:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case pkcs1PublicType:
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case pkcs8Type:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes); rsaErr == nil {
+				return rsaKey, nil
+			}
+			return nil, err
+		}
+		return key, nil
+	case pkixType:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			if rsaKey, rsaErr := x509.ParsePKCS1PublicKey(block.Bytes); rsaErr == nil {
+				return rsaKey, nil
+			}
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("keystore: unsupported PEM block type %q", block.Type)
+}
+
+// publicKey returns the public half of a supported private key.
+func publicKey(key crypto.PrivateKey) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey, nil
+	case ed25519.PrivateKey:
+		return k.Public(), nil
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey, nil
+	}
+	return nil, fmt.Errorf("keystore: unsupported key type %T", key)
+}
+
+// keyType names the algorithm of a supported private key.
+func keyType(key crypto.PrivateKey) string {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa"
+	case ed25519.PrivateKey:
+		return "ed25519"
+	case *ecdsa.PrivateKey:
+		return "ecdsa"
+	}
+	return "unknown"
+}
@@ -0,0 +1,99 @@
+package keystore
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// scryptKey derives a keyLen bytes key from password and salt with scrypt (RFC 7914).
+// N is the CPU/memory cost and must be a power of two greater than one, r the block
+// size and p the parallelization.
+func scryptKey(password []byte, salt []byte, N int, r int, p int, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, errors.New("keystore: scrypt N must be a power of two greater than 1")
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 || r > (1<<31-1)/128/p || N > (1<<31-1)/128/r {
+		return nil, errors.New("keystore: scrypt parameters are too large")
+	}
+
+	blockLen := 128 * r
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*blockLen)
+	if err != nil {
+		return nil, err
+	}
+	x := make([]uint32, 32*r)
+	v := make([]uint32, 32*r*N)
+	y := make([]uint32, 32*r)
+	for i := 0; i < p; i++ {
+		block := b[i*blockLen : (i+1)*blockLen]
+		for j := range x {
+			x[j] = binary.LittleEndian.Uint32(block[j*4:])
+		}
+		roMix(x, v, y, N, r)
+		for j := range x {
+			binary.LittleEndian.PutUint32(block[j*4:], x[j])
+		}
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+// roMix mixes the 32*r words of x in place, using v and y as scratch space.
+func roMix(x []uint32, v []uint32, y []uint32, N int, r int) {
+	words := 32 * r
+	for i := 0; i < N; i++ {
+		copy(v[i*words:], x)
+		blockMix(x, y, r)
+	}
+	for i := 0; i < N; i++ {
+		// integerify: the first word of the last 64 bytes block, as N fits in 32 bits
+		j := int(x[(2*r-1)*16] & uint32(N-1))
+		for k, w := range v[j*words : (j+1)*words] {
+			x[k] ^= w
+		}
+		blockMix(x, y, r)
+	}
+}
+
+// blockMix applies the scrypt BlockMix to the 2*r salsa blocks of b, using y as scratch.
+func blockMix(b []uint32, y []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for k := range t {
+			t[k] ^= b[i*16+k]
+		}
+		salsa208(&t)
+		// even blocks go to the first half of the output, odd blocks to the second
+		copy(y[((i&1)*r+i/2)*16:], t[:])
+	}
+	copy(b, y)
+}
+
+// salsa208 applies the Salsa20/8 core to the 16 words of b.
+func salsa208(b *[16]uint32) {
+	x := *b
+	quarter := func(a, b, c, d int) {
+		x[b] ^= bits.RotateLeft32(x[a]+x[d], 7)
+		x[c] ^= bits.RotateLeft32(x[b]+x[a], 9)
+		x[d] ^= bits.RotateLeft32(x[c]+x[b], 13)
+		x[a] ^= bits.RotateLeft32(x[d]+x[c], 18)
+	}
+	for i := 0; i < 8; i += 2 {
+		// columns
+		quarter(0, 4, 8, 12)
+		quarter(5, 9, 13, 1)
+		quarter(10, 14, 2, 6)
+		quarter(15, 3, 7, 11)
+		// rows
+		quarter(0, 1, 2, 3)
+		quarter(5, 6, 7, 4)
+		quarter(10, 11, 8, 9)
+		quarter(15, 12, 13, 14)
+	}
+	for i := range b {
+		b[i] += x[i]
+	}
+}
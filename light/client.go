@@ -0,0 +1,90 @@
+// Package light confirms payments without the UTXOPool. A light client keeps only the
+// epoch headers signed by the committee, and checks the transactions a full node hands
+// it against them through Merkle inclusion proofs.
+package light
+
+import (
+	"bytes"
+	"errors"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+var (
+	ErrUnknownTx    = errors.New("light: transaction in no epoch of the full node")
+	ErrBadProof     = errors.New("light: transaction not proven to be in the epoch")
+	ErrUnknownEpoch = errors.New("light: transaction proven in an epoch past the header chain")
+)
+
+// Server is what a light client needs of a full node.
+type Server interface {
+	// Headers returns the signed headers from epoch from on.
+	Headers(from int) ([]scrooge.SignedHeader, error)
+	// GetProof returns the transaction of hash and its inclusion proof.
+	GetProof(hash []byte) (*TxProof, error)
+}
+
+// Confirmation is a transaction proven to be accepted in Epoch, with the number of
+// epochs, that one included, whose headers the client holds since.
+type Confirmation struct {
+	Tx            *scrooge.Transaction
+	Epoch         int
+	Confirmations int
+}
+
+// Client is a light client trusting the committee it starts with and whoever that
+// committee hands over to in the signed headers.
+type Client struct {
+	server Server
+	chain  *scrooge.HeaderChain
+}
+
+// NewClient returns a light client asking server, trusting committee to sign epoch 0.
+func NewClient(server Server, committee *scrooge.Committee) *Client {
+	return &Client{server: server, chain: scrooge.NewHeaderChain(committee)}
+}
+
+// Sync appends the headers past the tip that the server has. It stops at the first
+// header that does not extend the chain or is not signed by the committee in charge.
+func (client *Client) Sync() error {
+	headers, err := client.server.Headers(client.chain.Height())
+	if err != nil {
+		return err
+	}
+	for _, signed := range headers {
+		if err := client.chain.Append(signed.Header, signed.Signatures); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Height returns the number of headers the client holds.
+func (client *Client) Height() int {
+	return client.chain.Height()
+}
+
+// Verify asks the server for the transaction of hash and checks that it is accepted in
+// an epoch of the header chain, syncing first.
+func (client *Client) Verify(hash []byte) (*Confirmation, error) {
+	if err := client.Sync(); err != nil {
+		return nil, err
+	}
+	answer, err := client.server.GetProof(hash)
+	if err != nil {
+		return nil, err
+	}
+	tx := answer.Tx
+	if tx == nil || !bytes.Equal(tx.Hash, hash) || !bytes.Equal(cryptoutil.HashSha256(tx.GetRawTx()), hash) {
+		return nil, scrooge.ErrTxBadHash
+	}
+	signed := client.chain.Header(answer.Epoch)
+	if signed == nil {
+		return nil, ErrUnknownEpoch
+	}
+	if answer.Proof == nil || !answer.Proof.Verify(hash, signed.Header.TxRoot) {
+		return nil, ErrBadProof
+	}
+	return &Confirmation{Tx: tx, Epoch: answer.Epoch, Confirmations: client.chain.Height() - answer.Epoch}, nil
+}
@@ -0,0 +1,116 @@
+package light
+
+import (
+	"crypto/ed25519"
+	"sync"
+
+	"scrooge"
+)
+
+// TxProof is the answer of a full node on a transaction: the transaction itself, the
+// epoch that accepted it, and its inclusion proof under the TxRoot of that epoch.
+type TxProof struct {
+	Tx    *scrooge.Transaction
+	Epoch int
+	Proof *scrooge.MerkleProof
+}
+
+// FullNode holds the whole UTXOPool, runs the epochs and signs their headers with the
+// keys of the committee in charge, and serves the headers and transaction proofs that
+// light clients ask for.
+type FullNode struct {
+	Handler *scrooge.TxHandler
+
+	mu    sync.Mutex
+	keys  []ed25519.PrivateKey
+	chain *scrooge.HeaderChain
+	// next is the committee the next header hands over to, signing with nextKeys.
+	next     *scrooge.Committee
+	nextKeys []ed25519.PrivateKey
+	// hashes holds the hashes of the transactions accepted in each epoch, in order.
+	hashes [][][]byte
+	txs    map[string]*scrooge.Transaction
+	epochs map[string]int
+}
+
+// NewFullNode returns a full node running the epochs on pool, signing with keys, which
+// must be enough of committee to meet its threshold.
+func NewFullNode(pool *scrooge.UTXOPool, committee *scrooge.Committee, keys ...ed25519.PrivateKey) *FullNode {
+	return &FullNode{
+		Handler: scrooge.NewTxHandler(pool),
+		keys:    keys,
+		chain:   scrooge.NewHeaderChain(committee),
+		txs:     make(map[string]*scrooge.Transaction),
+		epochs:  make(map[string]int),
+	}
+}
+
+// Rotate has the next header hand the committee over to next, which signs with keys
+// from the header after it on.
+func (node *FullNode) Rotate(next *scrooge.Committee, keys ...ed25519.PrivateKey) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.next, node.nextKeys = next, keys
+}
+
+// RunEpoch handles txs as an epoch, and signs and records its header. It returns the
+// accepted transactions. If the header cannot be recorded, as when the keys do not meet
+// the threshold of the committee, the handler is left as it was.
+func (node *FullNode) RunEpoch(txs []*scrooge.Transaction) ([]*scrooge.Transaction, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	// the epoch is tried on a copy of the pool, and only handled by node.Handler, which
+	// records its history, fees and metrics, once its header is appended. HandleTxs
+	// accepts the same transactions from the same pool.
+	trial := scrooge.NewTxHandler(node.Handler.Pool.Copy())
+	trial.MaxEpochBytes, trial.MaxEpochTxs, trial.Policy = node.Handler.MaxEpochBytes, node.Handler.MaxEpochTxs, node.Handler.Policy
+	accepted := trial.HandleTxs(txs)
+	header := scrooge.NewEpochHeader(node.chain.Tip(), trial.Pool, accepted, node.next)
+	sigs := make([]scrooge.HeaderSignature, len(node.keys))
+	for idx, key := range node.keys {
+		sigs[idx] = scrooge.SignHeader(header, key)
+	}
+	if err := node.chain.Append(header, sigs); err != nil {
+		return nil, err
+	}
+	node.Handler.HandleTxs(txs)
+	if node.next != nil {
+		node.keys, node.next, node.nextKeys = node.nextKeys, nil, nil
+	}
+	hashes := make([][]byte, len(accepted))
+	for idx, tx := range accepted {
+		hashes[idx] = tx.Hash
+		node.txs[string(tx.Hash)] = tx
+		node.epochs[string(tx.Hash)] = header.Epoch
+	}
+	node.hashes = append(node.hashes, hashes)
+	return accepted, nil
+}
+
+// Headers returns the signed headers from epoch from on.
+func (node *FullNode) Headers(from int) ([]scrooge.SignedHeader, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	var headers []scrooge.SignedHeader
+	for epoch := max(from, 0); epoch < node.chain.Height(); epoch++ {
+		headers = append(headers, *node.chain.Header(epoch))
+	}
+	return headers, nil
+}
+
+// GetProof returns the transaction of hash with the proof of its inclusion in the
+// epoch that accepted it.
+func (node *FullNode) GetProof(hash []byte) (*TxProof, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	epoch, ok := node.epochs[string(hash)]
+	if !ok {
+		return nil, ErrUnknownTx
+	}
+	hashes := node.hashes[epoch]
+	idx := 0
+	for string(hashes[idx]) != string(hash) {
+		idx++
+	}
+	return &TxProof{Tx: node.txs[string(hash)], Epoch: epoch, Proof: scrooge.NewMerkleProof(hashes, idx)}, nil
+}
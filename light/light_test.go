@@ -0,0 +1,203 @@
+package light
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"testing"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/internal/scroogetest"
+)
+
+// committee returns a committee of n fresh keys signing with threshold of them, and
+// its private keys.
+func committee(t *testing.T, threshold, n int) (*scrooge.Committee, []ed25519.PrivateKey) {
+	t.Helper()
+	keys := make([]ed25519.PublicKey, n)
+	privates := make([]ed25519.PrivateKey, n)
+	for idx := range keys {
+		public, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[idx], privates[idx] = public, private
+	}
+	c, err := scrooge.NewCommittee(threshold, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, privates
+}
+
+// fullNode returns a full node whose pool is the scroogetest pool of count outputs
+// owned by key, signing with the first threshold keys of a committee of four.
+func fullNode(t *testing.T, key *rsa.PrivateKey, count int) (*FullNode, *scrooge.Committee) {
+	t.Helper()
+	c, keys := committee(t, 3, 4)
+	return NewFullNode(scroogetest.Pool(key, count), c, keys[:3]...), c
+}
+
+func runEpoch(t *testing.T, node *FullNode, txs ...*scrooge.Transaction) {
+	t.Helper()
+	accepted, err := node.RunEpoch(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != len(txs) {
+		t.Fatalf("%v of %v transactions accepted", len(accepted), len(txs))
+	}
+}
+
+func TestPaymentConfirmations(t *testing.T) {
+	key, merchant := cryptoutil.GetPrivateKey(), cryptoutil.GetPrivateKey()
+	node, trusted := fullNode(t, key, 5)
+	client := NewClient(node, trusted)
+
+	payment := scroogetest.Spend(key, 2, merchant.PublicKey, 9)
+	runEpoch(t, node, scroogetest.Spend(key, 0, key.PublicKey, 9))
+	if _, err := client.Verify(payment.Hash); err != ErrUnknownTx {
+		t.Fatalf("payment before its epoch: %v", err)
+	}
+	runEpoch(t, node, scroogetest.Spend(key, 1, key.PublicKey, 9), payment, scroogetest.Spend(key, 3, key.PublicKey, 9))
+	confirmation, err := client.Verify(payment.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmation.Epoch != 1 || confirmation.Confirmations != 1 || confirmation.Tx.Outputs[0].Value != 9 {
+		t.Fatalf("confirmation: %+v", confirmation)
+	}
+
+	// the committee rotates, the client following it through the headers
+	next, nextKeys := committee(t, 2, 3)
+	node.Rotate(next, nextKeys[1:]...)
+	for epoch := 0; epoch < 3; epoch++ {
+		runEpoch(t, node)
+	}
+	if confirmation, err = client.Verify(payment.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if confirmation.Confirmations != 4 || client.Height() != 5 {
+		t.Fatalf("%v confirmations at height %v", confirmation.Confirmations, client.Height())
+	}
+	if !client.chain.Committee().Keys[0].Equal(next.Keys[0]) {
+		t.Fatalf("client did not follow the rotation")
+	}
+}
+
+func TestRunEpochWithoutThreshold(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	node, trusted := fullNode(t, key, 2)
+	node.Handler.History = scrooge.NewTxHistory()
+	node.Handler.Fees = scrooge.NewFeeEstimator()
+	client := NewClient(node, trusted)
+	next, nextKeys := committee(t, 2, 3)
+	node.Rotate(next, nextKeys[0])
+	runEpoch(t, node, scroogetest.Spend(key, 0, key.PublicKey, 9))
+
+	// the next committee signs with one key of the two it needs
+	tx := scroogetest.Spend(key, 1, key.PublicKey, 9)
+	txs := []*scrooge.Transaction{scroogetest.Spend(key, 5, key.PublicKey, 9), tx}
+	if _, err := node.RunEpoch(txs); err != scrooge.ErrHeaderThreshold {
+		t.Fatalf("epoch signed below the threshold: %v", err)
+	}
+	if node.Handler.Pool.Epoch != 1 || !node.Handler.Pool.Contains(scroogetest.Out(1)) {
+		t.Fatalf("pool changed by an epoch whose header was not appended")
+	}
+	if node.Handler.History.GetTx(tx.Hash) != nil || len(node.Handler.Fees.History()) != 1 {
+		t.Fatalf("history or fees kept the failed epoch")
+	}
+	if _, err := client.Verify(tx.Hash); err != ErrUnknownTx {
+		t.Fatalf("transaction of the failed epoch: %v", err)
+	}
+
+	// with enough keys, the same proposal makes the next epoch
+	node.keys = nextKeys[:2]
+	if accepted, err := node.RunEpoch(txs); err != nil || len(accepted) != 1 || accepted[0] != tx {
+		t.Fatalf("retried epoch accepted %v: %v", accepted, err)
+	}
+	if _, err := client.Verify(tx.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Handler.Fees.History()) != 2 {
+		t.Fatalf("fees recorded for %v epochs, want 2", len(node.Handler.Fees.History()))
+	}
+	if client.Height() != 2 || node.Handler.Pool.Epoch != 2 {
+		t.Fatalf("client at height %v, pool at epoch %v", client.Height(), node.Handler.Pool.Epoch)
+	}
+}
+
+// lying serves the headers and proofs of a full node, altered by its functions.
+type lying struct {
+	node    *FullNode
+	headers func(headers []scrooge.SignedHeader)
+	proof   func(proof *TxProof)
+}
+
+func (server *lying) Headers(from int) ([]scrooge.SignedHeader, error) {
+	headers, err := server.node.Headers(from)
+	if err == nil && server.headers != nil {
+		server.headers(headers)
+	}
+	return headers, err
+}
+
+func (server *lying) GetProof(hash []byte) (*TxProof, error) {
+	proof, err := server.node.GetProof(hash)
+	if err == nil && server.proof != nil {
+		copied := *proof
+		proof = &copied
+		server.proof(proof)
+	}
+	return proof, err
+}
+
+func TestLyingFullNode(t *testing.T) {
+	key, merchant := cryptoutil.GetPrivateKey(), cryptoutil.GetPrivateKey()
+	node, trusted := fullNode(t, key, 3)
+	payment, other := scroogetest.Spend(key, 0, merchant.PublicKey, 9), scroogetest.Spend(key, 1, key.PublicKey, 9)
+	runEpoch(t, node, payment, other)
+	runEpoch(t, node, scroogetest.Spend(key, 2, key.PublicKey, 9))
+	_, outsiders := committee(t, 3, 3)
+
+	for _, c := range []struct {
+		what    string
+		headers func(headers []scrooge.SignedHeader)
+		proof   func(proof *TxProof)
+		err     error
+	}{
+		{"honest", nil, nil, nil},
+		{"other transaction", nil, func(proof *TxProof) { proof.Tx = other }, scrooge.ErrTxBadHash},
+		{"altered transaction", nil, func(proof *TxProof) {
+			altered := *proof.Tx
+			altered.Outputs = []scrooge.TOutput{{Value: 90, Address: merchant.PublicKey}}
+			proof.Tx = &altered
+		}, scrooge.ErrTxBadHash},
+		{"other epoch", nil, func(proof *TxProof) { proof.Epoch = 1 }, ErrBadProof},
+		{"future epoch", nil, func(proof *TxProof) { proof.Epoch = 2 }, ErrUnknownEpoch},
+		{"other index", nil, func(proof *TxProof) {
+			moved := *proof.Proof
+			moved.Index = 1
+			proof.Proof = &moved
+		}, ErrBadProof},
+		{"no proof", nil, func(proof *TxProof) { proof.Proof = nil }, ErrBadProof},
+		{"unsigned header", func(headers []scrooge.SignedHeader) {
+			header := *headers[0].Header
+			header.TxRoot = scrooge.MerkleRoot([][]byte{payment.Hash})
+			headers[0].Header = &header
+		}, nil, scrooge.ErrHeaderSignature},
+		{"outsider header", func(headers []scrooge.SignedHeader) {
+			header := *headers[0].Header
+			header.TxRoot = scrooge.MerkleRoot([][]byte{payment.Hash})
+			headers[0] = scrooge.SignedHeader{Header: &header}
+			for _, key := range outsiders {
+				headers[0].Signatures = append(headers[0].Signatures, scrooge.SignHeader(&header, key))
+			}
+		}, nil, scrooge.ErrHeaderUnknownKey},
+	} {
+		client := NewClient(&lying{node: node, headers: c.headers, proof: c.proof}, trusted)
+		if _, err := client.Verify(payment.Hash); err != c.err {
+			t.Fatalf("%v: %v, expected %v", c.what, err, c.err)
+		}
+	}
+}
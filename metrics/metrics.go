@@ -0,0 +1,151 @@
+// Package metrics collects the measurements of a scrooge.TxHandler and its UTXOPool and
+// exports them in the Prometheus text exposition format.
+//
+// A Registry is a scrooge.Metrics: set it as the Metrics of a handler and with
+// UTXOPool.SetMetrics, then serve it with its Handler or write it with WriteText.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"scrooge"
+)
+
+// ContentType is the media type of the text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry accumulates the measurements it receives. It is safe for concurrent use.
+type Registry struct {
+	mu           sync.Mutex
+	epochs       int
+	epochSeconds float64
+	accepted     int
+	deferred     int
+	fees         float64
+	rejected     map[string]int
+	sigHits      int
+	sigMisses    int
+	utxos        int
+	value        float64
+}
+
+var _ scrooge.Metrics = (*Registry)(nil)
+
+func New() *Registry {
+	return &Registry{rejected: make(map[string]int)}
+}
+
+func (r *Registry) EpochHandled(duration time.Duration, accepted, deferred int, fees float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.epochs++
+	r.epochSeconds += duration.Seconds()
+	r.accepted += accepted
+	r.deferred += deferred
+	r.fees += fees
+}
+
+func (r *Registry) TxRejected(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rejected[reason]++
+}
+
+func (r *Registry) SignatureChecked(cacheHit bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cacheHit {
+		r.sigHits++
+	} else {
+		r.sigMisses++
+	}
+}
+
+func (r *Registry) PoolChanged(utxos int, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.utxos = utxos
+	r.value = value
+}
+
+// metric is one family of the text format.
+type metric struct {
+	name, kind, help string
+	samples          []sample
+}
+
+type sample struct {
+	suffix string
+	labels string
+	value  float64
+}
+
+func (r *Registry) snapshot() []metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reasons := make([]string, 0, len(r.rejected))
+	for reason := range r.rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	rejected := make([]sample, 0, len(reasons))
+	for _, reason := range reasons {
+		rejected = append(rejected, sample{labels: fmt.Sprintf(`{reason="%v"}`, escape(reason)), value: float64(r.rejected[reason])})
+	}
+
+	return []metric{
+		{"scrooge_epoch_duration_seconds", "summary", "Time spent in HandleTxs.", []sample{
+			{suffix: "_sum", value: r.epochSeconds},
+			{suffix: "_count", value: float64(r.epochs)},
+		}},
+		{"scrooge_txs_accepted_total", "counter", "Transactions accepted by HandleTxs.", []sample{{value: float64(r.accepted)}}},
+		{"scrooge_txs_deferred_total", "counter", "Transactions left out of an epoch by its budget.", []sample{{value: float64(r.deferred)}}},
+		{"scrooge_txs_rejected_total", "counter", "Transactions found invalid or non-standard, by reason.", rejected},
+		{"scrooge_fees_collected_total", "counter", "Fees paid by accepted transactions.", []sample{{value: r.fees}}},
+		{"scrooge_signature_checks_total", "counter", "Signature checks, by whether the signature cache had them.", []sample{
+			{labels: `{cache="hit"}`, value: float64(r.sigHits)},
+			{labels: `{cache="miss"}`, value: float64(r.sigMisses)},
+		}},
+		{"scrooge_utxos", "gauge", "Unspent outputs in the pool.", []sample{{value: float64(r.utxos)}}},
+		{"scrooge_utxo_value", "gauge", "Total value of the unspent outputs in the pool.", []sample{{value: r.value}}},
+	}
+}
+
+// escape escapes a label value for the text format.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// WriteText writes the metrics of r to w in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	for _, m := range r.snapshot() {
+		if _, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, s := range m.samples {
+			if _, err := fmt.Fprintf(w, "%v%v%v %v\n", m.name, s.suffix, s.labels, scrooge.FormatValue(s.value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler returns an HTTP handler serving the metrics of r in the text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "metrics: method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+func TestWriteText(t *testing.T) {
+	r := New()
+	r.EpochHandled(1500*time.Millisecond, 3, 1, 0.25)
+	r.EpochHandled(500*time.Millisecond, 2, 0, 0.5)
+	r.TxRejected("invalid spend")
+	r.TxRejected("invalid spend")
+	r.TxRejected(`odd "reason"`)
+	r.SignatureChecked(true)
+	r.SignatureChecked(false)
+	r.SignatureChecked(false)
+	r.PoolChanged(7, 12.5)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE scrooge_epoch_duration_seconds summary",
+		"scrooge_epoch_duration_seconds_sum 2",
+		"scrooge_epoch_duration_seconds_count 2",
+		"# TYPE scrooge_txs_accepted_total counter",
+		"scrooge_txs_accepted_total 5",
+		"scrooge_txs_deferred_total 1",
+		`scrooge_txs_rejected_total{reason="invalid spend"} 2`,
+		`scrooge_txs_rejected_total{reason="odd \"reason\""} 1`,
+		"scrooge_fees_collected_total 0.75",
+		`scrooge_signature_checks_total{cache="hit"} 1`,
+		`scrooge_signature_checks_total{cache="miss"} 2`,
+		"# TYPE scrooge_utxos gauge",
+		"scrooge_utxos 7",
+		"scrooge_utxo_value 12.5",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("no line %q in:\n%v", line, text)
+		}
+	}
+}
+
+func TestHandlerAndTxHandler(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 10, Address: key.PublicKey})
+	r := New()
+	handler := scrooge.NewTxHandler(pool)
+	handler.Metrics = r
+	pool.SetMetrics(r)
+
+	tx := scrooge.NewTransaction()
+	tx.AddInput([]byte("txhash#1"), 0)
+	tx.AddOutput(9, key.PublicKey)
+	sig, err := cryptoutil.RSASign(key, tx.GetRawDataToSign(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.AddSignature(sig, 0)
+	tx.Finalize()
+	handler.HandleTxs([]*scrooge.Transaction{tx})
+
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ContentType {
+		t.Fatalf("status %v, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{"scrooge_txs_accepted_total 1", "scrooge_fees_collected_total 1", "scrooge_utxos 1", "scrooge_utxo_value 9"} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("no line %q in:\n%v", line, buf.String())
+		}
+	}
+
+	resp, err = http.Post(srv.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: status %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,127 @@
+package netsim
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/internal/scroogetest"
+)
+
+type run struct {
+	net   *Network
+	nodes []*Node
+	// during is the epoch of each node just before the partition heals.
+	during []int
+}
+
+// simulate runs six nodes on a ring with a chord for ten seconds, node 0 being Scrooge
+// for the first eight, while txs are submitted one by one around the ring. The ring is
+// cut in two halves from the first to the fourth second.
+func simulate(seed int64, key *rsa.PrivateKey, txs []*scrooge.Transaction) *run {
+	pool := scroogetest.Pool(key, len(txs))
+	r := &run{net: New(seed)}
+	r.net.Loss = 0.05
+	for len(r.nodes) < 6 {
+		r.nodes = append(r.nodes, NewNode(r.net, pool.Copy()))
+	}
+	for idx, node := range r.nodes {
+		Connect(node, r.nodes[(idx+1)%len(r.nodes)])
+	}
+	Connect(r.nodes[1], r.nodes[4])
+	stop := r.nodes[0].RunScrooge(500 * time.Millisecond)
+	r.net.After(8*time.Second, stop)
+
+	for idx, tx := range txs {
+		node, tx := r.nodes[idx%len(r.nodes)], tx
+		r.net.After(time.Duration(idx)*300*time.Millisecond, func() { node.Submit(tx) })
+	}
+	r.net.After(time.Second, func() { r.net.Partition([]int{0, 1, 2}, []int{3, 4, 5}) })
+	r.net.After(4*time.Second, func() {
+		for _, node := range r.nodes {
+			r.during = append(r.during, node.Epoch())
+		}
+		r.net.Heal()
+	})
+	r.net.RunFor(10 * time.Second)
+	return r
+}
+
+func TestPoolsConvergeAfterPartition(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	txs := scroogetest.Spends(key, 12)
+	r := simulate(1, key, txs)
+
+	for idx := 3; idx < 6; idx++ {
+		if r.during[idx] >= r.during[0] {
+			t.Fatalf("node %v cut off from Scrooge reached epoch %v, Scrooge %v", idx, r.during[idx], r.during[0])
+		}
+	}
+	stats := r.net.Stats()
+	if stats.Cut == 0 || stats.Lost == 0 {
+		t.Fatalf("no message cut or lost: %+v", stats)
+	}
+
+	leader := r.nodes[0]
+	for _, node := range r.nodes {
+		if node.Epoch() != leader.Epoch() || !bytes.Equal(node.Handler.Pool.Commitment(), leader.Handler.Pool.Commitment()) {
+			t.Fatalf("node %v at epoch %v, Scrooge at %v: pools differ", node.ID, node.Epoch(), leader.Epoch())
+		}
+	}
+	for idx, tx := range txs {
+		if !leader.Handler.Pool.Contains(scrooge.UTXO{TxHash: string(tx.Hash), Index: 0}) {
+			t.Fatalf("transaction %v submitted to node %v never confirmed", idx, idx%len(r.nodes))
+		}
+	}
+}
+
+func TestSeedReplaysRun(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	txs := scroogetest.Spends(key, 6)
+	first := simulate(7, key, txs)
+	second := simulate(7, key, txs)
+	if !bytes.Equal(first.net.Trace(), second.net.Trace()) || first.net.Stats() != second.net.Stats() {
+		t.Fatalf("same seed, different runs: %+v and %+v", first.net.Stats(), second.net.Stats())
+	}
+	for idx := range first.nodes {
+		if first.during[idx] != second.during[idx] ||
+			!bytes.Equal(first.nodes[idx].Handler.Pool.Commitment(), second.nodes[idx].Handler.Pool.Commitment()) {
+			t.Fatalf("same seed, node %v ended differently", idx)
+		}
+	}
+	if other := simulate(8, key, txs); bytes.Equal(first.net.Trace(), other.net.Trace()) {
+		t.Fatalf("another seed replayed the same run")
+	}
+}
+
+func TestNetworkLatencyAndPartition(t *testing.T) {
+	net := New(1)
+	net.MinLatency, net.MaxLatency = 20*time.Millisecond, 20*time.Millisecond
+	var got []any
+	recorder := handlerFunc(func(from int, msg any) { got = append(got, msg) })
+	a, b, c := net.Join(recorder), net.Join(recorder), net.Join(recorder)
+
+	net.Send(a, b, "first")
+	net.RunFor(19 * time.Millisecond)
+	if len(got) != 0 {
+		t.Fatalf("message delivered before its latency")
+	}
+	net.RunFor(time.Millisecond)
+	net.Partition([]int{a})
+	net.Send(a, b, "cut")
+	net.Send(b, c, "same side")
+	net.RunFor(time.Second)
+	if len(got) != 2 || got[0] != "first" || got[1] != "same side" || net.Now() != time.Second+20*time.Millisecond {
+		t.Fatalf("delivered %v at %v", got, net.Now())
+	}
+	if stats := net.Stats(); stats != (Stats{Sent: 3, Cut: 1, Delivered: 2}) {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+type handlerFunc func(from int, msg any)
+
+func (f handlerFunc) Receive(from int, msg any) { f(from, msg) }
@@ -0,0 +1,174 @@
+// Package netsim simulates a network of scrooge nodes in a single goroutine, in
+// virtual time, so that multi-node runs need no sockets and no waiting.
+//
+// Messages are delivered after a random latency, may be lost, and are dropped between
+// nodes on different sides of a partition. Every random choice is drawn from one
+// seeded source and events at the same virtual time run in the order they were
+// scheduled, so that a seed replays a run exactly.
+package netsim
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/rand"
+	"time"
+)
+
+// Handler receives the messages delivered to a node.
+type Handler interface {
+	Receive(from int, msg any)
+}
+
+type event struct {
+	at  time.Duration
+	seq uint64
+	run func()
+}
+
+// eventQueue is a min-heap of events by time, ties broken by scheduling order.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// Stats counts the messages of a network. A sent message is lost, cut by a partition
+// or delivered; those still in flight are in none of the three.
+type Stats struct {
+	Sent      int
+	Lost      int
+	Cut       int
+	Delivered int
+}
+
+// Network is a simulated network. Time only passes in RunFor.
+type Network struct {
+	// MinLatency and MaxLatency bound the delay of each message, drawn uniformly.
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// Loss is the probability that a message is lost.
+	Loss float64
+
+	rng   *rand.Rand
+	now   time.Duration
+	queue eventQueue
+	seq   uint64
+	nodes []Handler
+	// side holds the side of the partition each node is on, all 0 when there is none.
+	side  []int
+	stats Stats
+	trace hash.Hash
+}
+
+// New returns an empty network drawing its random choices from seed, with latencies
+// between 10 and 100 milliseconds and no loss.
+func New(seed int64) *Network {
+	return &Network{
+		MinLatency: 10 * time.Millisecond,
+		MaxLatency: 100 * time.Millisecond,
+		rng:        rand.New(rand.NewSource(seed)),
+		trace:      sha256.New(),
+	}
+}
+
+// Join adds node to the network and returns its address.
+func (net *Network) Join(node Handler) int {
+	net.nodes = append(net.nodes, node)
+	net.side = append(net.side, 0)
+	return len(net.nodes) - 1
+}
+
+// Now returns the virtual time elapsed since the network was created.
+func (net *Network) Now() time.Duration {
+	return net.now
+}
+
+// Rand returns the random source of the network, for the nodes to draw from so that
+// their choices are replayed with the network.
+func (net *Network) Rand() *rand.Rand {
+	return net.rng
+}
+
+// After runs f once d has elapsed.
+func (net *Network) After(d time.Duration, f func()) {
+	net.seq++
+	heap.Push(&net.queue, &event{at: net.now + d, seq: net.seq, run: f})
+}
+
+// Send sends msg from node from to node to. Unless it is lost, it is delivered after
+// a random latency if the two nodes are then on the same side of any partition.
+func (net *Network) Send(from, to int, msg any) {
+	net.stats.Sent++
+	if net.rng.Float64() < net.Loss {
+		net.stats.Lost++
+		return
+	}
+	latency := net.MinLatency
+	if spread := net.MaxLatency - net.MinLatency; spread > 0 {
+		latency += time.Duration(net.rng.Int63n(int64(spread) + 1))
+	}
+	net.After(latency, func() {
+		if net.side[from] != net.side[to] {
+			net.stats.Cut++
+			return
+		}
+		net.stats.Delivered++
+		binary.Write(net.trace, binary.BigEndian, []int64{int64(net.now), int64(from), int64(to)})
+		fmt.Fprintf(net.trace, "%T", msg)
+		net.nodes[to].Receive(from, msg)
+	})
+}
+
+// Partition splits the network into sides that cannot reach each other: one for each
+// of sides, and one for the nodes in none of them.
+func (net *Network) Partition(sides ...[]int) {
+	net.Heal()
+	for idx, nodes := range sides {
+		for _, node := range nodes {
+			net.side[node] = idx + 1
+		}
+	}
+}
+
+// Heal ends the partition of the network.
+func (net *Network) Heal() {
+	for node := range net.side {
+		net.side[node] = 0
+	}
+}
+
+// RunFor runs the events due in the next d of virtual time.
+func (net *Network) RunFor(d time.Duration) {
+	end := net.now + d
+	for len(net.queue) > 0 && net.queue[0].at <= end {
+		next := heap.Pop(&net.queue).(*event)
+		net.now = next.at
+		next.run()
+	}
+	net.now = end
+}
+
+func (net *Network) Stats() Stats {
+	return net.stats
+}
+
+// Trace returns a digest of the deliveries so far: their times, ends and message
+// types. Two runs with the same trace delivered the same messages at the same times.
+func (net *Network) Trace() []byte {
+	return net.trace.Sum(nil)
+}
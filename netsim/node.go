@@ -0,0 +1,178 @@
+package netsim
+
+import (
+	"time"
+
+	"scrooge"
+)
+
+// DefaultStatusInterval is the default of Node.StatusInterval.
+const DefaultStatusInterval = time.Second
+
+// TxMsg relays a transaction.
+type TxMsg struct {
+	Tx *scrooge.Transaction
+}
+
+// EpochMsg carries the transactions Scrooge accepted in an epoch, in order.
+type EpochMsg struct {
+	Epoch int
+	Txs   []*scrooge.Transaction
+}
+
+// StatusMsg tells a peer how many epochs the sender has applied.
+type StatusMsg struct {
+	Epoch int
+}
+
+// GetEpochsMsg asks a peer for the epochs it has applied from From on.
+type GetEpochsMsg struct {
+	From int
+}
+
+// Node is a scrooge node of a simulated network, holding the pool of a TxHandler and
+// a Mempool. Nodes flood the transactions they hear to their peers. One of them is
+// Scrooge: it runs the epochs and floods their accepted transactions, which the others
+// apply in order with HandleTxs, so that all pools go through the same states.
+//
+// Every StatusInterval a node sends its peers its epoch and the candidates of its
+// mempool. A peer ahead of it is asked for the epochs it lacks, so that nodes cut off
+// by a partition or losses catch up, and transactions lost on the way to Scrooge are
+// eventually heard of.
+type Node struct {
+	ID      int
+	Handler *scrooge.TxHandler
+	Mempool *scrooge.Mempool
+	// StatusInterval is the period of the status messages, see Node.
+	StatusInterval time.Duration
+
+	net   *Network
+	peers []int
+	// seen holds the hashes of the transactions already relayed.
+	seen map[string]bool
+	// epochs holds the transactions of the epochs applied, from epoch base on.
+	base    int
+	epochs  [][]*scrooge.Transaction
+	pending map[int][]*scrooge.Transaction
+}
+
+// NewNode adds a node to net with pool as its UTXO pool. It starts sending its status
+// once StatusInterval has elapsed.
+func NewNode(net *Network, pool *scrooge.UTXOPool) *Node {
+	handler := scrooge.NewTxHandler(pool)
+	node := &Node{
+		Handler:        handler,
+		Mempool:        scrooge.NewMempool(handler, 0),
+		StatusInterval: DefaultStatusInterval,
+		net:            net,
+		seen:           make(map[string]bool),
+		base:           pool.Epoch,
+		pending:        make(map[int][]*scrooge.Transaction),
+	}
+	node.ID = net.Join(node)
+	net.After(node.StatusInterval, node.sendStatus)
+	return node
+}
+
+// Connect makes a and b peers of each other.
+func Connect(a, b *Node) {
+	a.peers = append(a.peers, b.ID)
+	b.peers = append(b.peers, a.ID)
+}
+
+// Epoch returns the number of epochs applied to the pool of node.
+func (node *Node) Epoch() int {
+	return node.Handler.Pool.Epoch
+}
+
+// Submit adds tx to the mempool of node and relays it to its peers.
+func (node *Node) Submit(tx *scrooge.Transaction) error {
+	if err := node.Mempool.Add(tx); err != nil {
+		return err
+	}
+	node.seen[string(tx.Hash)] = true
+	node.broadcast(-1, TxMsg{Tx: tx})
+	return nil
+}
+
+// RunScrooge makes node Scrooge, running an epoch every interval until stop is called.
+func (node *Node) RunScrooge(interval time.Duration) (stop func()) {
+	stopped := false
+	var tick func()
+	tick = func() {
+		if stopped {
+			return
+		}
+		accepted, _ := node.Mempool.RunEpoch()
+		node.epochs = append(node.epochs, accepted)
+		node.broadcast(-1, EpochMsg{Epoch: node.Epoch() - 1, Txs: accepted})
+		node.net.After(interval, tick)
+	}
+	node.net.After(interval, tick)
+	return func() { stopped = true }
+}
+
+// broadcast sends msg to the peers of node but except.
+func (node *Node) broadcast(except int, msg any) {
+	for _, peer := range node.peers {
+		if peer != except {
+			node.net.Send(node.ID, peer, msg)
+		}
+	}
+}
+
+func (node *Node) sendStatus() {
+	node.broadcast(-1, StatusMsg{Epoch: node.Epoch()})
+	for _, tx := range node.Mempool.Candidates() {
+		node.broadcast(-1, TxMsg{Tx: tx})
+	}
+	node.net.After(node.StatusInterval, node.sendStatus)
+}
+
+func (node *Node) Receive(from int, msg any) {
+	switch msg := msg.(type) {
+	case TxMsg:
+		if node.seen[string(msg.Tx.Hash)] {
+			return
+		}
+		node.seen[string(msg.Tx.Hash)] = true
+		if node.Mempool.Add(msg.Tx) == nil {
+			node.broadcast(from, msg)
+		}
+	case EpochMsg:
+		if _, ok := node.pending[msg.Epoch]; ok || msg.Epoch < node.Epoch() {
+			return
+		}
+		node.pending[msg.Epoch] = msg.Txs
+		node.broadcast(from, msg)
+		node.applyPending()
+		if msg.Epoch > node.Epoch() {
+			node.net.Send(node.ID, from, GetEpochsMsg{From: node.Epoch()})
+		}
+	case StatusMsg:
+		if msg.Epoch > node.Epoch() {
+			node.net.Send(node.ID, from, GetEpochsMsg{From: node.Epoch()})
+		}
+	case GetEpochsMsg:
+		for epoch := max(msg.From, node.base); epoch < node.Epoch(); epoch++ {
+			node.net.Send(node.ID, from, EpochMsg{Epoch: epoch, Txs: node.epochs[epoch-node.base]})
+		}
+	}
+}
+
+// applyPending applies the pending epochs that follow the last one applied.
+func (node *Node) applyPending() {
+	for {
+		txs, ok := node.pending[node.Epoch()]
+		if !ok {
+			return
+		}
+		delete(node.pending, node.Epoch())
+		accepted := node.Handler.HandleTxs(txs)
+		node.Mempool.Confirm(accepted)
+		node.epochs = append(node.epochs, txs)
+		for _, tx := range txs {
+			node.seen[string(tx.Hash)] = true
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"scrooge"
+)
+
+// MaxBodySize bounds the size of a request body.
+const MaxBodySize = 1 << 20
+
+var ErrNotLoopback = errors.New("node: refusing to listen on a non-loopback address")
+
+// route is one API endpoint. Its pattern segments in braces match any value, which
+// the handler reads with PathValue.
+type route struct {
+	method  string
+	pattern []string
+	handle  func(n *Node, w http.ResponseWriter, r *http.Request)
+}
+
+// The routes are matched by hand rather than by ServeMux patterns, which depend on the
+// GODEBUG settings of the importing program when it is built without a module.
+var routes = []route{
+	{"POST", []string{"tx"}, (*Node).handleSubmit},
+	{"GET", []string{"tx", "{hash}"}, (*Node).handleTx},
+	{"GET", []string{"utxo", "{hash}", "{index}"}, (*Node).handleUTXO},
+	{"GET", []string{"address", "{addr}", "balance"}, (*Node).handleBalance},
+	{"GET", []string{"address", "{addr}", "utxos"}, (*Node).handleUTXOs},
+	{"GET", []string{"address", "{addr}", "history"}, (*Node).handleHistory},
+	{"GET", []string{"utxo", "{hash}", "{index}", "spender"}, (*Node).handleSpender},
+	{"POST", []string{"epoch"}, (*Node).handleEpoch},
+	{"GET", []string{"fee", "{target}"}, (*Node).handleFee},
+	{"GET", []string{"metrics"}, (*Node).handleMetrics},
+}
+
+// match tells whether the path segments match the pattern of rt, and if so sets the
+// values of its wildcards on r.
+func (rt *route) match(r *http.Request, segments []string) bool {
+	if len(segments) != len(rt.pattern) {
+		return false
+	}
+	for idx, part := range rt.pattern {
+		if !strings.HasPrefix(part, "{") && part != segments[idx] {
+			return false
+		}
+	}
+	for idx, part := range rt.pattern {
+		if strings.HasPrefix(part, "{") {
+			r.SetPathValue(strings.Trim(part, "{}"), segments[idx])
+		}
+	}
+	return true
+}
+
+// Handler returns the HTTP API of n. Requests from non-loopback addresses are refused.
+func (n *Node) Handler() http.Handler {
+	return loopbackOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		found := false
+		for idx := range routes {
+			if !routes[idx].match(r, segments) {
+				continue
+			}
+			if routes[idx].method == r.Method {
+				routes[idx].handle(n, w, r)
+				return
+			}
+			found = true
+		}
+		if found {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("node: method %v not allowed on %v", r.Method, r.URL.Path))
+			return
+		}
+		writeError(w, http.StatusNotFound, ErrNotFound)
+	}))
+}
+
+// ListenAndServe serves the API of n on addr, which must be a loopback address such as
+// 127.0.0.1:8334 or localhost:8334.
+func (n *Node) ListenAndServe(addr string) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	return http.ListenAndServe(addr, n.Handler())
+}
+
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%w: %q", ErrNotLoopback, addr)
+	}
+	return nil
+}
+
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			writeError(w, http.StatusForbidden, errors.New("node: only loopback clients are served"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (n *Node) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	tx := &scrooge.Transaction{}
+	if err := json.Unmarshal(data, tx); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	hash, err := n.Submit(tx)
+	switch {
+	case errors.Is(err, ErrDuplicate), errors.Is(err, scrooge.ErrTxKnown), errors.Is(err, scrooge.ErrTxConflict),
+		errors.Is(err, scrooge.ErrReplacementFee), errors.Is(err, scrooge.ErrReplacementEvictions):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"hash": hash, "status": string(Pending)})
+	}
+}
+
+func (n *Node) handleTx(w http.ResponseWriter, r *http.Request) {
+	record, err := n.Tx(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+// parseUTXO reads the {hash} and {index} wildcards of a request.
+func parseUTXO(r *http.Request) (scrooge.UTXO, error) {
+	txHash, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil {
+		return scrooge.UTXO{}, fmt.Errorf("node: bad transaction hash: %v", err)
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 {
+		return scrooge.UTXO{}, fmt.Errorf("node: bad output index %q", r.PathValue("index"))
+	}
+	return scrooge.UTXO{TxHash: string(txHash), Index: index}, nil
+}
+
+func (n *Node) handleUTXO(w http.ResponseWriter, r *http.Request) {
+	utxo, err := parseUTXO(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entry, err := n.UTXO(utxo)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+type balanceResponse struct {
+	Address string `json:"address"`
+	Balance string `json:"balance"`
+	UTXOs   int    `json:"utxos"`
+}
+
+func (n *Node) handleBalance(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("addr")
+	entries := n.UTXOsOf(address)
+	writeJSON(w, http.StatusOK, balanceResponse{Address: address, Balance: scrooge.FormatValue(total(entries)), UTXOs: len(entries)})
+}
+
+func (n *Node) handleUTXOs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.UTXOsOf(r.PathValue("addr")))
+}
+
+func (n *Node) handleEpoch(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.RunEpoch())
+}
+
+type feeResponse struct {
+	Target     int     `json:"target"`
+	FeeRate    string  `json:"feeRate"`
+	Confidence float64 `json:"confidence"`
+	Epochs     int     `json:"epochs"`
+}
+
+func (n *Node) handleFee(w http.ResponseWriter, r *http.Request) {
+	target, err := strconv.Atoi(r.PathValue("target"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("node: bad fee target %q", r.PathValue("target")))
+		return
+	}
+	estimate, err := n.EstimateFee(target)
+	switch {
+	case errors.Is(err, scrooge.ErrNoFeeHistory):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusOK, feeResponse{
+			Target:     target,
+			FeeRate:    scrooge.FormatValue(estimate.Rate),
+			Confidence: estimate.Confidence,
+			Epochs:     estimate.Epochs,
+		})
+	}
+}
+
+func (n *Node) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	registry := n.registry
+	n.mu.Unlock()
+	if registry == nil {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+	registry.Handler().ServeHTTP(w, r)
+}
+
+func (n *Node) handleSpender(w http.ResponseWriter, r *http.Request) {
+	utxo, err := parseUTXO(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	spender, err := n.SpentBy(utxo)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, spender)
+}
+
+func (n *Node) handleHistory(w http.ResponseWriter, r *http.Request) {
+	var page [2]int
+	for idx, name := range []string{"offset", "limit"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("node: bad %v %q", name, value))
+			return
+		}
+		page[idx] = number
+	}
+	writeJSON(w, http.StatusOK, n.History(r.PathValue("addr"), page[0], page[1]))
+}
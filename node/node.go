@@ -0,0 +1,340 @@
+// Package node runs a Scrooge instance behind a local HTTP/JSON API.
+//
+// Transactions submitted to a Node wait in its mempool until an epoch hands them to
+// TxHandler.HandleTxs, or they are evicted. The API, served by Handler, is
+//
+//	POST /tx                       queue a transaction, encoded as in scrooge.schema.json
+//	GET  /tx/{hash}                status of a transaction: pending, orphan, replaced, accepted
+//	                               or rejected
+//	GET  /utxo/{hash}/{index}      an unspent output
+//	GET  /address/{addr}/balance   total value owned by an address
+//	GET  /address/{addr}/utxos     unspent outputs owned by an address
+//	GET  /address/{addr}/history   accepted transactions paying or spending from an address,
+//	                               newest first, paginated by ?offset= and ?limit=
+//	GET  /utxo/{hash}/{index}/spender
+//	                               the accepted transaction input that spent an output
+//	POST /epoch                    run HandleTxs on the mempool candidates
+//	GET  /fee/{epochs}             fee rate per byte accepted within that many epochs, with
+//	                               its confidence
+//	GET  /metrics                  metrics in the Prometheus text format, once EnableMetrics
+//	                               is called
+//
+// Hashes are hex and amounts are decimal strings, as in the JSON form of the ledger types.
+// The API is not authenticated, so it is only served on loopback addresses.
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+
+	"scrooge"
+	"scrooge/metrics"
+)
+
+// MempoolExpiry is the number of epochs a transaction may wait in the mempool.
+const MempoolExpiry = 10
+
+// RecordExpiry is the number of epochs the record of a transaction is kept once it left
+// the mempool. Accepted transactions are still found in the history after that.
+const RecordExpiry = 100
+
+var (
+	ErrDuplicate = errors.New("node: transaction already known")
+	ErrNotFound  = errors.New("node: not found")
+)
+
+// Status is the state of a transaction known to a Node.
+type Status string
+
+const (
+	Pending Status = "pending"
+	// Orphan transactions wait in the mempool for a parent.
+	Orphan Status = "orphan"
+	// Replaced transactions were evicted from the mempool by a conflicting one with a
+	// higher fee.
+	Replaced Status = "replaced"
+	Accepted Status = "accepted"
+	Rejected Status = "rejected"
+)
+
+// TxRecord is what a Node knows about a submitted transaction.
+type TxRecord struct {
+	Hash   string               `json:"hash"`
+	Status Status               `json:"status"`
+	Epoch  int                  `json:"epoch"`
+	Tx     *scrooge.Transaction `json:"tx"`
+}
+
+// EpochResult lists the transactions accepted by an epoch and those it evicted from the
+// mempool.
+type EpochResult struct {
+	Epoch    int      `json:"epoch"`
+	Accepted []string `json:"accepted"`
+	Rejected []string `json:"rejected"`
+}
+
+// Entry is an unspent output with its position in the pool.
+type Entry struct {
+	UTXO      scrooge.UTXO    `json:"utxo"`
+	CreatedAt int             `json:"createdAt"`
+	Output    scrooge.TOutput `json:"output"`
+}
+
+// HistoryItem is an accepted transaction with the outputs it spent.
+type HistoryItem struct {
+	Hash  string               `json:"hash"`
+	Epoch int                  `json:"epoch"`
+	Tx    *scrooge.Transaction `json:"tx"`
+	Spent []Entry              `json:"spent"`
+}
+
+// Spender is the input of an accepted transaction that spent an output.
+type Spender struct {
+	Hash  string `json:"hash"`
+	Input int    `json:"input"`
+	Epoch int    `json:"epoch"`
+}
+
+// Node holds a UTXO pool and a mempool of the transactions waiting for an epoch. It is
+// safe for concurrent use.
+type Node struct {
+	// OnEpoch, if set, is called with the result of every epoch once the node is
+	// unlocked again.
+	OnEpoch func(result EpochResult)
+
+	mu      sync.Mutex
+	handler *scrooge.TxHandler
+	mempool *scrooge.Mempool
+	// txs records the transactions submitted, by hex hash, until RecordExpiry epochs
+	// after they left the mempool.
+	txs map[string]*TxRecord
+	// registry is served on GET /metrics if set.
+	registry *metrics.Registry
+}
+
+func New(pool *scrooge.UTXOPool) *Node {
+	handler := scrooge.NewTxHandler(pool)
+	handler.Fees = scrooge.NewFeeEstimator()
+	handler.Policy = scrooge.DefaultPolicy()
+	handler.History = scrooge.NewTxHistory()
+	return &Node{
+		handler: handler,
+		mempool: scrooge.NewMempool(handler, MempoolExpiry),
+		txs:     make(map[string]*TxRecord),
+	}
+}
+
+// Submit adds tx to the mempool and returns its hex hash. It fails with the errors of
+// Mempool.Add, or ErrDuplicate if tx was already accepted. A rejected transaction may be
+// submitted again.
+func (n *Node) Submit(tx *scrooge.Transaction) (string, error) {
+	hash := hex.EncodeToString(tx.Hash)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.handler.History.GetTx(tx.Hash) != nil {
+		return hash, ErrDuplicate
+	}
+	if err := n.mempool.Add(tx); err != nil {
+		return hash, err
+	}
+	n.txs[hash] = &TxRecord{Hash: hash, Status: Pending, Epoch: n.handler.Pool.Epoch, Tx: tx}
+	return hash, nil
+}
+
+// Tx returns the record of the transaction with the given hex hash.
+func (n *Node) Tx(hash string) (TxRecord, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	record, ok := n.txs[hash]
+	if !ok {
+		if txHash, err := hex.DecodeString(hash); err == nil {
+			if entry := n.handler.History.GetTx(txHash); entry != nil {
+				return TxRecord{Hash: hash, Status: Accepted, Epoch: entry.Epoch, Tx: entry.Tx}, nil
+			}
+		}
+		return TxRecord{}, ErrNotFound
+	}
+	result := *record
+	if result.Status == Pending {
+		switch {
+		case n.mempool.Get(result.Tx.Hash) == nil:
+			result.Status = Replaced
+		case n.mempool.IsOrphan(result.Tx.Hash):
+			result.Status = Orphan
+		}
+	}
+	return result, nil
+}
+
+// RunEpoch runs an epoch on the mempool.
+func (n *Node) RunEpoch() EpochResult {
+	result := n.runEpoch()
+	if n.OnEpoch != nil {
+		n.OnEpoch(result)
+	}
+	return result
+}
+
+func (n *Node) runEpoch() EpochResult {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	epoch := n.handler.Pool.Epoch
+	accepted, evicted := n.mempool.RunEpoch()
+	result := EpochResult{Epoch: epoch, Accepted: []string{}, Rejected: []string{}}
+	for _, tx := range accepted {
+		result.Accepted = append(result.Accepted, n.settle(tx, Accepted, epoch))
+	}
+	for _, tx := range evicted {
+		result.Rejected = append(result.Rejected, n.settle(tx, Rejected, epoch))
+	}
+	n.expireRecords(epoch)
+	return result
+}
+
+// expireRecords drops the records of the transactions settled, or replaced in the
+// mempool, RecordExpiry epochs before epoch.
+func (n *Node) expireRecords(epoch int) {
+	for hash, record := range n.txs {
+		if record.Status == Pending && n.mempool.Get(record.Tx.Hash) != nil {
+			continue
+		}
+		if epoch-record.Epoch >= RecordExpiry {
+			delete(n.txs, hash)
+		}
+	}
+}
+
+// settle records the final status of tx and returns its hex hash.
+func (n *Node) settle(tx *scrooge.Transaction, status Status, epoch int) string {
+	hash := hex.EncodeToString(tx.Hash)
+	n.txs[hash].Status = status
+	n.txs[hash].Epoch = epoch
+	return hash
+}
+
+// UTXO returns the unspent output utxo.
+func (n *Node) UTXO(utxo scrooge.UTXO) (Entry, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	pool := n.handler.Pool
+	out := pool.GetTxOutput(utxo)
+	if out == nil {
+		return Entry{}, ErrNotFound
+	}
+	return Entry{UTXO: utxo, CreatedAt: pool.CreatedAt(utxo), Output: *out}, nil
+}
+
+// UTXOsOf returns the unspent outputs owned by address, as given by TOutput.OwnerAddress,
+// sorted by UTXO.
+func (n *Node) UTXOsOf(address string) []Entry {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	pool := n.handler.Pool
+	entries := []Entry{}
+	for _, utxo := range pool.UTXOsByOwner(address) {
+		entries = append(entries, Entry{UTXO: utxo, CreatedAt: pool.CreatedAt(utxo), Output: *pool.GetTxOutput(utxo)})
+	}
+	return entries
+}
+
+// Balance returns the total value owned by address.
+func (n *Node) Balance(address string) float64 {
+	return total(n.UTXOsOf(address))
+}
+
+func total(entries []Entry) float64 {
+	var value float64
+	for _, entry := range entries {
+		value += entry.Output.Value
+	}
+	return value
+}
+
+// WriteSnapshot writes the JSON form of the pool to w.
+func (n *Node) WriteSnapshot(w io.Writer) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	data, err := json.MarshalIndent(n.handler.Pool, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// EstimateFee returns the fee rate that gets a transaction accepted within targetEpochs
+// epochs, see FeeEstimator.EstimateFee.
+func (n *Node) EstimateFee(targetEpochs int) (scrooge.FeeEstimate, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handler.Fees.EstimateFee(targetEpochs)
+}
+
+// WriteFeeHistory writes the JSON form of the fee history of the node to w.
+func (n *Node) WriteFeeHistory(w io.Writer) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	data, err := json.MarshalIndent(n.handler.Fees, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadFeeHistory replaces the fee history of the node with one written by
+// WriteFeeHistory.
+func (n *Node) ReadFeeHistory(r io.Reader) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return json.NewDecoder(r).Decode(n.handler.Fees)
+}
+
+// SetLogger sends the diagnostics of the node's TxHandler to logger, see
+// TxHandler.Logger.
+func (n *Node) SetLogger(logger *slog.Logger) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handler.Logger = logger
+}
+
+// EnableMetrics makes the node report to registry and serve it on GET /metrics.
+func (n *Node) EnableMetrics(registry *metrics.Registry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.registry = registry
+	n.handler.Metrics = registry
+	n.handler.Pool.SetMetrics(registry)
+}
+
+// History returns the accepted transactions paying address or spending from it, newest
+// first, skipping offset of them and returning at most limit, all if limit is 0.
+func (n *Node) History(address string, offset, limit int) []HistoryItem {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	items := []HistoryItem{}
+	for _, entry := range n.handler.History.History(address, offset, limit) {
+		item := HistoryItem{Hash: hex.EncodeToString(entry.Tx.Hash), Epoch: entry.Epoch, Tx: entry.Tx, Spent: []Entry{}}
+		for _, spent := range entry.Spent {
+			item.Spent = append(item.Spent, Entry{UTXO: spent.UTXO, CreatedAt: spent.CreatedAt, Output: spent.Output})
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// SpentBy returns the accepted transaction input that spent utxo.
+func (n *Node) SpentBy(utxo scrooge.UTXO) (Spender, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	spend := n.handler.History.SpentBy(utxo)
+	if spend == nil {
+		return Spender{}, ErrNotFound
+	}
+	return Spender{Hash: hex.EncodeToString(spend.TxHash), Input: spend.Input, Epoch: spend.Epoch}, nil
+}
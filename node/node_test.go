@@ -0,0 +1,364 @@
+package node
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/metrics"
+)
+
+func payment(t *testing.T, from *rsa.PrivateKey, prevTxHash []byte, idx int, to rsa.PublicKey, values ...float64) *scrooge.Transaction {
+	tx := scrooge.NewTransaction()
+	tx.AddInput(prevTxHash, idx)
+	for _, value := range values {
+		tx.AddOutput(value, to)
+	}
+	sig, err := cryptoutil.RSASign(from, tx.GetRawDataToSign(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.AddSignature(sig, 0)
+	tx.Finalize()
+	return tx
+}
+
+// call sends a request to srv and decodes the JSON response into v, returning the status.
+func call(t *testing.T, srv *httptest.Server, method string, path string, body interface{}, v interface{}) int {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%v %v: content type %q", method, path, ct)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPI(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	aliceAddress := cryptoutil.GetAddress(alice.PublicKey)
+	bobAddress := cryptoutil.GetAddress(bob.PublicKey)
+
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 10, Address: alice.PublicKey})
+	srv := httptest.NewServer(New(pool).Handler())
+	defer srv.Close()
+
+	// bob pays the coin alice gives him back to her, the payment arriving first, and
+	// alice tries to spend her coin twice
+	toBob := payment(t, alice, []byte("txhash#1"), 0, bob.PublicKey, 6, 4)
+	toAlice := payment(t, bob, toBob.Hash, 1, alice.PublicKey, 4)
+	doubleSpend := payment(t, alice, []byte("txhash#1"), 0, alice.PublicKey, 10)
+
+	for _, tx := range []*scrooge.Transaction{toAlice, toBob} {
+		var resp map[string]string
+		if status := call(t, srv, "POST", "/tx", tx, &resp); status != http.StatusAccepted {
+			t.Fatalf("POST /tx: status %v: %v", status, resp)
+		}
+		if resp["hash"] != hex.EncodeToString(tx.Hash) || resp["status"] != "pending" {
+			t.Fatalf("POST /tx: %v", resp)
+		}
+		if tx == toAlice {
+			var record TxRecord
+			call(t, srv, "GET", "/tx/"+hex.EncodeToString(toAlice.Hash), nil, &record)
+			if record.Status != Orphan {
+				t.Fatalf("payment ahead of its parent: %+v", record)
+			}
+		}
+	}
+	if status := call(t, srv, "POST", "/tx", doubleSpend, nil); status != http.StatusConflict {
+		t.Fatalf("POST /tx of a double spend: status %v", status)
+	}
+	var record TxRecord
+	if status := call(t, srv, "GET", "/tx/"+hex.EncodeToString(toAlice.Hash), nil, &record); status != http.StatusOK || record.Status != Pending {
+		t.Fatalf("GET /tx: status %v, record %+v", status, record)
+	}
+	if !bytes.Equal(record.Tx.GetRawTx(), toAlice.GetRawTx()) {
+		t.Fatal("GET /tx returned another transaction")
+	}
+
+	var epoch EpochResult
+	if status := call(t, srv, "POST", "/epoch", nil, &epoch); status != http.StatusOK {
+		t.Fatalf("POST /epoch: status %v", status)
+	}
+	expected := EpochResult{
+		Epoch:    0,
+		Accepted: []string{hex.EncodeToString(toBob.Hash), hex.EncodeToString(toAlice.Hash)},
+		Rejected: []string{},
+	}
+	if fmt.Sprint(epoch) != fmt.Sprint(expected) {
+		t.Fatalf("POST /epoch: %+v, expected %+v", epoch, expected)
+	}
+	call(t, srv, "GET", "/tx/"+hex.EncodeToString(toBob.Hash), nil, &record)
+	if record.Status != Accepted || record.Epoch != 0 {
+		t.Fatalf("payment to bob: %+v", record)
+	}
+	if status := call(t, srv, "POST", "/tx", toBob, nil); status != http.StatusConflict {
+		t.Fatalf("POST /tx of an accepted transaction: status %v", status)
+	}
+
+	var entry Entry
+	if status := call(t, srv, "GET", fmt.Sprintf("/utxo/%x/0", toBob.Hash), nil, &entry); status != http.StatusOK {
+		t.Fatalf("GET /utxo: status %v", status)
+	}
+	if entry.Output.Value != 6 || entry.CreatedAt != 0 || entry.Output.OwnerAddress() != bobAddress {
+		t.Fatalf("GET /utxo: %+v", entry)
+	}
+	if status := call(t, srv, "GET", fmt.Sprintf("/utxo/%x/1", toBob.Hash), nil, nil); status != http.StatusNotFound {
+		t.Fatalf("GET /utxo of a spent output: status %v", status)
+	}
+
+	var balance balanceResponse
+	call(t, srv, "GET", "/address/"+aliceAddress+"/balance", nil, &balance)
+	if balance != (balanceResponse{Address: aliceAddress, Balance: "4", UTXOs: 1}) {
+		t.Fatalf("alice's balance: %+v", balance)
+	}
+	var entries []Entry
+	call(t, srv, "GET", "/address/"+bobAddress+"/utxos", nil, &entries)
+	if len(entries) != 1 || entries[0].UTXO != (scrooge.UTXO{TxHash: string(toBob.Hash), Index: 0}) {
+		t.Fatalf("bob's UTXOs: %+v", entries)
+	}
+	call(t, srv, "GET", "/address/nobody/utxos", nil, &entries)
+	if len(entries) != 0 {
+		t.Fatalf("nobody's UTXOs: %+v", entries)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 1, Address: alice.PublicKey})
+	srv := httptest.NewServer(New(pool).Handler())
+	defer srv.Close()
+
+	tx := payment(t, alice, []byte("txhash#1"), 0, alice.PublicKey, 1)
+	tampered := *tx
+	tampered.Outputs = []scrooge.TOutput{{Value: 2, Address: alice.PublicKey}}
+	overspend := payment(t, alice, []byte("txhash#1"), 0, alice.PublicKey, 2)
+
+	cases := []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{"POST", "/tx", "{", http.StatusBadRequest},
+		{"POST", "/tx", `{"inputs":[{"prevTxHash":"xyz","outputIndex":0}],"outputs":[]}`, http.StatusBadRequest},
+		{"POST", "/tx", &tampered, http.StatusBadRequest},
+		{"POST", "/tx", overspend, http.StatusAccepted},
+		{"POST", "/tx", overspend, http.StatusConflict},
+		{"POST", "/tx", tx, http.StatusConflict},
+		{"POST", "/tx", strings.Repeat(" ", MaxBodySize+1), http.StatusRequestEntityTooLarge},
+		{"GET", "/tx/00", nil, http.StatusNotFound},
+		{"GET", "/tx", nil, http.StatusMethodNotAllowed},
+		{"GET", "/blocks", nil, http.StatusNotFound},
+		{"GET", "/utxo/zz/0", nil, http.StatusBadRequest},
+		{"GET", "/utxo/00/-1", nil, http.StatusBadRequest},
+		{"GET", "/utxo/00/0", nil, http.StatusNotFound},
+		{"GET", "/fee/1", nil, http.StatusNotFound},
+		{"GET", "/fee/0", nil, http.StatusBadRequest},
+		{"GET", "/fee/soon", nil, http.StatusBadRequest},
+		{"POST", "/epoch", nil, http.StatusOK},
+		{"GET", "/fee/1", nil, http.StatusOK},
+		// the overspend was rejected, which frees its input
+		{"GET", "/tx/" + hex.EncodeToString(overspend.Hash), nil, http.StatusOK},
+		{"POST", "/tx", tx, http.StatusAccepted},
+	}
+	for _, c := range cases {
+		var resp map[string]interface{}
+		if status := call(t, srv, c.method, c.path, c.body, &resp); status != c.status {
+			t.Errorf("%v %v: status %v, expected %v: %v", c.method, c.path, status, c.status, resp)
+		}
+		if c.status >= 400 && resp["error"] == nil {
+			t.Errorf("%v %v: no error message", c.method, c.path)
+		}
+		if c.path == "/tx/"+hex.EncodeToString(overspend.Hash) && resp["status"] != string(Rejected) {
+			t.Errorf("overspend: %v", resp)
+		}
+	}
+}
+
+func TestFeeHistory(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 10, Address: alice.PublicKey})
+	n := New(pool)
+	tx := payment(t, alice, []byte("txhash#1"), 0, alice.PublicKey, 9)
+	if _, err := n.Submit(tx); err != nil {
+		t.Fatal(err)
+	}
+	n.RunEpoch()
+
+	var buf bytes.Buffer
+	if err := n.WriteFeeHistory(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restarted := New(scrooge.NewUTXOPool())
+	if err := restarted.ReadFeeHistory(&buf); err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := restarted.EstimateFee(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := n.EstimateFee(1)
+	if estimate != expected || estimate.Epochs != 1 {
+		t.Fatalf("estimate after reloading the history: %+v, expected %+v", estimate, expected)
+	}
+}
+
+func TestHistoryAPI(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	aliceAddress := cryptoutil.GetAddress(alice.PublicKey)
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 10, Address: alice.PublicKey})
+	srv := httptest.NewServer(New(pool).Handler())
+	defer srv.Close()
+
+	first := payment(t, alice, []byte("txhash#1"), 0, alice.PublicKey, 10)
+	second := payment(t, alice, first.Hash, 0, bob.PublicKey, 9)
+	for _, tx := range []*scrooge.Transaction{first, second} {
+		call(t, srv, "POST", "/tx", tx, nil)
+		call(t, srv, "POST", "/epoch", nil, nil)
+	}
+
+	var items []HistoryItem
+	if status := call(t, srv, "GET", "/address/"+aliceAddress+"/history", nil, &items); status != http.StatusOK ||
+		len(items) != 2 || items[0].Hash != hex.EncodeToString(second.Hash) || items[1].Epoch != 0 {
+		t.Fatalf("alice's history: status %v, %+v", status, items)
+	}
+	if len(items[0].Spent) != 1 || items[0].Spent[0].Output.Value != 10 || items[0].Spent[0].CreatedAt != 0 {
+		t.Fatalf("output spent by the second payment: %+v", items[0].Spent)
+	}
+	call(t, srv, "GET", "/address/"+aliceAddress+"/history?offset=1&limit=5", nil, &items)
+	if len(items) != 1 || items[0].Hash != hex.EncodeToString(first.Hash) {
+		t.Fatalf("second page of alice's history: %+v", items)
+	}
+	if status := call(t, srv, "GET", "/address/"+aliceAddress+"/history?limit=-1", nil, nil); status != http.StatusBadRequest {
+		t.Fatalf("negative limit: status %v", status)
+	}
+
+	var spender Spender
+	if status := call(t, srv, "GET", fmt.Sprintf("/utxo/%x/0/spender", first.Hash), nil, &spender); status != http.StatusOK ||
+		spender != (Spender{Hash: hex.EncodeToString(second.Hash), Input: 0, Epoch: 1}) {
+		t.Fatalf("spender of the first payment: status %v, %+v", status, spender)
+	}
+	if status := call(t, srv, "GET", fmt.Sprintf("/utxo/%x/0/spender", second.Hash), nil, nil); status != http.StatusNotFound {
+		t.Fatalf("spender of an unspent output: status %v", status)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 1, Address: cryptoutil.GetPrivateKey().PublicKey})
+	n := New(pool)
+	srv := httptest.NewServer(n.Handler())
+	defer srv.Close()
+	if status := call(t, srv, "GET", "/metrics", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("GET /metrics before EnableMetrics: status %v", status)
+	}
+
+	n.EnableMetrics(metrics.New())
+	n.RunEpoch()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(buf.String(), "scrooge_utxos 1\n") ||
+		!strings.Contains(buf.String(), "scrooge_epoch_duration_seconds_count 1\n") {
+		t.Fatalf("GET /metrics: status %v:\n%v", resp.StatusCode, buf.String())
+	}
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	n := New(scrooge.NewUTXOPool())
+	for _, addr := range []string{"0.0.0.0:8334", ":8334", "192.0.2.1:8334", "example.com:8334"} {
+		if err := n.ListenAndServe(addr); !errors.Is(err, ErrNotLoopback) {
+			t.Errorf("ListenAndServe(%q) returned %v", addr, err)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/epoch", nil)
+	req.RemoteAddr = "192.0.2.1:50000"
+	w := httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("request from a remote client: status %v", w.Code)
+	}
+	req.RemoteAddr = "[::1]:50000"
+	w = httptest.NewRecorder()
+	n.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("request from ::1: status %v", w.Code)
+	}
+}
+
+func TestRecordsExpire(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 1, Address: alice.PublicKey})
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 1}, &scrooge.TOutput{Value: 1, Address: alice.PublicKey})
+	n := New(pool)
+
+	tx := payment(t, alice, []byte("txhash#1"), 0, alice.PublicKey, 1)
+	overspend := payment(t, alice, []byte("txhash#1"), 1, alice.PublicKey, 2)
+	for _, tx := range []*scrooge.Transaction{tx, overspend} {
+		if _, err := n.Submit(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for epoch := 0; epoch <= RecordExpiry; epoch++ {
+		n.RunEpoch()
+	}
+
+	if len(n.txs) != 0 {
+		t.Fatalf("%v records kept", len(n.txs))
+	}
+	if _, err := n.Tx(hex.EncodeToString(overspend.Hash)); err != ErrNotFound {
+		t.Fatalf("expired rejected transaction: %v", err)
+	}
+	if record, err := n.Tx(hex.EncodeToString(tx.Hash)); err != nil || record.Status != Accepted || record.Epoch != 0 {
+		t.Fatalf("expired accepted transaction: %+v, %v", record, err)
+	}
+	if _, err := n.Submit(tx); err != ErrDuplicate {
+		t.Fatalf("accepted transaction submitted again: %v", err)
+	}
+}
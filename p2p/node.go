@@ -0,0 +1,809 @@
+// Package p2p lets scrooge nodes on one machine exchange transactions and epochs over TCP.
+//
+// Nodes speak a framed binary protocol, described in wire.go. A connection opens with a
+// handshake, each side sending a Version and answering the other's with a Verack. Nodes
+// then announce the transactions and epochs they learn of with Inv messages, which the
+// peers lacking them answer with a GetData, served with Tx and Epoch messages. A node
+// remembers what each peer has announced or been announced, so that an item is relayed
+// at most once per peer.
+//
+// As in package netsim, one node is Scrooge and runs the epochs, which it signs with its
+// Ed25519 key. The others know its public key, and replay each epoch it signed with
+// HandleTxs, in order, after checking on a copy of their pool that it accepts every
+// transaction of the epoch.
+//
+// Each node is identified by an Ed25519 key drawn when it is created. In the handshake it
+// signs the nonce of the Version of its peer with it, so that no peer can pass for
+// another. A peer breaking the protocol, sending a transaction whose hash does not match
+// or an epoch that Scrooge did not sign or that does not replay gathers misbehavior
+// points. At BanScore it is disconnected and its key banned for BanDuration: as nodes
+// only listen on loopback addresses, their peers all share an IP address.
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+const (
+	DefaultMaxInbound       = 16
+	DefaultMaxOutbound      = 8
+	DefaultBanDuration      = 24 * time.Hour
+	DefaultHandshakeTimeout = 5 * time.Second
+	DefaultRequestTimeout   = 10 * time.Second
+	// BanScore is the misbehavior score at which a peer is banned.
+	BanScore = 100
+	// sendQueue is the number of messages that may wait for a peer. A peer too slow
+	// to drain them is disconnected.
+	sendQueue = 256
+	// maxBans bounds the keys banned at once. Beyond it, the ban ending first is lifted.
+	maxBans = 4096
+)
+
+var (
+	ErrNotLoopback  = errors.New("p2p: refusing to listen on a non-loopback address")
+	ErrTooManyPeers = errors.New("p2p: too many peers")
+	ErrBanned       = errors.New("p2p: peer is banned")
+	ErrSelf         = errors.New("p2p: connected to self")
+	ErrVersion      = errors.New("p2p: unsupported protocol version")
+	ErrHandshake    = errors.New("p2p: unexpected message in handshake")
+	ErrClosed       = errors.New("p2p: node closed")
+	ErrNotScrooge   = errors.New("p2p: node has no key to sign epochs with")
+)
+
+// Node is a scrooge node holding the pool of a TxHandler and a Mempool, connected to
+// its peers over TCP. Its methods are safe for concurrent use.
+type Node struct {
+	// MaxInbound and MaxOutbound bound the connections accepted and dialed.
+	MaxInbound  int
+	MaxOutbound int
+	// BanDuration is how long a misbehaving peer is banned.
+	BanDuration time.Duration
+	// HandshakeTimeout bounds the time a connection may take to complete the handshake.
+	HandshakeTimeout time.Duration
+	// RequestTimeout is how long an item asked for may take to arrive before it is asked
+	// for to another peer that announced it.
+	RequestTimeout time.Duration
+	// Logger, if set, receives the connections and disconnections of peers at debug
+	// level and their misbehaviors at info level.
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	id       ed25519.PrivateKey
+	handler  *scrooge.TxHandler
+	mempool  *scrooge.Mempool
+	listener net.Listener
+	closed   bool
+	quit     chan struct{}
+	retrying bool
+	peers    map[*peer]bool
+	bans     map[string]time.Time
+	// scroogeKey verifies the epochs, key signs them if the node is Scrooge.
+	scroogeKey ed25519.PublicKey
+	key        ed25519.PrivateKey
+	// seen holds the hashes of the transactions already received or submitted.
+	seen map[string]bool
+	// requested maps the keys of the items asked for to their requests.
+	requested map[string]*request
+	// epochs holds the epochs applied, from epoch base on.
+	base    int
+	epochs  []*Epoch
+	pending map[int]pendingEpoch
+	wg      sync.WaitGroup
+}
+
+// pendingEpoch is an epoch received ahead of the ones it follows.
+type pendingEpoch struct {
+	msg  *Epoch
+	from *peer
+}
+
+// request is an item asked for to a peer.
+type request struct {
+	item InvItem
+	peer *peer
+	at   time.Time
+	// asked holds the peers asked for the item so far.
+	asked map[*peer]bool
+}
+
+// NewNode returns a node with pool as its UTXO pool, neither listening nor connected,
+// that applies the epochs signed by scroogeKey.
+func NewNode(pool *scrooge.UTXOPool, scroogeKey ed25519.PublicKey) *Node {
+	_, id, _ := ed25519.GenerateKey(rand.Reader)
+	handler := scrooge.NewTxHandler(pool)
+	return &Node{
+		MaxInbound:       DefaultMaxInbound,
+		MaxOutbound:      DefaultMaxOutbound,
+		BanDuration:      DefaultBanDuration,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		RequestTimeout:   DefaultRequestTimeout,
+		id:               id,
+		scroogeKey:       scroogeKey,
+		handler:          handler,
+		mempool:          scrooge.NewMempool(handler, 0),
+		quit:             make(chan struct{}),
+		peers:            make(map[*peer]bool),
+		bans:             make(map[string]time.Time),
+		seen:             make(map[string]bool),
+		requested:        make(map[string]*request),
+		base:             pool.Epoch,
+		pending:          make(map[int]pendingEpoch),
+	}
+}
+
+// NewScrooge returns a node like NewNode that runs the epochs and signs them with key.
+func NewScrooge(pool *scrooge.UTXOPool, key ed25519.PrivateKey) *Node {
+	n := NewNode(pool, key.Public().(ed25519.PublicKey))
+	n.key = key
+	return n
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (n *Node) logger() *slog.Logger {
+	if n.Logger != nil {
+		return n.Logger
+	}
+	return discardLogger
+}
+
+// Listen accepts peers on addr, which must be a loopback address such as
+// "127.0.0.1:0".
+func (n *Node) Listen(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return ErrNotLoopback
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		listener.Close()
+		return ErrClosed
+	}
+	n.listener = listener
+	n.wg.Add(1)
+	go n.accept(listener)
+	return nil
+}
+
+// Addr returns the address the node listens on, or nil.
+func (n *Node) Addr() net.Addr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listener == nil {
+		return nil
+	}
+	return n.listener.Addr()
+}
+
+func (n *Node) accept(listener net.Listener) {
+	defer n.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		refused := n.closed || n.full(true)
+		if !refused {
+			n.wg.Add(1)
+		}
+		n.mu.Unlock()
+		if refused {
+			n.logger().Debug("refused peer", "addr", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer n.wg.Done()
+			if _, err := n.open(conn, true); err != nil {
+				n.logger().Debug("handshake failed", "addr", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+// Connect dials the node listening on addr and completes the handshake.
+func (n *Node) Connect(addr string) error {
+	n.mu.Lock()
+	full := n.full(false)
+	n.mu.Unlock()
+	if full {
+		return ErrTooManyPeers
+	}
+	conn, err := net.DialTimeout("tcp", addr, n.HandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = n.open(conn, false)
+	return err
+}
+
+// open runs the handshake on conn and starts serving the peer.
+func (n *Node) open(conn net.Conn, inbound bool) (*peer, error) {
+	// a peer failing the handshake has not proven its key yet, so it is not banned
+	version, err := n.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := &peer{
+		node:    n,
+		conn:    conn,
+		key:     version.Key,
+		inbound: inbound,
+		epoch:   version.Epoch,
+		send:    make(chan Message, sendQueue),
+		done:    make(chan struct{}),
+		known:   make(map[string]bool),
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch {
+	case n.closed:
+		err = ErrClosed
+	case n.banned(version.Key):
+		err = ErrBanned
+	case n.full(inbound):
+		err = ErrTooManyPeers
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	n.peers[p] = true
+	n.logger().Debug("peer connected", "addr", conn.RemoteAddr(), "inbound", inbound)
+	n.wg.Add(2)
+	go p.writeLoop()
+	go p.readLoop()
+	if !n.retrying {
+		n.retrying = true
+		n.wg.Add(1)
+		go n.retryLoop(n.RequestTimeout)
+	}
+
+	var items []InvItem
+	for _, tx := range n.mempool.Candidates() {
+		items = append(items, InvItem{Type: InvTx, Hash: tx.Hash})
+	}
+	p.announce(items...)
+	if p.epoch > n.handler.Pool.Epoch {
+		n.request(p, n.missingEpochs(p.epoch))
+	}
+	return p, nil
+}
+
+// handshake exchanges Version and Verack messages on conn and returns the Version of the
+// peer, once it has proven that it holds its key.
+func (n *Node) handshake(conn net.Conn) (*Version, error) {
+	conn.SetDeadline(time.Now().Add(n.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	ours := &Version{Version: ProtocolVersion, Key: n.ID(), Nonce: binary.BigEndian.Uint64(nonce[:]), Epoch: n.Epoch()}
+	if err := WriteMessage(conn, ours); err != nil {
+		return nil, err
+	}
+	msg, err := ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	version, ok := msg.(*Version)
+	switch {
+	case !ok || len(version.Key) != ed25519.PublicKeySize:
+		return nil, ErrHandshake
+	case version.Version != ProtocolVersion:
+		return nil, ErrVersion
+	case version.Key.Equal(ours.Key):
+		return nil, ErrSelf
+	}
+	n.mu.Lock()
+	banned := n.banned(version.Key)
+	n.mu.Unlock()
+	if banned {
+		return nil, ErrBanned
+	}
+	if err := WriteMessage(conn, &Verack{Signature: ed25519.Sign(n.id, handshakeDigest(version.Nonce))}); err != nil {
+		return nil, err
+	}
+	if msg, err = ReadMessage(conn); err != nil {
+		return nil, err
+	}
+	verack, ok := msg.(*Verack)
+	if !ok || !ed25519.Verify(version.Key, handshakeDigest(ours.Nonce), verack.Signature) {
+		return nil, ErrHandshake
+	}
+	return version, nil
+}
+
+// handshakeDigest returns the data a node signs in the Verack answering a Version with
+// the given nonce.
+func handshakeDigest(nonce uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("scrooge p2p handshake"), nonce)
+}
+
+// full tells whether n has as many inbound or outbound peers as it may.
+func (n *Node) full(inbound bool) bool {
+	count := 0
+	for p := range n.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+	if inbound {
+		return count >= n.MaxInbound
+	}
+	return count >= n.MaxOutbound
+}
+
+func (n *Node) banned(key ed25519.PublicKey) bool {
+	until, ok := n.bans[string(key)]
+	if ok && time.Now().After(until) {
+		delete(n.bans, string(key))
+		return false
+	}
+	return ok
+}
+
+// ban bans key for BanDuration. With maxBans keys banned already, the bans that ended
+// are dropped and, if none did, the one ending first.
+func (n *Node) ban(addr net.Addr, key ed25519.PublicKey, reason error) {
+	n.logger().Info("banned peer", "addr", addr, "key", hex.EncodeToString(key), "reason", reason)
+	if len(n.bans) >= maxBans {
+		now := time.Now()
+		first := ""
+		for banned, until := range n.bans {
+			if now.After(until) {
+				delete(n.bans, banned)
+			} else if first == "" || until.Before(n.bans[first]) {
+				first = banned
+			}
+		}
+		if len(n.bans) >= maxBans {
+			delete(n.bans, first)
+		}
+	}
+	n.bans[string(key)] = time.Now().Add(n.BanDuration)
+}
+
+// Banned tells whether the peer identified by key is banned.
+func (n *Node) Banned(key ed25519.PublicKey) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.banned(key)
+}
+
+// ID returns the key that identifies n to its peers.
+func (n *Node) ID() ed25519.PublicKey {
+	return n.id.Public().(ed25519.PublicKey)
+}
+
+// Peers returns the number of connected peers.
+func (n *Node) Peers() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.peers)
+}
+
+// Close disconnects every peer and stops listening.
+func (n *Node) Close() error {
+	n.mu.Lock()
+	if !n.closed {
+		close(n.quit)
+	}
+	n.closed = true
+	var err error
+	if n.listener != nil {
+		err = n.listener.Close()
+	}
+	for p := range n.peers {
+		p.close()
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+	return err
+}
+
+// Epoch returns the number of epochs applied to the pool of n.
+func (n *Node) Epoch() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handler.Pool.Epoch
+}
+
+// Commitment returns the commitment of the pool of n, see UTXOPool.Commitment.
+func (n *Node) Commitment() []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handler.Pool.Commitment()
+}
+
+// Contains tells whether the UTXO pool of n contains utxo.
+func (n *Node) Contains(utxo scrooge.UTXO) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handler.Pool.Contains(utxo)
+}
+
+// Pending tells whether the transaction with the given hash is in the mempool of n.
+func (n *Node) Pending(hash []byte) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.mempool.Get(hash) != nil
+}
+
+// Submit adds tx to the mempool of n and announces it to its peers.
+func (n *Node) Submit(tx *scrooge.Transaction) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.mempool.Add(tx); err != nil {
+		return err
+	}
+	n.seen[string(tx.Hash)] = true
+	n.broadcast(nil, InvItem{Type: InvTx, Hash: tx.Hash})
+	return nil
+}
+
+// RunEpoch runs an epoch on the mempool of n, which must have been returned by
+// NewScrooge, signs it and announces it to the peers. It returns the accepted
+// transactions.
+func (n *Node) RunEpoch() ([]*scrooge.Transaction, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.key == nil {
+		return nil, ErrNotScrooge
+	}
+	accepted, _ := n.mempool.RunEpoch()
+	epoch := n.handler.Pool.Epoch - 1
+	n.epochs = append(n.epochs, &Epoch{Epoch: epoch, Txs: accepted, Signature: ed25519.Sign(n.key, epochDigest(epoch, accepted))})
+	n.broadcast(nil, InvItem{Type: InvEpoch, Epoch: epoch})
+	return accepted, nil
+}
+
+// epochDigest returns the data Scrooge signs for an epoch: the SHA-256 of its number and
+// of the hashes of its transactions.
+func epochDigest(epoch int, txs []*scrooge.Transaction) []byte {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(epoch)))
+	for _, tx := range txs {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(tx.Hash))))
+		h.Write(tx.Hash)
+	}
+	return h.Sum(nil)
+}
+
+// broadcast announces item to the peers but except that do not know of it yet.
+func (n *Node) broadcast(except *peer, item InvItem) {
+	for p := range n.peers {
+		if p != except {
+			p.announce(item)
+		}
+	}
+}
+
+// request asks p for the items not already asked for to a connected peer within
+// RequestTimeout.
+func (n *Node) request(p *peer, items []InvItem) {
+	now := time.Now()
+	var wanted []InvItem
+	for _, item := range items {
+		req, ok := n.requested[item.key()]
+		if ok && n.peers[req.peer] && now.Sub(req.at) < n.RequestTimeout {
+			continue
+		}
+		if !ok {
+			req = &request{item: item, asked: make(map[*peer]bool)}
+			n.requested[item.key()] = req
+		}
+		req.peer, req.at, req.asked[p] = p, now, true
+		wanted = append(wanted, item)
+	}
+	if len(wanted) > 0 {
+		p.queue(&GetData{Items: wanted})
+	}
+}
+
+// retryLoop calls retry every half timeout until n is closed.
+func (n *Node) retryLoop(timeout time.Duration) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.mu.Lock()
+			n.retry()
+			n.mu.Unlock()
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// retry asks for the items not received within RequestTimeout again, each to a peer that
+// announced it and was not asked yet. It forgets the items no such peer has, which are
+// asked for again when announced.
+func (n *Node) retry() {
+	now := time.Now()
+	for key, req := range n.requested {
+		if n.peers[req.peer] && now.Sub(req.at) < n.RequestTimeout {
+			continue
+		}
+		delete(n.requested, key)
+		for p := range n.peers {
+			if !req.asked[p] && p.has(req.item) {
+				n.requested[key] = req
+				n.request(p, []InvItem{req.item})
+				break
+			}
+		}
+	}
+}
+
+// missingEpochs returns the epochs before epoch n has neither applied nor pending, at
+// most MaxInvItems.
+func (n *Node) missingEpochs(epoch int) []InvItem {
+	var items []InvItem
+	for e := n.handler.Pool.Epoch; e < epoch && len(items) < MaxInvItems; e++ {
+		if _, ok := n.pending[e]; !ok {
+			items = append(items, InvItem{Type: InvEpoch, Epoch: e})
+		}
+	}
+	return items
+}
+
+// misbehave adds score to the misbehavior score of p, banning it at BanScore.
+func (n *Node) misbehave(p *peer, score int, reason error) {
+	p.score += score
+	n.logger().Info("peer misbehaved", "addr", p.conn.RemoteAddr(), "score", p.score, "reason", reason)
+	if p.score >= BanScore {
+		n.ban(p.conn.RemoteAddr(), p.key, reason)
+		p.close()
+	}
+}
+
+// handle processes a message of p.
+func (n *Node) handle(p *peer, msg Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch msg := msg.(type) {
+	case *Inv:
+		var wanted []InvItem
+		for _, item := range msg.Items {
+			p.known[item.key()] = true
+			switch item.Type {
+			case InvTx:
+				if !n.seen[string(item.Hash)] {
+					wanted = append(wanted, item)
+				}
+			case InvEpoch:
+				p.epoch = max(p.epoch, item.Epoch+1)
+				wanted = append(wanted, n.missingEpochs(item.Epoch+1)...)
+			}
+		}
+		n.request(p, wanted)
+	case *GetData:
+		for _, item := range msg.Items {
+			p.known[item.key()] = true
+			switch item.Type {
+			case InvTx:
+				if tx := n.mempool.Get(item.Hash); tx != nil {
+					p.queue(&Tx{Tx: tx})
+				}
+			case InvEpoch:
+				if item.Epoch >= n.base && item.Epoch < n.handler.Pool.Epoch {
+					p.queue(n.epochs[item.Epoch-n.base])
+				}
+			}
+		}
+	case *Tx:
+		n.receiveTx(p, msg.Tx)
+	case *Epoch:
+		n.receiveEpoch(p, msg)
+	default:
+		n.misbehave(p, BanScore, ErrHandshake)
+	}
+}
+
+func validHash(tx *scrooge.Transaction) bool {
+	return bytes.Equal(cryptoutil.HashSha256(tx.GetRawTx()), tx.Hash)
+}
+
+func (n *Node) receiveTx(p *peer, tx *scrooge.Transaction) {
+	if !validHash(tx) {
+		n.misbehave(p, BanScore, scrooge.ErrTxBadHash)
+		return
+	}
+	item := InvItem{Type: InvTx, Hash: tx.Hash}
+	p.known[item.key()] = true
+	delete(n.requested, item.key())
+	if n.seen[string(tx.Hash)] {
+		return
+	}
+	n.seen[string(tx.Hash)] = true
+	if err := n.mempool.Add(tx); err != nil {
+		n.logger().Debug("transaction refused", "addr", p.conn.RemoteAddr(), "err", err)
+		return
+	}
+	n.broadcast(p, item)
+}
+
+func (n *Node) receiveEpoch(p *peer, msg *Epoch) {
+	item := InvItem{Type: InvEpoch, Epoch: msg.Epoch}
+	p.known[item.key()] = true
+	p.epoch = max(p.epoch, msg.Epoch+1)
+	delete(n.requested, item.key())
+	if _, ok := n.pending[msg.Epoch]; ok || msg.Epoch < n.handler.Pool.Epoch {
+		return
+	}
+	for _, tx := range msg.Txs {
+		if !validHash(tx) {
+			n.misbehave(p, BanScore, scrooge.ErrTxBadHash)
+			return
+		}
+	}
+	if !ed25519.Verify(n.scroogeKey, epochDigest(msg.Epoch, msg.Txs), msg.Signature) {
+		n.misbehave(p, BanScore, errForgedEpoch)
+		return
+	}
+	n.pending[msg.Epoch] = pendingEpoch{msg: msg, from: p}
+	n.applyPending()
+	if msg.Epoch > n.handler.Pool.Epoch {
+		n.request(p, n.missingEpochs(msg.Epoch))
+	}
+}
+
+var (
+	// errForgedEpoch is the misbehavior of a peer sending an epoch Scrooge did not sign.
+	errForgedEpoch = errors.New("p2p: epoch not signed by Scrooge")
+	// errBadEpoch is the misbehavior of a peer sending an epoch that does not replay.
+	errBadEpoch = errors.New("p2p: epoch rejects some of its transactions")
+)
+
+// applyPending applies the pending epochs that follow the last one applied, announcing
+// each to the peers.
+func (n *Node) applyPending() {
+	for {
+		epoch := n.handler.Pool.Epoch
+		pending, ok := n.pending[epoch]
+		if !ok {
+			return
+		}
+		delete(n.pending, epoch)
+		txs := pending.msg.Txs
+		replay := scrooge.NewTxHandler(n.handler.Pool.Copy())
+		if len(replay.HandleTxs(txs)) != len(txs) {
+			n.misbehave(pending.from, BanScore, errBadEpoch)
+			return
+		}
+		n.handler.Pool = replay.Pool
+		n.mempool.Confirm(txs)
+		n.epochs = append(n.epochs, pending.msg)
+		for _, tx := range txs {
+			n.seen[string(tx.Hash)] = true
+		}
+		n.broadcast(nil, InvItem{Type: InvEpoch, Epoch: epoch})
+	}
+}
+
+// peer is a connection to another node. Its fields but send and done are guarded by
+// the mutex of node.
+type peer struct {
+	node *Node
+	conn net.Conn
+	// key identifies the peer, which proved it holds it in the handshake.
+	key     ed25519.PublicKey
+	inbound bool
+	// epoch is the number of epochs the peer has announced.
+	epoch int
+	// known holds the keys of the items the peer has announced or been announced.
+	known map[string]bool
+	score int
+
+	send      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// announce sends the peer an Inv of the items it does not know of yet.
+func (p *peer) announce(items ...InvItem) {
+	var fresh []InvItem
+	for _, item := range items {
+		if !p.known[item.key()] {
+			p.known[item.key()] = true
+			fresh = append(fresh, item)
+		}
+	}
+	for len(fresh) > 0 {
+		batch := fresh[:min(len(fresh), MaxInvItems)]
+		fresh = fresh[len(batch):]
+		p.queue(&Inv{Items: batch})
+	}
+}
+
+// has tells whether the peer announced item.
+func (p *peer) has(item InvItem) bool {
+	if item.Type == InvEpoch {
+		return item.Epoch < p.epoch
+	}
+	return p.known[item.key()]
+}
+
+// queue sends msg to the peer, disconnecting it if too many messages wait already.
+func (p *peer) queue(msg Message) {
+	select {
+	case p.send <- msg:
+	case <-p.done:
+	default:
+		p.node.logger().Debug("peer too slow", "addr", p.conn.RemoteAddr())
+		p.close()
+	}
+}
+
+// close disconnects the peer. It must be called with the mutex of node held.
+func (p *peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.conn.Close()
+		delete(p.node.peers, p)
+		p.node.logger().Debug("peer disconnected", "addr", p.conn.RemoteAddr())
+	})
+}
+
+func (p *peer) writeLoop() {
+	defer p.node.wg.Done()
+	for {
+		select {
+		case msg := <-p.send:
+			if err := WriteMessage(p.conn, msg); err != nil {
+				p.node.mu.Lock()
+				p.close()
+				p.node.mu.Unlock()
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *peer) readLoop() {
+	defer p.node.wg.Done()
+	for {
+		msg, err := ReadMessage(p.conn)
+		if err != nil {
+			p.node.mu.Lock()
+			if IsProtocolError(err) {
+				p.node.misbehave(p, BanScore, err)
+			} else if !errors.Is(err, io.EOF) {
+				p.node.logger().Debug("read failed", "addr", p.conn.RemoteAddr(), "err", err)
+			}
+			p.close()
+			p.node.mu.Unlock()
+			return
+		}
+		p.node.handle(p, msg)
+	}
+}
@@ -0,0 +1,497 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/internal/scroogetest"
+)
+
+// scroogeKey is the key with which the first node of startNodes signs its epochs.
+var scroogeKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+func newNode(pool *scrooge.UTXOPool) *Node {
+	return NewNode(pool.Copy(), scroogeKey.Public().(ed25519.PublicKey))
+}
+
+// startNodes returns count nodes listening on loopback ports, closed with the test. The
+// first is Scrooge.
+func startNodes(t *testing.T, count int, pool *scrooge.UTXOPool) []*Node {
+	nodes := make([]*Node, count)
+	for idx := range nodes {
+		nodes[idx] = newNode(pool)
+		if idx == 0 {
+			nodes[idx] = NewScrooge(pool.Copy(), scroogeKey)
+		}
+		if err := nodes[idx].Listen("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nodes[idx].Close() })
+	}
+	return nodes
+}
+
+func connect(t *testing.T, from, to *Node) {
+	t.Helper()
+	if err := from.Connect(to.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventually fails the test unless cond holds within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+	}
+}
+
+// dial connects to node, closing the connection with the test.
+func dial(t *testing.T, node *Node) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", node.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// peerKey returns the key of a peer played by a test, drawn from seed.
+func peerKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+// handshake plays the handshake of a peer at epoch 0 identified by key on conn.
+func handshake(t *testing.T, conn net.Conn, key ed25519.PrivateKey) {
+	t.Helper()
+	ours := &Version{Version: ProtocolVersion, Key: key.Public().(ed25519.PublicKey), Nonce: 1}
+	if err := WriteMessage(conn, ours); err != nil {
+		t.Fatal(err)
+	}
+	version, ok := read(t, conn).(*Version)
+	if !ok {
+		t.Fatalf("handshake opened without a Version")
+	}
+	WriteMessage(conn, &Verack{Signature: ed25519.Sign(key, handshakeDigest(version.Nonce))})
+	verack, ok := read(t, conn).(*Verack)
+	if !ok || !ed25519.Verify(version.Key, handshakeDigest(ours.Nonce), verack.Signature) {
+		t.Fatalf("handshake not acknowledged with the key of the node")
+	}
+}
+
+func read(t *testing.T, conn net.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// readUntilEpoch reads the messages on conn up to an Inv announcing an epoch and returns
+// those before it.
+func readUntilEpoch(t *testing.T, conn net.Conn) []Message {
+	t.Helper()
+	var msgs []Message
+	for {
+		msg := read(t, conn)
+		if inv, ok := msg.(*Inv); ok && inv.Items[0].Type == InvEpoch {
+			return msgs
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	edKey, _, _ := ed25519.GenerateKey(nil)
+	txs := scroogetest.Spends(key, 1)
+	tx := txs[0]
+	tx.AddMultisigOutput(1, key.PublicKey, key.PublicKey)
+	tx.AddRevocableOutput(2, key.PublicKey, key.PublicKey, []byte("revocation"), 3)
+	tx.AddEd25519Output(3, edKey)
+	tx.Inputs = append(tx.Inputs, scrooge.TInput{PrevTxHash: []byte("coinbase"), OutputIdx: -1, Preimage: []byte("secret")})
+	tx.Replaceable = true
+	tx.Finalize()
+
+	for _, msg := range []Message{
+		&Version{Version: ProtocolVersion, Key: edKey, Nonce: 42, Epoch: 7},
+		&Verack{},
+		&Verack{Signature: []byte("signature")},
+		&Inv{Items: []InvItem{{Type: InvTx, Hash: tx.Hash}, {Type: InvEpoch, Epoch: 3}}},
+		&GetData{Items: []InvItem{{Type: InvEpoch, Epoch: 0}}},
+		&Tx{Tx: tx},
+		&Epoch{Epoch: 5, Txs: []*scrooge.Transaction{tx, tx}, Signature: []byte("signature")},
+	} {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadMessage(&buf)
+		if err != nil {
+			t.Fatalf("%T: %v", msg, err)
+		}
+		switch got := got.(type) {
+		case *Tx:
+			if !bytes.Equal(got.Tx.GetRawTx(), tx.GetRawTx()) || !validHash(got.Tx) {
+				t.Fatalf("transaction changed on the wire")
+			}
+		case *Epoch:
+			if got.Epoch != 5 || len(got.Txs) != 2 || !bytes.Equal(got.Txs[1].Hash, tx.Hash) || string(got.Signature) != "signature" {
+				t.Fatalf("epoch changed on the wire")
+			}
+		default:
+			if !reflect.DeepEqual(got, msg) {
+				t.Fatalf("sent %+v, received %+v", msg, got)
+			}
+		}
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var frame bytes.Buffer
+	WriteMessage(&frame, &Inv{Items: []InvItem{{Type: InvEpoch, Epoch: 1}}})
+	valid := frame.Bytes()
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	var nan bytes.Buffer
+	tx := scrooge.NewTransaction()
+	tx.AddOutput(math.NaN(), cryptoutil.GetPrivateKey().PublicKey)
+	tx.Finalize()
+	WriteMessage(&nan, &Tx{Tx: tx})
+
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"magic", corrupt(func(b []byte) []byte { b[0] = 'X'; return b }), ErrBadMagic},
+		{"command", corrupt(func(b []byte) []byte { b[4] = 99; return b }), ErrUnknownCommand},
+		{"checksum", corrupt(func(b []byte) []byte { b[len(b)-1]++; return b }), ErrBadChecksum},
+		{"length", corrupt(func(b []byte) []byte { b[5] = 0xff; return b }), ErrTooLarge},
+		{"truncated", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		{"item type", corrupt(func(b []byte) []byte { b[headerSize+4] = 9; return fixChecksum(b) }), ErrMalformed},
+		{"trailing", fixChecksum(append(corrupt(func(b []byte) []byte { b[8]++; return b }), 0)), ErrMalformed},
+		{"NaN value", nan.Bytes(), ErrMalformed},
+	} {
+		if _, err := ReadMessage(bytes.NewReader(tc.frame)); !errors.Is(err, tc.err) {
+			t.Errorf("%v: got %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+// fixChecksum recomputes the checksum of a frame whose payload was altered.
+func fixChecksum(frame []byte) []byte {
+	var fixed bytes.Buffer
+	payload := frame[headerSize:]
+	WriteMessage(&fixed, rawMessage(payload))
+	copy(frame[9:headerSize], fixed.Bytes()[9:headerSize])
+	return frame
+}
+
+// rawMessage is an Inv whose payload is given as is.
+type rawMessage []byte
+
+func (rawMessage) Command() Command           { return CmdInv }
+func (msg rawMessage) encode(w *writer)       { w.Write(msg) }
+func (msg rawMessage) decode(r *reader) error { return nil }
+
+// signEpoch returns the epoch of txs signed with key.
+func signEpoch(key ed25519.PrivateKey, epoch int, txs ...*scrooge.Transaction) *Epoch {
+	return &Epoch{Epoch: epoch, Txs: txs, Signature: ed25519.Sign(key, epochDigest(epoch, txs))}
+}
+
+func TestGossipAndEpochs(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool, txs := scroogetest.Pool(key, 6), scroogetest.Spends(key, 6)
+	nodes := startNodes(t, 4, pool)
+	for idx := 1; idx < len(nodes); idx++ {
+		connect(t, nodes[idx], nodes[idx-1])
+	}
+	leader, last := nodes[0], nodes[len(nodes)-1]
+
+	for epoch, batch := range [][]*scrooge.Transaction{txs[:3], txs[3:]} {
+		for idx, tx := range batch {
+			if err := nodes[len(nodes)-1-idx%2].Submit(tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		eventually(t, "transactions to reach Scrooge", func() bool {
+			for _, tx := range batch {
+				if !leader.Pending(tx.Hash) {
+					return false
+				}
+			}
+			return true
+		})
+		if accepted, err := leader.RunEpoch(); err != nil || len(accepted) != len(batch) {
+			t.Fatalf("epoch %v accepted %v transactions, want %v", epoch, len(accepted), len(batch))
+		}
+		eventually(t, "the epoch to reach every node", func() bool {
+			for _, node := range nodes {
+				if node.Epoch() != epoch+1 || !bytes.Equal(node.Commitment(), leader.Commitment()) {
+					return false
+				}
+			}
+			return true
+		})
+		for _, tx := range batch {
+			if last.Pending(tx.Hash) || !last.Contains(scrooge.UTXO{TxHash: string(tx.Hash)}) {
+				t.Fatalf("transaction not confirmed by the last node")
+			}
+		}
+	}
+
+	late := newNode(pool)
+	t.Cleanup(func() { late.Close() })
+	connect(t, late, last)
+	eventually(t, "a new node to catch up", func() bool {
+		return late.Epoch() == 2 && bytes.Equal(late.Commitment(), leader.Commitment())
+	})
+}
+
+func TestForgedEpochNotApplied(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool, txs := scroogetest.Pool(key, 2), scroogetest.Spends(key, 2)
+	nodes := startNodes(t, 2, pool)
+	leader, follower := nodes[0], nodes[1]
+	connect(t, follower, leader)
+	_, forger, _ := ed25519.GenerateKey(nil)
+
+	// a peer sends the first epoch before Scrooge, with a transaction of its choice
+	conn := dial(t, follower)
+	handshake(t, conn, peerKey(1))
+	WriteMessage(conn, signEpoch(forger, 0, txs[0]))
+	eventually(t, "the forger to be disconnected", func() bool { return follower.Peers() == 1 })
+	if follower.Epoch() != 0 {
+		t.Fatalf("forged epoch applied")
+	}
+
+	if err := leader.Submit(txs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.RunEpoch(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the epoch of Scrooge to be applied", func() bool {
+		return follower.Epoch() == 1 && bytes.Equal(follower.Commitment(), leader.Commitment())
+	})
+	if follower.Contains(scrooge.UTXO{TxHash: string(txs[0].Hash)}) {
+		t.Fatalf("transaction of the forged epoch confirmed")
+	}
+}
+
+func TestInventoryRelayedOncePerPeer(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool, txs := scroogetest.Pool(key, 2), scroogetest.Spends(key, 2)
+	nodes := startNodes(t, 3, pool)
+	connect(t, nodes[1], nodes[0])
+	connect(t, nodes[2], nodes[0])
+	connect(t, nodes[2], nodes[1])
+	leader := nodes[0]
+	conn := dial(t, leader)
+	handshake(t, conn, peerKey(1))
+	eventually(t, "the handshake", func() bool { return leader.Peers() == 3 })
+
+	// the transaction reaches Scrooge from both other nodes, it is announced once
+	if err := nodes[1].Submit(txs[0]); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the transaction to spread", func() bool { return nodes[2].Pending(txs[0].Hash) && leader.Pending(txs[0].Hash) })
+	WriteMessage(conn, &Inv{Items: []InvItem{{Type: InvTx, Hash: txs[0].Hash}}})
+	leader.RunEpoch()
+	msgs := readUntilEpoch(t, conn)
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], &Inv{Items: []InvItem{{Type: InvTx, Hash: txs[0].Hash}}}) {
+		t.Fatalf("got %v messages before the epoch, want an Inv of the transaction: %+v", len(msgs), msgs)
+	}
+
+	// a transaction from the peer is asked for and relayed to the others, not back
+	WriteMessage(conn, &Inv{Items: []InvItem{{Type: InvTx, Hash: txs[1].Hash}}})
+	if msg := read(t, conn); !reflect.DeepEqual(msg, &GetData{Items: []InvItem{{Type: InvTx, Hash: txs[1].Hash}}}) {
+		t.Fatalf("got %+v, want a GetData of the transaction", msg)
+	}
+	WriteMessage(conn, &Tx{Tx: txs[1]})
+	WriteMessage(conn, &Tx{Tx: txs[1]})
+	eventually(t, "the transaction to spread", func() bool { return nodes[1].Pending(txs[1].Hash) && nodes[2].Pending(txs[1].Hash) })
+	leader.RunEpoch()
+	if msgs := readUntilEpoch(t, conn); len(msgs) != 0 {
+		t.Fatalf("transaction echoed to its sender: %+v", msgs)
+	}
+}
+
+func TestUnservedRequestsRetried(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool, txs := scroogetest.Pool(key, 1), scroogetest.Spends(key, 1)
+	nodes := startNodes(t, 2, pool)
+	node, other := nodes[1], nodes[0]
+	node.RequestTimeout = 50 * time.Millisecond
+
+	// a peer announces the transaction first and never serves it
+	conn := dial(t, node)
+	handshake(t, conn, peerKey(1))
+	WriteMessage(conn, &Inv{Items: []InvItem{{Type: InvTx, Hash: txs[0].Hash}}})
+	if msg := read(t, conn); !reflect.DeepEqual(msg, &GetData{Items: []InvItem{{Type: InvTx, Hash: txs[0].Hash}}}) {
+		t.Fatalf("got %+v, want a GetData of the transaction", msg)
+	}
+	if err := other.Submit(txs[0]); err != nil {
+		t.Fatal(err)
+	}
+	connect(t, other, node)
+	eventually(t, "the transaction to be asked for to the other peer", func() bool { return node.Pending(txs[0].Hash) })
+	if node.Peers() != 2 {
+		t.Fatalf("got %v peers, want 2", node.Peers())
+	}
+}
+
+func TestMisbehavingPeersBanned(t *testing.T) {
+	key := cryptoutil.GetPrivateKey()
+	pool, txs := scroogetest.Pool(key, 1), scroogetest.Spends(key, 1)
+	badHash := *txs[0]
+	badHash.Hash = []byte("not the hash")
+	unknown := scrooge.NewTransaction()
+	unknown.AddInput([]byte("nowhere"), 0)
+	unknown.AddOutput(1, key.PublicKey)
+	unknown.Finalize()
+
+	_, forger, _ := ed25519.GenerateKey(nil)
+
+	var garbage bytes.Buffer
+	WriteMessage(&garbage, &Verack{})
+	garbage.Bytes()[len(garbage.Bytes())-1]++
+
+	for idx, tc := range []struct {
+		name string
+		send func(conn net.Conn)
+	}{
+		{"bad checksum", func(conn net.Conn) { conn.Write(garbage.Bytes()) }},
+		{"bad hash", func(conn net.Conn) { WriteMessage(conn, &Tx{Tx: &badHash}) }},
+		{"forged epoch", func(conn net.Conn) {
+			WriteMessage(conn, signEpoch(forger, 0, txs[0]))
+		}},
+		{"bad epoch", func(conn net.Conn) {
+			WriteMessage(conn, signEpoch(scroogeKey, 0, txs[0], unknown))
+		}},
+		{"second version", func(conn net.Conn) { WriteMessage(conn, &Version{Version: ProtocolVersion}) }},
+	} {
+		// every peer dials from 127.0.0.1, banning one must leave the others connected
+		nodes := startNodes(t, 2, pool)
+		node, honest := nodes[0], nodes[1]
+		connect(t, honest, node)
+		peer := peerKey(byte(idx + 2))
+		conn := dial(t, node)
+		handshake(t, conn, peer)
+		tc.send(conn)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, err := ReadMessage(conn); err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.ECONNRESET) {
+					t.Fatalf("%v: connection not closed: %v", tc.name, err)
+				}
+				break
+			}
+		}
+		if !node.Banned(peer.Public().(ed25519.PublicKey)) || node.Banned(honest.ID()) {
+			t.Fatalf("%v: peer not banned", tc.name)
+		}
+		if node.Epoch() != 0 {
+			t.Fatalf("%v: epoch applied", tc.name)
+		}
+		eventually(t, "the misbehaving peer to be disconnected", func() bool { return node.Peers() == 1 })
+		if honest.Peers() != 1 {
+			t.Fatalf("%v: honest peer disconnected", tc.name)
+		}
+
+		// the banned peer gets no Verack, whatever the nonce of its Version
+		conn = dial(t, node)
+		WriteMessage(conn, &Version{Version: ProtocolVersion, Key: peer.Public().(ed25519.PublicKey), Nonce: 99})
+		if msg := read(t, conn); msg.Command() != CmdVersion {
+			t.Fatalf("%v: got command %v, want the Version of the node", tc.name, msg.Command())
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if msg, err := ReadMessage(conn); err == nil {
+			t.Fatalf("%v: banned peer answered with %+v", tc.name, msg)
+		}
+	}
+}
+
+func TestBansBounded(t *testing.T) {
+	pool := scrooge.NewUTXOPool()
+	node := newNode(pool)
+	var last ed25519.PublicKey
+	for idx := 0; idx < maxBans+10; idx++ {
+		last = binary.BigEndian.AppendUint64(make([]byte, ed25519.PublicKeySize-8), uint64(idx))
+		node.ban(nil, last, errBadEpoch)
+	}
+	if len(node.bans) != maxBans || !node.Banned(last) {
+		t.Fatalf("%v keys banned, want %v with the last one", len(node.bans), maxBans)
+	}
+}
+
+func TestHandshakeProvesKey(t *testing.T) {
+	pool := scrooge.NewUTXOPool()
+	nodes := startNodes(t, 2, pool)
+	node, honest := nodes[0], nodes[1]
+
+	// a peer passing for another node cannot sign the nonce of the Version
+	conn := dial(t, node)
+	WriteMessage(conn, &Version{Version: ProtocolVersion, Key: honest.ID(), Nonce: 1})
+	version, ok := read(t, conn).(*Version)
+	if !ok {
+		t.Fatalf("handshake opened without a Version")
+	}
+	WriteMessage(conn, &Verack{Signature: ed25519.Sign(peerKey(1), handshakeDigest(version.Nonce))})
+	read(t, conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if msg, err := ReadMessage(conn); err == nil {
+		t.Fatalf("impostor answered with %+v", msg)
+	}
+	if node.Peers() != 0 || node.Banned(honest.ID()) {
+		t.Fatalf("impostor connected or got the node it passed for banned")
+	}
+	connect(t, honest, node)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	pool := scrooge.NewUTXOPool()
+	nodes := startNodes(t, 4, pool)
+	nodes[0].MaxInbound = 1
+	nodes[3].MaxOutbound = 1
+
+	connect(t, nodes[1], nodes[0])
+	eventually(t, "the handshake", func() bool { return nodes[0].Peers() == 1 })
+	if err := nodes[2].Connect(nodes[0].Addr().String()); err == nil {
+		t.Fatalf("connected beyond MaxInbound")
+	}
+	connect(t, nodes[3], nodes[1])
+	if err := nodes[3].Connect(nodes[2].Addr().String()); !errors.Is(err, ErrTooManyPeers) {
+		t.Fatalf("connected beyond MaxOutbound: %v", err)
+	}
+	if err := nodes[2].Connect(nodes[2].Addr().String()); !errors.Is(err, ErrSelf) {
+		t.Fatalf("connected to self: %v", err)
+	}
+	if err := newNode(pool).Listen("0.0.0.0:0"); !errors.Is(err, ErrNotLoopback) {
+		t.Fatalf("listened on a public address: %v", err)
+	}
+	if nodes[0].Peers() != 1 || nodes[2].Peers() != 0 {
+		t.Fatalf("peers %v and %v, want 1 and 0", nodes[0].Peers(), nodes[2].Peers())
+	}
+	if _, err := nodes[1].RunEpoch(); !errors.Is(err, ErrNotScrooge) {
+		t.Fatalf("ran an epoch without a key: %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"scrooge"
+)
+
+// A frame is the Magic, a command byte, the length of the payload as a big-endian
+// uint32, the first four bytes of the SHA-256 of the payload, and the payload.
+const (
+	headerSize = 13
+	// MaxPayload bounds the payload of a frame.
+	MaxPayload = 1 << 22
+	// MaxInvItems bounds the items of an Inv or GetData message.
+	MaxInvItems = 1000
+	// ProtocolVersion is the version sent in the handshake. Peers must use the same.
+	ProtocolVersion = 1
+)
+
+// Magic starts every frame.
+var Magic = [4]byte{'S', 'C', 'R', 'G'}
+
+var (
+	ErrBadMagic       = errors.New("p2p: bad frame magic")
+	ErrBadChecksum    = errors.New("p2p: bad frame checksum")
+	ErrTooLarge       = errors.New("p2p: frame payload too large")
+	ErrUnknownCommand = errors.New("p2p: unknown command")
+	ErrMalformed      = errors.New("p2p: malformed message")
+)
+
+// Command identifies the type of a message on the wire.
+type Command uint8
+
+const (
+	CmdVersion Command = iota + 1
+	CmdVerack
+	CmdInv
+	CmdGetData
+	CmdTx
+	CmdEpoch
+)
+
+// Message is a message of the protocol.
+type Message interface {
+	Command() Command
+	encode(w *writer)
+	decode(r *reader) error
+}
+
+// Version opens the handshake. Key is the Ed25519 key that identifies the node, Nonce is
+// drawn at random for the connection, and Epoch is the number of epochs the node has
+// applied.
+type Version struct {
+	Version uint32
+	Key     ed25519.PublicKey
+	Nonce   uint64
+	Epoch   int
+}
+
+// Verack acknowledges the Version of the peer and ends the handshake. Signature is the
+// signature of the Nonce of the peer by the Key of the sender, proving that it holds it.
+type Verack struct {
+	Signature []byte
+}
+
+// InvType is the type of an inventory item.
+type InvType uint8
+
+const (
+	InvTx InvType = iota + 1
+	InvEpoch
+)
+
+// InvItem names a transaction by its Hash or an epoch by its number.
+type InvItem struct {
+	Type  InvType
+	Hash  []byte
+	Epoch int
+}
+
+// key returns a string unique to the item, to index inventories.
+func (item InvItem) key() string {
+	if item.Type == InvEpoch {
+		return fmt.Sprintf("epoch:%d", item.Epoch)
+	}
+	return "tx:" + string(item.Hash)
+}
+
+// Inv announces items the sender has.
+type Inv struct {
+	Items []InvItem
+}
+
+// GetData asks for the items of an Inv, answered with a Tx or an Epoch message for each
+// item the peer still has.
+type GetData struct {
+	Items []InvItem
+}
+
+// Tx carries a transaction.
+type Tx struct {
+	Tx *scrooge.Transaction
+}
+
+// Epoch carries the transactions Scrooge accepted in an epoch, in order, and the
+// Ed25519 signature of Scrooge over the number of the epoch and their hashes.
+type Epoch struct {
+	Epoch     int
+	Txs       []*scrooge.Transaction
+	Signature []byte
+}
+
+func (*Version) Command() Command { return CmdVersion }
+func (*Verack) Command() Command  { return CmdVerack }
+func (*Inv) Command() Command     { return CmdInv }
+func (*GetData) Command() Command { return CmdGetData }
+func (*Tx) Command() Command      { return CmdTx }
+func (*Epoch) Command() Command   { return CmdEpoch }
+
+func newMessage(cmd Command) (Message, error) {
+	switch cmd {
+	case CmdVersion:
+		return &Version{}, nil
+	case CmdVerack:
+		return &Verack{}, nil
+	case CmdInv:
+		return &Inv{}, nil
+	case CmdGetData:
+		return &GetData{}, nil
+	case CmdTx:
+		return &Tx{}, nil
+	case CmdEpoch:
+		return &Epoch{}, nil
+	}
+	return nil, ErrUnknownCommand
+}
+
+// WriteMessage writes msg to w in a frame.
+func WriteMessage(w io.Writer, msg Message) error {
+	var payload writer
+	msg.encode(&payload)
+	if payload.Len() > MaxPayload {
+		return ErrTooLarge
+	}
+	checksum := sha256.Sum256(payload.Bytes())
+	frame := make([]byte, headerSize, headerSize+payload.Len())
+	copy(frame, Magic[:])
+	frame[4] = byte(msg.Command())
+	binary.BigEndian.PutUint32(frame[5:], uint32(payload.Len()))
+	copy(frame[9:], checksum[:4])
+	_, err := w.Write(append(frame, payload.Bytes()...))
+	return err
+}
+
+// ReadMessage reads a frame from r and decodes its message. Errors other than those of
+// r are protocol violations by the writer.
+func ReadMessage(r io.Reader) (Message, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], Magic[:]) {
+		return nil, ErrBadMagic
+	}
+	msg, err := newMessage(Command(header[4]))
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[5:])
+	if length > MaxPayload {
+		return nil, ErrTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if checksum := sha256.Sum256(payload); !bytes.Equal(checksum[:4], header[9:]) {
+		return nil, ErrBadChecksum
+	}
+	rd := &reader{data: payload}
+	if err := msg.decode(rd); err != nil {
+		return nil, err
+	}
+	if rd.err == nil && len(rd.data) > 0 {
+		return nil, ErrMalformed
+	}
+	return msg, rd.err
+}
+
+// IsProtocolError tells whether err, returned by ReadMessage, is a violation of the
+// protocol rather than an error of the connection.
+func IsProtocolError(err error) bool {
+	return errors.Is(err, ErrBadMagic) || errors.Is(err, ErrBadChecksum) || errors.Is(err, ErrTooLarge) ||
+		errors.Is(err, ErrUnknownCommand) || errors.Is(err, ErrMalformed)
+}
+
+func (msg *Version) encode(w *writer) {
+	w.uint32(msg.Version)
+	w.bytes(msg.Key)
+	w.uint64(msg.Nonce)
+	w.uint32(uint32(msg.Epoch))
+}
+
+func (msg *Version) decode(r *reader) error {
+	msg.Version = r.uint32()
+	if key := r.bytes(); key != nil {
+		msg.Key = key
+	}
+	msg.Nonce = r.uint64()
+	msg.Epoch = int(r.uint32())
+	return r.err
+}
+
+func (msg *Verack) encode(w *writer)       { w.bytes(msg.Signature) }
+func (msg *Verack) decode(r *reader) error { msg.Signature = r.bytes(); return r.err }
+
+func (msg *Inv) encode(w *writer)           { encodeItems(w, msg.Items) }
+func (msg *Inv) decode(r *reader) error     { msg.Items = decodeItems(r); return r.err }
+func (msg *GetData) encode(w *writer)       { encodeItems(w, msg.Items) }
+func (msg *GetData) decode(r *reader) error { msg.Items = decodeItems(r); return r.err }
+
+func encodeItems(w *writer, items []InvItem) {
+	w.uint32(uint32(len(items)))
+	for _, item := range items {
+		w.uint8(uint8(item.Type))
+		if item.Type == InvEpoch {
+			w.uint32(uint32(item.Epoch))
+		} else {
+			w.bytes(item.Hash)
+		}
+	}
+}
+
+func decodeItems(r *reader) []InvItem {
+	count := r.count(MaxInvItems)
+	items := make([]InvItem, 0, count)
+	for idx := 0; idx < count && r.err == nil; idx++ {
+		item := InvItem{Type: InvType(r.uint8())}
+		switch item.Type {
+		case InvTx:
+			item.Hash = r.bytes()
+		case InvEpoch:
+			item.Epoch = int(r.uint32())
+		default:
+			r.fail()
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (msg *Tx) encode(w *writer) { w.tx(msg.Tx) }
+
+func (msg *Tx) decode(r *reader) error {
+	msg.Tx = r.tx()
+	return r.err
+}
+
+func (msg *Epoch) encode(w *writer) {
+	w.uint32(uint32(msg.Epoch))
+	w.uint32(uint32(len(msg.Txs)))
+	for _, tx := range msg.Txs {
+		w.tx(tx)
+	}
+	w.bytes(msg.Signature)
+}
+
+func (msg *Epoch) decode(r *reader) error {
+	msg.Epoch = int(r.uint32())
+	count := r.count(MaxPayload)
+	msg.Txs = make([]*scrooge.Transaction, 0, min(count, MaxInvItems))
+	for idx := 0; idx < count && r.err == nil; idx++ {
+		msg.Txs = append(msg.Txs, r.tx())
+	}
+	msg.Signature = r.bytes()
+	return r.err
+}
+
+// writer builds a payload. Byte strings are prefixed with their length as a uint32.
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) uint8(v uint8) { w.WriteByte(v) }
+func (w *writer) uint32(v uint32) {
+	w.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+func (w *writer) uint64(v uint64) {
+	w.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+func (w *writer) bytes(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.Write(b)
+}
+
+func (w *writer) rsaKey(key rsa.PublicKey) {
+	if key.N == nil {
+		w.bytes(nil)
+		return
+	}
+	w.bytes(x509.MarshalPKCS1PublicKey(&key))
+}
+
+func (w *writer) tx(tx *scrooge.Transaction) {
+	w.bytes(tx.Hash)
+	w.uint32(uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		w.bytes(in.PrevTxHash)
+		w.uint32(uint32(int32(in.OutputIdx)))
+		w.bytes(in.Signature)
+		w.bytes(in.CoSignature)
+		w.bytes(in.Preimage)
+	}
+	w.uint32(uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		w.uint64(math.Float64bits(out.Value))
+		w.uint8(uint8(out.Type))
+		w.rsaKey(out.Address)
+		w.rsaKey(out.CoSigner)
+		w.bytes(out.RevocationHash)
+		w.uint32(uint32(int32(out.Delay)))
+		w.bytes(out.EdAddress)
+	}
+	replaceable := uint8(0)
+	if tx.Replaceable {
+		replaceable = 1
+	}
+	w.uint8(replaceable)
+}
+
+// reader decodes a payload. After the first failure err is ErrMalformed and every read
+// returns a zero value.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) fail() {
+	r.err = ErrMalformed
+	r.data = nil
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.fail()
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// count reads a number of elements, failing if it exceeds limit.
+func (r *reader) count(limit int) int {
+	n := r.uint32()
+	if uint64(n) > uint64(limit) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *reader) bytes() []byte {
+	n := r.count(len(r.data))
+	if n == 0 {
+		return nil
+	}
+	return append([]byte(nil), r.next(n)...)
+}
+
+func (r *reader) rsaKey() rsa.PublicKey {
+	der := r.bytes()
+	if der == nil || r.err != nil {
+		return rsa.PublicKey{}
+	}
+	key, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		r.fail()
+		return rsa.PublicKey{}
+	}
+	return *key
+}
+
+func (r *reader) tx() *scrooge.Transaction {
+	tx := &scrooge.Transaction{Hash: r.bytes()}
+	inputs := r.count(len(r.data))
+	for idx := 0; idx < inputs && r.err == nil; idx++ {
+		tx.Inputs = append(tx.Inputs, scrooge.TInput{
+			PrevTxHash:  r.bytes(),
+			OutputIdx:   int(int32(r.uint32())),
+			Signature:   r.bytes(),
+			CoSignature: r.bytes(),
+			Preimage:    r.bytes(),
+		})
+	}
+	outputs := r.count(len(r.data))
+	for idx := 0; idx < outputs && r.err == nil; idx++ {
+		tx.Outputs = append(tx.Outputs, scrooge.TOutput{
+			Value:          math.Float64frombits(r.uint64()),
+			Type:           scrooge.OutputType(r.uint8()),
+			Address:        r.rsaKey(),
+			CoSigner:       r.rsaKey(),
+			RevocationHash: r.bytes(),
+			Delay:          int(int32(r.uint32())),
+			EdAddress:      r.bytes(),
+		})
+		if value := tx.Outputs[len(tx.Outputs)-1].Value; math.IsNaN(value) || math.IsInf(value, 0) {
+			r.fail()
+		}
+	}
+	switch r.uint8() {
+	case 0:
+	case 1:
+		tx.Replaceable = true
+	default:
+		r.fail()
+	}
+	return tx
+}
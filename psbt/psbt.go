@@ -0,0 +1,311 @@
+// Package psbt is a portable container for transactions signed by several parties.
+//
+// A Packet carries the unsigned transaction, the output spent by each input and the
+// signatures collected so far, so that it can be passed between processes as JSON. The
+// work is split in roles, each of which may be played by a different party:
+//
+//   - the creator builds the unsigned transaction and wraps it with New,
+//   - updaters fill in the spent outputs with SetSpentOutput or Update,
+//   - signers add their signatures with SignRSA or SignEd25519,
+//   - the combiner merges copies signed by different parties with Combine,
+//   - the finalizer checks every signature and extracts the Transaction with Finalize.
+package psbt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+// Version is the format version written by Encode.
+const Version = 1
+
+var ErrIncomplete = errors.New("psbt: transaction is not fully signed")
+
+// Input is the signing state of one transaction input.
+type Input struct {
+	// Spent is the output claimed by the input.
+	Spent *scrooge.TOutput `json:"spent,omitempty"`
+	// Signatures maps the address of each signer, as given by cryptoutil.GetAddress or
+	// cryptoutil.GetEd25519Address, to its signature.
+	Signatures map[string][]byte `json:"signatures,omitempty"`
+	// Preimage is the revocation secret when spending a PayToRevocable output through
+	// its CoSigner.
+	Preimage []byte `json:"preimage,omitempty"`
+}
+
+type Packet struct {
+	Version int `json:"version"`
+	// Tx is the transaction without any signatures or hash.
+	Tx     scrooge.Transaction `json:"tx"`
+	Inputs []Input             `json:"inputs"`
+	// Meta holds free-form data shared by the parties, such as a description.
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// New wraps tx, stripping its hash and any signatures it already carries.
+func New(tx *scrooge.Transaction) *Packet {
+	p := &Packet{Version: Version, Meta: make(map[string]string)}
+	p.Tx.Inputs = make([]scrooge.TInput, 0, tx.NumInputs())
+	for _, in := range tx.Inputs {
+		p.Tx.Inputs = append(p.Tx.Inputs, scrooge.TInput{PrevTxHash: in.PrevTxHash, OutputIdx: in.OutputIdx})
+	}
+	p.Tx.Outputs = append([]scrooge.TOutput(nil), tx.Outputs...)
+	p.Tx.Replaceable = tx.Replaceable
+	p.Inputs = make([]Input, tx.NumInputs())
+	return p
+}
+
+// Decode reads a packet written by Encode.
+func Decode(data []byte) (*Packet, error) {
+	p := &Packet{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("psbt: unsupported version %v", p.Version)
+	}
+	if len(p.Inputs) != p.Tx.NumInputs() {
+		return nil, fmt.Errorf("psbt: %v inputs for a transaction with %v", len(p.Inputs), p.Tx.NumInputs())
+	}
+	return p, nil
+}
+
+// Encode serializes p as JSON.
+func (p *Packet) Encode() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// SetSpentOutput records out as the output spent by input idx.
+func (p *Packet) SetSpentOutput(idx int, out *scrooge.TOutput) error {
+	if idx < 0 || idx >= len(p.Inputs) {
+		return fmt.Errorf("psbt: no input %v", idx)
+	}
+	copied := *out
+	p.Inputs[idx].Spent = &copied
+	return nil
+}
+
+// Update fills in the spent output of every input found in pool and returns the number
+// of inputs still missing one.
+func (p *Packet) Update(pool *scrooge.UTXOPool) int {
+	missing := 0
+	for idx, in := range p.Tx.Inputs {
+		if p.Inputs[idx].Spent != nil {
+			continue
+		}
+		out := pool.GetTxOutput(scrooge.UTXO{TxHash: string(in.PrevTxHash), Index: in.OutputIdx})
+		if out == nil {
+			missing++
+			continue
+		}
+		p.SetSpentOutput(idx, out)
+	}
+	return missing
+}
+
+// SetPreimage records the revocation preimage of input idx.
+func (p *Packet) SetPreimage(idx int, preimage []byte) error {
+	if idx < 0 || idx >= len(p.Inputs) {
+		return fmt.Errorf("psbt: no input %v", idx)
+	}
+	p.Inputs[idx].Preimage = preimage
+	return nil
+}
+
+// SignRSA signs every input whose spent output needs a signature by key and returns
+// the number of signatures added.
+func (p *Packet) SignRSA(key *rsa.PrivateKey) (int, error) {
+	address := cryptoutil.GetAddress(key.PublicKey)
+	signed := 0
+	for idx := range p.Inputs {
+		if !p.needs(idx, address) {
+			continue
+		}
+		sig, err := cryptoutil.RSASign(key, p.Tx.GetRawDataToSign(idx))
+		if err != nil {
+			return signed, err
+		}
+		p.addSignature(idx, address, sig)
+		signed++
+	}
+	return signed, nil
+}
+
+// SignEd25519 signs every input whose spent output needs a signature by key and returns
+// the number of signatures added.
+func (p *Packet) SignEd25519(key ed25519.PrivateKey) int {
+	address := cryptoutil.GetEd25519Address(key.Public().(ed25519.PublicKey))
+	signed := 0
+	for idx := range p.Inputs {
+		if p.needs(idx, address) {
+			p.addSignature(idx, address, ed25519.Sign(key, p.Tx.GetRawDataToSign(idx)))
+			signed++
+		}
+	}
+	return signed
+}
+
+// needs reports whether input idx still lacks a signature by address.
+func (p *Packet) needs(idx int, address string) bool {
+	for _, signer := range p.signers(idx) {
+		if signer.address == address {
+			_, ok := p.Inputs[idx].Signatures[address]
+			return !ok
+		}
+	}
+	return false
+}
+
+func (p *Packet) addSignature(idx int, address string, sig []byte) {
+	if p.Inputs[idx].Signatures == nil {
+		p.Inputs[idx].Signatures = make(map[string][]byte)
+	}
+	p.Inputs[idx].Signatures[address] = sig
+}
+
+// Combine merges the spent outputs, signatures and metadata of packets of the same
+// transaction into a new packet.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("psbt: nothing to combine")
+	}
+	combined := New(&packets[0].Tx)
+	for _, p := range packets {
+		if !bytes.Equal(p.Tx.GetRawTx(), combined.Tx.GetRawTx()) {
+			return nil, errors.New("psbt: packets hold different transactions")
+		}
+		for key, value := range p.Meta {
+			combined.Meta[key] = value
+		}
+		for idx, in := range p.Inputs {
+			merged := &combined.Inputs[idx]
+			if in.Spent != nil {
+				if merged.Spent != nil && !sameOutput(merged.Spent, in.Spent) {
+					return nil, fmt.Errorf("psbt: conflicting spent outputs for input %v", idx)
+				}
+				merged.Spent = in.Spent
+			}
+			if in.Preimage != nil {
+				if merged.Preimage != nil && !bytes.Equal(merged.Preimage, in.Preimage) {
+					return nil, fmt.Errorf("psbt: conflicting preimages for input %v", idx)
+				}
+				merged.Preimage = in.Preimage
+			}
+			for address, sig := range in.Signatures {
+				if existing, ok := merged.Signatures[address]; ok && !bytes.Equal(existing, sig) {
+					return nil, fmt.Errorf("psbt: conflicting signatures by %v on input %v", address, idx)
+				}
+				combined.addSignature(idx, address, sig)
+			}
+		}
+	}
+	return combined, nil
+}
+
+// Missing returns, for each input, the addresses whose signature is still needed.
+// Inputs without spent output list no addresses, but are never complete.
+func (p *Packet) Missing() map[int][]string {
+	missing := make(map[int][]string)
+	for idx := range p.Inputs {
+		for _, signer := range p.signers(idx) {
+			if _, ok := p.Inputs[idx].Signatures[signer.address]; !ok {
+				missing[idx] = append(missing[idx], signer.address)
+			}
+		}
+	}
+	return missing
+}
+
+// Finalize checks every signature and returns the signed and finalized transaction.
+func (p *Packet) Finalize() (*scrooge.Transaction, error) {
+	tx := scrooge.NewTransaction()
+	for _, in := range p.Tx.Inputs {
+		tx.AddInput(in.PrevTxHash, in.OutputIdx)
+	}
+	tx.Outputs = append(tx.Outputs, p.Tx.Outputs...)
+	tx.Replaceable = p.Tx.Replaceable
+
+	for idx, in := range p.Inputs {
+		if in.Spent == nil {
+			return nil, fmt.Errorf("psbt: input %v: %w, spent output unknown", idx, ErrIncomplete)
+		}
+		signers := p.signers(idx)
+		if len(signers) == 0 {
+			return nil, fmt.Errorf("psbt: input %v spends an output of unknown type %v", idx, in.Spent.Type)
+		}
+		rawData := tx.GetRawDataToSign(idx)
+		for _, signer := range signers {
+			sig, ok := in.Signatures[signer.address]
+			if !ok {
+				return nil, fmt.Errorf("psbt: input %v: %w, missing signature by %v", idx, ErrIncomplete, signer.address)
+			}
+			if !signer.verify(rawData, sig) {
+				return nil, fmt.Errorf("psbt: input %v: invalid signature by %v", idx, signer.address)
+			}
+			if signer.coSignature {
+				tx.AddCoSignature(sig, idx)
+			} else {
+				tx.AddSignature(sig, idx)
+			}
+		}
+		tx.AddPreimage(in.Preimage, idx)
+	}
+	tx.Finalize()
+	return tx, nil
+}
+
+// signer is a signature required by an input.
+type signer struct {
+	address string
+	verify  func(rawData []byte, sig []byte) bool
+	// coSignature is set when the signature goes to TInput.CoSignature
+	coSignature bool
+}
+
+// signers lists the signatures needed to spend the output of input idx.
+func (p *Packet) signers(idx int) []signer {
+	out := p.Inputs[idx].Spent
+	if out == nil {
+		return nil
+	}
+	rsaSigner := func(key rsa.PublicKey, coSignature bool) signer {
+		return signer{
+			address:     cryptoutil.GetAddress(key),
+			verify:      func(rawData []byte, sig []byte) bool { return cryptoutil.RSAVerify(&key, rawData, sig) },
+			coSignature: coSignature,
+		}
+	}
+	switch out.Type {
+	case scrooge.PayToAddress:
+		return []signer{rsaSigner(out.Address, false)}
+	case scrooge.PayToMultisig:
+		return []signer{rsaSigner(out.Address, false), rsaSigner(out.CoSigner, true)}
+	case scrooge.PayToRevocable:
+		if p.Inputs[idx].Preimage != nil {
+			return []signer{rsaSigner(out.CoSigner, false)}
+		}
+		return []signer{rsaSigner(out.Address, false)}
+	case scrooge.PayToEd25519:
+		key := out.EdAddress
+		return []signer{{
+			address: cryptoutil.GetEd25519Address(key),
+			verify: func(rawData []byte, sig []byte) bool {
+				return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, rawData, sig)
+			},
+		}}
+	}
+	return nil
+}
+
+func sameOutput(a *scrooge.TOutput, b *scrooge.TOutput) bool {
+	txA := scrooge.Transaction{Outputs: []scrooge.TOutput{*a}}
+	txB := scrooge.Transaction{Outputs: []scrooge.TOutput{*b}}
+	return bytes.Equal(txA.GetRawTx(), txB.GetRawTx())
+}
@@ -0,0 +1,143 @@
+package psbt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+)
+
+// roundTrip sends p to another party, as it would travel between processes.
+func roundTrip(p *Packet, t *testing.T) *Packet {
+	t.Helper()
+	data, err := p.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return decoded
+}
+
+func testPool(alice *rsa.PrivateKey, bob *rsa.PrivateKey, carol ed25519.PublicKey) *scrooge.UTXOPool {
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 10.5, Address: alice.PublicKey})
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 1}, &scrooge.TOutput{Value: 2.5, Address: bob.PublicKey})
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 2}, &scrooge.TOutput{Value: 4, Address: alice.PublicKey, Type: scrooge.PayToMultisig, CoSigner: bob.PublicKey})
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 3}, &scrooge.TOutput{Value: 1, Type: scrooge.PayToEd25519, EdAddress: carol})
+	return pool
+}
+
+func TestMultiPartySigning(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	carolPub, carol, _ := ed25519.GenerateKey(rand.Reader)
+	pool := testPool(alice, bob, carolPub)
+
+	// creator
+	tx := scrooge.NewTransaction()
+	for idx := 0; idx < 4; idx++ {
+		tx.AddInput([]byte("txhash#1"), idx)
+	}
+	tx.AddOutput(15, bob.PublicKey)
+	tx.AddEd25519Output(3, carolPub)
+	created := New(tx)
+	created.Meta["memo"] = "dinner"
+
+	// updater
+	if missing := created.Update(pool); missing != 0 {
+		t.Fatalf("Update left %v inputs without spent output", missing)
+	}
+	if missing := created.Missing(); len(missing[2]) != 2 || len(missing) != 4 {
+		t.Fatalf("Missing=%v, expected 2 signers on the multisig input", missing)
+	}
+
+	// each signer works on its own copy
+	aliceCopy := roundTrip(created, t)
+	if signed, err := aliceCopy.SignRSA(alice); err != nil || signed != 2 {
+		t.Fatalf("alice signed %v inputs: %v", signed, err)
+	}
+	bobCopy := roundTrip(created, t)
+	if signed, err := bobCopy.SignRSA(bob); err != nil || signed != 2 {
+		t.Fatalf("bob signed %v inputs: %v", signed, err)
+	}
+	carolCopy := roundTrip(created, t)
+	if signed := carolCopy.SignEd25519(carol); signed != 1 {
+		t.Fatalf("carol signed %v inputs", signed)
+	}
+
+	// nobody can finalize a partial copy
+	if _, err := bobCopy.Finalize(); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("Finalize of a partial copy: err=%v", err)
+	}
+	partial, err := Combine(roundTrip(aliceCopy, t), roundTrip(bobCopy, t))
+	if err != nil {
+		t.Fatalf("Combine: %v", err)
+	}
+	if missing := partial.Missing(); len(missing) != 1 || len(missing[3]) != 1 {
+		t.Errorf("Missing=%v, expected carol's signature only", missing)
+	}
+
+	// combiner and finalizer
+	combined, err := Combine(partial, roundTrip(carolCopy, t))
+	if err != nil {
+		t.Fatalf("Combine: %v", err)
+	}
+	if combined.Meta["memo"] != "dinner" {
+		t.Errorf("metadata was lost: %v", combined.Meta)
+	}
+	final, err := roundTrip(combined, t).Finalize()
+	if err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	handler := scrooge.NewTxHandler(pool)
+	if accepted := handler.HandleTxs([]*scrooge.Transaction{final}); len(accepted) != 1 {
+		t.Fatalf("finalized transaction was rejected")
+	}
+}
+
+func TestCombineRejectsMismatches(t *testing.T) {
+	alice := cryptoutil.GetPrivateKey()
+	bob := cryptoutil.GetPrivateKey()
+	carolPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	pool := testPool(alice, bob, carolPub)
+
+	tx := scrooge.NewTransaction()
+	tx.AddInput([]byte("txhash#1"), 0)
+	tx.AddOutput(10, bob.PublicKey)
+	p := New(tx)
+	p.Update(pool)
+
+	other := scrooge.NewTransaction()
+	other.AddInput([]byte("txhash#1"), 0)
+	other.AddOutput(10, alice.PublicKey)
+	if _, err := Combine(p, New(other)); err == nil {
+		t.Errorf("Combine merged packets of different transactions")
+	}
+
+	lying := roundTrip(p, t)
+	lying.Inputs[0].Spent.Value = 100
+	if _, err := Combine(p, lying); err == nil {
+		t.Errorf("Combine merged conflicting spent outputs")
+	}
+
+	// a signature by the wrong key is never placed
+	if signed, _ := p.SignRSA(bob); signed != 0 {
+		t.Errorf("bob signed an input he does not own")
+	}
+	forged := roundTrip(p, t)
+	forged.addSignature(0, cryptoutil.GetAddress(alice.PublicKey), []byte("forged"))
+	if _, err := forged.Finalize(); err == nil || errors.Is(err, ErrIncomplete) {
+		t.Errorf("Finalize with a forged signature: err=%v", err)
+	}
+
+	if _, err := Decode([]byte(`{"version": 2}`)); err == nil {
+		t.Errorf("Decode accepted an unknown version")
+	}
+}
@@ -0,0 +1,117 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+)
+
+// Strategy selects which coins fund a transaction.
+type Strategy int
+
+const (
+	// LargestFirst spends the largest coins until the target is covered.
+	LargestFirst Strategy = iota
+	// BranchAndBound looks for a set of coins matching the target closely enough to
+	// need no change output, and falls back to LargestFirst when there is none.
+	BranchAndBound
+	// SmallestSufficient spends the smallest single coin covering the target, and falls
+	// back to LargestFirst when no coin is large enough on its own.
+	SmallestSufficient
+)
+
+// maxBnBTries bounds the number of nodes BranchAndBound visits.
+const maxBnBTries = 100000
+
+var ErrInsufficientFunds = errors.New("wallet: insufficient funds")
+
+// SelectCoins picks coins worth at least target with the given strategy. changeWindow
+// is the amount of excess BranchAndBound accepts without creating change.
+func SelectCoins(coins []Coin, target float64, strategy Strategy, changeWindow float64) ([]Coin, error) {
+	sorted := append([]Coin(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Value > sorted[j].Output.Value
+	})
+	var total float64
+	for _, coin := range sorted {
+		total += coin.Output.Value
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	switch strategy {
+	case BranchAndBound:
+		if selected := branchAndBound(sorted, target, changeWindow); selected != nil {
+			return selected, nil
+		}
+	case SmallestSufficient:
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if sorted[i].Output.Value >= target {
+				return []Coin{sorted[i]}, nil
+			}
+		}
+	}
+	return largestFirst(sorted, target), nil
+}
+
+// largestFirst expects coins sorted by decreasing value.
+func largestFirst(coins []Coin, target float64) []Coin {
+	var selected []Coin
+	var sum float64
+	for _, coin := range coins {
+		if sum >= target {
+			break
+		}
+		selected = append(selected, coin)
+		sum += coin.Output.Value
+	}
+	return selected
+}
+
+// branchAndBound runs a depth-first search over coins sorted by decreasing value for
+// the selection whose sum lies in [target, target+window] with the least excess. It
+// returns nil when no such selection is found within maxBnBTries nodes.
+func branchAndBound(coins []Coin, target float64, window float64) []Coin {
+	// remaining[i] is the value of coins[i:]
+	remaining := make([]float64, len(coins)+1)
+	for i := len(coins) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + coins[i].Output.Value
+	}
+
+	var best []int
+	bestExcess := window
+	var current []int
+	tries := 0
+
+	var search func(idx int, sum float64)
+	search = func(idx int, sum float64) {
+		tries++
+		if tries > maxBnBTries || sum > target+bestExcess || (best != nil && bestExcess == 0) {
+			return
+		}
+		if sum >= target {
+			if best == nil || sum-target < bestExcess {
+				best = append(best[:0], current...)
+				bestExcess = sum - target
+			}
+			return
+		}
+		if idx == len(coins) || sum+remaining[idx] < target {
+			return
+		}
+		current = append(current, idx)
+		search(idx+1, sum+coins[idx].Output.Value)
+		current = current[:len(current)-1]
+		search(idx+1, sum)
+	}
+	search(0, 0)
+
+	if best == nil {
+		return nil
+	}
+	selected := make([]Coin, 0, len(best))
+	for _, idx := range best {
+		selected = append(selected, coins[idx])
+	}
+	return selected
+}
@@ -0,0 +1,356 @@
+// Package wallet keeps the keys of a scrooge user, finds the coins they own in a
+// UTXOPool and builds signed transactions spending them.
+//
+// A wallet holds RSA keys, Ed25519 keys, or both. Wallets created from a seed derive
+// all their Ed25519 keys from it, so the seed alone is enough to restore them.
+package wallet
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sort"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/hdkey"
+)
+
+// DefaultChangeWindow is the smallest change worth an output of its own. Less than this
+// is left to the transaction fee.
+const DefaultChangeWindow = 0.0001
+
+// DefaultGapLimit is the number of consecutive unused addresses after which Discover
+// stops looking.
+const DefaultGapLimit = 20
+
+// Coin is an unspent output owned by one of the wallet keys.
+type Coin struct {
+	UTXO   scrooge.UTXO
+	Output *scrooge.TOutput
+	key    *rsa.PrivateKey
+	edKey  ed25519.PrivateKey
+}
+
+// Payment is one output of a transaction built by the wallet. It pays EdAddress when
+// set, and Address otherwise.
+type Payment struct {
+	Address   rsa.PublicKey
+	EdAddress ed25519.PublicKey
+	Value     float64
+}
+
+type Wallet struct {
+	keys   []*rsa.PrivateKey
+	edKeys []ed25519.PrivateKey
+
+	// account is the derivation root of wallets created by NewFromSeed
+	account *hdkey.Key
+	// nextIndex is the index of the next address derived from account
+	nextIndex uint32
+
+	// Strategy is the coin selection used by Send.
+	Strategy Strategy
+	// ChangeWindow is the smallest change Send pays back to the wallet.
+	ChangeWindow float64
+	// Replaceable makes Send build transactions that a higher fee can replace.
+	Replaceable bool
+}
+
+// New returns a wallet holding keys, generating one if none is given.
+func New(keys ...*rsa.PrivateKey) *Wallet {
+	w := &Wallet{ChangeWindow: DefaultChangeWindow}
+	for _, key := range keys {
+		w.AddKey(key)
+	}
+	if len(w.keys) == 0 {
+		w.NewKey()
+	}
+	return w
+}
+
+// NewFromSeed returns a wallet deriving its Ed25519 keys from seed, as returned by
+// hdkey.Seed, along m/44'/1984'/account'. It starts with the first address only; call
+// Discover to find the others in use.
+func NewFromSeed(seed []byte, account uint32) (*Wallet, error) {
+	master, err := hdkey.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wallet{
+		ChangeWindow: DefaultChangeWindow,
+		account:      master.Child(hdkey.Purpose).Child(hdkey.CoinType).Child(account),
+	}
+	w.NewAddress()
+	return w, nil
+}
+
+// NewAddress derives the next Ed25519 key of a wallet created by NewFromSeed and
+// returns its public key.
+func (w *Wallet) NewAddress() ed25519.PublicKey {
+	if w.account == nil {
+		panic("wallet: NewAddress on a wallet without seed")
+	}
+	key := w.account.Child(w.nextIndex).PrivateKey()
+	w.nextIndex++
+	w.AddEdKey(key)
+	return key.Public().(ed25519.PublicKey)
+}
+
+// Discover derives addresses of a wallet created by NewFromSeed until gapLimit
+// consecutive addresses own nothing in pool, and adds every address up to the last one
+// in use. It returns the number of addresses in use.
+func (w *Wallet) Discover(pool *scrooge.UTXOPool, gapLimit int) int {
+	if w.account == nil {
+		return 0
+	}
+	owners := make(map[string]bool)
+	for _, out := range pool.H {
+		if out != nil && out.Type == scrooge.PayToEd25519 {
+			owners[string(out.EdAddress)] = true
+		}
+	}
+	used := 0
+	gap := 0
+	for index := uint32(0); gap < gapLimit; index++ {
+		key := w.account.Child(index).PrivateKey()
+		if !owners[string(key.Public().(ed25519.PublicKey))] {
+			gap++
+			continue
+		}
+		used++
+		gap = 0
+		for w.nextIndex <= index {
+			w.NewAddress()
+		}
+	}
+	return used
+}
+
+// AddKey adds key to the wallet, ignoring keys it already holds.
+func (w *Wallet) AddKey(key *rsa.PrivateKey) {
+	if w.keyFor(&key.PublicKey) == nil {
+		w.keys = append(w.keys, key)
+	}
+}
+
+// AddEdKey adds an Ed25519 key to the wallet, ignoring keys it already holds.
+func (w *Wallet) AddEdKey(key ed25519.PrivateKey) {
+	if w.edKeyFor(key.Public().(ed25519.PublicKey)) == nil {
+		w.edKeys = append(w.edKeys, key)
+	}
+}
+
+// NewKey generates a key, adds it to the wallet and returns it.
+func (w *Wallet) NewKey() *rsa.PrivateKey {
+	key := cryptoutil.GetPrivateKey()
+	w.keys = append(w.keys, key)
+	return key
+}
+
+// Keys returns the wallet keys in the order they were added.
+func (w *Wallet) Keys() []*rsa.PrivateKey {
+	return append([]*rsa.PrivateKey(nil), w.keys...)
+}
+
+// EdKeys returns the Ed25519 keys of the wallet in the order they were added.
+func (w *Wallet) EdKeys() []ed25519.PrivateKey {
+	return append([]ed25519.PrivateKey(nil), w.edKeys...)
+}
+
+// Addresses returns the RSA public keys of the wallet.
+func (w *Wallet) Addresses() []rsa.PublicKey {
+	addresses := make([]rsa.PublicKey, 0, len(w.keys))
+	for _, key := range w.keys {
+		addresses = append(addresses, key.PublicKey)
+	}
+	return addresses
+}
+
+// EdAddresses returns the Ed25519 public keys of the wallet.
+func (w *Wallet) EdAddresses() []ed25519.PublicKey {
+	addresses := make([]ed25519.PublicKey, 0, len(w.edKeys))
+	for _, key := range w.edKeys {
+		addresses = append(addresses, key.Public().(ed25519.PublicKey))
+	}
+	return addresses
+}
+
+// ChangeAddress is the address receiving the change of transactions built by Send:
+// the first RSA key, or the first Ed25519 key of wallets without RSA keys.
+func (w *Wallet) ChangeAddress() Payment {
+	if len(w.keys) > 0 {
+		return Payment{Address: w.keys[0].PublicKey}
+	}
+	return Payment{EdAddress: w.edKeys[0].Public().(ed25519.PublicKey)}
+}
+
+// Owns reports whether the wallet holds the key of address.
+func (w *Wallet) Owns(address *rsa.PublicKey) bool {
+	return w.keyFor(address) != nil
+}
+
+func (w *Wallet) keyFor(address *rsa.PublicKey) *rsa.PrivateKey {
+	for _, key := range w.keys {
+		if key.PublicKey.Equal(address) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (w *Wallet) edKeyFor(address ed25519.PublicKey) ed25519.PrivateKey {
+	for _, key := range w.edKeys {
+		if address.Equal(key.Public()) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Scan returns the PayToAddress and PayToEd25519 outputs of pool owned by the wallet,
+// largest first.
+func (w *Wallet) Scan(pool *scrooge.UTXOPool) []Coin {
+	var coins []Coin
+	for _, key := range w.keys {
+		for _, utxo := range pool.UTXOsByOwner(cryptoutil.GetAddress(key.PublicKey)) {
+			if out := pool.GetTxOutput(utxo); out.Type == scrooge.PayToAddress {
+				coins = append(coins, Coin{UTXO: utxo, Output: out, key: key})
+			}
+		}
+	}
+	for _, key := range w.edKeys {
+		for _, utxo := range pool.UTXOsByOwner(cryptoutil.GetEd25519Address(key.Public().(ed25519.PublicKey))) {
+			if out := pool.GetTxOutput(utxo); out.Type == scrooge.PayToEd25519 {
+				coins = append(coins, Coin{UTXO: utxo, Output: out, edKey: key})
+			}
+		}
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].Output.Value != coins[j].Output.Value {
+			return coins[i].Output.Value > coins[j].Output.Value
+		}
+		if coins[i].UTXO.TxHash != coins[j].UTXO.TxHash {
+			return coins[i].UTXO.TxHash < coins[j].UTXO.TxHash
+		}
+		return coins[i].UTXO.Index < coins[j].UTXO.Index
+	})
+	return coins
+}
+
+// Balance returns the total value of the wallet coins in pool.
+func (w *Wallet) Balance(pool *scrooge.UTXOPool) float64 {
+	var balance float64
+	for _, coin := range w.Scan(pool) {
+		balance += coin.Output.Value
+	}
+	return balance
+}
+
+// BalanceOf returns the value held by the RSA address in pool.
+func (w *Wallet) BalanceOf(pool *scrooge.UTXOPool, address rsa.PublicKey) float64 {
+	var balance float64
+	for _, coin := range w.Scan(pool) {
+		if coin.Output.Type == scrooge.PayToAddress && coin.Output.Address.Equal(&address) {
+			balance += coin.Output.Value
+		}
+	}
+	return balance
+}
+
+// BalanceOfEd returns the value held by the Ed25519 address in pool.
+func (w *Wallet) BalanceOfEd(pool *scrooge.UTXOPool, address ed25519.PublicKey) float64 {
+	var balance float64
+	for _, coin := range w.Scan(pool) {
+		if coin.Output.Type == scrooge.PayToEd25519 && coin.Output.EdAddress.Equal(address) {
+			balance += coin.Output.Value
+		}
+	}
+	return balance
+}
+
+// Send builds a finalized transaction making payments out of the wallet coins in pool
+// and leaving fee to Scrooge. Change of at least ChangeWindow goes back to
+// ChangeAddress.
+func (w *Wallet) Send(pool *scrooge.UTXOPool, payments []Payment, fee float64) (*scrooge.Transaction, error) {
+	if len(payments) == 0 {
+		return nil, errors.New("wallet: no payments")
+	}
+	if fee < 0 {
+		return nil, fmt.Errorf("wallet: negative fee %v", fee)
+	}
+	target := fee
+	for _, payment := range payments {
+		if payment.Value < 0 {
+			return nil, fmt.Errorf("wallet: negative payment %v", payment.Value)
+		}
+		target += payment.Value
+	}
+
+	coins, err := SelectCoins(w.Scan(pool), target, w.Strategy, w.ChangeWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := scrooge.NewTransaction()
+	var inValue float64
+	for _, coin := range coins {
+		tx.AddInput([]byte(coin.UTXO.TxHash), coin.UTXO.Index)
+		inValue += coin.Output.Value
+	}
+	for _, payment := range payments {
+		addPayment(tx, payment)
+	}
+	if change := inValue - target; change >= w.ChangeWindow && change > 0 {
+		changePayment := w.ChangeAddress()
+		changePayment.Value = change
+		addPayment(tx, changePayment)
+	}
+	tx.Replaceable = w.Replaceable
+	if err := w.Sign(tx, coins); err != nil {
+		return nil, err
+	}
+	tx.Finalize()
+	return tx, nil
+}
+
+// Sign signs each input of tx with the key of the coin it spends, in input order.
+func (w *Wallet) Sign(tx *scrooge.Transaction, coins []Coin) error {
+	if len(coins) != tx.NumInputs() {
+		return fmt.Errorf("wallet: %v coins for %v inputs", len(coins), tx.NumInputs())
+	}
+	for idx, coin := range coins {
+		rawData := tx.GetRawDataToSign(idx)
+		if coin.Output.Type == scrooge.PayToEd25519 {
+			key := coin.edKey
+			if key == nil {
+				if key = w.edKeyFor(coin.Output.EdAddress); key == nil {
+					return fmt.Errorf("wallet: input %v is not owned by the wallet", idx)
+				}
+			}
+			tx.AddSignature(ed25519.Sign(key, rawData), idx)
+			continue
+		}
+		key := coin.key
+		if key == nil {
+			if key = w.keyFor(&coin.Output.Address); key == nil {
+				return fmt.Errorf("wallet: input %v is not owned by the wallet", idx)
+			}
+		}
+		signature, err := cryptoutil.RSASign(key, rawData)
+		if err != nil {
+			return err
+		}
+		tx.AddSignature(signature, idx)
+	}
+	return nil
+}
+
+func addPayment(tx *scrooge.Transaction, payment Payment) {
+	if payment.EdAddress != nil {
+		tx.AddEd25519Output(payment.Value, payment.EdAddress)
+	} else {
+		tx.AddOutput(payment.Value, payment.Address)
+	}
+}
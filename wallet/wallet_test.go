@@ -0,0 +1,199 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+
+	"scrooge"
+	"scrooge/cryptoutil"
+	"scrooge/hdkey"
+)
+
+func testCoins(values ...float64) []Coin {
+	coins := make([]Coin, 0, len(values))
+	for idx, value := range values {
+		coins = append(coins, Coin{
+			UTXO:   scrooge.UTXO{TxHash: fmt.Sprintf("txhash#%v", idx), Index: 0},
+			Output: &scrooge.TOutput{Value: value},
+		})
+	}
+	return coins
+}
+
+func coinValues(coins []Coin) []float64 {
+	values := make([]float64, 0, len(coins))
+	for _, coin := range coins {
+		values = append(values, coin.Output.Value)
+	}
+	return values
+}
+
+func TestSelectCoins(t *testing.T) {
+	coins := testCoins(1, 8, 3, 5, 2)
+
+	cases := []struct {
+		strategy Strategy
+		target   float64
+		expected []float64
+	}{
+		{LargestFirst, 9, []float64{8, 5}},
+		{LargestFirst, 19, []float64{8, 5, 3, 2, 1}},
+		{BranchAndBound, 9, []float64{8, 1}},
+		{BranchAndBound, 10, []float64{8, 2}},
+		{BranchAndBound, 18.5, []float64{8, 5, 3, 2, 1}},
+		{SmallestSufficient, 4, []float64{5}},
+		{SmallestSufficient, 8, []float64{8}},
+		{SmallestSufficient, 9, []float64{8, 5}},
+	}
+	for _, c := range cases {
+		selected, err := SelectCoins(coins, c.target, c.strategy, 0.0001)
+		if err != nil {
+			t.Errorf("strategy %v target %v: %v", c.strategy, c.target, err)
+			continue
+		}
+		if fmt.Sprint(coinValues(selected)) != fmt.Sprint(c.expected) {
+			t.Errorf("strategy %v target %v: selected %v, expected %v", c.strategy, c.target, coinValues(selected), c.expected)
+		}
+	}
+
+	for _, strategy := range []Strategy{LargestFirst, BranchAndBound, SmallestSufficient} {
+		if _, err := SelectCoins(coins, 19.5, strategy, 0.0001); err != ErrInsufficientFunds {
+			t.Errorf("strategy %v: err=%v, expected ErrInsufficientFunds", strategy, err)
+		}
+	}
+}
+
+func TestWalletSendThroughHandleTxs(t *testing.T) {
+	alice := New(cryptoutil.GetPrivateKey(), cryptoutil.GetPrivateKey())
+	bob := New()
+	aliceKeys := alice.Keys()
+
+	pool := scrooge.NewUTXOPool()
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 0}, &scrooge.TOutput{Value: 10.5, Address: aliceKeys[0].PublicKey})
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 1}, &scrooge.TOutput{Value: 1, Address: aliceKeys[1].PublicKey})
+	pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: 2}, &scrooge.TOutput{Value: 2.5, Address: bob.ChangeAddress().Address})
+
+	if balance := alice.Balance(pool); balance != 11.5 {
+		t.Fatalf("alice balance=%v, expected 11.5", balance)
+	}
+	if balance := alice.BalanceOf(pool, aliceKeys[1].PublicKey); balance != 1 {
+		t.Fatalf("alice second key balance=%v, expected 1", balance)
+	}
+
+	if _, err := alice.Send(pool, nil, 0); err == nil {
+		t.Fatalf("Send without payments succeeded")
+	}
+	// spends both keys' coins and returns the change to the first key
+	tx, err := alice.Send(pool, []Payment{{Address: bob.ChangeAddress().Address, Value: 11}}, 0.1)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if tx.NumInputs() != 2 || tx.NumOutputs() != 2 {
+		t.Fatalf("transaction has %v inputs and %v outputs, expected 2 and 2", tx.NumInputs(), tx.NumOutputs())
+	}
+
+	handler := scrooge.NewTxHandler(pool)
+	if accepted := handler.HandleTxs([]*scrooge.Transaction{tx}); len(accepted) != 1 {
+		t.Fatalf("transaction built by the wallet was rejected")
+	}
+	if balance := alice.Balance(pool); balance < 0.39999 || balance > 0.40001 {
+		t.Errorf("alice balance=%v, expected 0.4", balance)
+	}
+	if balance := bob.Balance(pool); balance != 13.5 {
+		t.Errorf("bob balance=%v, expected 13.5", balance)
+	}
+
+	if _, err := alice.Send(pool, []Payment{{Address: bob.ChangeAddress().Address, Value: 1}}, 0); err != ErrInsufficientFunds {
+		t.Errorf("err=%v, expected ErrInsufficientFunds", err)
+	}
+}
+
+func TestWalletSendWithoutChange(t *testing.T) {
+	alice := New()
+	bob := New()
+
+	pool := scrooge.NewUTXOPool()
+	for idx, value := range []float64{4, 3, 2} {
+		pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: idx}, &scrooge.TOutput{Value: value, Address: alice.ChangeAddress().Address})
+	}
+
+	alice.Strategy = BranchAndBound
+	tx, err := alice.Send(pool, []Payment{{Address: bob.ChangeAddress().Address, Value: 5}}, 0)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if tx.NumInputs() != 2 || tx.NumOutputs() != 1 {
+		t.Fatalf("transaction has %v inputs and %v outputs, expected 2 and 1", tx.NumInputs(), tx.NumOutputs())
+	}
+	handler := scrooge.NewTxHandler(pool)
+	if accepted := handler.HandleTxs([]*scrooge.Transaction{tx}); len(accepted) != 1 {
+		t.Fatalf("transaction built by the wallet was rejected")
+	}
+	if balance := alice.Balance(pool); balance != 4 {
+		t.Errorf("alice balance=%v, expected 4", balance)
+	}
+}
+
+func TestWalletRestoreFromMnemonic(t *testing.T) {
+	mnemonic, err := hdkey.NewMnemonic(128)
+	if err != nil {
+		t.Fatalf("NewMnemonic: %v", err)
+	}
+	seed, err := hdkey.Seed(mnemonic, "")
+	if err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	original, err := NewFromSeed(seed, 0)
+	if err != nil {
+		t.Fatalf("NewFromSeed: %v", err)
+	}
+	var addresses []ed25519.PublicKey
+	for len(original.EdAddresses()) <= 15 {
+		addresses = append(addresses, original.NewAddress())
+	}
+	addresses = append(original.EdAddresses()[:1], addresses...)
+
+	pool := scrooge.NewUTXOPool()
+	for idx, index := range []int{0, 3, 15} {
+		pool.AddUTXO(scrooge.UTXO{TxHash: "txhash#1", Index: idx}, &scrooge.TOutput{Value: float64(idx + 1), Type: scrooge.PayToEd25519, EdAddress: addresses[index]})
+	}
+
+	// the gap between addresses 3 and 15 hides the last one from a small gap limit
+	restored, _ := NewFromSeed(seed, 0)
+	if used := restored.Discover(pool, 5); used != 2 || restored.Balance(pool) != 3 {
+		t.Errorf("gap limit 5 found %v addresses holding %v, expected 2 holding 3", used, restored.Balance(pool))
+	}
+	restored, _ = NewFromSeed(seed, 0)
+	if used := restored.Discover(pool, DefaultGapLimit); used != 3 || restored.Balance(pool) != 6 {
+		t.Errorf("default gap limit found %v addresses holding %v, expected 3 holding 6", used, restored.Balance(pool))
+	}
+	if len(restored.EdAddresses()) != 16 {
+		t.Errorf("restored wallet has %v addresses, expected 16", len(restored.EdAddresses()))
+	}
+	if next := restored.NewAddress(); !next.Equal(original.NewAddress()) {
+		t.Errorf("restored wallet derives a different next address")
+	}
+
+	// another account of the same seed owns nothing
+	other, _ := NewFromSeed(seed, 1)
+	if used := other.Discover(pool, DefaultGapLimit); used != 0 {
+		t.Errorf("account 1 found %v addresses in use", used)
+	}
+
+	bob := New()
+	tx, err := restored.Send(pool, []Payment{{Address: bob.ChangeAddress().Address, Value: 5.5}}, 0)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	handler := scrooge.NewTxHandler(pool)
+	if accepted := handler.HandleTxs([]*scrooge.Transaction{tx}); len(accepted) != 1 {
+		t.Fatalf("transaction signed with derived keys was rejected")
+	}
+	if balance := restored.BalanceOfEd(pool, addresses[0]); balance != 0.5 {
+		t.Errorf("change balance=%v, expected 0.5", balance)
+	}
+	if balance := bob.Balance(pool); balance != 5.5 {
+		t.Errorf("bob balance=%v, expected 5.5", balance)
+	}
+}